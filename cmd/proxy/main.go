@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/alecthomas/kong"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
 	"github.com/pzhenzhou/elika/pkg/common"
 	"github.com/pzhenzhou/elika/pkg/metrics"
 	"github.com/pzhenzhou/elika/pkg/proxy"
@@ -25,34 +26,49 @@ func main() {
 	if err := proxyCfg.Validate(); err != nil {
 		ctx.FatalIfErrorf(err)
 	}
-	fmt.Print(proxy.Banner)
-	logger.Info("ElikaProxyServer ", "Config", proxyCfg)
+	if err := be_cluster.CheckStaticBackend(&proxyCfg); err != nil {
+		ctx.FatalIfErrorf(err)
+	}
+	if proxyCfg.ShowBanner() {
+		fmt.Print(proxy.Banner)
+		fmt.Println(common.GetBuildInfo())
+	}
+	logger.Info("ElikaProxyServer ", "BuildInfo", common.GetBuildInfo().String(), "Config", proxyCfg)
 	SetupAllServer()
 }
 
 func SetupAllServer() {
-	srvListener := proxyCfg.ServiceListener()
-	m := cmux2.New(srvListener)
-
 	httpSrv := web_service.NewWebServer(&proxyCfg)
 	proxySrv := proxy.NewElikaProxy(&proxyCfg)
+	httpSrv.AddHandler(web_service.NewAdminHandler(proxyCfg.WebServer.AdminToken,
+		web_service.NewRebalanceHandler(proxySrv.SessionManager())))
+	httpSrv.AddHandler(web_service.NewHotKeysHandler(proxySrv.SessionManager()))
+	httpSrv.AddHandler(web_service.NewRouteHandler(proxySrv.SessionManager()))
+	httpSrv.AddHandler(web_service.NewRingHandler(proxySrv.SessionManager()))
+	httpSrv.AddHandler(web_service.NewTransactionsHandler(proxySrv.SessionManager()))
+	httpSrv.AddHandler(web_service.NewReadyHandler(proxySrv.SessionManager()))
+	httpSrv.AddHandler(&web_service.VersionHandler{})
+	httpSrv.AddHandler(web_service.NewAdminHandler(proxyCfg.WebServer.AdminToken, &web_service.SetTenantACLHandler{}))
+	httpSrv.AddHandler(web_service.NewAdminHandler(proxyCfg.WebServer.AdminToken, &web_service.DeleteTenantACLHandler{}))
+	httpSrv.AddHandler(web_service.NewAdminHandler(proxyCfg.WebServer.AdminToken, &web_service.SetTenantTTLHandler{}))
+	httpSrv.AddHandler(web_service.NewAdminHandler(proxyCfg.WebServer.AdminToken, &web_service.DeleteTenantTTLHandler{}))
+	httpSrv.AddHandler(web_service.NewAdminHandler(proxyCfg.WebServer.AdminToken, &web_service.BindTenantHandler{}))
+	httpSrv.AddHandler(web_service.NewAdminHandler(proxyCfg.WebServer.AdminToken, &web_service.UnbindTenantHandler{}))
 
+	var metricsCollector metrics.ProxyMetricsCollector
 	if proxyCfg.Metrics.EnableMetrics {
-		metricsConfig := metrics.DefaultConfig()
-		if proxyCfg.Metrics.MetricsSinkType == "prometheus" {
-			metricsConfig.ExposeSink = metrics.PrometheusSink
-		} else if proxyCfg.Metrics.MetricsSinkType == "memory" {
-			metricsConfig.ExposeSink = metrics.InMemorySink
-		} else if proxyCfg.Metrics.MetricsSinkType == "all" {
-			metricsConfig.ExposeSink = metrics.AllMetricsSink
-		}
-		metricsCollector, err := metrics.NewMetricsCollector(metricsConfig)
+		// the proxy serves without metrics rather than failing to start
+		collector, err := newMetricsCollector(&proxyCfg.Metrics)
 		if err == nil {
+			metricsCollector = collector
 			metricsMiddleware := metrics.NewProxyMetricsMiddleware(metricsCollector)
 			proxySrv.SetMetricsMiddleware(metricsMiddleware)
-			httpSrv.SetMetricHandler(metrics.ExposeMetricURL, metricsCollector)
+			httpSrv.SetMetricHandler(proxyCfg.Metrics.MetricsPath, metricsCollector)
+			httpSrv.AddHandler(web_service.NewStatsHandler(metricsCollector, proxySrv.SessionManager()))
+			httpSrv.AddHandler(web_service.NewAdminHandler(proxyCfg.WebServer.AdminToken,
+				web_service.NewMetricsResetHandler(metricsCollector)))
 		} else {
-			logger.Error(err, "Failed to create metrics collector")
+			logger.Error(err, "Failed to create metrics collector, metrics are disabled")
 		}
 	}
 
@@ -65,19 +81,29 @@ func SetupAllServer() {
 			errChan <- err
 		}
 	}()
-	// start http proxy
-	go func() {
-		if err := httpSrv.Start(m); err != nil {
-			errChan <- err
-		}
-	}()
-
-	go func() {
-		logger.Info("Starting cmux proxy...", "ServiceAddr", srvListener.Addr())
-		if err := m.Serve(); err != nil {
-			errChan <- err
-		}
-	}()
+	// start http proxy, on its own port or multiplexed on the service port
+	if httpListener := proxyCfg.HttpListener(); httpListener != nil {
+		go func() {
+			logger.Info("Starting http proxy...", "HttpAddr", httpListener.Addr())
+			if err := httpSrv.Serve(httpListener); err != nil {
+				errChan <- err
+			}
+		}()
+	} else {
+		srvListener := proxyCfg.ServiceListener()
+		m := cmux2.New(srvListener)
+		go func() {
+			if err := httpSrv.Start(m); err != nil {
+				errChan <- err
+			}
+		}()
+		go func() {
+			logger.Info("Starting cmux proxy...", "ServiceAddr", srvListener.Addr())
+			if err := m.Serve(); err != nil {
+				errChan <- err
+			}
+		}()
+	}
 
 	select {
 	case err := <-errChan:
@@ -87,7 +113,23 @@ func SetupAllServer() {
 		logger.Info("Received signal, shutting down...", "Sigs", sig)
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
-		httpSrv.Shutdown(ctx)
+		// stop accepting and drain the sessions first so that their last records are
+		// flushed, the web server keeps serving /metrics until the end
 		proxySrv.Shutdown(ctx)
+		if metricsCollector != nil {
+			metricsCollector.Shutdown()
+		}
+		httpSrv.Shutdown(ctx)
+	}
+}
+
+func newMetricsCollector(cfg *common.MetricsConfig) (metrics.ProxyMetricsCollector, error) {
+	sink, err := metrics.ParseExposeSink(cfg.MetricsSinkType)
+	if err != nil {
+		return nil, err
 	}
+	metricsConfig := metrics.DefaultConfig()
+	metricsConfig.ExposeSink = sink
+	metricsConfig.MetricsEndpoint = cfg.MetricsPath
+	return metrics.NewMetricsCollector(metricsConfig)
 }
@@ -0,0 +1,42 @@
+package be_cluster
+
+import (
+	"errors"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// ErrAdminCmdNotSupported is replied to an admin command of a tenant that is not an admin.
+var ErrAdminCmdNotSupported = errors.New("ERR command not supported by proxy")
+
+// AdminTenants are the tenants allowed to run the admin commands, e.g. FAILOVER, REPLICAOF or
+// CLIENT PAUSE. These change the topology or the state of the backend, which is shared by every
+// tenant routed to it.
+type AdminTenants struct {
+	tenants map[string]struct{}
+}
+
+func NewAdminTenants(tenants []string) *AdminTenants {
+	adminTenants := &AdminTenants{tenants: make(map[string]struct{}, len(tenants))}
+	for _, tenant := range tenants {
+		if tenant != "" {
+			adminTenants.tenants[tenant] = struct{}{}
+		}
+	}
+	return adminTenants
+}
+
+// IsAdminCommand reports whether the metadata of the command has the admin flag.
+func IsAdminCommand(packet *respio.RespPacket) bool {
+	meta := respio.LookupCommand(packet)
+	return meta != nil && meta.HasFlag(respio.CmdFlagAdmin)
+}
+
+// Allow reports whether the tenant may run the command, only admin tenants run admin commands.
+func (a *AdminTenants) Allow(tenant string, packet *respio.RespPacket) bool {
+	if !IsAdminCommand(packet) {
+		return true
+	}
+	_, ok := a.tenants[tenant]
+	return ok
+}
@@ -0,0 +1,35 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminTenants_Allow(t *testing.T) {
+	adminCmds := []*respio.RespPacket{
+		respio.NewCommand("FAILOVER"),
+		respio.NewCommand("replicaof", "no", "one"),
+		respio.NewCommand("SLAVEOF", "10.0.0.1", "6379"),
+		respio.NewCommand("CLIENT", "PAUSE", "1000"),
+		respio.NewCommand("client", "unpause"),
+	}
+
+	// rejected by default
+	none := NewAdminTenants(nil)
+	for _, packet := range adminCmds {
+		assert.True(t, IsAdminCommand(packet), string(packet.GetCommand()))
+		assert.False(t, none.Allow("tenant-a", packet), string(packet.GetCommand()))
+	}
+	assert.True(t, none.Allow("tenant-a", respio.NewCommand("GET", "k")))
+	assert.True(t, none.Allow("tenant-a", respio.NewCommand("CLIENT", "SETNAME", "app")))
+
+	admins := NewAdminTenants([]string{"ops", ""})
+	for _, packet := range adminCmds {
+		assert.True(t, admins.Allow("ops", packet), string(packet.GetCommand()))
+		assert.False(t, admins.Allow("tenant-a", packet), string(packet.GetCommand()))
+	}
+	// an empty entry does not make the clients without a username admins
+	assert.False(t, admins.Allow("", respio.NewCommand("FAILOVER")))
+}
@@ -0,0 +1,86 @@
+package be_cluster
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+var wrongPassPrefix = []byte("WRONGPASS")
+
+type authCacheEntry struct {
+	hash     [sha256.Size]byte
+	expireAt time.Time
+}
+
+// AuthCache remembers the passwords the backend accepted per tenant, so an identical AUTH from
+// a new connection is verified without a backend round trip. Only a salted hash is kept, the
+// salt is random per process.
+type AuthCache struct {
+	ttl     time.Duration
+	salt    []byte
+	entries *xsync.MapOf[string, authCacheEntry]
+}
+
+func NewAuthCache(ttl time.Duration) *AuthCache {
+	salt := make([]byte, 16)
+	_, _ = rand.Read(salt)
+	return &AuthCache{
+		ttl:     ttl,
+		salt:    salt,
+		entries: xsync.NewMapOf[string, authCacheEntry](),
+	}
+}
+
+func (c *AuthCache) hash(password []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(c.salt)
+	h.Write(password)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// Verify reports whether the backend accepted the same credentials within the TTL.
+func (c *AuthCache) Verify(authInfo *common.AuthInfo) bool {
+	entry, ok := c.entries.Load(string(authInfo.Username))
+	if !ok {
+		return false
+	}
+	if time.Now().After(entry.expireAt) {
+		c.entries.Delete(string(authInfo.Username))
+		return false
+	}
+	hash := c.hash(authInfo.Password)
+	return subtle.ConstantTimeCompare(hash[:], entry.hash[:]) == 1
+}
+
+// Store caches the credentials after the backend accepted them.
+func (c *AuthCache) Store(authInfo *common.AuthInfo) {
+	c.entries.Store(string(authInfo.Username), authCacheEntry{
+		hash:     c.hash(authInfo.Password),
+		expireAt: time.Now().Add(c.ttl),
+	})
+}
+
+// Invalidate drops the cached password of a tenant.
+func (c *AuthCache) Invalidate(username []byte) {
+	c.entries.Delete(string(username))
+}
+
+// observe updates the cache with the backend reply to an AUTH.
+func (c *AuthCache) observe(authInfo *common.AuthInfo, reply *respio.RespPacket) {
+	switch {
+	case isAuthOK(reply):
+		c.Store(authInfo)
+	case (reply.Type == respio.RespError || reply.Type == respio.RespBlobError) &&
+		bytes.HasPrefix(reply.Data, wrongPassPrefix):
+		c.Invalidate(authInfo.Username)
+	}
+}
@@ -0,0 +1,91 @@
+package be_cluster
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthCache_VerifyAndExpire(t *testing.T) {
+	cache := NewAuthCache(50 * time.Millisecond)
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a"), Password: []byte("secret")}
+	assert.False(t, cache.Verify(authInfo))
+
+	cache.Store(authInfo)
+	assert.True(t, cache.Verify(authInfo))
+	// another password or another tenant is a miss
+	assert.False(t, cache.Verify(&common.AuthInfo{Username: []byte("tenant-a"), Password: []byte("other")}))
+	assert.False(t, cache.Verify(&common.AuthInfo{Username: []byte("tenant-b"), Password: []byte("secret")}))
+	// only a salted hash is kept
+	entry, _ := cache.entries.Load("tenant-a")
+	assert.False(t, bytes.Contains(entry.hash[:], authInfo.Password))
+
+	assert.Eventually(t, func() bool {
+		return !cache.Verify(authInfo)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, cache.entries.Size())
+}
+
+func TestSessionManager_AuthCache(t *testing.T) {
+	var wrongPass bool
+	handler := func(req *respio.RespPacket) *respio.RespPacket {
+		if wrongPass {
+			return respio.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		return respio.NewStatus(string(respio.OkCmd))
+	}
+	sm, session, reader := newTestSessionManager(t, handler)
+	sm.authCache = NewAuthCache(time.Minute)
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a"), Password: []byte("secret")}
+	auth := func() *respio.RespPacket {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(),
+			respio.NewAuthPacket(authInfo.Username, authInfo.Password), authInfo))
+		reply, err := reader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+
+	assert.False(t, sm.VerifyCachedAuth(authInfo))
+	assert.Equal(t, respio.OkCmd, auth().Data)
+	assert.True(t, sm.VerifyCachedAuth(authInfo))
+
+	// the password was changed on the backend
+	wrongPass = true
+	assert.Equal(t, respio.RespError, auth().Type)
+	assert.False(t, sm.VerifyCachedAuth(authInfo))
+}
+
+func TestSessionManager_AuthListener(t *testing.T) {
+	handler := func(req *respio.RespPacket) *respio.RespPacket {
+		if string(req.Array[len(req.Array)-1].Data) != "secret" {
+			return respio.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		return respio.NewStatus(string(respio.OkCmd))
+	}
+	sm, session, reader := newTestSessionManager(t, handler)
+	type outcome struct {
+		tenant  string
+		success bool
+	}
+	outcomes := make(chan outcome, 4)
+	sm.SetAuthListener(func(tenant string, success bool) {
+		outcomes <- outcome{tenant, success}
+	})
+	auth := func(username, password string) outcome {
+		authInfo := &common.AuthInfo{Username: []byte(username), Password: []byte(password)}
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(),
+			respio.NewAuthPacket(authInfo.Username, authInfo.Password), authInfo))
+		_, err := reader.Read()
+		assert.NoError(t, err)
+		return <-outcomes
+	}
+
+	assert.Equal(t, outcome{"tenant-a", false}, auth("tenant-a", "wrong"))
+	assert.Equal(t, outcome{"tenant-a", true}, auth("tenant-a", "secret"))
+	// no label per unknown username
+	assert.Equal(t, outcome{unknownTenant, false}, auth("nobody", "wrong"))
+}
@@ -12,6 +12,9 @@ var (
 	IllegalStateError = errors.New("illegal state. unexpected read from socket")
 )
 
+// checkConn tells whether the connection was closed by the backend. The socket is only peeked,
+// the bytes waiting in it are left to the ReadLoop. Bytes waiting on a connection without a
+// request in flight cannot answer anything, they are reported as IllegalStateError.
 func checkConn(conn net.Conn) error {
 	_ = conn.SetDeadline(time.Time{})
 	sysConn, ok := conn.(syscall.Conn)
@@ -23,10 +26,10 @@ func checkConn(conn net.Conn) error {
 		return err
 	}
 	var sysErr error
-	// read data from the socket buffer. check if the connection is still alive
+	// peek at the socket buffer without blocking. check if the connection is still alive
 	if err := rawConn.Read(func(fd uintptr) bool {
 		var buf [1]byte
-		n, err := syscall.Read(int(fd), buf[:])
+		n, _, err := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
 		switch {
 		case n == 0 && err == nil:
 			sysErr = io.EOF
@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"golang.org/x/sys/unix"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/lithammer/shortuuid/v4"
+	"github.com/puzpuzpuz/xsync/v3"
 	"github.com/pzhenzhou/elika/pkg/common"
 	"github.com/pzhenzhou/elika/pkg/respio"
 )
@@ -25,18 +27,39 @@ type TxState struct {
 	OwnerSession *Session
 	// OwnerId string
 	State respio.TxCmdStateType
+	// Since is when the session pinned the connection, by its first WATCH or MULTI
+	Since time.Time
+	// StartedAt is when the MULTI was sent, zero before it
+	StartedAt time.Time
+}
+
+// Active reports whether the connection is pinned by a WATCH or a MULTI.
+func (s *TxState) Active() bool {
+	return s != nil && s.State != "" && s.State != respio.TxCmdStateEnd
 }
 
 var (
 	logger       = common.InitLogger().WithName("backend")
 	drainTimeout = 500 * time.Millisecond
+	// Replies above these thresholds are forwarded as pre-encoded bytes instead of a packet tree.
+	largeReplyBytes    = int64(common.MB)
+	largeReplyElements = int64(4096)
+	// ErrBackendConnClosed is replied to the requests left in the queues of a closed connection
+	ErrBackendConnClosed = errors.New("ERR backend connection closed")
+	// ErrBackendConnDesynced is replied to the request whose reply showed that the replies of a
+	// connection no longer match its requests
+	ErrBackendConnDesynced = errors.New("ERR backend connection out of sync")
 )
 
 type BackendConn struct {
-	Id     string
-	conn   net.Conn
+	Id   string
+	conn net.Conn
+	// wire is conn as seen by the writer, it counts the bytes sent
+	wire   *countingConn
 	reader *respio.RespReader
 	writer *respio.RespWriter
+	// leasedIO holds the reader and the writer, returned to the pool once the loops stopped
+	leasedIO *respio.RespIO
 	// pendingQ :The commands that have been sent to the backend but not yet match the response.
 	// A typical pattern is a FIFO structure that you push new request.
 	pendingQ chan *RequestContext
@@ -49,6 +72,29 @@ type BackendConn struct {
 	usedAt  int64
 	txLock  sync.RWMutex
 	wg      sync.WaitGroup
+	// stopped is closed once the loops stopped and the connection is released
+	stopped chan struct{}
+	// outstanding counts the requests enqueued and not answered yet, a request being written
+	// is in neither queue
+	outstanding atomic.Int64
+	// maxInFlight is the outstanding requests above which the sessions routed to the connection
+	// stop dispatching, 0 is no limit
+	maxInFlight atomic.Int64
+	// resume is closed once outstanding drops below maxInFlight, it is created by Saturated
+	resume   chan struct{}
+	resumeMu sync.Mutex
+	// retired is set once the connection outlived ConnMaxLifetime and was replaced in its pool
+	retired atomic.Bool
+	// desynced is set once a reply could not answer its request, the next replies are not read
+	desynced atomic.Bool
+	// resp3 is set while the connection speaks RESP3, a session switched it with HELLO 3
+	resp3 atomic.Bool
+	// onDesync is set by the pool before the connection is routed to, it replaces the connection
+	onDesync func(*BackendConn)
+	// health is shared with the other connections of the instance, it is set by the pool
+	health atomic.Pointer[instanceHealth]
+	// scripts are the shas of the scripts the connection accepted, tracked by the ReadLoop
+	scripts *xsync.MapOf[string, struct{}]
 	// instanceId field to track which backend instance this connection belongs to
 	instanceId string
 }
@@ -83,23 +129,34 @@ func NewBackendConn(timeout time.Duration, addr string, queueSize int) (*Backend
 		logger.Error(err, "Failed to new backend", "Addr", addr)
 		return nil, err
 	}
+	return newBackendConn(conn, addr, queueSize), nil
+}
+
+// newBackendConn wraps an established connection and starts its read/write loops.
+func newBackendConn(conn net.Conn, addr string, queueSize int) *BackendConn {
+	wire := &countingConn{Conn: conn}
+	leasedIO := respio.AcquireRespIO(wire)
 	serverConn := &BackendConn{
 		Id:         shortuuid.New(),
 		created:    time.Now(),
 		conn:       conn,
-		reader:     respio.NewRespReader(conn),
-		writer:     respio.NewRespWriter(conn),
+		wire:       wire,
+		reader:     leasedIO.Reader,
+		writer:     leasedIO.Writer,
+		leasedIO:   leasedIO,
 		writeQ:     make(chan *RequestContext, queueSize),
-		quit:       make(chan struct{}, 2),
+		quit:       make(chan struct{}),
 		pendingQ:   make(chan *RequestContext, queueSize),
 		wg:         sync.WaitGroup{},
+		stopped:    make(chan struct{}),
 		txLock:     sync.RWMutex{},
 		instanceId: addr,
 		closed:     atomic.Bool{},
+		scripts:    xsync.NewMapOf[string, struct{}](),
 	}
 	serverConn.wg.Add(2)
 	serverConn.start()
-	return serverConn, nil
+	return serverConn
 }
 
 func (bc *BackendConn) start() {
@@ -118,17 +175,22 @@ func (bc *BackendConn) drainWriteQ() {
 			if !ok {
 				return
 			}
+			if bc.desynced.Load() {
+				bc.answered()
+				pCtx.Session.deliver(NewErrResponseContext(pCtx, ErrBackendConnClosed))
+				continue
+			}
 			if err := bc.WriteAndFlush(pCtx.Request); err != nil {
-				errorPacket := respio.AcquireRespPacket()
-				errorPacket.Type = respio.RespError
-				errorPacket.Data = []byte(err.Error())
-
-				pCtx.Session.OutQ <- &ResponseContext{
-					Response: errorPacket,
-				}
+				bc.answered()
+				pCtx.Session.deliver(NewErrResponseContext(pCtx, err))
 				continue
 			}
-			bc.pendingQ <- pCtx
+			select {
+			case bc.pendingQ <- pCtx:
+			default:
+				bc.answered()
+				pCtx.Session.deliver(NewErrResponseContext(pCtx, ErrBackendConnClosed))
+			}
 		default:
 			// logger.Info("WriteQ is empty")
 			return
@@ -148,13 +210,18 @@ func (bc *BackendConn) drainPendingQ() {
 				return
 			}
 			packet, err := bc.reader.Read()
+			bc.answered()
 			if err != nil {
-				pCtx.Session.OutQ <- NewErrResponseContext(err)
+				pCtx.Session.deliver(NewErrResponseContext(pCtx, err))
 				continue
 			}
-			pCtx.Session.OutQ <- &ResponseContext{
-				Response: packet,
-			}
+			pCtx.Session.deliver(&ResponseContext{
+				RequestId:  pCtx.RequestId,
+				Response:   packet,
+				awaitReply: pCtx.awaitReply,
+				ordered:    pCtx.ordered,
+				seq:        pCtx.seq,
+			})
 		default:
 			// logger.Info("PendingQ is empty")
 			return
@@ -173,8 +240,117 @@ func (bc *BackendConn) WriteAndFlush(pkt *respio.RespPacket) error {
 	return bc.writer.Flush()
 }
 
+// writeRequest writes and flushes a request. A write failing before any byte reached the backend
+// is retried once, unless the connection is gone: the backend has not seen the command, so the
+// retry cannot run it twice. The error is fatal when the connection is gone or the backend got
+// part of the request, the next request would be read as the rest of it.
+func (bc *BackendConn) writeRequest(pkt *respio.RespPacket) (fatal bool, err error) {
+	sent := bc.wire.written
+	err = bc.WriteAndFlush(pkt)
+	if err == nil || common.IsBackendUnavailable(err) || bc.wire.written != sent {
+		return err != nil, err
+	}
+	logger.Info("BackendConn write failed before sending, retrying", "connId", bc.Id, "error", err)
+	// the partly encoded request and the error are dropped
+	bc.writer.Reset(bc.wire)
+	if err = bc.WriteAndFlush(pkt); err == nil {
+		return false, nil
+	}
+	if common.IsBackendUnavailable(err) || bc.wire.written != sent {
+		return true, err
+	}
+	// the next requests start from a clean buffer
+	bc.writer.Reset(bc.wire)
+	return false, err
+}
+
+// countingConn counts the bytes written to the connection. Only the WriteLoop writes.
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// Enqueue queues the request for the WriteLoop, the session gives the reply its place. A closed
+// connection answers it with an error right away, its loops are gone and a full writeQ would
+// block the caller forever.
 func (bc *BackendConn) Enqueue(pCtx *RequestContext) {
-	bc.writeQ <- pCtx
+	if !pCtx.NoReply && !pCtx.ordered {
+		pCtx.seq = pCtx.Session.expectReply()
+		pCtx.ordered = true
+	}
+	if bc.closed.Load() {
+		recordDrop(DropBackendGone)
+		pCtx.Session.deliver(NewErrResponseContext(pCtx, ErrBackendConnClosed))
+		return
+	}
+	bc.outstanding.Add(1)
+	select {
+	case bc.writeQ <- pCtx:
+		// the connection may have closed since the check, once stopped is closed no loop reads
+		// writeQ and closeAfterLoops may have drained it already
+		select {
+		case <-bc.stopped:
+			bc.failQueued()
+		default:
+		}
+	case <-bc.stopped:
+		bc.answered()
+		recordDrop(DropBackendGone)
+		pCtx.Session.deliver(NewErrResponseContext(pCtx, ErrBackendConnClosed))
+	}
+}
+
+// SetMaxInFlight sets the outstanding requests above which Saturated holds the sessions back,
+// 0 removes the limit.
+func (bc *BackendConn) SetMaxInFlight(limit int) {
+	bc.maxInFlight.Store(int64(limit))
+}
+
+// InFlight returns the number of requests enqueued and not answered yet.
+func (bc *BackendConn) InFlight() int {
+	return int(bc.outstanding.Load())
+}
+
+// Saturated returns nil while the connection takes more requests. Otherwise it returns a channel
+// closed once the requests in flight dropped below the limit, or the connection closed. The
+// limit is checked before a command is dispatched, the queues absorb the commands dispatched
+// by the sessions checking at the same time.
+func (bc *BackendConn) Saturated() <-chan struct{} {
+	limit := bc.maxInFlight.Load()
+	if limit <= 0 {
+		return nil
+	}
+	bc.resumeMu.Lock()
+	defer bc.resumeMu.Unlock()
+	if bc.closed.Load() || bc.outstanding.Load() < limit {
+		return nil
+	}
+	if bc.resume == nil {
+		bc.resume = make(chan struct{})
+	}
+	return bc.resume
+}
+
+// answered counts a request out, the sessions held back resume when it drops below the limit.
+func (bc *BackendConn) answered() {
+	if bc.outstanding.Add(-1) == bc.maxInFlight.Load()-1 {
+		bc.releaseSaturated()
+	}
+}
+
+func (bc *BackendConn) releaseSaturated() {
+	bc.resumeMu.Lock()
+	defer bc.resumeMu.Unlock()
+	if bc.resume != nil {
+		close(bc.resume)
+		bc.resume = nil
+	}
 }
 
 func (bc *BackendConn) WriteLoop() {
@@ -186,25 +362,33 @@ func (bc *BackendConn) WriteLoop() {
 		select {
 		case <-bc.quit:
 			logger.Info("BackendConn WriteLoop quit")
+			bc.drainWriteQ()
 			return
 		case pCtx, ok := <-bc.writeQ:
 			if !ok {
 				return
 			}
 			// logger.Info("BackendConn WriteLoop packet", "packet", pCtx.Request, "Id", bc.Id)
-			if err := bc.WriteAndFlush(pCtx.Request); err != nil {
-				logger.Error(err, "BackendConn Failed to write packet")
-				pCtx.Session.OutQ <- NewErrResponseContext(err)
-				if common.IsBackendUnavailable(err) {
+			pCtx.sentAt = time.Now()
+			if fatal, err := bc.writeRequest(pCtx.Request); err != nil {
+				logger.Error(err, "BackendConn Failed to write packet", "RequestId", pCtx.RequestId)
+				bc.answered()
+				pCtx.Session.deliver(NewErrResponseContext(pCtx, err))
+				if fatal {
 					logger.Info("BackendConn WriteLoop connection closed", "error", err)
 					bc.Clear()
 					return
 				}
 				continue
 			}
-			currTxState := bc.LoadTxnState()
-			if currTxState == nil {
-				bc.pendingQ <- pCtx
+			select {
+			case bc.pendingQ <- pCtx:
+			case <-bc.quit:
+				// the ReadLoop may be gone already
+				bc.answered()
+				pCtx.Session.deliver(NewErrResponseContext(pCtx, ErrBackendConnClosed))
+				bc.drainWriteQ()
+				return
 			}
 		}
 	}
@@ -219,9 +403,10 @@ func (bc *BackendConn) ReadLoop() {
 		select {
 		case <-bc.quit:
 			logger.Info("BackendConn ReadLoop quit")
+			bc.drainPendingQ()
 			return
 		default:
-			packet, err := bc.reader.Read()
+			packet, err := bc.readReply()
 			// logger.Info("BackendConn ReadLoop packet", "packet", packet, "Id", bc.Id)
 			if err != nil {
 				if common.IsBackendUnavailable(err) {
@@ -231,62 +416,223 @@ func (bc *BackendConn) ReadLoop() {
 				}
 				continue
 			}
-			currTxState := bc.LoadTxnState()
-			if currTxState != nil && currTxState.OwnerSession != nil {
-				currTxState.OwnerSession.OutQ <- &ResponseContext{
-					Response: packet,
-				}
-				if currTxState.State == respio.TxCmdStateEnd {
-					bc.ClearTxnState()
-				}
-				continue
-			}
-
+			// Requests in a transaction are matched like any other, a state decided when the
+			// request was written may already be stale when its reply arrives.
 			pCtx := <-bc.pendingQ
+			bc.answered()
+			if isDesyncedReply(packet) {
+				bc.recycleDesynced(pCtx, packet)
+				return
+			}
+			bc.health.Load().observe(packet, bc.instanceId)
+			if !pCtx.sentAt.IsZero() {
+				backendLatencies.Observe(bc.instanceId, time.Since(pCtx.sentAt))
+			}
+			bc.releaseTxnState(pCtx.Request)
+			if !isErrorReply(packet) {
+				bc.trackScript(pCtx.Request)
+			}
 			rspCtx := &ResponseContext{
-				Response: packet,
+				RequestId:  pCtx.RequestId,
+				Response:   packet,
+				Resp3:      bc.resp3.Load(),
+				awaitReply: pCtx.awaitReply,
+				ordered:    pCtx.ordered,
+				seq:        pCtx.seq,
 			}
-			// Safely check if session has auth info with password
-			authInfo := pCtx.Session.GetAuthInfo()
-			if authInfo != nil && authInfo.Password == nil {
-				// Update the complete auth info in the session
-				// This preserves the username set earlier but adds the password
-				// The username is needed for routing, the password for re-authentication
-				if pCtx.Request.IsAuthCmd() && packet.Type == respio.RespStatus &&
-					bytes.Equal(packet.Data, respio.OkCmd) {
-					rspCtx.Callback = func(session *Session) {
-						existingAuthInfo := session.GetAuthInfo()
-						if existingAuthInfo != nil {
-							session.SetAuthInfo(&common.AuthInfo{
-								Username: existingAuthInfo.Username,
-								Password: pCtx.AuthInfo.Password,
-							})
-						} else {
-							session.SetAuthInfo(pCtx.AuthInfo)
-						}
-					}
-				} else if pCtx.Request.IsAuthCmd() {
-					// auth failed
-					logger.Info("BackendConn ReadLoop auth failed", "packet", packet, "Id", bc.Id)
+			if pCtx.Request.IsAuthCmd() {
+				if pCtx.authCache != nil && pCtx.AuthInfo != nil {
+					pCtx.authCache.observe(pCtx.AuthInfo, packet)
+				}
+				if pCtx.onAuth != nil {
+					pCtx.onAuth(isAuthOK(packet))
+				}
+				rspCtx.Callback = bc.authReplyCallback(pCtx, packet, isAuthOK(packet))
+			} else if protoVer, ok := pCtx.Request.HelloProtoVer(); ok {
+				rspCtx.Callback = helloReplyCallback(protoVer, packet)
+				if pCtx.Request.IsHelloAuth() {
+					// the HELLO authenticated the client as well
+					rspCtx.Callback = chainCallbacks(rspCtx.Callback,
+						bc.authReplyCallback(pCtx, packet, !isErrorReply(packet)))
+				}
+				if !isErrorReply(packet) {
+					bc.resp3.Store(protoVer >= respio.Resp3)
 				}
 			}
-			pCtx.Session.OutQ <- rspCtx
+			if pCtx.NoReply {
+				if rspCtx.Callback != nil {
+					rspCtx.Callback(pCtx.Session)
+				}
+				respio.ReleaseRespPacket(packet)
+				continue
+			}
+			pCtx.Session.deliver(rspCtx)
 		}
 	}
 }
 
+// readReply reads the next reply from the backend. A large reply (a big value or a huge
+// LRANGE) is only forwarded by the proxy, so it is captured as raw bytes with
+// RespReader.ReadRaw rather than materialized as one packet per element. The reply is still
+// buffered whole, only the packet tree and its encoding are saved: it is written to the client
+// by the ReplyLoop of its session, so that a slow client does not hold the replies of the
+// sessions sharing the connection.
+func (bc *BackendConn) readReply() (*respio.RespPacket, error) {
+	marker, length, err := bc.reader.PeekMessageLen()
+	if err != nil {
+		return nil, err
+	}
+	if !isLargeReply(marker, length) {
+		return bc.reader.Read()
+	}
+	raw, err := bc.reader.ReadRaw()
+	if err != nil {
+		return nil, err
+	}
+	packet := respio.AcquireRespPacket()
+	packet.Type = respio.RespRaw
+	packet.Data = raw
+	return packet, nil
+}
+
+// isDesyncedReply reports whether the reply cannot answer any request of a shared connection. The
+// proxy rejects CLIENT TRACKING and keeps no shared connection subscribed, so a push is a reply
+// matched to the wrong request, or data the backend sent unasked.
+func isDesyncedReply(reply *respio.RespPacket) bool {
+	return reply.Type == respio.RespPush
+}
+
+// recycleDesynced fails the request instead of answering it with the reply of another one, and
+// closes the connection without reading the replies of the pending requests, they get an error
+// as well. The connection is retired so that its sessions move with their next command, and
+// the pool dials its replacement.
+func (bc *BackendConn) recycleDesynced(pCtx *RequestContext, reply *respio.RespPacket) {
+	logger.Info("BackendConn replies out of sync, recycling the connection", "connId", bc.Id,
+		"Addr", bc.instanceId, "RequestId", pCtx.RequestId, "ReplyType", string(reply.Type))
+	respio.ReleaseRespPacket(reply)
+	bc.desynced.Store(true)
+	bc.Retire()
+	if !pCtx.NoReply {
+		pCtx.Session.deliver(NewErrResponseContext(pCtx, ErrBackendConnDesynced))
+	}
+	bc.Clear()
+	if bc.onDesync != nil {
+		go bc.onDesync(bc)
+	}
+}
+
+func isLargeReply(marker byte, length int64) bool {
+	switch marker {
+	case respio.RespString, respio.RespBlobError, respio.RespVerbatim:
+		return length >= largeReplyBytes
+	case respio.RespArray, respio.RespMap, respio.RespSet, respio.RespPush:
+		return length >= largeReplyElements
+	default:
+		return false
+	}
+}
+
+// helloReplyCallback records the protocol version once the backend has accepted a HELLO.
+func helloReplyCallback(protoVer int, reply *respio.RespPacket) func(*Session) {
+	if reply.Type == respio.RespError || reply.Type == respio.RespBlobError {
+		return nil
+	}
+	return func(session *Session) {
+		session.setProtoVersion(protoVer)
+	}
+}
+
+func isAuthOK(reply *respio.RespPacket) bool {
+	return reply.Type == respio.RespStatus && bytes.Equal(reply.Data, respio.OkCmd)
+}
+
+// chainCallbacks runs the callbacks in turn, a nil one is skipped.
+func chainCallbacks(first, second func(*Session)) func(*Session) {
+	if first == nil {
+		return second
+	}
+	if second == nil {
+		return first
+	}
+	return func(session *Session) {
+		first(session)
+		second(session)
+	}
+}
+
+// authReplyCallback updates the session auth state once the backend has answered an AUTH, or
+// a HELLO with the AUTH option.
+// On success the session keeps the verified credentials: the username is needed for routing,
+// the password for re-authentication. On failure the routing-only auth info set by the
+// dispatcher is dropped, so the client is not treated as authenticated and can retry.
+func (bc *BackendConn) authReplyCallback(reqCtx *RequestContext, reply *respio.RespPacket, ok bool) func(*Session) {
+	authInfo := reqCtx.AuthInfo
+	if authInfo == nil {
+		return nil
+	}
+	if ok {
+		return func(session *Session) {
+			session.SetAuthInfo(&common.AuthInfo{
+				Username: authInfo.Username,
+				Password: authInfo.Password,
+			})
+		}
+	}
+	logger.Info("BackendConn ReadLoop auth failed", "packet", reply, "Id", bc.Id,
+		"RequestId", reqCtx.RequestId)
+	return func(session *Session) {
+		session.ResetPendingAuth()
+	}
+}
+
 func (bc *BackendConn) LoadTxnState() *TxState {
 	bc.txLock.RLock()
 	defer bc.txLock.RUnlock()
 	return bc.txState
 }
 
+// UpdateTxnState moves the transaction state with a WATCH, UNWATCH, MULTI, EXEC or DISCARD.
+// The connection is pinned to the session from WATCH or MULTI until the reply of the command
+// ending the state.
 func (bc *BackendConn) UpdateTxnState(session *Session, stateType respio.TxCmdStateType) {
 	bc.txLock.Lock()
 	defer bc.txLock.Unlock()
-	bc.txState = &TxState{
+	switch stateType {
+	case respio.TxCmdStateUnwatch:
+		// inside MULTI the UNWATCH is only queued
+		if bc.txState == nil || bc.txState.State != respio.TxCmdStateWatch {
+			return
+		}
+		stateType = respio.TxCmdStateEnd
+	case respio.TxCmdStateWatch:
+		// WATCH inside MULTI is an error and does not leave the transaction
+		if bc.txState != nil && bc.txState.State == respio.TxCmdStateBegin {
+			return
+		}
+	}
+	now := time.Now()
+	txState := &TxState{
 		OwnerSession: session,
 		State:        stateType,
+		Since:        now,
+	}
+	if bc.txState.Active() && bc.txState.OwnerSession == session {
+		// e.g. the MULTI after a WATCH
+		txState.Since = bc.txState.Since
+	}
+	if stateType == respio.TxCmdStateBegin {
+		txState.StartedAt = now
+	}
+	bc.txState = txState
+}
+
+// releaseTxnState unpins the connection once the command ending the transaction is answered.
+func (bc *BackendConn) releaseTxnState(request *respio.RespPacket) {
+	if _, state, ok := request.IsTxCmd(); !ok || state == respio.TxCmdStateBegin || state == respio.TxCmdStateWatch {
+		return
+	}
+	if txState := bc.LoadTxnState(); txState != nil && txState.State == respio.TxCmdStateEnd {
+		bc.ClearTxnState()
 	}
 }
 
@@ -296,21 +642,117 @@ func (bc *BackendConn) ClearTxnState() {
 	bc.txState = nil
 }
 
-func (bc *BackendConn) Buffered() int {
-	return bc.reader.Buffered()
+// WriteQLen returns the number of requests waiting to be written to the backend.
+func (bc *BackendConn) WriteQLen() int {
+	return len(bc.writeQ)
+}
+
+// PendingQLen returns the number of requests written to the backend and waiting for a reply.
+func (bc *BackendConn) PendingQLen() int {
+	return len(bc.pendingQ)
 }
 
-func (bc *BackendConn) drainQueues() {
-	bc.drainWriteQ()
-	bc.drainPendingQ()
+// IsIdle reports whether every request enqueued has been answered, or the connection is
+// closed and its requests failed.
+func (bc *BackendConn) IsIdle() bool {
+	return bc.closed.Load() || bc.outstanding.Load() == 0
 }
 
+// Retire marks a connection replaced in its pool. The sessions bound to it move to another
+// connection with their next command, unless they are in a transaction.
+func (bc *BackendConn) Retire() {
+	bc.retired.Store(true)
+}
+
+func (bc *BackendConn) IsRetired() bool {
+	return bc.retired.Load()
+}
+
+// InstanceUnavailable reports whether the instance of the connection answered LOADING or BUSY
+// lately, its sessions move to another instance with their next command.
+func (bc *BackendConn) InstanceUnavailable() bool {
+	return bc.health.Load().IsUnavailable()
+}
+
+// trackScript records the scripts loaded on the connection by a command it answered.
+func (bc *BackendConn) trackScript(packet *respio.RespPacket) {
+	switch cmdType, arg := classifyScriptCmd(packet); cmdType {
+	case scriptCmdLoad:
+		bc.scripts.Store(scriptSha(arg), struct{}{})
+	case scriptCmdFlush:
+		bc.scripts.Clear()
+	}
+}
+
+// hasScript reports whether the script with the sha was run on the connection.
+func (bc *BackendConn) hasScript(sha []byte) bool {
+	_, ok := bc.scripts.Load(strings.ToLower(string(sha)))
+	return ok
+}
+
+// Buffered returns the reply bytes read ahead, 0 once the connection is closed: its reader may
+// be leased by another connection.
+func (bc *BackendConn) Buffered() int {
+	if bc.closed.Load() {
+		return 0
+	}
+	return bc.reader.Buffered()
+}
+
+// Clear stops the loops. Each loop drains its own queue, the deadline bounds both the
+// blocked I/O of the loops and the drains. Only the first call does anything, it is called by
+// the loops on an I/O error and by Close concurrently.
 func (bc *BackendConn) Clear() {
 	if !bc.closed.Swap(true) {
 		close(bc.quit)
-		bc.drainQueues()
-		bc.innerClose()
+		if bc.conn != nil {
+			deadline := time.Now()
+			if bc.WriteQLen() > 0 || bc.PendingQLen() > 0 {
+				deadline = deadline.Add(drainTimeout)
+			}
+			_ = bc.conn.SetDeadline(deadline)
+		}
+		go bc.closeAfterLoops()
+	}
+}
+
+// closeAfterLoops releases the connection once both loops stopped. The requests they could not
+// drain in time get an error, so no client waits for a reply forever. The queues are drained
+// again once stopped is closed, for the requests Enqueue pushed meanwhile.
+func (bc *BackendConn) closeAfterLoops() {
+	bc.wg.Wait()
+	failed := bc.failQueued()
+	bc.innerClose()
+	respio.ReleaseRespIO(bc.leasedIO)
+	close(bc.stopped)
+	failed += bc.failQueued()
+	if failed > 0 {
+		logger.Info("BackendConn failed the undrained requests", "connId", bc.Id, "Requests", failed)
+	}
+	bc.releaseSaturated()
+}
+
+// failQueued answers the requests left in the queues of the stopped loops with an error. Enqueue
+// and closeAfterLoops may drain at the same time, every request is taken once.
+func (bc *BackendConn) failQueued() int {
+	failed := 0
+	for _, queue := range []chan *RequestContext{bc.writeQ, bc.pendingQ} {
+		for {
+			var pCtx *RequestContext
+			select {
+			case pCtx = <-queue:
+			default:
+			}
+			if pCtx == nil {
+				break
+			}
+			bc.answered()
+			recordDrop(DropBackendGone)
+			pCtx.Session.deliver(NewErrResponseContext(pCtx, ErrBackendConnClosed))
+			failed++
+		}
 	}
+	return failed
 }
 
 func (bc *BackendConn) innerClose() {
@@ -320,16 +762,13 @@ func (bc *BackendConn) innerClose() {
 	}
 }
 
+// Close stops the connection and waits up to a second for its loops. It can be called many
+// times and from many goroutines, every call waits for the same shutdown.
 func (bc *BackendConn) Close() error {
 	bc.Clear()
 	// Wait for goroutines with timeout
-	done := make(chan struct{})
-	go func() {
-		bc.wg.Wait()
-		done <- struct{}{}
-	}()
 	select {
-	case <-done:
+	case <-bc.stopped:
 		logger.Info("BackendConn shutdown completed", "connId", bc.Id)
 		return nil
 	case <-time.After(1 * time.Second):
@@ -354,6 +793,14 @@ func (bc *BackendConn) RemoteAddr() net.Addr {
 	return nil
 }
 
+// ProtoVersion returns the protocol version the connection speaks.
+func (bc *BackendConn) ProtoVersion() int {
+	if bc.resp3.Load() {
+		return respio.Resp3
+	}
+	return respio.Resp2
+}
+
 func (bc *BackendConn) EnsureAuth(authPacket *respio.RespPacket, sessionAuthInfo *common.AuthInfo) (*respio.RespPacket, error) {
 	// Create a channel for the response
 	responseCh := make(chan struct {
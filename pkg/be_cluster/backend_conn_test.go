@@ -0,0 +1,416 @@
+package be_cluster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPipeBackendConn returns a BackendConn wired to an in-memory fake backend that
+// answers every request with the packet returned by handler.
+func newPipeBackendConn(t *testing.T, handler func(req *respio.RespPacket) *respio.RespPacket) *BackendConn {
+	return newPipeBackendConnAt(t, "pipe", handler)
+}
+
+func newPipeBackendConnAt(t *testing.T, addr string, handler func(req *respio.RespPacket) *respio.RespPacket) *BackendConn {
+	proxySide, backendSide := net.Pipe()
+	go serveBackendPipe(backendSide, handler)
+	bc := newBackendConn(proxySide, addr, 16)
+	t.Cleanup(func() {
+		_ = bc.Close()
+		_ = backendSide.Close()
+	})
+	return bc
+}
+
+// serveBackendPipe answers every request read from the backend side of a pipe until it closes.
+func serveBackendPipe(backendSide net.Conn, handler func(req *respio.RespPacket) *respio.RespPacket) {
+	reader := respio.NewRespReader(backendSide)
+	writer := respio.NewRespWriter(backendSide)
+	for {
+		req, err := reader.Read()
+		if err != nil {
+			return
+		}
+		if err := writer.Write(handler(req)); err != nil {
+			return
+		}
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// newPipeSession returns a session whose replies can be read from the returned reader.
+func newPipeSession(t *testing.T, id string) (*Session, *respio.RespReader) {
+	clientSide, proxySide := net.Pipe()
+	session := NewSession(id, proxySide, 16)
+	go session.ReplyLoop()
+	t.Cleanup(func() {
+		session.Close()
+		_ = clientSide.Close()
+	})
+	return session, respio.NewRespReader(clientSide)
+}
+
+func TestBackendConn_AuthRetry(t *testing.T) {
+	password := []byte("admin")
+	bc := newPipeBackendConn(t, func(req *respio.RespPacket) *respio.RespPacket {
+		if req.IsAuthCmd() && !bytes.Equal(req.Array[len(req.Array)-1].Data, password) {
+			return &respio.RespPacket{Type: respio.RespError, Data: []byte("WRONGPASS invalid username-password pair")}
+		}
+		return &respio.RespPacket{Type: respio.RespStatus, Data: respio.OkCmd}
+	})
+	session, clientReader := newPipeSession(t, "auth-retry")
+	username := []byte("admin")
+
+	auth := func(pass []byte) *respio.RespPacket {
+		// the dispatcher sets the routing auth info before forwarding
+		session.SetAuthInfo(&common.AuthInfo{Username: username})
+		authInfo := &common.AuthInfo{Username: username, Password: pass}
+		bc.Enqueue(&RequestContext{
+			Session:  session,
+			Request:  respio.NewAuthPacket(username, pass),
+			AuthInfo: authInfo,
+		})
+		reply, err := clientReader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+
+	reply := auth([]byte("wrong"))
+	assert.Equal(t, respio.RespError, reply.Type)
+	assert.False(t, session.IsAuthenticated())
+
+	reply = auth(password)
+	assert.Equal(t, respio.RespStatus, reply.Type)
+	assert.True(t, session.IsAuthenticated())
+	assert.Equal(t, password, session.GetAuthInfo().Password)
+}
+
+func TestBackendConn_TxnStartedAt(t *testing.T) {
+	bc := newPipeBackendConn(t, func(req *respio.RespPacket) *respio.RespPacket {
+		return respio.NewStatus(string(respio.OkCmd))
+	})
+	session, clientReader := newPipeSession(t, "txn")
+	send := func(args ...string) {
+		packet := respio.NewCommand(args...)
+		if _, state, ok := packet.IsTxCmd(); ok {
+			bc.UpdateTxnState(session, state)
+		}
+		bc.Enqueue(&RequestContext{RequestId: NextRequestId(), Session: session, Request: packet})
+		_, err := clientReader.Read()
+		assert.NoError(t, err)
+	}
+
+	// a WATCH pins the connection, the transaction starts with MULTI
+	send("WATCH", "k")
+	assert.True(t, bc.LoadTxnState().StartedAt.IsZero())
+	beforeMulti := time.Now()
+	send("MULTI")
+	txState := bc.LoadTxnState()
+	assert.False(t, txState.StartedAt.Before(beforeMulti))
+	assert.True(t, txState.Since.Before(txState.StartedAt) || txState.Since.Equal(txState.StartedAt))
+	send("SET", "k", "v")
+	assert.Equal(t, txState.StartedAt, bc.LoadTxnState().StartedAt)
+
+	send("EXEC")
+	assert.Eventually(t, func() bool { return bc.LoadTxnState() == nil }, time.Second, 10*time.Millisecond)
+}
+
+func TestBackendConn_LargeReply(t *testing.T) {
+	largeValue := bytes.Repeat([]byte("v"), int(largeReplyBytes))
+	bc := newPipeBackendConn(t, func(req *respio.RespPacket) *respio.RespPacket {
+		return &respio.RespPacket{Type: respio.RespString, Data: largeValue}
+	})
+	session, clientReader := newPipeSession(t, "large-reply")
+	bc.Enqueue(&RequestContext{Session: session, Request: respio.NewCommand("GET", "big")})
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespString, reply.Type)
+	assert.Equal(t, largeValue, reply.Data)
+}
+
+func TestBackendConn_LargeReplyNoHeadOfLineBlocking(t *testing.T) {
+	largeValue := bytes.Repeat([]byte("v"), 4*int(largeReplyBytes))
+	bc := newPipeBackendConn(t, func(req *respio.RespPacket) *respio.RespPacket {
+		if string(req.Array[1].Data) == "big" {
+			return &respio.RespPacket{Type: respio.RespString, Data: largeValue}
+		}
+		return echoKey(req)
+	})
+	// the client of the large reply reads nothing until the end, its ReplyLoop is stuck writing
+	slow, slowReader := newPipeSession(t, "slow-client")
+	fast, fastReader := newPipeSession(t, "co-tenant")
+	bc.Enqueue(&RequestContext{Session: slow, Request: respio.NewCommand("GET", "big")})
+	for i := 0; i < 10; i++ {
+		bc.Enqueue(&RequestContext{Session: fast, Request: respio.NewCommand("GET", fmt.Sprintf("k%d", i))})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			reply, err := fastReader.Read()
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("k%d", i), string(reply.Data))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the replies of the co-tenant are starved by the large reply")
+	}
+
+	reply, err := slowReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, largeValue, reply.Data)
+}
+
+func TestBackendConn_TeardownFailsQueued(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend func(conn net.Conn)
+		queued  func(bc *BackendConn) bool
+	}{
+		// the requests are written and wait in pendingQ
+		{"no reply", func(conn net.Conn) { _, _ = io.Copy(io.Discard, conn) },
+			func(bc *BackendConn) bool { return bc.PendingQLen() == 3 }},
+		// the first write blocks, the others wait in writeQ
+		{"no read", func(conn net.Conn) {},
+			func(bc *BackendConn) bool { return bc.WriteQLen() == 2 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxySide, backendSide := net.Pipe()
+			go tt.backend(backendSide)
+			defer backendSide.Close()
+			bc := newBackendConn(proxySide, "pipe", 16)
+
+			var clientReaders []*respio.RespReader
+			for i := 0; i < 3; i++ {
+				session, clientReader := newPipeSession(t, fmt.Sprintf("%s-%d", tt.name, i))
+				bc.Enqueue(&RequestContext{Session: session, Request: respio.NewCommand("GET", "k")})
+				clientReaders = append(clientReaders, clientReader)
+			}
+			assert.Eventually(t, func() bool { return tt.queued(bc) }, time.Second, 10*time.Millisecond)
+			_ = bc.Close()
+
+			for _, clientReader := range clientReaders {
+				reply, err := clientReader.Read()
+				assert.NoError(t, err)
+				assert.Equal(t, respio.RespError, reply.Type)
+			}
+		})
+	}
+}
+
+func TestBackendConn_ConcurrentClose(t *testing.T) {
+	bc := newPipeBackendConn(t, echoKey)
+	session, clientReader := newPipeSession(t, "concurrent-close")
+	bc.Enqueue(&RequestContext{Session: session, Request: respio.NewCommand("GET", "a")})
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(reply.Data))
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			assert.NoError(t, bc.Close())
+		}()
+	}
+	// the ReadLoop may clear the connection at the same time
+	close(start)
+	bc.Clear()
+	wg.Wait()
+
+	select {
+	case <-bc.stopped:
+	default:
+		assert.Fail(t, "loops still running")
+	}
+	// closing again returns at once
+	began := time.Now()
+	assert.NoError(t, bc.Close())
+	assert.Less(t, time.Since(began), 100*time.Millisecond)
+
+	// a request enqueued after the close is answered, not lost in writeQ
+	bc.Enqueue(&RequestContext{Session: session, Request: respio.NewCommand("GET", "b")})
+	reply, err = clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespError, reply.Type)
+	assert.Equal(t, ErrBackendConnClosed.Error(), string(reply.Data))
+}
+
+func TestBackendConn_EnqueueRacingClose(t *testing.T) {
+	bc := newPipeBackendConn(t, echoKey)
+	session, clientReader := newPipeSession(t, "enqueue-racing-close")
+	assert.NoError(t, bc.Close())
+	// as if every Enqueue passed the closed check right before the close, writeQ has room and
+	// its select may pick the send
+	bc.closed.Store(false)
+	defer bc.closed.Store(true)
+	for i := 0; i < 20; i++ {
+		bc.Enqueue(&RequestContext{Session: session, Request: respio.NewCommand("GET", "k")})
+		replied := make(chan *respio.RespPacket, 1)
+		go func() {
+			reply, _ := clientReader.Read()
+			replied <- reply
+		}()
+		select {
+		case reply := <-replied:
+			assert.Equal(t, ErrBackendConnClosed.Error(), string(reply.Data))
+		case <-time.After(time.Second):
+			t.Fatal("a request enqueued during the close was left in writeQ")
+		}
+	}
+	assert.Equal(t, 0, bc.WriteQLen())
+	assert.Equal(t, 0, bc.InFlight())
+}
+
+func TestBackendConn_MaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	bc := newPipeBackendConn(t, func(req *respio.RespPacket) *respio.RespPacket {
+		<-release
+		return echoKey(req)
+	})
+	bc.SetMaxInFlight(2)
+	session, clientReader := newPipeSession(t, "max-in-flight")
+	assert.Nil(t, bc.Saturated())
+
+	for _, key := range []string{"a", "b"} {
+		bc.Enqueue(&RequestContext{Session: session, Request: respio.NewCommand("GET", key)})
+	}
+	assert.Equal(t, 2, bc.InFlight())
+	resume := bc.Saturated()
+	assert.NotNil(t, resume)
+	// every caller waits on the same channel
+	assert.Equal(t, resume, bc.Saturated())
+
+	release <- struct{}{}
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(reply.Data))
+	select {
+	case <-resume:
+	case <-time.After(time.Second):
+		assert.Fail(t, "saturated connection not released")
+	}
+	assert.Nil(t, bc.Saturated())
+	assert.Equal(t, 1, bc.InFlight())
+
+	close(release)
+	reply, err = clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(reply.Data))
+}
+
+// flakyConn fails the first writes without sending anything.
+type flakyConn struct {
+	net.Conn
+	failures atomic.Int32
+}
+
+func (c *flakyConn) Write(p []byte) (int, error) {
+	if c.failures.Add(-1) >= 0 {
+		return 0, errors.New("transient write error")
+	}
+	return c.Conn.Write(p)
+}
+
+func TestBackendConn_RetryUnsentWrite(t *testing.T) {
+	var received atomic.Int32
+	newFlakyConn := func(failures int32) *BackendConn {
+		proxySide, backendSide := net.Pipe()
+		go serveBackendPipe(backendSide, func(req *respio.RespPacket) *respio.RespPacket {
+			received.Add(1)
+			return respio.NewInteger(int64(received.Load()))
+		})
+		conn := &flakyConn{Conn: proxySide}
+		conn.failures.Store(failures)
+		bc := newBackendConn(conn, "flaky", 16)
+		t.Cleanup(func() {
+			_ = bc.Close()
+			_ = backendSide.Close()
+		})
+		return bc
+	}
+	session, clientReader := newPipeSession(t, "flaky")
+	incr := func(bc *BackendConn) *respio.RespPacket {
+		bc.Enqueue(&RequestContext{RequestId: NextRequestId(), Session: session, Request: respio.NewCommand("INCR", "k")})
+		reply, err := clientReader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+
+	// a transient error, the retry goes through and the command runs once
+	bc := newFlakyConn(1)
+	assert.Equal(t, respio.NewInteger(1).Data, incr(bc).Data)
+	assert.Equal(t, int32(1), received.Load())
+
+	// the retry fails as well, the client gets the error and the connection keeps working
+	bc = newFlakyConn(2)
+	reply := incr(bc)
+	assert.Equal(t, respio.RespError, reply.Type)
+	assert.Equal(t, "transient write error", string(reply.Data))
+	assert.Equal(t, int32(1), received.Load())
+	// the failed request is counted out
+	assert.Equal(t, 0, bc.InFlight())
+	assert.Equal(t, respio.NewInteger(2).Data, incr(bc).Data)
+	assert.Equal(t, int32(2), received.Load())
+	assert.Equal(t, 0, bc.InFlight())
+}
+
+// partialConn sends half of the first write and fails it.
+type partialConn struct {
+	net.Conn
+	failed atomic.Bool
+}
+
+func (c *partialConn) Write(p []byte) (int, error) {
+	if c.failed.CompareAndSwap(false, true) {
+		n, _ := c.Conn.Write(p[:len(p)/2])
+		return n, errors.New("transient write error")
+	}
+	return c.Conn.Write(p)
+}
+
+func TestBackendConn_PartialWriteClosesConn(t *testing.T) {
+	proxySide, backendSide := net.Pipe()
+	go serveBackendPipe(backendSide, func(req *respio.RespPacket) *respio.RespPacket {
+		return respio.NewStatus("OK")
+	})
+	bc := newBackendConn(&partialConn{Conn: proxySide}, "partial", 16)
+	t.Cleanup(func() {
+		_ = bc.Close()
+		_ = backendSide.Close()
+	})
+	session, clientReader := newPipeSession(t, "partial")
+
+	bc.Enqueue(&RequestContext{RequestId: NextRequestId(), Session: session, Request: respio.NewCommand("SET", "k", "v")})
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespError, reply.Type)
+	// the backend got half a command, the connection is not reused
+	select {
+	case <-bc.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the connection was not closed after a partial write")
+	}
+	assert.True(t, bc.closed.Load())
+	assert.Equal(t, 0, bc.InFlight())
+}
@@ -0,0 +1,56 @@
+package be_cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DialFailureCause tells why a backend connection could not be established.
+type DialFailureCause string
+
+const (
+	DialFailTimeout DialFailureCause = "timeout"
+	DialFailRefused DialFailureCause = "refused"
+	DialFailOther   DialFailureCause = "other"
+)
+
+// DialListener observes every dial of a backend connection, err is nil on success.
+type DialListener func(addr string, latency time.Duration, err error)
+
+var dialListener atomic.Pointer[DialListener]
+
+// SetDialListener must be called before the pools dial their backends, nil removes the listener.
+func SetDialListener(listener DialListener) {
+	if listener == nil {
+		dialListener.Store(nil)
+		return
+	}
+	dialListener.Store(&listener)
+}
+
+// DialFailureCauseOf classifies a dial error.
+func DialFailureCauseOf(err error) DialFailureCause {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return DialFailTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return DialFailRefused
+	default:
+		return DialFailOther
+	}
+}
+
+// dial establishes a connection with the Dialer of the pool and reports its latency.
+func (p *BackendPool) dial(ctx context.Context) (*BackendConn, error) {
+	start := time.Now()
+	conn, err := p.cfg.Dialer(ctx)
+	if listener := dialListener.Load(); listener != nil {
+		(*listener)(p.cfg.Addr, time.Since(start), err)
+	}
+	return conn, err
+}
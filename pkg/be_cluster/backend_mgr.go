@@ -1,16 +1,35 @@
 package be_cluster
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/puzpuzpuz/xsync/v3"
 	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+const (
+	// drainCheckInterval is how often a draining instance is checked for requests in flight
+	drainCheckInterval = 100 * time.Millisecond
+	// drainMaxWait bounds the drain of an instance, a transaction still open is aborted then
+	drainMaxWait = 10 * time.Minute
 )
 
 var (
 	mgrOnce sync.Once
 	mgr     *BackendManager
+
+	// ErrBackendsNotReady is returned while no backend cluster is online yet, e.g. the control
+	// plane has not pushed any cluster. Like Redis's LOADING, clients are expected to retry.
+	ErrBackendsNotReady = errors.New("LOADING backends not ready")
+	// poolReadyTimeout bounds the fill of the pool of an instance coming online, the change
+	// notify handles no other change meanwhile
+	poolReadyTimeout = 30 * time.Second
 )
 
 type BackendManager struct {
@@ -19,76 +38,266 @@ type BackendManager struct {
 	config        *common.ProxyConfig
 	instancePool  *xsync.MapOf[string, *FixedPool]
 	clusterKeyMap *xsync.MapOf[string, *ClusterKey]
+	// registry holds the explicit tenant bindings, they win over the owner of an instance
+	registry ClusterRegistry
+	// draining holds the instances sessions are being moved off, new routes avoid them
+	draining *xsync.MapOf[string, struct{}]
+	// stopNotify stops the goroutines started by PrepareCluster, notifyDone is closed once the
+	// change notify returned
+	stopNotify context.CancelFunc
+	notifyDone chan struct{}
+	// prober caches the last PING of every instance
+	prober backendProber
 }
 
 func GetBackendManager(config *common.ProxyConfig) *BackendManager {
 	mgrOnce.Do(func() {
-		mgr = &BackendManager{
-			config:        config,
-			router:        NewBackendRouter(config),
-			balancerRef:   NewBalancer(GetBalancerType(&config.Router)),
-			instancePool:  xsync.NewMapOf[string, *FixedPool](),
-			clusterKeyMap: xsync.NewMapOf[string, *ClusterKey](),
-		}
+		mgr = newBackendManager(config, NewBackendRouter(config))
 		mgr.PrepareCluster()
 	})
 	return mgr
 }
 
+func newBackendManager(config *common.ProxyConfig, router BackendRouter) *BackendManager {
+	return &BackendManager{
+		config:        config,
+		router:        router,
+		balancerRef:   NewBalancer(GetBalancerType(&config.Router)),
+		instancePool:  xsync.NewMapOf[string, *FixedPool](),
+		clusterKeyMap: xsync.NewMapOf[string, *ClusterKey](),
+		registry:      GetClusterRegistry(),
+		draining:      xsync.NewMapOf[string, struct{}](),
+	}
+}
+
 func (m *BackendManager) backendOffline(instance *ClusterInstance) {
 	logger.Info("ProxySrv Backend offline", "instance", instance.GetAddr())
+	m.draining.Delete(instance.GetAddr())
+	backendLatencies.Remove(instance.GetAddr())
 	offlinePool, ok := m.instancePool.LoadAndDelete(instance.GetAddr())
 	if ok {
 		_ = offlinePool.Close()
 	}
 }
 
-func (m *BackendManager) backendOnline(instance *ClusterInstance) {
+// backendDraining stops routing new sessions to the instance and moves its sessions off at their
+// next command, a transaction in progress finishes on it first. Unlike backendOffline, the pool is
+// closed only once no request is in flight and no transaction holds a connection.
+func (m *BackendManager) backendDraining(instance *ClusterInstance) {
+	addr := instance.GetAddr()
+	pool, ok := m.instancePool.Load(addr)
+	if !ok {
+		logger.Info("ProxySrv Backend to drain is not online", "instance", addr)
+		return
+	}
+	if !pool.drain() {
+		logger.Info("ProxySrv Backend already draining", "instance", addr)
+		return
+	}
+	logger.Info("ProxySrv Backend draining", "instance", addr)
+	m.DrainInstance(addr)
+	go m.closeWhenQuiesced(addr, pool, drainCheckInterval, drainMaxWait)
+}
+
+// closeWhenQuiesced closes the drained pool once it is idle, or after maxWait. The pool is taken
+// out of the instances unless it was replaced meanwhile, the instance being ready again. The
+// instance stays marked draining until it is ready or offline, the router still selects it.
+func (m *BackendManager) closeWhenQuiesced(addr string, pool *FixedPool, interval, maxWait time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(maxWait)
+	// the first check waits an interval, a Forward that loaded a connection before the drain
+	// may still enqueue to it
+	quiesced := false
+	for !quiesced && time.Now().Before(deadline) {
+		<-ticker.C
+		quiesced = pool.quiesced()
+	}
+	m.instancePool.Compute(addr, func(current *FixedPool, loaded bool) (*FixedPool, bool) {
+		return current, !loaded || current == pool
+	})
+	_ = pool.Close()
+	logger.Info("ProxySrv drained Backend closed", "instance", addr, "Quiesced", quiesced)
+}
+
+func (m *BackendManager) backendOnline(ctx context.Context, instance *ClusterInstance) {
 	logger.Info("ProxySrv Backend online", "instance", instance.GetAddr())
-	_, ok := m.instancePool.Load(instance.GetAddr())
-	if ok {
+	existing, ok := m.instancePool.Load(instance.GetAddr())
+	if ok && !existing.isDraining() {
 		logger.Info("ProxySrv Backend already online", "instance", instance.GetAddr())
 		return
 	}
+	if ok {
+		// the draining pool is closed once idle, the sessions move to the new one
+		logger.Info("ProxySrv Backend ready again while draining", "instance", instance.GetAddr())
+	}
 	tenantKeyStr := instance.EncodeClusterKey()
 	tenantCode, _ := common.DecodeBase62(tenantKeyStr)
 	logger.Info("ProxySrv BeMgr TenantKeyOnline", "TenantCode", tenantCode)
 	m.clusterKeyMap.Store(instance.Owner, &instance.Key)
 	poolCfg := NewFixedPoolCfgFromBackend(instance, m.config)
+	if !m.config.BeConnPool.IsFixed {
+		poolCfg = NewDefaultPoolCfgFromBackend(instance, m.config)
+	}
 	pool := NewFixedPool(poolCfg)
-	pool.WaitPoolReady()
+	readyCtx, cancel := context.WithTimeout(ctx, poolReadyTimeout)
+	defer cancel()
+	if err := pool.WaitPoolReady(readyCtx); err != nil {
+		// the instance stays offline until the router reports it ready again
+		logger.Error(err, "ProxySrv Backend pool not ready, closing it", "instance", instance.GetAddr(),
+			"Timeout", poolReadyTimeout)
+		_ = pool.Close()
+		return
+	}
 	m.instancePool.Store(instance.GetAddr(), pool)
+	m.draining.Delete(instance.GetAddr())
 }
 
+// PrepareCluster follows the backend changes of the router until Close.
 func (m *BackendManager) PrepareCluster() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stopNotify = cancel
+	m.notifyDone = make(chan struct{})
 	go func(r BackendRouter) {
-		r.BackendChangeNotify(func(instance *ClusterInstance) {
+		defer close(m.notifyDone)
+		r.BackendChangeNotify(ctx, func(instance *ClusterInstance) {
 			status := instance.Status
 			if status == ClusterStatusReady {
-				m.backendOnline(instance)
+				m.backendOnline(ctx, instance)
 			} else if status == ClusterStatusOffline {
 				m.backendOffline(instance)
+			} else if status == ClusterStatusDraining {
+				m.backendDraining(instance)
 			} else {
 				logger.Info("Backend status not ready", "status", status)
 			}
 		})
 	}(m.router)
+	if m.config.Router.ReadyGrace > 0 {
+		go m.warnIfNotReady(ctx, m.config.Router.ReadyGrace)
+	}
+}
+
+// warnIfNotReady logs a warning when no backend is online after the grace period.
+// Until then, clients get ErrBackendsNotReady.
+func (m *BackendManager) warnIfNotReady(ctx context.Context, grace time.Duration) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(grace):
+	}
+	if !m.IsBackendReady() {
+		logger.Info("WARN: no backend cluster is online after the grace period, check the control plane",
+			"GracePeriod", grace, "RouterType", m.config.Router.RouterType, "CpAddr", m.config.Router.CpAddr)
+	}
+}
+
+// IsBackendReady reports whether at least one backend pool is online.
+func (m *BackendManager) IsBackendReady() bool {
+	return m.instancePool.Size() > 0
 }
 
 func (m *BackendManager) GetBackendFixedPool(userName string) (*FixedPool, error) {
+	if !m.IsBackendReady() {
+		return nil, ErrBackendsNotReady
+	}
 	tenantKey := m.GetTenantKey(userName)
 	if tenantKey == nil {
 		return nil, fmt.Errorf("no tenant key found for auth %+v", userName)
 	}
-	beInstance, _ := m.router.Selector(m.balancerRef, tenantKey)
+	beInstance, err := m.router.Selector(m.balancerRef, tenantKey)
+	if err != nil {
+		return nil, err
+	}
 	pool, ok := m.instancePool.Load(beInstance.GetAddr())
+	if m.IsDraining(beInstance.GetAddr()) || ok && pool.IsUnavailable() {
+		if other := m.routablePool(tenantKey); other != nil {
+			return other, nil
+		}
+		logger.Info("WARN: no other backend to move sessions to, keep the selected one",
+			"Addr", beInstance.GetAddr(), "Owner", userName)
+	}
 	if !ok {
 		return nil, fmt.Errorf("no backend avaiable for auth %+v", userName)
 	}
 	return pool, nil
 }
 
+// DrainInstance stops routing new sessions to the instance until it goes offline.
+func (m *BackendManager) DrainInstance(addr string) {
+	m.draining.Store(addr, struct{}{})
+}
+
+func (m *BackendManager) IsDraining(addr string) bool {
+	_, ok := m.draining.Load(addr)
+	return ok
+}
+
+// routablePool returns the pool of an instance of the tenant's cluster that is neither draining
+// nor answering LOADING or BUSY.
+func (m *BackendManager) routablePool(tenantKey *ClusterKey) *FixedPool {
+	instances, err := m.router.ListBackend(tenantKey)
+	if err != nil {
+		return nil
+	}
+	for _, instance := range instances {
+		if m.IsDraining(instance.GetAddr()) {
+			continue
+		}
+		if pool, ok := m.instancePool.Load(instance.GetAddr()); ok && !pool.IsUnavailable() {
+			return pool
+		}
+	}
+	return nil
+}
+
+// tenantPools returns the pools of every online instance of the tenant's cluster.
+func (m *BackendManager) tenantPools(userName string) ([]*FixedPool, error) {
+	if !m.IsBackendReady() {
+		return nil, ErrBackendsNotReady
+	}
+	tenantKey := m.GetTenantKey(userName)
+	if tenantKey == nil {
+		return nil, fmt.Errorf("no tenant key found for auth %+v", userName)
+	}
+	instances, err := m.router.ListBackend(tenantKey)
+	if err != nil {
+		return nil, err
+	}
+	pools := make([]*FixedPool, 0, len(instances))
+	for _, instance := range instances {
+		if pool, ok := m.instancePool.Load(instance.GetAddr()); ok {
+			pools = append(pools, pool)
+		}
+	}
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("no backend available for auth %+v", userName)
+	}
+	return pools, nil
+}
+
+// tenantInstancePool returns the pool of the instance at addr when it is an instance of the
+// cluster of the tenant.
+func (m *BackendManager) tenantInstancePool(userName string, addr string) (*FixedPool, bool) {
+	tenantKey := m.GetTenantKey(userName)
+	if tenantKey == nil {
+		return nil, false
+	}
+	instances, err := m.router.ListBackend(tenantKey)
+	if err != nil || !slices.ContainsFunc(instances, func(instance *ClusterInstance) bool {
+		return instance.GetAddr() == addr
+	}) {
+		return nil, false
+	}
+	return m.instancePool.Load(addr)
+}
+
+// GetTenantKey returns the cluster of a tenant, the one it is bound to, or else the cluster of an
+// instance the tenant owns.
 func (m *BackendManager) GetTenantKey(userName string) *ClusterKey {
+	if key := m.registry.GetTenantBinding(userName); key != nil {
+		return key
+	}
 	tk, ok := m.clusterKeyMap.Load(userName)
 	if !ok {
 		return nil
@@ -96,9 +305,194 @@ func (m *BackendManager) GetTenantKey(userName string) *ClusterKey {
 	return tk
 }
 
-func (m *BackendManager) Close() {
-	m.instancePool.Range(func(key string, value *FixedPool) bool {
-		_ = value.Close()
+// PoolStatus is a snapshot of the connection pool of a backend instance.
+type PoolStatus struct {
+	Addr        string `json:"addr"`
+	Connections int    `json:"connections"`
+	// InTxn counts the connections pinned by a WATCH or a MULTI
+	InTxn    int `json:"in_txn"`
+	WriteQ   int `json:"write_q"`
+	PendingQ int `json:"pending_q"`
+	// InFlight counts the requests enqueued and not answered yet
+	InFlight int  `json:"in_flight"`
+	Draining bool `json:"draining"`
+	// Unavailable is set while the instance answers LOADING or BUSY
+	Unavailable bool `json:"unavailable"`
+}
+
+// RingStatus is the consistent hash ring spreading the sessions of a backend instance over the
+// connections of its pool.
+type RingStatus struct {
+	Addr string `json:"addr"`
+	// Partitions is the number of partitions of the ring, the keys hash to a partition
+	Partitions int `json:"partitions"`
+	// MaxPartitions bounds the partitions owned by a member, the bounded load of the ring
+	MaxPartitions int          `json:"max_partitions"`
+	Members       []RingMember `json:"members"`
+}
+
+// RingMember is a connection of a ring and the partitions it owns.
+type RingMember struct {
+	ConnId     string `json:"conn_id"`
+	Partitions int    `json:"partitions"`
+	// Share is the fraction of the partitions, an even ring gives each member the same share
+	Share float64 `json:"share"`
+}
+
+// RingStatus returns the ring of the pool of every online backend instance, or only of the
+// instance of the tenant if not empty.
+func (m *BackendManager) RingStatus(tenant string) ([]RingStatus, error) {
+	var tenantPool *FixedPool
+	if tenant != "" {
+		pool, err := m.GetBackendFixedPool(tenant)
+		if err != nil {
+			return nil, err
+		}
+		tenantPool = pool
+	}
+	statuses := make([]RingStatus, 0, m.instancePool.Size())
+	m.instancePool.Range(func(addr string, pool *FixedPool) bool {
+		if tenantPool != nil && pool != tenantPool {
+			return true
+		}
+		statuses = append(statuses, RingStatus{
+			Addr:          addr,
+			Partitions:    consistentCfg.PartitionCount,
+			MaxPartitions: int(pool.cHasher.AverageLoad()),
+			Members:       pool.ringMembers(),
+		})
+		return true
+	})
+	return statuses, nil
+}
+
+// PoolStatus returns the status of the pool of every online backend instance.
+func (m *BackendManager) PoolStatus() []PoolStatus {
+	statuses := make([]PoolStatus, 0, m.instancePool.Size())
+	m.instancePool.Range(func(addr string, pool *FixedPool) bool {
+		status := PoolStatus{Addr: addr, Draining: m.IsDraining(addr), Unavailable: pool.IsUnavailable()}
+		pool.onLines.Range(func(_ string, conn *BackendConn) bool {
+			status.Connections++
+			if conn.LoadTxnState().Active() {
+				status.InTxn++
+			}
+			status.WriteQ += conn.WriteQLen()
+			status.PendingQ += conn.PendingQLen()
+			status.InFlight += conn.InFlight()
+			return true
+		})
+		statuses = append(statuses, status)
+		return true
+	})
+	return statuses
+}
+
+// TxnStatus is a backend connection pinned by the WATCH or the MULTI of a session.
+type TxnStatus struct {
+	Addr      string                `json:"addr"`
+	ConnId    string                `json:"conn_id"`
+	SessionId string                `json:"session_id"`
+	State     respio.TxCmdStateType `json:"state"`
+	Since     time.Time             `json:"since"`
+	// StartedAt is when the MULTI was sent, nil while the connection is only watched
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// OpenMs is how long the connection has been pinned, in milliseconds
+	OpenMs int64 `json:"open_ms"`
+}
+
+// Transactions returns the connections pinned by a transaction, the oldest first.
+func (m *BackendManager) Transactions() []TxnStatus {
+	txns := make([]TxnStatus, 0)
+	now := time.Now()
+	m.instancePool.Range(func(addr string, pool *FixedPool) bool {
+		pool.onLines.Range(func(_ string, conn *BackendConn) bool {
+			txState := conn.LoadTxnState()
+			if !txState.Active() || txState.OwnerSession == nil {
+				return true
+			}
+			txn := TxnStatus{
+				Addr:      addr,
+				ConnId:    conn.Id,
+				SessionId: txState.OwnerSession.Id,
+				State:     txState.State,
+				Since:     txState.Since,
+				OpenMs:    now.Sub(txState.Since).Milliseconds(),
+			}
+			if !txState.StartedAt.IsZero() {
+				txn.StartedAt = &txState.StartedAt
+			}
+			txns = append(txns, txn)
+			return true
+		})
+		return true
+	})
+	slices.SortFunc(txns, func(a, b TxnStatus) int {
+		return a.Since.Compare(b.Since)
+	})
+	return txns
+}
+
+// queueDepths sums the backend connection queues per backend instance.
+func (m *BackendManager) queueDepths() []QueueDepth {
+	depths := make([]QueueDepth, 0)
+	for _, status := range m.PoolStatus() {
+		depths = append(depths,
+			QueueDepth{Queue: QueueNameWrite, Owner: status.Addr, Depth: status.WriteQ},
+			QueueDepth{Queue: QueueNamePending, Owner: status.Addr, Depth: status.PendingQ},
+			QueueDepth{Queue: QueueNameInFlight, Owner: status.Addr, Depth: status.InFlight})
+	}
+	return depths
+}
+
+// Close shuts the pools down in order. The backend changes are no longer followed, the idle
+// connections are closed at once, the busy ones are given until ctx is done to answer their
+// requests, then every pool is closed.
+func (m *BackendManager) Close(ctx context.Context) {
+	m.stopChangeNotify(ctx)
+	var busy []*BackendConn
+	m.instancePool.Range(func(_ string, pool *FixedPool) bool {
+		busy = append(busy, pool.closeIdleConns()...)
 		return true
 	})
+	if len(busy) > 0 {
+		logger.Info("Waiting for the busy backend connections", "Connections", len(busy))
+		ticker := time.NewTicker(drainCheckInterval)
+		defer ticker.Stop()
+	wait:
+		for !allIdle(busy) {
+			select {
+			case <-ctx.Done():
+				logger.Info("Closing the busy backend connections", "error", ctx.Err())
+				break wait
+			case <-ticker.C:
+			}
+		}
+	}
+	m.instancePool.Range(func(_ string, pool *FixedPool) bool {
+		_ = pool.Close()
+		return true
+	})
+}
+
+// stopChangeNotify stops the change notify of the router and waits for it, a change being
+// applied finishes first.
+func (m *BackendManager) stopChangeNotify(ctx context.Context) {
+	if m.stopNotify == nil {
+		return
+	}
+	m.stopNotify()
+	select {
+	case <-m.notifyDone:
+	case <-ctx.Done():
+		logger.Info("Backend change notify still running", "error", ctx.Err())
+	}
+}
+
+func allIdle(conns []*BackendConn) bool {
+	for _, conn := range conns {
+		if !conn.IsIdle() {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,245 @@
+package be_cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buraksezer/consistent"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSyncConfig() *common.ProxyConfig {
+	return &common.ProxyConfig{
+		Router: common.BackendRouterConfig{
+			RouterType: "sync",
+			LBType:     "tenant-hash",
+		},
+	}
+}
+
+func TestBackendManager_NotReady(t *testing.T) {
+	beMgr := newBackendManager(newTestSyncConfig(), &SyncRouter{registry: newDefaultClusterRegistry()})
+
+	_, err := beMgr.GetBackendFixedPool("admin")
+	assert.ErrorIs(t, err, ErrBackendsNotReady)
+	assert.False(t, beMgr.IsBackendReady())
+
+	// once a cluster arrives the LOADING state is cleared
+	beMgr.instancePool.Store("127.0.0.1:6379", &FixedPool{})
+	assert.True(t, beMgr.IsBackendReady())
+	_, err = beMgr.GetBackendFixedPool("admin")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrBackendsNotReady)
+}
+
+func TestBackendManager_OnlinePoolNotReady(t *testing.T) {
+	defer func(timeout time.Duration) { poolReadyTimeout = timeout }(poolReadyTimeout)
+	poolReadyTimeout = 200 * time.Millisecond
+	config := newTestSyncConfig()
+	config.BeConnPool.IsFixed = true
+	config.BeConnPool.MaxSize = 2
+	beMgr := newBackendManager(config, &SyncRouter{registry: newDefaultClusterRegistry()})
+	// nothing listens there, the pool never fills
+	instance := LocalClusterInstance("127.0.0.1", 1)
+
+	start := time.Now()
+	beMgr.backendOnline(context.Background(), instance)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.False(t, beMgr.IsBackendReady())
+
+	// the manager closing ends the wait as well
+	poolReadyTimeout = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	beMgr.backendOnline(ctx, instance)
+	assert.False(t, beMgr.IsBackendReady())
+}
+
+func TestBackendManager_CloseDrainsBusyConns(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		timeout time.Duration
+		answer  bool
+	}{
+		{"busy answered", 5 * time.Second, true},
+		{"busy forced", 300 * time.Millisecond, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			idle := newPipeBackendConn(t, echoKey)
+			busy := newPipeBackendConn(t, func(req *respio.RespPacket) *respio.RespPacket {
+				<-release
+				return echoKey(req)
+			})
+			defer close(release)
+			pool := newSingleConnPool(idle)
+			pool.putOnline(busy, nil)
+			pool.innerPool = &BackendPool{cfg: &PoolConfig{}, conns: []*BackendConn{idle, busy}}
+			beMgr := newBackendManager(newTestSyncConfig(), &listRouter{})
+			beMgr.instancePool.Store("127.0.0.1:6379", pool)
+
+			session, clientReader := newPipeSession(t, tt.name)
+			busy.Enqueue(&RequestContext{Session: session, Request: respio.NewCommand("GET", "k")})
+			closed := make(chan struct{})
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+				defer cancel()
+				beMgr.Close(ctx)
+				close(closed)
+			}()
+
+			// the idle connection is closed at once, the busy one is given time
+			assert.Eventually(t, idle.closed.Load, time.Second, 10*time.Millisecond)
+			assert.False(t, busy.closed.Load())
+			if tt.answer {
+				time.Sleep(3 * drainCheckInterval)
+				assert.False(t, busy.closed.Load())
+				release <- struct{}{}
+				reply, err := clientReader.Read()
+				assert.NoError(t, err)
+				assert.Equal(t, "k", string(reply.Data))
+			}
+			select {
+			case <-closed:
+			case <-time.After(2 * time.Second):
+				assert.Fail(t, "close not done")
+			}
+			assert.True(t, busy.closed.Load())
+		})
+	}
+}
+
+func TestBackendManager_TenantBinding(t *testing.T) {
+	registry := newDefaultClusterRegistry()
+	beMgr := newBackendManager(newTestSyncConfig(), &SyncRouter{registry: registry})
+	beMgr.registry = registry
+	ownerKey := ClusterKey{Name: ClusterName{Name: "owner-cluster"}}
+	boundKey := ClusterKey{Name: ClusterName{Name: "bound-cluster"}}
+	beMgr.clusterKeyMap.Store("tenant-a", &ownerKey)
+
+	assert.Error(t, registry.BindTenant("tenant-a", boundKey))
+	assert.Equal(t, &ownerKey, beMgr.GetTenantKey("tenant-a"))
+
+	assert.NoError(t, registry.AddCluster(&boundKey))
+	assert.NoError(t, registry.BindTenant("tenant-a", boundKey))
+	assert.NoError(t, registry.BindTenant("tenant-b", boundKey))
+	// the binding wins over the owner of an instance
+	assert.Equal(t, &boundKey, beMgr.GetTenantKey("tenant-a"))
+	assert.Equal(t, &boundKey, beMgr.GetTenantKey("tenant-b"))
+	assert.Nil(t, beMgr.GetTenantKey("tenant-c"))
+
+	registry.UnbindTenant("tenant-a")
+	registry.UnbindTenant("tenant-b")
+	assert.Equal(t, &ownerKey, beMgr.GetTenantKey("tenant-a"))
+	assert.Nil(t, beMgr.GetTenantKey("tenant-b"))
+}
+
+func TestBackendManager_CloseStopsChangeNotify(t *testing.T) {
+	config := newTestSyncConfig()
+	config.Router.ReadyGrace = time.Hour
+	registry := newDefaultClusterRegistry()
+	beMgr := newBackendManager(config, &SyncRouter{registry: registry})
+	beMgr.PrepareCluster()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	beMgr.Close(ctx)
+	select {
+	case <-beMgr.notifyDone:
+	default:
+		t.Fatal("the change notify is still running")
+	}
+	// a second Close does not block
+	beMgr.Close(ctx)
+
+	// a closed notify channel stops the loop as well
+	close(registry.notify)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		(&SyncRouter{registry: registry}).BackendChangeNotify(context.Background(), func(*ClusterInstance) {})
+	}()
+	assert.Eventually(t, func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBackendManager_RingStatus(t *testing.T) {
+	instanceA, instanceB := LocalClusterInstance("127.0.0.1", 6379), LocalClusterInstance("127.0.0.1", 6380)
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instanceA}})
+	newRingPool := func(connIds ...string) *FixedPool {
+		pool := &FixedPool{onLines: xsync.NewMapOf[string, *BackendConn](), cHasher: consistent.New(nil, consistentCfg)}
+		for _, id := range connIds {
+			pool.cHasher.Add(Member{key: id})
+		}
+		return pool
+	}
+	beMgr.instancePool.Store(instanceA.GetAddr(), newRingPool("conn-1", "conn-2", "conn-3", "conn-4"))
+	beMgr.instancePool.Store(instanceB.GetAddr(), newRingPool("conn-5"))
+	beMgr.clusterKeyMap.Store("tenant-a", &instanceA.Key)
+
+	rings, err := beMgr.RingStatus("")
+	assert.NoError(t, err)
+	assert.Len(t, rings, 2)
+
+	rings, err = beMgr.RingStatus("tenant-a")
+	assert.NoError(t, err)
+	assert.Len(t, rings, 1)
+	ring := rings[0]
+	assert.Equal(t, instanceA.GetAddr(), ring.Addr)
+	assert.Equal(t, consistentCfg.PartitionCount, ring.Partitions)
+	ids, partitions, share := make([]string, 0, len(ring.Members)), 0, 0.0
+	for _, member := range ring.Members {
+		ids = append(ids, member.ConnId)
+		partitions += member.Partitions
+		share += member.Share
+		assert.Positive(t, member.Partitions)
+		assert.LessOrEqual(t, member.Partitions, ring.MaxPartitions)
+	}
+	assert.Equal(t, []string{"conn-1", "conn-2", "conn-3", "conn-4"}, ids)
+	assert.Equal(t, ring.Partitions, partitions)
+	assert.InDelta(t, 1.0, share, 1e-9)
+
+	_, err = beMgr.RingStatus("unknown")
+	assert.Error(t, err)
+}
+
+func TestBackendManager_ProbeBackends(t *testing.T) {
+	defer func(timeout, ttl time.Duration) { probeTimeout, probeCacheTTL = timeout, ttl }(probeTimeout, probeCacheTTL)
+	probeTimeout, probeCacheTTL = 100*time.Millisecond, time.Minute
+	instanceA, instanceB := LocalClusterInstance("127.0.0.1", 6379), LocalClusterInstance("127.0.0.1", 6380)
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instanceA, instanceB}})
+	beMgr.instancePool.Store(instanceA.GetAddr(), newSingleConnPool(newPipeBackendConnAt(t, instanceA.GetAddr(),
+		func(req *respio.RespPacket) *respio.RespPacket {
+			return respio.NewStatus("PONG")
+		})))
+	// the connection of the other instance is up but its backend never answers
+	hung := make(chan struct{})
+	t.Cleanup(func() { close(hung) })
+	beMgr.instancePool.Store(instanceB.GetAddr(), newSingleConnPool(newPipeBackendConnAt(t, instanceB.GetAddr(),
+		func(req *respio.RespPacket) *respio.RespPacket {
+			<-hung
+			return respio.NewStatus("PONG")
+		})))
+
+	probes := beMgr.ProbeBackends()
+	assert.Equal(t, []InstanceProbe{
+		{Addr: instanceA.GetAddr(), Healthy: true},
+		{Addr: instanceB.GetAddr(), Healthy: false, Error: errProbeTimeout.Error()},
+	}, probes)
+
+	// the result is cached, the hung backend is not probed again
+	beMgr.instancePool.Delete(instanceB.GetAddr())
+	assert.Equal(t, probes, beMgr.ProbeBackends())
+	probeCacheTTL = 0
+	assert.Equal(t, []InstanceProbe{{Addr: instanceA.GetAddr(), Healthy: true}}, beMgr.ProbeBackends())
+}
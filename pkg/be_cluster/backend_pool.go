@@ -3,6 +3,7 @@ package be_cluster
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -29,12 +30,39 @@ var (
 	ErrClosed = errors.New("elika proxy: client is closed")
 
 	// ErrPoolExhausted is returned from a innerPool connection method
-	// when the maximum number of database connections in the innerPool has been reached.
-	ErrPoolExhausted = errors.New("elika proxy: connection innerPool exhausted")
+	// when the maximum number of database connections in the innerPool has been reached,
+	// or every connection of a FixedPool is pinned by a transaction.
+	ErrPoolExhausted = errors.New("ERR connection pool exhausted")
 
 	// ErrPoolTimeout timed out waiting to get a connection from the connection innerPool.
-	ErrPoolTimeout        = errors.New("elika proxy: connection innerPool timeout")
-	defaultTryDialBackoff = backoff.WithMaxElapsedTime(30 * time.Minute)
+	ErrPoolTimeout = errors.New("ERR connection pool timeout")
+
+	errConnNotInPool = errors.New("connection is not in the pool")
+)
+
+// ForwardErrorType names the error counter of a request that could not be forwarded. Waiting
+// too long for a connection is told apart from hitting the ceiling of the pool.
+func ForwardErrorType(err error) string {
+	switch {
+	case errors.Is(err, ErrPoolTimeout):
+		return "pool_timeout"
+	case errors.Is(err, ErrPoolExhausted):
+		return "pool_exhausted"
+	default:
+		return "forwarding_error"
+	}
+}
+
+const (
+	defaultRetryInitialInterval = 500 * time.Millisecond
+	defaultRetryMaxInterval     = 30 * time.Second
+	defaultRetryMaxElapsed      = 30 * time.Minute
+	defaultIdleFillRetries      = 5
+	defaultDialTimeout          = 3 * time.Second
+	defaultReadyPollInterval    = 50 * time.Millisecond
+	defaultScaleInterval        = time.Second
+	defaultScaleUpInFlight      = 32
+	retryRandomizationFactor    = 0.5
 )
 
 type PoolConfig struct {
@@ -53,6 +81,63 @@ type PoolConfig struct {
 	MinActiveSize   int
 	ConnMaxLifetime time.Duration
 	PoolWaitTimeout time.Duration
+	// RetryInitialInterval is the first reconnect delay after a dial failure, it grows exponentially
+	// up to RetryMaxInterval. RetryMaxElapsed bounds the total reconnect time.
+	RetryInitialInterval time.Duration
+	RetryMaxInterval     time.Duration
+	RetryMaxElapsed      time.Duration
+	// IdleFillRetries is the number of dial attempts to fill an idle connection, 0 is the default
+	IdleFillRetries uint
+	// DialTimeout bounds the dial of a backend connection, 0 is the default
+	DialTimeout time.Duration
+	// MaxInFlight is the requests in flight above which the sessions of a connection are held
+	// back, 0 is no limit
+	MaxInFlight int
+	// ReadyPollInterval is how often WaitPoolReady checks the pool size besides being signaled
+	// by the last connection, 0 is the default
+	ReadyPollInterval time.Duration
+	// Dynamic pools start with MinActiveSize connections and scale up to MaxActiveSize, a
+	// connection is added once the requests in flight per connection reach ScaleUpInFlight.
+	// ScaleInterval is how often the load is checked, 0 are the defaults.
+	Dynamic         bool
+	ScaleUpInFlight int
+	ScaleInterval   time.Duration
+}
+
+func (cfg *PoolConfig) dialTimeout() time.Duration {
+	if cfg.DialTimeout > 0 {
+		return cfg.DialTimeout
+	}
+	return defaultDialTimeout
+}
+
+// initialSize is the connections of the pool once it is ready.
+func (cfg *PoolConfig) initialSize() int {
+	if cfg.Dynamic {
+		return cfg.MinActiveSize
+	}
+	return cfg.PoolSize
+}
+
+func (cfg *PoolConfig) scaleInterval() time.Duration {
+	if cfg.ScaleInterval > 0 {
+		return cfg.ScaleInterval
+	}
+	return defaultScaleInterval
+}
+
+func (cfg *PoolConfig) scaleUpInFlight() int {
+	if cfg.ScaleUpInFlight > 0 {
+		return cfg.ScaleUpInFlight
+	}
+	return defaultScaleUpInFlight
+}
+
+func (cfg *PoolConfig) readyPollInterval() time.Duration {
+	if cfg.ReadyPollInterval > 0 {
+		return cfg.ReadyPollInterval
+	}
+	return defaultReadyPollInterval
 }
 
 type BackendPoolStatus struct {
@@ -72,32 +157,46 @@ type BackendPoolStatus struct {
 
 func NewFixedPoolCfgFromBackend(instance *ClusterInstance, config *common.ProxyConfig) *PoolConfig {
 	cfg := &PoolConfig{
-		Addr:            instance.GetAddr(),
-		PoolSize:        config.BeConnPool.MaxSize,
-		MaxIdleSize:     config.BeConnPool.MaxSize,
-		MinIdleSize:     config.BeConnPool.MaxSize,
-		MaxActiveSize:   10,
-		PoolWaitTimeout: 1 * time.Second,
-		ConnMaxLifetime: 0,
+		Addr:              instance.GetAddr(),
+		PoolSize:          config.BeConnPool.MaxSize,
+		MaxIdleSize:       config.BeConnPool.MaxSize,
+		MinIdleSize:       config.BeConnPool.MaxSize,
+		MaxActiveSize:     10,
+		PoolWaitTimeout:   1 * time.Second,
+		ConnMaxLifetime:   config.BeConnPool.ConnMaxLifetime,
+		RetryMaxElapsed:   config.BeConnPool.RetryMaxElapsed,
+		DialTimeout:       config.BeConnPool.DialTimeout,
+		MaxInFlight:       config.BeConnPool.MaxInFlight,
+		ReadyPollInterval: config.BeConnPool.ReadyPollInterval,
 	}
 	cfg.Dialer = func(ctx context.Context) (*BackendConn, error) {
-		return NewBackendConn(3*time.Second, cfg.Addr, 10240)
+		return NewBackendConn(cfg.dialTimeout(), cfg.Addr, 10240)
 	}
 	return cfg
 }
 
+// NewDefaultPoolCfgFromBackend is the config of a dynamic pool, it opens min-size connections
+// and grows up to max-size under load.
 func NewDefaultPoolCfgFromBackend(instance *ClusterInstance, config *common.ProxyConfig) *PoolConfig {
+	maxSize := config.BeConnPool.MaxSize
+	minSize := min(max(config.BeConnPool.MinSize, 1), maxSize)
 	cfg := &PoolConfig{
-		Addr:            instance.GetAddr(),
-		PoolSize:        config.BeConnPool.MaxSize,
-		MaxIdleSize:     config.BeConnPool.MaxIdle,
-		MinIdleSize:     1,
-		MaxActiveSize:   10,
-		PoolWaitTimeout: 1 * time.Second,
-		ConnMaxLifetime: 0,
+		Addr:              instance.GetAddr(),
+		PoolSize:          maxSize,
+		MaxIdleSize:       maxSize,
+		MinIdleSize:       minSize,
+		MinActiveSize:     minSize,
+		MaxActiveSize:     maxSize,
+		Dynamic:           true,
+		PoolWaitTimeout:   1 * time.Second,
+		ConnMaxLifetime:   config.BeConnPool.ConnMaxLifetime,
+		RetryMaxElapsed:   config.BeConnPool.RetryMaxElapsed,
+		DialTimeout:       config.BeConnPool.DialTimeout,
+		MaxInFlight:       config.BeConnPool.MaxInFlight,
+		ReadyPollInterval: config.BeConnPool.ReadyPollInterval,
 	}
 	cfg.Dialer = func(ctx context.Context) (*BackendConn, error) {
-		return NewBackendConn(3*time.Second, cfg.Addr, 10240)
+		return NewBackendConn(cfg.dialTimeout(), cfg.Addr, 10240)
 	}
 	return cfg
 }
@@ -116,6 +215,11 @@ type BackendPool struct {
 	status      *BackendPoolStatus
 	addr        string
 	lastDialErr atomic.Value
+	// testing is set while a testConn is probing the backend
+	testing atomic.Bool
+	// filled is closed once the pool holds PoolSize connections for the first time
+	filled     chan struct{}
+	filledOnce sync.Once
 }
 
 func NewBackendConnPool(cfg *PoolConfig) *BackendPool {
@@ -126,6 +230,7 @@ func NewBackendConnPool(cfg *PoolConfig) *BackendPool {
 		conns:     make([]*BackendConn, 0, cfg.PoolSize),
 		idleConns: make([]*BackendConn, 0, cfg.PoolSize),
 		status:    &BackendPoolStatus{},
+		filled:    make(chan struct{}),
 	}
 	pool.mu.Lock()
 	pool.checkMinIdleConns()
@@ -146,7 +251,7 @@ func (p *BackendPool) checkMinIdleConns() {
 			p.idleConnLen++
 
 			go func() {
-				err := p.addIdleConn()
+				err := p.addIdleConnWithRetry()
 				if err != nil && !errors.Is(err, ErrClosed) {
 					logger.Error(err, "add idle cluster failed", "addr", p.cfg.Addr)
 					p.mu.Lock()
@@ -162,6 +267,24 @@ func (p *BackendPool) checkMinIdleConns() {
 	}
 }
 
+// addIdleConnWithRetry retries a transient dial failure so that a pool started while the
+// backend is briefly unavailable still fills up to MinIdleSize.
+func (p *BackendPool) addIdleConnWithRetry() error {
+	retries := p.cfg.IdleFillRetries
+	if retries == 0 {
+		retries = defaultIdleFillRetries
+	}
+	_, err := backoff.Retry(context.Background(), func() (struct{}, error) {
+		err := p.addIdleConn()
+		if errors.Is(err, ErrClosed) {
+			return struct{}{}, backoff.Permanent(err)
+		}
+		return struct{}{}, err
+	}, backoff.WithBackOff(p.newRetryBackOff()), backoff.WithMaxTries(retries),
+		backoff.WithMaxElapsedTime(p.retryMaxElapsed()))
+	return err
+}
+
 func (p *BackendPool) addIdleConn() error {
 	if p.IsClosed() {
 		return ErrClosed
@@ -174,9 +297,17 @@ func (p *BackendPool) addIdleConn() error {
 	defer p.mu.Unlock()
 	p.conns = append(p.conns, conn)
 	p.idleConns = append(p.idleConns, conn)
+	p.signalFilled()
 	return nil
 }
 
+// signalFilled closes filled when the last connection was added, it must be called in a lock.
+func (p *BackendPool) signalFilled() {
+	if len(p.conns) >= p.cfg.initialSize() {
+		p.filledOnce.Do(func() { close(p.filled) })
+	}
+}
+
 func (p *BackendPool) IsClosed() bool {
 	return atomic.LoadUint32(&p.closed) == 1
 }
@@ -185,19 +316,50 @@ func (p *BackendPool) freeSlot() {
 	<-p.queue
 }
 
+// newRetryBackOff returns the reconnect policy of this pool. Each call has its own randomized
+// exponential backoff so that pools reconnecting to the same backend don't retry in lockstep.
+func (p *BackendPool) newRetryBackOff() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.RandomizationFactor = retryRandomizationFactor
+	b.InitialInterval = defaultRetryInitialInterval
+	if p.cfg.RetryInitialInterval > 0 {
+		b.InitialInterval = p.cfg.RetryInitialInterval
+	}
+	b.MaxInterval = defaultRetryMaxInterval
+	if p.cfg.RetryMaxInterval > 0 {
+		b.MaxInterval = p.cfg.RetryMaxInterval
+	}
+	return b
+}
+
+func (p *BackendPool) retryMaxElapsed() time.Duration {
+	if p.cfg.RetryMaxElapsed > 0 {
+		return p.cfg.RetryMaxElapsed
+	}
+	return defaultRetryMaxElapsed
+}
+
 func (p *BackendPool) testConn() {
+	// one probe per pool is enough, every failed dial would start another one
+	if !p.testing.CompareAndSwap(false, true) {
+		return
+	}
+	defer p.testing.Store(false)
+	retryBackOff := p.newRetryBackOff()
+	// spread the first attempt as well, all pools usually observe the failure at the same time
+	common.SleepRandom(int(retryBackOff.InitialInterval.Milliseconds()), 0)
 	backend, err := backoff.Retry[*BackendConn](context.Background(), func() (*BackendConn, error) {
 		if p.IsClosed() {
 			return nil, nil
 		}
-		testConn, dialErr := p.cfg.Dialer(context.Background())
+		testConn, dialErr := p.dial(context.Background())
 		if dialErr != nil {
 			p.lastDialErr.Store(dialErr)
 			return nil, dialErr
 		}
 		atomic.StoreUint32(&p.errNums, 0)
 		return testConn, nil
-	}, defaultTryDialBackoff)
+	}, backoff.WithBackOff(retryBackOff), backoff.WithMaxElapsedTime(p.retryMaxElapsed()))
 
 	if err == nil && backend != nil {
 		_ = backend.Close()
@@ -230,12 +392,13 @@ func (p *BackendPool) dialConn(ctx context.Context) (*BackendConn, error) {
 	if atomic.LoadUint32(&p.errNums) >= uint32(p.cfg.PoolSize) {
 		return nil, p.lastDialError()
 	}
-	backendConn, err := p.cfg.Dialer(ctx)
+	backendConn, err := p.dial(ctx)
 	if err != nil {
 		p.lastDialErr.Store(err)
 		go p.testConn()
 		return nil, err
 	}
+	backendConn.SetMaxInFlight(p.cfg.MaxInFlight)
 	return backendConn, nil
 }
 
@@ -251,18 +414,24 @@ func (p *BackendPool) lastDialError() error {
 func (p *BackendPool) Put(backend *BackendConn) {
 	if p.IsClosed() {
 		logger.Info("WARN: put cluster to closed innerPool", "addr", p.cfg.Addr)
+		recordDrop(DropPoolClosed)
 		return
 	}
-	if backend.reader.Buffered() > 0 {
+	if buffered := backend.Buffered(); buffered > 0 {
 		logger.Info("WARN: put cluster with buffered data", "addr", backend.RemoteAddr(),
-			"DataBuffer", backend.reader.Buffered())
+			"DataBuffer", buffered)
 		_ = p.removeConnAndClose(backend)
 		p.freeSlot()
 		return
 	}
 	var closeConn bool
 	p.mu.Lock()
-	if p.cfg.MaxIdleSize == 0 || p.idleConnLen < p.cfg.MaxIdleSize {
+	if backend.IsRetired() || p.expired(backend) {
+		// the removal refills the pool with a fresh connection
+		backend.Retire()
+		p.tryRemoveConn(backend)
+		closeConn = true
+	} else if p.cfg.MaxIdleSize == 0 || p.idleConnLen < p.cfg.MaxIdleSize {
 		p.idleConns = append(p.idleConns, backend)
 		p.idleConnLen++
 	} else {
@@ -307,6 +476,7 @@ func (p *BackendPool) Get(ctx context.Context) (*BackendConn, error) {
 	atomic.AddUint32(&p.status.DelayedGets, 1)
 	newConn, err := p.makeConn(ctx)
 	if err != nil {
+		p.freeSlot()
 		return nil, err
 	}
 	return newConn, nil
@@ -335,19 +505,55 @@ func (p *BackendPool) makeConn(ctx context.Context) (*BackendConn, error) {
 	}
 	p.conns = append(p.conns, conn)
 	p.createConn++
+	p.signalFilled()
 
 	return conn, nil
 }
 
+// expired reports whether the connection outlived ConnMaxLifetime.
+func (p *BackendPool) expired(backendConn *BackendConn) bool {
+	return p.cfg.ConnMaxLifetime > 0 && time.Since(backendConn.created) > p.cfg.ConnMaxLifetime
+}
+
+// replaceConn dials a connection taking the place of old in the pool. Closing old is left to
+// the caller, it may still have requests in flight.
+func (p *BackendPool) replaceConn(old *BackendConn) (*BackendConn, error) {
+	conn, err := p.dialConn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.IsClosed() {
+		_ = conn.Close()
+		return nil, ErrClosed
+	}
+	for i, c := range p.conns {
+		if c == old {
+			p.conns[i] = conn
+			return conn, nil
+		}
+	}
+	_ = conn.Close()
+	return nil, fmt.Errorf("%w: %s", errConnNotInPool, old.Id)
+}
+
 func (p *BackendPool) health(backendConn *BackendConn) bool {
 	now := time.Now()
-	if p.cfg.ConnMaxLifetime > 0 && now.Sub(backendConn.created) > p.cfg.ConnMaxLifetime {
+	if p.expired(backendConn) {
 		return false
 	}
-	if p.cfg.ConnMaxLifetime > 0 && now.Sub(backendConn.UsedAt()) > p.cfg.ConnMaxLifetime {
+	// a connection never handed out has no last use
+	if p.cfg.ConnMaxLifetime > 0 && atomic.LoadInt64(&backendConn.usedAt) != 0 &&
+		now.Sub(backendConn.UsedAt()) > p.cfg.ConnMaxLifetime {
 		return false
 	}
-	if checkConn(backendConn.conn) != nil {
+	if backendConn.closed.Load() {
+		return false
+	}
+	// the socket of a connection in use holds replies for its ReadLoop, or a transaction is
+	// open on it, only an idle one is checked
+	if backendConn.LoadTxnState() == nil && backendConn.IsIdle() && checkConn(backendConn.conn) != nil {
 		return false
 	}
 	backendConn.SetUsedAt(now)
@@ -390,7 +596,9 @@ func (p *BackendPool) getSlot(ctx context.Context) error {
 }
 
 func (p *BackendPool) removeConnAndClose(backendConn *BackendConn) error {
+	p.mu.Lock()
 	p.tryRemoveConn(backendConn)
+	p.mu.Unlock()
 	return backendConn.Close()
 }
 
@@ -0,0 +1,347 @@
+package be_cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestBackendPool_ReconnectJitter(t *testing.T) {
+	newFailingPool := func(attempts *[]time.Time) *BackendPool {
+		var mu sync.Mutex
+		return NewBackendConnPool(&PoolConfig{
+			Addr:                 "127.0.0.1:1",
+			PoolSize:             1,
+			RetryInitialInterval: 20 * time.Millisecond,
+			RetryMaxInterval:     100 * time.Millisecond,
+			RetryMaxElapsed:      300 * time.Millisecond,
+			Dialer: func(ctx context.Context) (*BackendConn, error) {
+				mu.Lock()
+				*attempts = append(*attempts, time.Now())
+				mu.Unlock()
+				return nil, errors.New("connection refused")
+			},
+		})
+	}
+	var attempts1, attempts2 []time.Time
+	pool1, pool2 := newFailingPool(&attempts1), newFailingPool(&attempts2)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); pool1.testConn() }()
+	go func() { defer wg.Done(); pool2.testConn() }()
+	wg.Wait()
+
+	assert.Greater(t, len(attempts1), 1)
+	assert.Greater(t, len(attempts2), 1)
+	// the retry delays are randomized per pool, so the two schedules never line up
+	n := min(len(attempts1), len(attempts2))
+	coincide := 0
+	for i := 0; i < n; i++ {
+		if attempts1[i].Sub(attempts2[i]).Abs() < time.Millisecond {
+			coincide++
+		}
+	}
+	assert.Less(t, coincide, n)
+}
+
+func TestBackendPool_IdleFillRetry(t *testing.T) {
+	var dials atomic.Int32
+	pool := NewBackendConnPool(&PoolConfig{
+		Addr:                 "pipe",
+		PoolSize:             1,
+		MinIdleSize:          1,
+		RetryInitialInterval: 10 * time.Millisecond,
+		Dialer: func(ctx context.Context) (*BackendConn, error) {
+			// the backend is down for the first two attempts
+			if dials.Add(1) <= 2 {
+				return nil, errors.New("connection refused")
+			}
+			proxySide, backendSide := net.Pipe()
+			t.Cleanup(func() { _ = backendSide.Close() })
+			return newBackendConn(proxySide, "pipe", 16), nil
+		},
+	})
+	defer pool.Close()
+
+	assert.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.idleConns) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, pool.Size())
+}
+
+// unresponsiveAddr returns the address of a listener whose accept queue is full, so a dial to
+// it hangs until the dial timeout.
+func unresponsiveAddr(t *testing.T) string {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = unix.Close(fd) })
+	assert.NoError(t, unix.Bind(fd, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+	assert.NoError(t, unix.Listen(fd, 0))
+	sa, err := unix.Getsockname(fd)
+	assert.NoError(t, err)
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*unix.SockaddrInet4).Port)
+	// fill the accept queue, the next handshakes are not answered
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 50*time.Millisecond)
+		if err != nil {
+			return addr
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+	}
+}
+
+func TestBackendPool_DialTimeout(t *testing.T) {
+	host, port, _ := net.SplitHostPort(unresponsiveAddr(t))
+	portNum, _ := strconv.Atoi(port)
+	config := &common.ProxyConfig{BeConnPool: common.BackendPoolConfig{
+		MaxSize:     1,
+		DialTimeout: 100 * time.Millisecond,
+	}}
+	cfg := NewFixedPoolCfgFromBackend(LocalClusterInstance(host, portNum), config)
+	assert.Equal(t, 100*time.Millisecond, cfg.dialTimeout())
+	assert.Equal(t, defaultDialTimeout, (&PoolConfig{}).dialTimeout())
+
+	start := time.Now()
+	conn, err := cfg.Dialer(context.Background())
+	assert.Nil(t, conn)
+	var netErr net.Error
+	assert.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestBackendPool_RetireExpiredOnPut(t *testing.T) {
+	pool := NewBackendConnPool(&PoolConfig{
+		Addr:            "pipe",
+		PoolSize:        2,
+		MinIdleSize:     1,
+		MaxIdleSize:     2,
+		PoolWaitTimeout: time.Second,
+		ConnMaxLifetime: 50 * time.Millisecond,
+		Dialer: func(ctx context.Context) (*BackendConn, error) {
+			return newPipeBackendConn(t, echoKey), nil
+		},
+	})
+	defer pool.Close()
+	assert.Eventually(t, func() bool { return pool.Size() == 1 }, time.Second, 10*time.Millisecond)
+
+	conn, err := pool.Get(context.Background())
+	assert.NoError(t, err)
+	// the connection expires while it is in use
+	time.Sleep(60 * time.Millisecond)
+	pool.Put(conn)
+	assert.True(t, conn.IsRetired())
+	assert.True(t, conn.closed.Load())
+
+	// the pool is refilled with a new connection
+	assert.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.idleConns) == 1 && pool.idleConns[0] != conn
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBackendPool_PutToClosedPoolCountsDrop(t *testing.T) {
+	var mu sync.Mutex
+	drops := make(map[DropReason]int)
+	SetDropListener(func(reason DropReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		drops[reason]++
+	})
+	defer SetDropListener(nil)
+	countOf := func(reason DropReason) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return drops[reason]
+	}
+
+	pool := NewBackendConnPool(&PoolConfig{
+		Addr:            "pipe",
+		PoolSize:        1,
+		MinIdleSize:     1,
+		MaxIdleSize:     1,
+		PoolWaitTimeout: time.Second,
+		Dialer: func(ctx context.Context) (*BackendConn, error) {
+			return newPipeBackendConn(t, echoKey), nil
+		},
+	})
+	conn, err := pool.Get(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, pool.Close())
+	pool.Put(conn)
+	assert.Equal(t, 1, countOf(DropPoolClosed))
+
+	// a request on the closed connection is dropped as well
+	_ = conn.Close()
+	session, clientReader := newPipeSession(t, "dropped")
+	conn.Enqueue(&RequestContext{RequestId: NextRequestId(), Session: session, Request: respio.NewCommand("GET", "k")})
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, ErrBackendConnClosed.Error(), string(reply.Data))
+	assert.GreaterOrEqual(t, countOf(DropBackendGone), 1)
+}
+
+func TestBackendPool_DialFailureIsReported(t *testing.T) {
+	var mu sync.Mutex
+	failures := make(map[DialFailureCause]int)
+	SetDialListener(func(addr string, latency time.Duration, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failures[DialFailureCauseOf(err)]++
+		}
+	})
+	defer SetDialListener(nil)
+
+	addr := unusedAddr(t)
+	pool := NewBackendConnPool(&PoolConfig{
+		Addr:            addr,
+		PoolSize:        1,
+		MaxIdleSize:     1,
+		PoolWaitTimeout: time.Second,
+		RetryMaxElapsed: time.Millisecond,
+		Dialer: func(ctx context.Context) (*BackendConn, error) {
+			return NewBackendConn(time.Second, addr, 16)
+		},
+	})
+	defer pool.Close()
+	_, err := pool.Get(context.Background())
+	assert.Error(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.GreaterOrEqual(t, failures[DialFailRefused], 1)
+	assert.Zero(t, failures[DialFailTimeout])
+}
+
+func TestDialFailureCauseOf(t *testing.T) {
+	assert.Equal(t, DialFailTimeout, DialFailureCauseOf(context.DeadlineExceeded))
+	assert.Equal(t, DialFailTimeout, DialFailureCauseOf(&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}))
+	assert.Equal(t, DialFailRefused, DialFailureCauseOf(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	assert.Equal(t, DialFailOther, DialFailureCauseOf(errors.New("no route")))
+}
+
+func TestBackendPool_TimeoutAndExhausted(t *testing.T) {
+	newPool := func(poolSize, maxActive int) *BackendPool {
+		pool := NewBackendConnPool(&PoolConfig{
+			Addr:            "pipe",
+			PoolSize:        poolSize,
+			MaxActiveSize:   maxActive,
+			PoolWaitTimeout: 50 * time.Millisecond,
+			Dialer: func(ctx context.Context) (*BackendConn, error) {
+				return newPipeBackendConn(t, echoKey), nil
+			},
+		})
+		t.Cleanup(func() { _ = pool.Close() })
+		return pool
+	}
+
+	// waiting for a slot
+	pool := newPool(1, 0)
+	_, err := pool.Get(context.Background())
+	assert.NoError(t, err)
+	_, err = pool.Get(context.Background())
+	assert.ErrorIs(t, err, ErrPoolTimeout)
+	assert.Equal(t, "ERR connection pool timeout", err.Error())
+	assert.Equal(t, "pool_timeout", ForwardErrorType(err))
+
+	// hitting the ceiling of active connections, the slot is given back
+	pool = newPool(2, 1)
+	_, err = pool.Get(context.Background())
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = pool.Get(context.Background())
+		assert.ErrorIs(t, err, ErrPoolExhausted)
+	}
+	assert.Equal(t, "ERR connection pool exhausted", err.Error())
+	assert.Equal(t, "pool_exhausted", ForwardErrorType(err))
+	assert.Equal(t, "forwarding_error", ForwardErrorType(ErrBackendNotReady))
+}
+
+// newTCPConnPair returns both ends of a loopback TCP connection, checkConn needs a socket.
+func newTCPConnPair(t *testing.T) (net.Conn, net.Conn) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer lis.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := lis.Accept()
+		accepted <- conn
+	}()
+	proxySide, err := net.Dial("tcp", lis.Addr().String())
+	assert.NoError(t, err)
+	backendSide := <-accepted
+	t.Cleanup(func() {
+		_ = proxySide.Close()
+		_ = backendSide.Close()
+	})
+	return proxySide, backendSide
+}
+
+func TestCheckConn_PeeksPendingData(t *testing.T) {
+	proxySide, backendSide := newTCPConnPair(t)
+	assert.NoError(t, checkConn(proxySide))
+
+	_, err := backendSide.Write([]byte("+PONG\r\n"))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return errors.Is(checkConn(proxySide), IllegalStateError)
+	}, time.Second, 10*time.Millisecond)
+	// the check consumed nothing
+	assert.ErrorIs(t, checkConn(proxySide), IllegalStateError)
+	reply, err := respio.NewRespReader(proxySide).Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "PONG", string(reply.Data))
+
+	_ = backendSide.Close()
+	assert.Eventually(t, func() bool {
+		return checkConn(proxySide) != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBackendPool_HealthWithReplyInFlight(t *testing.T) {
+	proxySide, backendSide := newTCPConnPair(t)
+	conn := newBackendConn(proxySide, "tcp", 16)
+	t.Cleanup(func() { _ = conn.Close() })
+	pool := &BackendPool{cfg: &PoolConfig{}}
+	assert.True(t, pool.health(conn))
+
+	session := &Session{Id: "health", OutQ: make(chan *ResponseContext, 1)}
+	conn.Enqueue(&RequestContext{Session: session, Request: respio.NewCommand("GET", "k")})
+	request, err := respio.NewRespReader(backendSide).Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "GET", string(request.GetCommand()))
+	// the reply arrives in parts, the ReadLoop waits for the rest meanwhile
+	_, err = backendSide.Write([]byte("$5\r\nhel"))
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, pool.health(conn))
+	_, err = backendSide.Write([]byte("lo\r\n"))
+	assert.NoError(t, err)
+	rspCtx := <-session.OutQ
+	assert.Equal(t, "hello", string(rspCtx.Response.Data))
+
+	// a transaction is not disturbed either
+	conn.UpdateTxnState(session, respio.TxCmdStateWatch)
+	assert.True(t, pool.health(conn))
+	conn.ClearTxnState()
+
+	_ = backendSide.Close()
+	assert.Eventually(t, func() bool { return !pool.health(conn) }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,102 @@
+package be_cluster
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+var (
+	// probeTimeout bounds the wait for the PONG of a backend instance
+	probeTimeout = time.Second
+	// probeCacheTTL is how long a probe result is served, frequent health checks do not hammer
+	// the backends
+	probeCacheTTL = 2 * time.Second
+
+	errProbeTimeout = errors.New("PING timed out")
+)
+
+// InstanceProbe is the outcome of a PING sent to a backend instance.
+type InstanceProbe struct {
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// backendProber caches the probes of all the backend instances.
+type backendProber struct {
+	mu       sync.Mutex
+	probes   []InstanceProbe
+	probedAt time.Time
+}
+
+// Probe sends a PING on a connection of the pool out of a transaction, a connection that died
+// since the pool was ready fails it.
+func (f *FixedPool) Probe(timeout time.Duration) error {
+	conn, err := f.GetNoTxConn()
+	if err != nil {
+		return err
+	}
+	collector := &Session{
+		Id:   "probe",
+		OutQ: make(chan *ResponseContext, 1),
+	}
+	conn.Enqueue(&RequestContext{
+		RequestId: NextRequestId(),
+		Session:   collector,
+		Request:   respio.NewCommand("PING"),
+	})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case rspCtx := <-collector.OutQ:
+		reply := rspCtx.Response
+		defer respio.ReleaseRespPacket(reply)
+		if reply.Type != respio.RespStatus || !strings.EqualFold(string(reply.Data), "PONG") {
+			return fmt.Errorf("unexpected PING reply: %s", reply.Data)
+		}
+		return nil
+	case <-timer.C:
+		return errProbeTimeout
+	}
+}
+
+// ProbeBackends pings every backend instance, sorted by address. The result is cached for
+// probeCacheTTL.
+func (m *BackendManager) ProbeBackends() []InstanceProbe {
+	m.prober.mu.Lock()
+	defer m.prober.mu.Unlock()
+	if m.prober.probes != nil && time.Since(m.prober.probedAt) < probeCacheTTL {
+		return m.prober.probes
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		probes = make([]InstanceProbe, 0, m.instancePool.Size())
+	)
+	m.instancePool.Range(func(addr string, pool *FixedPool) bool {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probe := InstanceProbe{Addr: addr, Healthy: true}
+			if err := pool.Probe(probeTimeout); err != nil {
+				probe.Healthy, probe.Error = false, err.Error()
+			}
+			mu.Lock()
+			probes = append(probes, probe)
+			mu.Unlock()
+		}()
+		return true
+	})
+	wg.Wait()
+	slices.SortFunc(probes, func(a, b InstanceProbe) int {
+		return strings.Compare(a.Addr, b.Addr)
+	})
+	m.prober.probes, m.prober.probedAt = probes, time.Now()
+	return probes
+}
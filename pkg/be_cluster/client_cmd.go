@@ -0,0 +1,167 @@
+package be_cluster
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// ClientCmdAction tells how the proxy handles a CLIENT sub command. Backend connections are
+// shared by many sessions, so per-connection state must never reach the backend.
+type ClientCmdAction int
+
+const (
+	// ClientCmdForward the sub command has no connection state and is forwarded
+	ClientCmdForward ClientCmdAction = iota
+	// ClientCmdLocal the proxy answers from the session
+	ClientCmdLocal
+	// ClientCmdSession the proxy stores the state on the session
+	ClientCmdSession
+	// ClientCmdReject the sub command would affect the other sessions of the backend connection
+	ClientCmdReject
+)
+
+var clientSubCommands = map[string]ClientCmdAction{
+	"help":         ClientCmdForward,
+	"id":           ClientCmdLocal,
+	"info":         ClientCmdLocal,
+	"list":         ClientCmdLocal,
+	"getname":      ClientCmdLocal,
+	"setname":      ClientCmdSession,
+	"setinfo":      ClientCmdSession,
+	"reply":        ClientCmdSession,
+	"no-evict":     ClientCmdSession,
+	"no-touch":     ClientCmdSession,
+	"kill":         ClientCmdReject,
+	"pause":        ClientCmdReject,
+	"unpause":      ClientCmdReject,
+	"unblock":      ClientCmdReject,
+	"tracking":     ClientCmdReject,
+	"trackinginfo": ClientCmdReject,
+	"getredir":     ClientCmdReject,
+	"caching":      ClientCmdReject,
+}
+
+// ClassifyClientCmd returns the action for a CLIENT command and its lower-cased sub command.
+// Unknown sub commands are rejected.
+func ClassifyClientCmd(packet *respio.RespPacket) (ClientCmdAction, string) {
+	if len(packet.Array) < 2 {
+		return ClientCmdReject, ""
+	}
+	subCmd := strings.ToLower(string(packet.Array[1].Data))
+	action, ok := clientSubCommands[subCmd]
+	if !ok {
+		return ClientCmdReject, subCmd
+	}
+	return action, subCmd
+}
+
+// HandleClientCommand handles a CLIENT command at the proxy. It returns false if the command
+// must be forwarded to the backend. A nil reply means the client gets no reply.
+func HandleClientCommand(session *Session, packet *respio.RespPacket) (*respio.RespPacket, bool) {
+	action, subCmd := ClassifyClientCmd(packet)
+	switch action {
+	case ClientCmdForward:
+		return nil, false
+	case ClientCmdLocal:
+		return session.clientLocalReply(subCmd), true
+	case ClientCmdSession:
+		return session.updateClientState(subCmd, packet.Array[2:]), true
+	default:
+		if subCmd == "" {
+			return respio.NewError("ERR wrong number of arguments for 'client' command"), true
+		}
+		return respio.NewError(fmt.Sprintf("ERR CLIENT %s is not supported by the proxy",
+			strings.ToUpper(subCmd))), true
+	}
+}
+
+func (s *Session) clientLocalReply(subCmd string) *respio.RespPacket {
+	switch subCmd {
+	case "id":
+		return respio.NewInteger(int64(s.clientId))
+	case "getname":
+		return respio.NewBulkString(s.name)
+	default:
+		// INFO and LIST only show the session itself, the backend connections are shared
+		return respio.NewBulkString([]byte(s.clientInfo()))
+	}
+}
+
+// clientInfo formats the session like a line of CLIENT LIST. The user is the tenant of the
+// session. SELECT is forwarded rather than tracked by the session, db is always 0.
+func (s *Session) clientInfo() string {
+	var laddr, user string
+	if s.Client != nil {
+		laddr = s.Client.LocalAddr().String()
+	}
+	if authInfo := s.GetAuthInfo(); authInfo != nil {
+		user = string(authInfo.Username)
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s db=0 resp=%d user=%s lib-name=%s lib-ver=%s\n",
+		s.clientId, s.Id, laddr, s.name, s.ProtoVersion(), user, s.libName, s.libVer)
+}
+
+func (s *Session) updateClientState(subCmd string, args []*respio.RespPacket) *respio.RespPacket {
+	switch subCmd {
+	case "setinfo":
+		if len(args) != 2 {
+			return errClientSyntax(subCmd)
+		}
+		switch strings.ToLower(string(args[0].Data)) {
+		case "lib-name":
+			s.libName = bytes.Clone(args[1].Data)
+		case "lib-ver":
+			s.libVer = bytes.Clone(args[1].Data)
+		}
+		return respio.NewStatus(string(respio.OkCmd))
+	case "setname":
+		if len(args) != 1 {
+			return errClientSyntax(subCmd)
+		}
+		if bytes.ContainsAny(args[0].Data, " \n") {
+			return respio.NewError("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		s.name = bytes.Clone(args[0].Data)
+		return respio.NewStatus(string(respio.OkCmd))
+	}
+	if len(args) != 1 {
+		return errClientSyntax(subCmd)
+	}
+	mode := strings.ToLower(string(args[0].Data))
+	if subCmd == "reply" {
+		switch mode {
+		case "on":
+			s.replyMode = ReplyModeOn
+			return respio.NewStatus(string(respio.OkCmd))
+		case "off":
+			s.replyMode = ReplyModeOff
+			return nil
+		case "skip":
+			// the CLIENT REPLY SKIP itself has no reply either
+			if s.replyMode != ReplyModeOff {
+				s.replyMode = ReplyModeSkip
+			}
+			return nil
+		default:
+			return errClientSyntax(subCmd)
+		}
+	}
+	// NO-EVICT and NO-TOUCH only apply to the session, the shared backend connections keep
+	// the server defaults.
+	if mode != "on" && mode != "off" {
+		return errClientSyntax(subCmd)
+	}
+	if subCmd == "no-evict" {
+		s.noEvict = mode == "on"
+	} else {
+		s.noTouch = mode == "on"
+	}
+	return respio.NewStatus(string(respio.OkCmd))
+}
+
+func errClientSyntax(subCmd string) *respio.RespPacket {
+	return respio.NewError(fmt.Sprintf("ERR syntax error in 'client|%s' command", subCmd))
+}
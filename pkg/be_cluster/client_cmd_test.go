@@ -0,0 +1,145 @@
+package be_cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+// echoKey answers every command with its first argument.
+func echoKey(req *respio.RespPacket) *respio.RespPacket {
+	return &respio.RespPacket{Type: respio.RespString, Data: req.Array[1].Data}
+}
+
+func TestClassifyClientCmd(t *testing.T) {
+	tests := []struct {
+		args   []string
+		action ClientCmdAction
+	}{
+		{[]string{"CLIENT", "ID"}, ClientCmdLocal},
+		{[]string{"client", "GETNAME"}, ClientCmdLocal},
+		{[]string{"CLIENT", "SETNAME", "app"}, ClientCmdSession},
+		{[]string{"CLIENT", "REPLY", "OFF"}, ClientCmdSession},
+		{[]string{"CLIENT", "NO-EVICT", "ON"}, ClientCmdSession},
+		{[]string{"CLIENT", "NO-TOUCH", "ON"}, ClientCmdSession},
+		{[]string{"CLIENT", "UNPAUSE"}, ClientCmdReject},
+		{[]string{"CLIENT", "KILL", "ID", "1"}, ClientCmdReject},
+		{[]string{"CLIENT", "NOT-A-SUBCOMMAND"}, ClientCmdReject},
+		{[]string{"CLIENT"}, ClientCmdReject},
+		{[]string{"CLIENT", "HELP"}, ClientCmdForward},
+	}
+	for _, tt := range tests {
+		action, _ := ClassifyClientCmd(respio.NewCommand(tt.args...))
+		assert.Equal(t, tt.action, action, tt.args)
+	}
+}
+
+// clientCmdSender mimics the proxy dispatch of an authenticated session.
+func clientCmdSender(t *testing.T, sm *SessionManager, session *Session) func(args ...string) {
+	return func(args ...string) {
+		packet := respio.NewCommand(args...)
+		if args[0] == "CLIENT" {
+			if reply, handled := HandleClientCommand(session, packet); handled {
+				session.ReplyLocal(reply)
+				return
+			}
+		}
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), packet, session.GetAuthInfo()))
+	}
+}
+
+func TestHandleClientCommand_Reply(t *testing.T) {
+	sm, session, clientReader := newTestSessionManager(t, echoKey)
+	send := clientCmdSender(t, sm, session)
+
+	send("CLIENT", "REPLY", "OFF")
+	send("GET", "k1")
+	send("CLIENT", "SETNAME", "app")
+	send("CLIENT", "REPLY", "ON")
+	send("GET", "k2")
+
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespStatus, reply.Type)
+	assert.Equal(t, respio.OkCmd, reply.Data)
+	reply, err = clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", string(reply.Data))
+	// the name was set while the replies were off
+	assert.Equal(t, "app", string(session.name))
+}
+
+func TestHandleClientCommand_ReplySkip(t *testing.T) {
+	sm, session, clientReader := newTestSessionManager(t, echoKey)
+	send := clientCmdSender(t, sm, session)
+
+	send("CLIENT", "REPLY", "SKIP")
+	send("GET", "k1")
+	send("GET", "k2")
+	send("CLIENT", "REPLY", "SKIP")
+	send("CLIENT", "ID")
+	send("GET", "k3")
+
+	for _, expected := range []string{"k2", "k3"} {
+		reply, err := clientReader.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(reply.Data))
+	}
+	assert.Equal(t, ReplyModeOn, session.replyMode)
+}
+
+func TestHandleClientCommand_Info(t *testing.T) {
+	sm, session, clientReader := newTestSessionManager(t, echoKey)
+	send := clientCmdSender(t, sm, session)
+
+	send("CLIENT", "SETNAME", "app")
+	send("CLIENT", "SETINFO", "LIB-NAME", "redis-py")
+	send("CLIENT", "SETINFO", "lib-ver", "5.0.1")
+	send("CLIENT", "INFO")
+	for i := 0; i < 3; i++ {
+		_, err := clientReader.Read()
+		assert.NoError(t, err)
+	}
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespString, reply.Type)
+	expected := fmt.Sprintf("id=%d addr=%s laddr=pipe name=app db=0 resp=2 user=tenant-a lib-name=redis-py lib-ver=5.0.1\n",
+		session.clientId, session.Id)
+	assert.Equal(t, expected, string(reply.Data))
+}
+
+func TestHandleClientCommand_LocalReplyOrder(t *testing.T) {
+	release := make(chan struct{})
+	sm, session, clientReader := newTestSessionManager(t, func(req *respio.RespPacket) *respio.RespPacket {
+		if string(req.Array[1].Data) == "slow" {
+			<-release
+		}
+		return echoKey(req)
+	})
+	send := clientCmdSender(t, sm, session)
+
+	// the local replies wait for the replies of the commands pipelined before them
+	send("CLIENT", "SETNAME", "app")
+	send("GET", "slow")
+	send("CLIENT", "GETNAME")
+	send("GET", "k2")
+	send("CLIENT", "ID")
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.OkCmd, reply.Data)
+	close(release)
+	for _, expected := range []string{"slow", "app", "k2"} {
+		reply, err = clientReader.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(reply.Data))
+	}
+	reply, err = clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(session.clientId), string(reply.Data))
+	session.orderMu.Lock()
+	defer session.orderMu.Unlock()
+	assert.Equal(t, session.nextSeq, session.nextOut)
+	assert.Empty(t, session.held)
+}
@@ -0,0 +1,54 @@
+package be_cluster
+
+import "sync"
+
+// clusterEventBuffer is how many changes a subscriber may lag behind before it is dropped
+const clusterEventBuffer = 64
+
+// clusterEvents fans the cluster changes out to the subscribers of the registry, the notify
+// channel stays the one of the router. The registry never waits for a subscriber: one that falls
+// behind is unsubscribed and its channel closed, it lists the clusters again when it subscribes
+// anew.
+type clusterEvents struct {
+	mu          sync.Mutex
+	subscribers map[chan *ClusterInstance]struct{}
+}
+
+func newClusterEvents() *clusterEvents {
+	return &clusterEvents{
+		subscribers: make(map[chan *ClusterInstance]struct{}),
+	}
+}
+
+func (e *clusterEvents) subscribe() (<-chan *ClusterInstance, func()) {
+	events := make(chan *ClusterInstance, clusterEventBuffer)
+	e.mu.Lock()
+	e.subscribers[events] = struct{}{}
+	e.mu.Unlock()
+	return events, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.remove(events)
+	}
+}
+
+func (e *clusterEvents) publish(instance *ClusterInstance) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for events := range e.subscribers {
+		select {
+		case events <- instance:
+		default:
+			logger.Info("Dropping a slow cluster event subscriber", "Instance", instance.GetAddr())
+			e.remove(events)
+		}
+	}
+}
+
+// remove must be called with the lock held, a subscriber already removed is ignored.
+func (e *clusterEvents) remove(events chan *ClusterInstance) {
+	if _, ok := e.subscribers[events]; ok {
+		delete(e.subscribers, events)
+		close(events)
+	}
+}
@@ -19,7 +19,9 @@ const (
 	ClusterStatusReady   ClusterStatus = "ready"
 	ClusterStatusOnline  ClusterStatus = "online"
 	ClusterStatusOffline ClusterStatus = "offline"
-	ClusterStatusDeleted ClusterStatus = "deleted"
+	// ClusterStatusDraining moves the sessions off the instance, its pool is closed once idle
+	ClusterStatusDraining ClusterStatus = "draining"
+	ClusterStatusDeleted  ClusterStatus = "deleted"
 )
 
 type SharedClusterInstance struct {
@@ -192,19 +194,44 @@ func ClusterKeyHash(key ClusterKey, _ uint64) uint64 {
 	return hash
 }
 
+// ErrClusterExists is returned when a cluster is added twice
+var ErrClusterExists = errors.New("cluster already exists")
+
 // ClusterRegistry is a registry for clusters. It is used to manage the clusters and their instances.
 type ClusterRegistry interface {
-	// AddCluster adds a cluster to the registry.
+	// AddCluster adds a cluster to the registry. A cluster added already keeps its instances,
+	// ErrClusterExists is returned.
 	AddCluster(key *ClusterKey) error
 	// StatusChange updates the status of a cluster. It is used to update the status of a cluster when it is online or offline.
 	// Must be after AddCluster.
 	StatusChange(instance *ClusterInstance) error
 	// Notify is a channel that will be notified when a cluster is added or updated.
 	Notify() chan *ClusterInstance
+	// SubscribeChanges returns the cluster changes from now on and a func ending the subscription.
+	// The channel is closed when the subscriber falls behind, it is never waited for.
+	SubscribeChanges() (<-chan *ClusterInstance, func())
 	// GetClusterInstance returns the cluster instance for the given key.
 	GetClusterInstance(key ClusterKey) (*SharedClusterInstance, error)
 	// AllClusterInstances returns all cluster instances.
 	AllClusterInstances() []*ClusterInstance
+	// SetTenantACL validates and stores the ACL of a tenant, replacing the previous one.
+	SetTenantACL(tenant string, acl *TenantACL) error
+	// DeleteTenantACL removes the ACL of a tenant, it may run every command again.
+	DeleteTenantACL(tenant string)
+	// GetTenantACL returns the ACL of a tenant, nil if it is not restricted.
+	GetTenantACL(tenant string) *TenantACL
+	// SetTenantTTLPolicy validates and stores the TTL policy of a tenant, replacing the previous one.
+	SetTenantTTLPolicy(tenant string, policy *TenantTTLPolicy) error
+	// DeleteTenantTTLPolicy removes the TTL policy of a tenant, its writes are not rewritten anymore.
+	DeleteTenantTTLPolicy(tenant string)
+	// GetTenantTTLPolicy returns the TTL policy of a tenant, nil if it has none.
+	GetTenantTTLPolicy(tenant string) *TenantTTLPolicy
+	// BindTenant routes a tenant to a cluster, replacing the previous binding. Must be after AddCluster.
+	BindTenant(tenant string, key ClusterKey) error
+	// UnbindTenant removes the binding of a tenant.
+	UnbindTenant(tenant string)
+	// GetTenantBinding returns the cluster a tenant is bound to, nil if it is not bound.
+	GetTenantBinding(tenant string) *ClusterKey
 }
 
 var (
@@ -216,6 +243,14 @@ var (
 type DefaultClusterRegistry struct {
 	clusters *xsync.MapOf[ClusterKey, *SharedClusterInstance]
 	notify   chan *ClusterInstance
+	// events fans the changes out to the subscribers besides the router
+	events *clusterEvents
+	// acls holds the ACL per tenant, the tenant is the AUTH username
+	acls *xsync.MapOf[string, *TenantACL]
+	// ttlPolicies holds the TTL policy per tenant
+	ttlPolicies *xsync.MapOf[string, *TenantTTLPolicy]
+	// bindings holds the cluster per tenant, set explicitly instead of derived from the owner
+	bindings *xsync.MapOf[string, ClusterKey]
 }
 
 func (h *DefaultClusterRegistry) AllClusterInstances() []*ClusterInstance {
@@ -238,6 +273,10 @@ func (h *DefaultClusterRegistry) Notify() chan *ClusterInstance {
 	return h.notify
 }
 
+func (h *DefaultClusterRegistry) SubscribeChanges() (<-chan *ClusterInstance, func()) {
+	return h.events.subscribe()
+}
+
 func GetClusterRegistry() ClusterRegistry {
 	registryOnce.Do(func() {
 		registryInstance = newDefaultClusterRegistry()
@@ -247,13 +286,19 @@ func GetClusterRegistry() ClusterRegistry {
 
 func newDefaultClusterRegistry() *DefaultClusterRegistry {
 	return &DefaultClusterRegistry{
-		clusters: xsync.NewMapOfWithHasher[ClusterKey, *SharedClusterInstance](ClusterKeyHash),
-		notify:   make(chan *ClusterInstance, 1024),
+		clusters:    xsync.NewMapOfWithHasher[ClusterKey, *SharedClusterInstance](ClusterKeyHash),
+		notify:      make(chan *ClusterInstance, 1024),
+		events:      newClusterEvents(),
+		acls:        xsync.NewMapOf[string, *TenantACL](),
+		ttlPolicies: xsync.NewMapOf[string, *TenantTTLPolicy](),
+		bindings:    xsync.NewMapOf[string, ClusterKey](),
 	}
 }
 
 func (h *DefaultClusterRegistry) AddCluster(key *ClusterKey) error {
-	h.clusters.Store(*key, NewSharedClusterInstance())
+	if _, loaded := h.clusters.LoadOrStore(*key, NewSharedClusterInstance()); loaded {
+		return ErrClusterExists
+	}
 	return nil
 }
 
@@ -266,5 +311,67 @@ func (h *DefaultClusterRegistry) StatusChange(instance *ClusterInstance) error {
 		return oldValue, false
 	})
 	h.notify <- instance
+	h.events.publish(instance)
+	return nil
+}
+
+func (h *DefaultClusterRegistry) SetTenantACL(tenant string, acl *TenantACL) error {
+	if err := acl.Validate(); err != nil {
+		return err
+	}
+	h.acls.Store(tenant, acl)
+	return nil
+}
+
+func (h *DefaultClusterRegistry) DeleteTenantACL(tenant string) {
+	h.acls.Delete(tenant)
+}
+
+func (h *DefaultClusterRegistry) GetTenantACL(tenant string) *TenantACL {
+	if h.acls.Size() == 0 {
+		// the common case, no lookup per command
+		return nil
+	}
+	acl, _ := h.acls.Load(tenant)
+	return acl
+}
+
+func (h *DefaultClusterRegistry) SetTenantTTLPolicy(tenant string, policy *TenantTTLPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	h.ttlPolicies.Store(tenant, policy)
+	return nil
+}
+
+func (h *DefaultClusterRegistry) DeleteTenantTTLPolicy(tenant string) {
+	h.ttlPolicies.Delete(tenant)
+}
+
+func (h *DefaultClusterRegistry) GetTenantTTLPolicy(tenant string) *TenantTTLPolicy {
+	if h.ttlPolicies.Size() == 0 {
+		return nil
+	}
+	policy, _ := h.ttlPolicies.Load(tenant)
+	return policy
+}
+
+func (h *DefaultClusterRegistry) BindTenant(tenant string, key ClusterKey) error {
+	if _, ok := h.clusters.Load(key); !ok {
+		return errors.New("cluster not found")
+	}
+	h.bindings.Store(tenant, key)
 	return nil
 }
+
+func (h *DefaultClusterRegistry) UnbindTenant(tenant string) {
+	h.bindings.Delete(tenant)
+}
+
+func (h *DefaultClusterRegistry) GetTenantBinding(tenant string) *ClusterKey {
+	key, ok := h.bindings.Load(tenant)
+	if !ok {
+		return nil
+	}
+	return &key
+}
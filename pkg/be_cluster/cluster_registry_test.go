@@ -21,3 +21,48 @@ func TestClusterInstance_EncodeClusterKey(t *testing.T) {
 
 	assert.Equal(t, clusterKeyHash, base62)
 }
+
+func TestClusterRegistry_SubscribeChanges(t *testing.T) {
+	registry := newDefaultClusterRegistry()
+	instance := LocalClusterInstance("127.0.0.1", 6379)
+	assert.NoError(t, registry.AddCluster(&instance.Key))
+
+	events, unsubscribe := registry.SubscribeChanges()
+	slow, _ := registry.SubscribeChanges()
+	assert.NoError(t, registry.StatusChange(instance))
+	// every subscriber receives the change, the router does as well
+	assert.Same(t, instance, <-events)
+	assert.Same(t, instance, <-slow)
+	assert.Same(t, instance, <-registry.Notify())
+
+	// the slow subscriber is dropped instead of holding the registry back
+	for i := 0; i < clusterEventBuffer+1; i++ {
+		assert.NoError(t, registry.StatusChange(instance))
+		<-events
+		<-registry.Notify()
+	}
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, clusterEventBuffer, received)
+
+	unsubscribe()
+	_, ok := <-events
+	assert.False(t, ok)
+	// ending a subscription twice is harmless
+	unsubscribe()
+	assert.NoError(t, registry.StatusChange(instance))
+}
+
+func TestClusterRegistry_AddClusterTwice(t *testing.T) {
+	registry := newDefaultClusterRegistry()
+	instance := LocalClusterInstance("127.0.0.1", 6379)
+	assert.NoError(t, registry.AddCluster(&instance.Key))
+	assert.NoError(t, registry.StatusChange(instance))
+
+	assert.ErrorIs(t, registry.AddCluster(&instance.Key), ErrClusterExists)
+	cluster, err := registry.GetClusterInstance(instance.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ClusterInstance{instance}, cluster.GetAllClusterForRead())
+}
@@ -0,0 +1,53 @@
+package be_cluster
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// ErrCommandNotAllowed is replied to a command missing from the allowlist.
+var ErrCommandNotAllowed = errors.New("ERR command not allowed")
+
+// connectionCmds are always allowed, a client could not connect or probe the proxy otherwise.
+var connectionCmds = []string{"auth", "hello", "ping", "quit"}
+
+// CommandAllowlist restricts the commands of every client to an explicit set, e.g. GET, SET
+// and DEL for a cache tier. It is checked before the tenant ACLs.
+type CommandAllowlist struct {
+	allowed map[string]struct{}
+}
+
+// NewCommandAllowlist returns nil when no command is listed, every command is allowed then.
+func NewCommandAllowlist(cmds []string) *CommandAllowlist {
+	allowed := make(map[string]struct{}, len(cmds)+len(connectionCmds))
+	for _, cmd := range cmds {
+		if cmd = strings.ToLower(strings.TrimSpace(cmd)); cmd != "" {
+			allowed[cmd] = struct{}{}
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, cmd := range connectionCmds {
+		allowed[cmd] = struct{}{}
+	}
+	return &CommandAllowlist{
+		allowed: allowed,
+	}
+}
+
+func isConnectionCmd(packet *respio.RespPacket) bool {
+	return slices.Contains(connectionCmds, strings.ToLower(string(packet.GetCommand())))
+}
+
+// Allow reports whether the command is on the list, a nil allowlist allows every command.
+func (a *CommandAllowlist) Allow(packet *respio.RespPacket) bool {
+	if a == nil {
+		return true
+	}
+	_, ok := a.allowed[strings.ToLower(string(packet.GetCommand()))]
+	return ok
+}
@@ -0,0 +1,26 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandAllowlist(t *testing.T) {
+	allowlist := NewCommandAllowlist([]string{"GET", " set ", "del"})
+	assert.True(t, allowlist.Allow(respio.NewCommand("get", "k")))
+	assert.True(t, allowlist.Allow(respio.NewCommand("SET", "k", "v")))
+	assert.False(t, allowlist.Allow(respio.NewCommand("FLUSHALL")))
+	assert.False(t, allowlist.Allow(respio.NewCommand("CONFIG", "SET", "maxmemory", "0")))
+	// clients can still connect
+	for _, cmd := range []string{"AUTH", "HELLO", "PING", "QUIT"} {
+		assert.True(t, allowlist.Allow(respio.NewCommand(cmd)), cmd)
+	}
+
+	// no allowlist, every command runs
+	none := NewCommandAllowlist(nil)
+	assert.Nil(t, none)
+	assert.True(t, none.Allow(respio.NewCommand("FLUSHALL")))
+	assert.Nil(t, NewCommandAllowlist([]string{" "}))
+}
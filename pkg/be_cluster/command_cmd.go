@@ -0,0 +1,45 @@
+package be_cluster
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+var (
+	commandCmd    = []byte("command")
+	getKeysSubCmd = []byte("getkeys")
+)
+
+// HandleCommandGetKeys answers COMMAND GETKEYS with the keys the proxy extracts from the command,
+// the same ones the key pins, the tenant ACLs and the hot key sampling see. It returns false for
+// the other COMMAND sub commands, they are answered by the backend.
+func HandleCommandGetKeys(packet *respio.RespPacket) (*respio.RespPacket, bool) {
+	if len(packet.Array) < 2 || !bytes.EqualFold(packet.Array[0].Data, commandCmd) ||
+		!bytes.EqualFold(packet.Array[1].Data, getKeysSubCmd) {
+		return nil, false
+	}
+	if len(packet.Array) < 3 {
+		return respio.NewError("ERR wrong number of arguments for 'command|getkeys' command"), true
+	}
+	command := &respio.RespPacket{Type: respio.RespArray, Array: packet.Array[2:]}
+	meta := respio.LookupCommand(command)
+	if meta == nil {
+		return respio.NewError("ERR Invalid command specified"), true
+	}
+	if !meta.KeysExtractable() {
+		return respio.NewError(fmt.Sprintf("ERR the proxy cannot extract the keys of '%s'", meta.Name)), true
+	}
+	keys := meta.ExtractKeys(command)
+	if len(keys) == 0 {
+		return respio.NewError("ERR The command has no key arguments"), true
+	}
+	reply := respio.AcquireRespPacket()
+	reply.Type = respio.RespArray
+	reply.Array = make([]*respio.RespPacket, 0, len(keys))
+	for _, key := range keys {
+		reply.Array = append(reply.Array, respio.NewBulkString(key))
+	}
+	return reply, true
+}
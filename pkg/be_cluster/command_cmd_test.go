@@ -0,0 +1,49 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleCommandGetKeys(t *testing.T) {
+	getKeys := func(args ...string) []string {
+		reply, handled := HandleCommandGetKeys(respio.NewCommand(append([]string{"COMMAND", "GETKEYS"}, args...)...))
+		assert.True(t, handled, args)
+		assert.Equal(t, respio.RespArray, reply.Type, string(reply.Data))
+		var keys []string
+		for _, elem := range reply.Array {
+			keys = append(keys, string(elem.Data))
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"foo"}, getKeys("SET", "foo", "bar"))
+	assert.Equal(t, []string{"a", "b"}, getKeys("MSET", "a", "1", "b", "2"))
+	assert.Equal(t, []string{"k1", "k2"}, getKeys("eval", "return 1", "2", "k1", "k2", "arg"))
+	assert.Equal(t, []string{"src", "dst"}, getKeys("LMOVE", "src", "dst", "LEFT", "RIGHT"))
+	assert.Equal(t, []string{"k"}, getKeys("OBJECT", "ENCODING", "k"))
+
+	tests := []struct {
+		args   []string
+		errMsg string
+	}{
+		{[]string{"COMMAND", "GETKEYS"}, "ERR wrong number of arguments for 'command|getkeys' command"},
+		{[]string{"COMMAND", "GETKEYS", "NOSUCHCMD", "k"}, "ERR Invalid command specified"},
+		{[]string{"COMMAND", "GETKEYS", "PING"}, "ERR The command has no key arguments"},
+		{[]string{"COMMAND", "GETKEYS", "EVAL", "return 1", "0"}, "ERR The command has no key arguments"},
+		{[]string{"COMMAND", "GETKEYS", "ZUNIONSTORE", "dst", "1", "z1"}, "ERR the proxy cannot extract the keys of 'zunionstore'"},
+	}
+	for _, tt := range tests {
+		reply, handled := HandleCommandGetKeys(respio.NewCommand(tt.args...))
+		assert.True(t, handled, tt.args)
+		assert.Equal(t, respio.RespError, reply.Type, tt.args)
+		assert.Equal(t, tt.errMsg, string(reply.Data), tt.args)
+	}
+
+	// the other sub commands are answered by the backend
+	for _, args := range [][]string{{"COMMAND"}, {"COMMAND", "INFO", "get"}, {"GET", "getkeys"}} {
+		_, handled := HandleCommandGetKeys(respio.NewCommand(args...))
+		assert.False(t, handled, args)
+	}
+}
@@ -0,0 +1,142 @@
+package be_cluster
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// ProxyConfigPrefix names the parameters of the proxy itself in CONFIG GET, e.g.
+// "CONFIG GET proxy.backend-pool.*". The other parameters are the ones of the backend.
+const ProxyConfigPrefix = "proxy."
+
+var (
+	configCmd    = []byte("config")
+	getSubCmd    = []byte("get")
+	setSubCmd    = []byte("set")
+	secretParams = []string{"token", "password"}
+)
+
+// ProxyConfigParams flattens the proxy configuration into the parameters of CONFIG GET, named
+// like the command line flags. Secrets, e.g. the admin token, are left out.
+func ProxyConfigParams(config *common.ProxyConfig) map[string]string {
+	params := make(map[string]string)
+	flattenConfig(reflect.ValueOf(config).Elem(), ProxyConfigPrefix, params)
+	return params
+}
+
+func flattenConfig(value reflect.Value, prefix string, params map[string]string) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if _, embedded := field.Tag.Lookup("embed"); embedded {
+			flattenConfig(value.Field(i), prefix+field.Tag.Get("prefix"), params)
+			continue
+		}
+		name := field.Tag.Get("name")
+		if name == "" {
+			name = kebabCase(field.Name)
+		}
+		if slices.ContainsFunc(secretParams, func(secret string) bool { return strings.Contains(name, secret) }) {
+			continue
+		}
+		params[prefix+name] = formatParam(value.Field(i).Interface())
+	}
+}
+
+// formatParam formats a value like Redis does, booleans are yes or no.
+func formatParam(value any) string {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case time.Duration:
+		return v.String()
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// kebabCase names a field like kong does for a flag without a name, e.g. EnableTLS is enable-tls.
+func kebabCase(name string) string {
+	var builder strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 &&
+			(unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			builder.WriteByte('-')
+		}
+		builder.WriteRune(unicode.ToLower(r))
+	}
+	return builder.String()
+}
+
+func isProxyParam(pattern []byte) bool {
+	return len(pattern) >= len(ProxyConfigPrefix) &&
+		strings.EqualFold(string(pattern[:len(ProxyConfigPrefix)]), ProxyConfigPrefix)
+}
+
+// HandleConfigCommand answers the CONFIG commands on the proxy parameters. It returns false if
+// the command must be sent to the backend: CONFIG GET of backend parameters is answered by the
+// instance of the session, CONFIG SET is broadcast to every connection.
+func HandleConfigCommand(config *common.ProxyConfig, packet *respio.RespPacket) (*respio.RespPacket, bool) {
+	if len(packet.Array) < 3 || !bytes.EqualFold(packet.Array[0].Data, configCmd) {
+		return nil, false
+	}
+	subCmd, args := packet.Array[1].Data, packet.Array[2:]
+	step := 1
+	switch {
+	case bytes.EqualFold(subCmd, setSubCmd):
+		// the parameters of CONFIG SET are followed by their values
+		step = 2
+	case !bytes.EqualFold(subCmd, getSubCmd):
+		return nil, false
+	}
+	proxyArgs, names := 0, 0
+	for i := 0; i < len(args); i += step {
+		names++
+		if isProxyParam(args[i].Data) {
+			proxyArgs++
+		}
+	}
+	switch {
+	case proxyArgs == 0:
+		return nil, false
+	case step == 2:
+		return respio.NewError("ERR the proxy parameters are read-only, restart the proxy to change them"), true
+	case proxyArgs != names:
+		return respio.NewError("ERR CONFIG GET cannot mix proxy and backend parameters"), true
+	}
+	params := ProxyConfigParams(config)
+	matched := make([]string, 0, len(params))
+	for name := range params {
+		for _, arg := range args {
+			if globMatch(strings.ToLower(string(arg.Data)), name) {
+				matched = append(matched, name)
+				break
+			}
+		}
+	}
+	slices.Sort(matched)
+	reply := respio.AcquireRespPacket()
+	reply.Type = respio.RespMap
+	reply.Array = make([]*respio.RespPacket, 0, 2*len(matched))
+	for _, name := range matched {
+		reply.Array = append(reply.Array, respio.NewBulkString([]byte(name)),
+			respio.NewBulkString([]byte(params[name])))
+	}
+	return reply, true
+}
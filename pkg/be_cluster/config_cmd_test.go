@@ -0,0 +1,81 @@
+package be_cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleConfigCommand(t *testing.T) {
+	config := &common.ProxyConfig{
+		ProxyPort: 6378,
+		EnableTLS: true,
+		BeConnPool: common.BackendPoolConfig{
+			MaxSize:     30,
+			MaxIdle:     10,
+			DialTimeout: 3 * time.Second,
+		},
+		Security:  common.SecurityConfig{AllowedCmds: []string{"get", "set"}},
+		WebServer: common.WebServerConfig{AdminToken: "secret-token"},
+	}
+	params := ProxyConfigParams(config)
+	assert.Equal(t, "6378", params["proxy.port"])
+	assert.Equal(t, "yes", params["proxy.enable-tls"])
+	assert.Equal(t, "3s", params["proxy.backend-pool.dial-timeout"])
+	assert.Equal(t, "get,set", params["proxy.security.allowed-cmds"])
+	// secrets are never answered
+	assert.NotContains(t, params, "proxy.web-proxy.admin-token")
+	for _, value := range params {
+		assert.NotEqual(t, "secret-token", value)
+	}
+
+	reply, handled := HandleConfigCommand(config, respio.NewCommand("CONFIG", "GET", "PROXY.backend-pool.max-*", "proxy.port"))
+	assert.True(t, handled)
+	assert.Equal(t, respio.RespMap, reply.Type)
+	var pairs []string
+	for _, elem := range reply.Array {
+		pairs = append(pairs, string(elem.Data))
+	}
+	assert.Equal(t, []string{"proxy.backend-pool.max-idle", "10", "proxy.backend-pool.max-in-flight", "0",
+		"proxy.backend-pool.max-size", "30", "proxy.port", "6378"}, pairs)
+
+	tests := []struct {
+		name    string
+		args    []string
+		handled bool
+		errMsg  string
+	}{
+		{name: "backend get", args: []string{"CONFIG", "GET", "maxmemory"}},
+		// broadcast to every backend connection
+		{name: "backend set", args: []string{"CONFIG", "SET", "maxmemory", "proxy.x"}},
+		{name: "resetstat", args: []string{"CONFIG", "RESETSTAT"}},
+		{name: "mixed get", args: []string{"CONFIG", "GET", "proxy.port", "maxmemory"}, handled: true,
+			errMsg: "ERR CONFIG GET cannot mix proxy and backend parameters"},
+		{name: "proxy set", args: []string{"CONFIG", "SET", "proxy.port", "6380"}, handled: true,
+			errMsg: "ERR the proxy parameters are read-only, restart the proxy to change them"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, handled := HandleConfigCommand(config, respio.NewCommand(tt.args...))
+			assert.Equal(t, tt.handled, handled)
+			if tt.errMsg != "" {
+				assert.Equal(t, respio.RespError, reply.Type)
+				assert.Equal(t, tt.errMsg, string(reply.Data))
+			}
+		})
+	}
+}
+
+func TestKebabCase(t *testing.T) {
+	for name, expect := range map[string]string{
+		"MultiCore": "multi-core",
+		"EnableTLS": "enable-tls",
+		"CoreNum":   "core-num",
+		"TLSConfig": "tls-config",
+	} {
+		assert.Equal(t, expect, kebabCase(name))
+	}
+}
@@ -0,0 +1,51 @@
+package be_cluster
+
+import (
+	"errors"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// ErrDebugDisabled is replied to DEBUG when the DEBUG policy blocks it.
+var ErrDebugDisabled = errors.New("ERR DEBUG is disabled by the proxy")
+
+// DebugCmdAction tells how the proxy handles a DEBUG sub command under the DEBUG policy.
+type DebugCmdAction int
+
+const (
+	// DebugCmdReject the policy blocks DEBUG
+	DebugCmdReject DebugCmdAction = iota
+	// DebugCmdForward the sub command is forwarded like any command
+	DebugCmdForward
+	// DebugCmdExclusive the sub command blocks the backend connection, e.g. DEBUG SLEEP, it runs
+	// on a connection of its own
+	DebugCmdExclusive
+)
+
+// ClassifyDebugCmd applies the DEBUG policy to a DEBUG command and returns its lower-cased sub
+// command. Every sub command follows the policy, DEBUG OBJECT included: block rejects them all.
+func ClassifyDebugCmd(policy string, packet *respio.RespPacket) (DebugCmdAction, string) {
+	subCmd, _ := packet.DebugSubCommand()
+	switch policy {
+	case common.DebugPolicyAllow:
+		return DebugCmdForward, subCmd
+	case common.DebugPolicyExclusive:
+		if subCmd == "sleep" {
+			return DebugCmdExclusive, subCmd
+		}
+		return DebugCmdForward, subCmd
+	default:
+		return DebugCmdReject, subCmd
+	}
+}
+
+// IsKeyedDebugCmd reports whether a DEBUG sub command introspects a key, e.g. DEBUG OBJECT. Once
+// allowed by the DEBUG policy it is forwarded to the instance holding the key like OBJECT FREQ.
+func IsKeyedDebugCmd(packet *respio.RespPacket) bool {
+	if _, ok := packet.DebugSubCommand(); !ok {
+		return false
+	}
+	meta := respio.LookupCommand(packet)
+	return meta != nil && meta.FirstKey > 0
+}
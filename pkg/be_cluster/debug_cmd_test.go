@@ -0,0 +1,45 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsKeyedDebugCmd(t *testing.T) {
+	debugObject := respio.NewCommand("DEBUG", "OBJECT", "k1")
+	assert.True(t, IsKeyedDebugCmd(debugObject))
+	assert.Equal(t, [][]byte{[]byte("k1")}, respio.LookupCommand(debugObject).ExtractKeys(debugObject))
+	assert.True(t, IsKeyedDebugCmd(respio.NewCommand("debug", "object", "k1")))
+
+	// the sub commands without a key go to the instance of the tenant
+	assert.False(t, IsKeyedDebugCmd(respio.NewCommand("DEBUG", "SLEEP", "1")))
+	assert.False(t, IsKeyedDebugCmd(respio.NewCommand("DEBUG", "JMAP")))
+	assert.False(t, IsKeyedDebugCmd(respio.NewCommand("DEBUG")))
+	assert.False(t, IsKeyedDebugCmd(respio.NewCommand("OBJECT", "FREQ", "k1")))
+}
+
+func TestClassifyDebugCmd(t *testing.T) {
+	tests := []struct {
+		policy string
+		args   []string
+		action DebugCmdAction
+	}{
+		{common.DebugPolicyBlock, []string{"DEBUG", "SLEEP", "1"}, DebugCmdReject},
+		// a keyed sub command is under the policy as well
+		{common.DebugPolicyBlock, []string{"DEBUG", "OBJECT", "k1"}, DebugCmdReject},
+		{"", []string{"debug", "object", "k1"}, DebugCmdReject},
+		{common.DebugPolicyExclusive, []string{"DEBUG", "SLEEP", "1"}, DebugCmdExclusive},
+		{common.DebugPolicyExclusive, []string{"DEBUG", "OBJECT", "k1"}, DebugCmdForward},
+		{common.DebugPolicyAllow, []string{"DEBUG", "SLEEP", "1"}, DebugCmdForward},
+		{common.DebugPolicyAllow, []string{"DEBUG", "OBJECT", "k1"}, DebugCmdForward},
+	}
+	for _, tt := range tests {
+		action, _ := ClassifyDebugCmd(tt.policy, respio.NewCommand(tt.args...))
+		assert.Equal(t, tt.action, action, tt.policy, tt.args)
+	}
+	_, subCmd := ClassifyDebugCmd(common.DebugPolicyAllow, respio.NewCommand("DEBUG", "Sleep", "0"))
+	assert.Equal(t, "sleep", subCmd)
+}
@@ -0,0 +1,35 @@
+package be_cluster
+
+import "sync/atomic"
+
+// DropReason tells why a request, its reply or a connection was dropped instead of being served.
+type DropReason string
+
+const (
+	// DropPoolClosed a connection was given back to a closed pool
+	DropPoolClosed DropReason = "pool_closed"
+	// DropSessionClosed the client went away before its command was forwarded or answered
+	DropSessionClosed DropReason = "session_closed"
+	// DropBackendGone the backend connection closed before the request was answered
+	DropBackendGone DropReason = "backend_gone"
+)
+
+// DropListener observes the drops, e.g. to count them in the metrics.
+type DropListener func(reason DropReason)
+
+var dropListener atomic.Pointer[DropListener]
+
+// SetDropListener must be called before the proxy serves clients, nil removes the listener.
+func SetDropListener(listener DropListener) {
+	if listener == nil {
+		dropListener.Store(nil)
+		return
+	}
+	dropListener.Store(&listener)
+}
+
+func recordDrop(reason DropReason) {
+	if listener := dropListener.Load(); listener != nil {
+		(*listener)(reason)
+	}
+}
@@ -1,16 +1,21 @@
 package be_cluster
 
 import (
+	"context"
 	"errors"
-	"github.com/pzhenzhou/elika/pkg/respio"
 	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/buraksezer/consistent"
+	"github.com/cenkalti/backoff/v5"
 	"github.com/cespare/xxhash/v2"
 	"github.com/puzpuzpuz/xsync/v3"
 	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
 )
 
 type Member struct {
@@ -35,12 +40,29 @@ var (
 	}
 )
 
+// ErrBackendNotReady is returned when the pool has no online connection to route to, before
+// it is filled or after its connections were cleared.
+var ErrBackendNotReady = errors.New("ERR backend not ready")
+
+var errBroadcastTimeout = errors.New("ERR broadcast to the backend connections timed out")
+
+// broadcastTimeout bounds the wait for the replies of a broadcast command.
+const broadcastTimeout = 3 * time.Second
+
 type FixedPool struct {
 	fixedCfg  *PoolConfig
 	innerPool *BackendPool
 	ready     uint32
 	onLines   *xsync.MapOf[string, *BackendConn]
 	cHasher   *consistent.Consistent
+	// ringMu serializes the membership changes, so that onLines and cHasher hold the same
+	// connections. A lookup takes no lock, a member is added to onLines before the ring and
+	// removed from the ring before onLines.
+	ringMu sync.Mutex
+	// draining is set once the instance of the pool is drained, the pool is closed when idle
+	draining atomic.Bool
+	// health tracks the LOADING and BUSY replies of the instance
+	health instanceHealth
 }
 
 func NewFixedPool(cfg *PoolConfig) *FixedPool {
@@ -60,60 +82,372 @@ func (f *FixedPool) SetAuthInfo(auth *common.AuthInfo) {
 	f.innerPool.SetAuthInfo(auth)
 }
 
-func (f *FixedPool) WaitPoolReady() {
-	ticker := time.NewTicker(50 * time.Millisecond)
+// WaitPoolReady puts the connections online once the pool is full. The pool signals its last
+// connection, the size is also polled in case the pool shrank and refilled since.
+func (f *FixedPool) WaitPoolReady(ctx context.Context) error {
+	ticker := time.NewTicker(f.fixedCfg.readyPollInterval())
 	defer ticker.Stop()
-	size := f.fixedCfg.PoolSize
-	for {
+	size := f.fixedCfg.initialSize()
+	filled := f.innerPool.filled
+	for f.innerPool.Size() != size {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-filled:
+			// closed for good, the poll takes over
+			filled = nil
 		case <-ticker.C:
-			if f.innerPool.Size() == size {
-				for _, conn := range f.innerPool.conns {
-					f.onLines.Store(conn.Id, conn)
-					f.cHasher.Add(Member{
-						key: conn.Id,
-					})
-				}
-				atomic.StoreUint32(&f.ready, 1)
-				return
-			}
 		}
 	}
+	f.innerPool.mu.Lock()
+	conns := slices.Clone(f.innerPool.conns)
+	f.innerPool.mu.Unlock()
+	for _, conn := range conns {
+		f.putOnline(conn, nil)
+	}
+	atomic.StoreUint32(&f.ready, 1)
+	if lifetime := f.fixedCfg.ConnMaxLifetime; lifetime > 0 {
+		go f.rotateLoop(lifetime)
+	}
+	if f.fixedCfg.Dynamic {
+		go f.scaleLoop()
+	}
+	return nil
 }
 
 func (f *FixedPool) GetNoTxConn() (*BackendConn, error) {
 	var candidates []*BackendConn
+	online := 0
 	f.onLines.Range(func(key string, conn *BackendConn) bool {
-		if txState := conn.LoadTxnState(); txState == nil || txState.State == "" || txState.State == respio.TxCmdStateEnd {
+		online++
+		if !conn.closed.Load() && !conn.LoadTxnState().Active() {
 			candidates = append(candidates, conn)
 		}
 		return true
 	})
+	if online == 0 {
+		return nil, ErrBackendNotReady
+	}
 	candidatesLen := len(candidates)
 	if candidatesLen == 0 {
-		return nil, errors.New("no connection found")
+		// every connection is held by a transaction
+		return nil, ErrPoolExhausted
 	}
-	if candidatesLen == 1 {
-		return candidates[0], nil
+	// start at a random candidate and take the first one still out of a transaction
+	start := rand.IntN(candidatesLen)
+	for i := 0; i < candidatesLen; i++ {
+		conn := candidates[(start+i)%candidatesLen]
+		if !conn.LoadTxnState().Active() {
+			return conn, nil
+		}
 	}
+	return nil, ErrPoolExhausted
+}
+
+func (f *FixedPool) GetConnByKey(key []byte) (*BackendConn, error) {
 	for {
-		randIdx := rand.IntN(candidatesLen)
-		conn := candidates[randIdx]
-		if conn.LoadTxnState() != nil && conn.LoadTxnState().State == respio.TxCmdStateBegin {
-			continue
+		// the ring has no partition owner while it is empty
+		member := f.cHasher.LocateKey(key)
+		if member == nil {
+			return nil, ErrBackendNotReady
 		}
-		return conn, nil
+		conn, ok := f.onLines.Load(member.String())
+		if !ok {
+			return nil, errors.New("no connection found")
+		}
+		if !conn.closed.Load() {
+			return conn, nil
+		}
+		// a closed connection takes no request, its keys move to the other members and the
+		// sessions bound to it follow with their next command
+		logger.Info("Closed backend connection removed from the ring", "BackendConn", conn.Id)
+		conn.Retire()
+		f.removeOnline(conn)
 	}
 }
 
-func (f *FixedPool) GetConnByKey(key []byte) (*BackendConn, error) {
-	member := f.cHasher.LocateKey(key)
-	if conn, ok := f.onLines.Load(member.String()); ok {
-		return conn, nil
+// Broadcast sends the command to every online connection of the pool and waits for all the
+// replies. The first error reply is returned if any, otherwise the first reply. The packet is
+// shared by the connections, it is not released once written.
+func (f *FixedPool) Broadcast(reqId uint64, packet *respio.RespPacket) (*respio.RespPacket, error) {
+	wait, err := f.sendBroadcast(reqId, packet)
+	if err != nil {
+		return nil, err
+	}
+	return wait()
+}
+
+// sendBroadcast enqueues the command on every online connection of the pool not pinned by a
+// transaction, the returned function waits for the replies.
+func (f *FixedPool) sendBroadcast(reqId uint64, packet *respio.RespPacket) (func() (*respio.RespPacket, error), error) {
+	var conns []*BackendConn
+	skipped := 0
+	f.onLines.Range(func(_ string, conn *BackendConn) bool {
+		// a MULTI of another session would queue the command, its EXEC would answer it. The
+		// scripts missing there are loaded before the next EVALSHA, see ensureScript.
+		if conn.LoadTxnState().Active() {
+			skipped++
+			return true
+		}
+		conns = append(conns, conn)
+		return true
+	})
+	if len(conns) == 0 {
+		if skipped > 0 {
+			return nil, ErrPoolExhausted
+		}
+		return nil, ErrBackendNotReady
+	}
+	// buffered for every reply, a late one after the timeout does not block the ReadLoop
+	collector := &Session{
+		Id:   "broadcast",
+		OutQ: make(chan *ResponseContext, len(conns)),
+	}
+	for _, conn := range conns {
+		conn.Enqueue(&RequestContext{
+			RequestId: reqId,
+			Session:   collector,
+			Request:   packet,
+		})
+	}
+	return func() (*respio.RespPacket, error) {
+		timeout := time.NewTimer(broadcastTimeout)
+		defer timeout.Stop()
+		var reply *respio.RespPacket
+		for range conns {
+			select {
+			case rspCtx := <-collector.OutQ:
+				reply = mergeBroadcastReply(reply, rspCtx.Response)
+			case <-timeout.C:
+				respio.ReleaseRespPacket(reply)
+				return nil, errBroadcastTimeout
+			}
+		}
+		return reply, nil
+	}, nil
+}
+
+// mergeBroadcastReply keeps the first error reply, or the first reply when all succeed, and
+// releases the other one.
+func mergeBroadcastReply(kept, reply *respio.RespPacket) *respio.RespPacket {
+	switch {
+	case reply == nil:
+		return kept
+	case kept == nil:
+		return reply
+	case !isErrorReply(kept) && isErrorReply(reply):
+		respio.ReleaseRespPacket(kept)
+		return reply
+	default:
+		respio.ReleaseRespPacket(reply)
+		return kept
 	}
-	return nil, errors.New("no connection found")
 }
 
+func isErrorReply(reply *respio.RespPacket) bool {
+	return reply.Type == respio.RespError || reply.Type == respio.RespBlobError
+}
+
+// DialExclusive opens a connection to the backend of the pool that is not shared with the
+// other sessions. The caller owns and closes it.
+func (f *FixedPool) DialExclusive() (*BackendConn, error) {
+	return f.fixedCfg.Dialer(context.Background())
+}
+
+// Close closes the connections of the pool. They are retired first, the sessions bound to them
+// move to another pool with their next command, e.g. once the instance is back online.
 func (f *FixedPool) Close() error {
+	f.onLines.Range(func(_ string, conn *BackendConn) bool {
+		conn.Retire()
+		return true
+	})
+	f.clearConns()
 	return f.innerPool.Close()
 }
+
+// rotationInterval is how often the connections are checked against their lifetime.
+func rotationInterval(lifetime time.Duration) time.Duration {
+	return min(lifetime/4, time.Minute)
+}
+
+// rotateLoop replaces the connections that outlived their lifetime until the pool is closed.
+func (f *FixedPool) rotateLoop(lifetime time.Duration) {
+	ticker := time.NewTicker(rotationInterval(lifetime))
+	defer ticker.Stop()
+	for range ticker.C {
+		if f.innerPool.IsClosed() {
+			return
+		}
+		f.rotateExpired(lifetime)
+	}
+}
+
+// rotateExpired swaps every expired connection for a new one in the ring. The expired ones are
+// retired rather than closed, busy sessions move off them with their next command.
+func (f *FixedPool) rotateExpired(lifetime time.Duration) {
+	// a draining pool is closed soon, a fresh connection would take sessions back
+	if f.draining.Load() {
+		return
+	}
+	f.onLines.Range(func(id string, conn *BackendConn) bool {
+		if time.Since(conn.created) < lifetime {
+			return true
+		}
+		newConn, err := f.innerPool.replaceConn(conn)
+		if err != nil {
+			logger.Info("Failed to rotate backend connection", "Addr", f.fixedCfg.Addr,
+				"BackendConn", id, "error", err)
+			// the next tick retries
+			return false
+		}
+		f.putOnline(newConn, conn)
+		conn.Retire()
+		logger.Info("Backend connection rotated", "Addr", f.fixedCfg.Addr, "Retired", id,
+			"BackendConn", newConn.Id)
+		go closeRetired(conn, rotationInterval(lifetime))
+		return true
+	})
+}
+
+// drain retires every connection, the sessions bound to them move with their next command. It
+// returns false if the pool was draining already.
+func (f *FixedPool) drain() bool {
+	if !f.draining.CompareAndSwap(false, true) {
+		return false
+	}
+	f.onLines.Range(func(_ string, conn *BackendConn) bool {
+		conn.Retire()
+		return true
+	})
+	return true
+}
+
+func (f *FixedPool) isDraining() bool {
+	return f.draining.Load()
+}
+
+// IsUnavailable reports whether the instance of the pool answered LOADING or BUSY lately.
+func (f *FixedPool) IsUnavailable() bool {
+	return f.health.IsUnavailable()
+}
+
+// quiesced reports whether no request is in flight and no transaction holds a connection.
+func (f *FixedPool) quiesced() bool {
+	idle := true
+	f.onLines.Range(func(_ string, conn *BackendConn) bool {
+		idle = conn.IsIdle() && !conn.LoadTxnState().Active()
+		return idle
+	})
+	return idle
+}
+
+// closeIdleConns closes the connections without a request in flight and returns the others. It
+// is meant for the shutdown, a transaction left open by a closed session does not matter then.
+func (f *FixedPool) closeIdleConns() []*BackendConn {
+	var busy []*BackendConn
+	f.onLines.Range(func(_ string, conn *BackendConn) bool {
+		if conn.IsIdle() {
+			conn.Clear()
+		} else {
+			busy = append(busy, conn)
+		}
+		return true
+	})
+	return busy
+}
+
+// putOnline adds the connection to the ring in place of replaced, if not nil. The connection is
+// added before the removal, so the ring is never empty. A connection whose replies went out of
+// sync is replaced by the pool.
+func (f *FixedPool) putOnline(conn *BackendConn, replaced *BackendConn) {
+	conn.onDesync = f.replaceDesynced
+	conn.health.Store(&f.health)
+	f.ringMu.Lock()
+	defer f.ringMu.Unlock()
+	f.onLines.Store(conn.Id, conn)
+	f.cHasher.Add(Member{key: conn.Id})
+	if replaced != nil {
+		f.cHasher.Remove(replaced.Id)
+		f.onLines.Delete(replaced.Id)
+	}
+}
+
+// removeOnline takes the connection out of the ring, its keys rehash to the other members.
+func (f *FixedPool) removeOnline(conn *BackendConn) {
+	f.ringMu.Lock()
+	defer f.ringMu.Unlock()
+	// the member may have been replaced meanwhile
+	if current, ok := f.onLines.Load(conn.Id); ok && current == conn {
+		f.cHasher.Remove(conn.Id)
+		f.onLines.Delete(conn.Id)
+	}
+}
+
+// replaceDesynced dials the replacement of a connection closed because its replies went out of
+// sync, retrying while the backend is unavailable. A lookup takes the closed connection out of
+// the ring meanwhile.
+func (f *FixedPool) replaceDesynced(conn *BackendConn) {
+	newConn, err := backoff.Retry(context.Background(), func() (*BackendConn, error) {
+		newConn, err := f.innerPool.replaceConn(conn)
+		if errors.Is(err, ErrClosed) || errors.Is(err, errConnNotInPool) {
+			// the pool is closed, or the connection was rotated meanwhile
+			return nil, backoff.Permanent(err)
+		}
+		return newConn, err
+	}, backoff.WithBackOff(f.innerPool.newRetryBackOff()),
+		backoff.WithMaxElapsedTime(f.innerPool.retryMaxElapsed()))
+	if err != nil {
+		logger.Info("Failed to replace desynced backend connection", "Addr", f.fixedCfg.Addr,
+			"BackendConn", conn.Id, "error", err)
+		return
+	}
+	f.putOnline(newConn, conn)
+	logger.Info("Desynced backend connection replaced", "Addr", f.fixedCfg.Addr,
+		"Replaced", conn.Id, "BackendConn", newConn.Id)
+}
+
+// closeRetired closes a retired connection once its requests are answered and no transaction
+// holds it. The first check waits an interval, a Forward that loaded the connection before it
+// was retired may still enqueue to it.
+func closeRetired(conn *BackendConn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if conn.IsIdle() && !conn.LoadTxnState().Active() {
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// ringMembers returns the connections of the ring and the partitions each owns, sorted by id.
+func (f *FixedPool) ringMembers() []RingMember {
+	f.ringMu.Lock()
+	members, loads := f.cHasher.GetMembers(), f.cHasher.LoadDistribution()
+	f.ringMu.Unlock()
+	ringMembers := make([]RingMember, 0, len(members))
+	for _, member := range members {
+		partitions := int(loads[member.String()])
+		ringMembers = append(ringMembers, RingMember{
+			ConnId:     member.String(),
+			Partitions: partitions,
+			Share:      float64(partitions) / float64(consistentCfg.PartitionCount),
+		})
+	}
+	slices.SortFunc(ringMembers, func(a, b RingMember) int {
+		return strings.Compare(a.ConnId, b.ConnId)
+	})
+	return ringMembers
+}
+
+// clearConns takes every connection out of the ring, the pool is not ready until refilled.
+func (f *FixedPool) clearConns() {
+	atomic.StoreUint32(&f.ready, 0)
+	f.ringMu.Lock()
+	defer f.ringMu.Unlock()
+	f.onLines.Range(func(id string, _ *BackendConn) bool {
+		f.cHasher.Remove(id)
+		f.onLines.Delete(id)
+		return true
+	})
+}
@@ -0,0 +1,419 @@
+package be_cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buraksezer/consistent"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixedPool_EmptyRing(t *testing.T) {
+	pool := &FixedPool{
+		onLines: xsync.NewMapOf[string, *BackendConn](),
+		cHasher: consistent.New(nil, consistentCfg),
+	}
+	conn, err := pool.GetConnByKey([]byte("session-a"))
+	assert.Nil(t, conn)
+	assert.ErrorIs(t, err, ErrBackendNotReady)
+	conn, err = pool.GetNoTxConn()
+	assert.Nil(t, conn)
+	assert.ErrorIs(t, err, ErrBackendNotReady)
+}
+
+func TestFixedPool_ClearedRing(t *testing.T) {
+	pool := newSingleConnPool(newPipeBackendConn(t, echoKey))
+	conn, err := pool.GetConnByKey([]byte("session-a"))
+	assert.NoError(t, err)
+	assert.NotNil(t, conn)
+
+	pool.clearConns()
+	assert.False(t, pool.IsReady())
+	_, err = pool.GetConnByKey([]byte("session-a"))
+	assert.ErrorIs(t, err, ErrBackendNotReady)
+	_, err = pool.GetNoTxConn()
+	assert.ErrorIs(t, err, ErrBackendNotReady)
+}
+
+func TestFixedPool_RingMembership(t *testing.T) {
+	pool := &FixedPool{
+		onLines: xsync.NewMapOf[string, *BackendConn](),
+		cHasher: consistent.New(nil, consistentCfg),
+	}
+	conns := make([]*BackendConn, 3)
+	for i := range conns {
+		conns[i] = newPipeBackendConn(t, echoKey)
+		pool.putOnline(conns[i], nil)
+	}
+	locate := func() map[*BackendConn]int {
+		owners := make(map[*BackendConn]int)
+		for i := 0; i < 300; i++ {
+			conn, err := pool.GetConnByKey([]byte(fmt.Sprintf("session-%d", i)))
+			assert.NoError(t, err)
+			owners[conn]++
+		}
+		return owners
+	}
+	assert.Len(t, locate(), 3)
+
+	// the keys of a removed member rehash to the remaining ones
+	pool.removeOnline(conns[1])
+	owners := locate()
+	assert.Len(t, owners, 2)
+	assert.NotContains(t, owners, conns[1])
+	assert.Equal(t, 2, pool.onLines.Size())
+
+	// a closed member is taken out of the ring by the lookup
+	_ = conns[2].Close()
+	owners = locate()
+	assert.Equal(t, map[*BackendConn]int{conns[0]: 300}, owners)
+	assert.Equal(t, 1, pool.onLines.Size())
+
+	// a replaced member hands its keys to the new connection
+	newConn := newPipeBackendConn(t, echoKey)
+	pool.putOnline(newConn, conns[0])
+	assert.Equal(t, map[*BackendConn]int{newConn: 300}, locate())
+	_, ok := pool.onLines.Load(conns[0].Id)
+	assert.False(t, ok)
+}
+
+func TestSessionManager_RouteToClearedPool(t *testing.T) {
+	sm, session, _ := newTestSessionManager(t, echoKey)
+	pool, _ := sm.beMgr.instancePool.Load("127.0.0.1:6379")
+	pool.clearConns()
+
+	err := sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", "a"), session.GetAuthInfo())
+	assert.ErrorIs(t, err, ErrBackendNotReady)
+	assert.Equal(t, "ERR backend not ready", err.Error())
+}
+
+func TestFixedPool_RotateExpiredConns(t *testing.T) {
+	const lifetime = 20 * time.Millisecond
+	sm, session, reader := newTestSessionManager(t, echoKey)
+	pool, _ := sm.beMgr.instancePool.Load("127.0.0.1:6379")
+	var old *BackendConn
+	pool.onLines.Range(func(_ string, conn *BackendConn) bool {
+		old = conn
+		return true
+	})
+	pool.fixedCfg = &PoolConfig{
+		Addr:     "127.0.0.1:6379",
+		PoolSize: 1,
+		Dialer: func(ctx context.Context) (*BackendConn, error) {
+			return newPipeBackendConnAt(t, "127.0.0.1:6379", echoKey), nil
+		},
+	}
+	pool.innerPool = &BackendPool{cfg: pool.fixedCfg, conns: []*BackendConn{old}}
+
+	get := func(key string) {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", key), session.GetAuthInfo()))
+		reply, err := reader.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, key, string(reply.Data))
+	}
+	get("a")
+	time.Sleep(lifetime)
+	pool.rotateExpired(lifetime)
+
+	assert.True(t, old.IsRetired())
+	conn, err := pool.GetConnByKey([]byte(session.Id))
+	assert.NoError(t, err)
+	assert.NotSame(t, old, conn)
+	assert.Equal(t, []*BackendConn{conn}, pool.innerPool.conns)
+
+	// the session bound to the retired connection moves with its next command
+	get("b")
+	pair, _ := sm.sessions.Load(session.Id)
+	assert.Same(t, conn, pair.backend)
+	assert.Eventually(t, old.closed.Load, time.Second, 10*time.Millisecond)
+}
+
+func TestFixedPool_WaitPoolReady(t *testing.T) {
+	var dials atomic.Int32
+	gate := make(chan struct{})
+	pool := NewFixedPool(&PoolConfig{
+		Addr:        "pipe",
+		PoolSize:    3,
+		MinIdleSize: 3,
+		// readiness must not wait for a tick
+		ReadyPollInterval: time.Hour,
+		Dialer: func(ctx context.Context) (*BackendConn, error) {
+			if dials.Add(1) == 3 {
+				<-gate
+			}
+			return newPipeBackendConn(t, echoKey), nil
+		},
+	})
+	defer pool.Close()
+	ready := make(chan time.Time, 1)
+	go func() {
+		assert.NoError(t, pool.WaitPoolReady(context.Background()))
+		ready <- time.Now()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, pool.IsReady())
+
+	released := time.Now()
+	close(gate)
+	select {
+	case readyAt := <-ready:
+		assert.Less(t, readyAt.Sub(released), 100*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("the pool was not ready after its last connection")
+	}
+	assert.True(t, pool.IsReady())
+	assert.Equal(t, 3, pool.onLines.Size())
+
+	// a pool that cannot fill gives up with the context
+	failing := NewFixedPool(&PoolConfig{
+		Addr:        "127.0.0.1:1",
+		PoolSize:    1,
+		MinIdleSize: 1,
+		Dialer: func(ctx context.Context) (*BackendConn, error) {
+			return nil, errors.New("connection refused")
+		},
+	})
+	defer failing.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, failing.WaitPoolReady(ctx), context.DeadlineExceeded)
+	assert.False(t, failing.IsReady())
+}
+
+func TestFixedPool_DynamicScaling(t *testing.T) {
+	newPool := func(dynamic bool, release chan struct{}) *FixedPool {
+		// a fixed pool is filled up to PoolSize, a dynamic one opens MinActiveSize connections
+		minIdle := 3
+		if dynamic {
+			minIdle = 1
+		}
+		pool := NewFixedPool(&PoolConfig{
+			Addr:            "pipe",
+			PoolSize:        3,
+			MinIdleSize:     minIdle,
+			MinActiveSize:   1,
+			MaxActiveSize:   3,
+			Dynamic:         dynamic,
+			ScaleUpInFlight: 2,
+			ScaleInterval:   10 * time.Millisecond,
+			Dialer: func(ctx context.Context) (*BackendConn, error) {
+				return newPipeBackendConn(t, func(req *respio.RespPacket) *respio.RespPacket {
+					// the HELLO of a new connection is answered right away
+					if string(req.GetCommand()) == "GET" {
+						<-release
+					}
+					return echoKey(req)
+				}), nil
+			},
+		})
+		t.Cleanup(func() { _ = pool.Close() })
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.NoError(t, pool.WaitPoolReady(ctx))
+		return pool
+	}
+	// load holds requests in flight on every connection of the pool until release is closed
+	load := func(pool *FixedPool) {
+		collector := &Session{Id: "load", OutQ: make(chan *ResponseContext, 16)}
+		pool.onLines.Range(func(_ string, conn *BackendConn) bool {
+			for i := 0; i < 4; i++ {
+				conn.Enqueue(&RequestContext{RequestId: NextRequestId(), Session: collector,
+					Request: respio.NewCommand("GET", "k")})
+			}
+			return true
+		})
+	}
+
+	release := make(chan struct{})
+	dynamic := newPool(true, release)
+	fixed := newPool(false, release)
+	assert.Equal(t, 1, dynamic.onLines.Size())
+	assert.Equal(t, 3, fixed.onLines.Size())
+	load(dynamic)
+	load(fixed)
+
+	// the dynamic pool grows up to MaxActiveSize under load
+	assert.Eventually(t, func() bool { return dynamic.onLines.Size() == 3 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, dynamic.onLines.Size())
+	assert.Equal(t, 3, dynamic.innerPool.Size())
+	assert.Equal(t, 3, fixed.onLines.Size())
+
+	// and shrinks back to MinActiveSize once idle, the fixed pool stays the same
+	close(release)
+	assert.Eventually(t, func() bool { return dynamic.onLines.Size() == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, dynamic.innerPool.Size())
+	assert.Equal(t, 3, fixed.onLines.Size())
+	assert.Equal(t, 3, fixed.innerPool.Size())
+}
+
+func TestFixedPool_RecycleDesyncedConn(t *testing.T) {
+	// the fake backend answers a GET of "desync" with a push, as if the replies were shifted
+	handler := func(req *respio.RespPacket) *respio.RespPacket {
+		if string(req.Array[1].Data) == "desync" {
+			return &respio.RespPacket{Type: respio.RespPush,
+				Array: []*respio.RespPacket{respio.NewBulkString([]byte("invalidate"))}}
+		}
+		return echoKey(req)
+	}
+	sm, session, reader := newTestSessionManager(t, handler)
+	pool, _ := sm.beMgr.instancePool.Load("127.0.0.1:6379")
+	var old *BackendConn
+	pool.onLines.Range(func(_ string, conn *BackendConn) bool {
+		old = conn
+		return true
+	})
+	pool.fixedCfg = &PoolConfig{
+		Addr:     "127.0.0.1:6379",
+		PoolSize: 1,
+		Dialer: func(ctx context.Context) (*BackendConn, error) {
+			return newPipeBackendConnAt(t, "127.0.0.1:6379", handler), nil
+		},
+	}
+	pool.innerPool = &BackendPool{cfg: pool.fixedCfg, conns: []*BackendConn{old}}
+	old.onDesync = pool.replaceDesynced
+
+	get := func(key string) *respio.RespPacket {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", key), session.GetAuthInfo()))
+		reply, err := reader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+	assert.Equal(t, "a", string(get("a").Data))
+	reply := get("desync")
+	assert.Equal(t, respio.RespError, reply.Type)
+	assert.Equal(t, ErrBackendConnDesynced.Error(), string(reply.Data))
+
+	assert.Eventually(t, old.closed.Load, time.Second, 10*time.Millisecond)
+	assert.True(t, old.IsRetired())
+	assert.Eventually(t, func() bool {
+		conn, err := pool.GetConnByKey([]byte(session.Id))
+		return err == nil && conn != old
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, pool.onLines.Size())
+
+	// the session bound to the desynced connection moves with its next command
+	assert.Equal(t, "b", string(get("b").Data))
+	pair, _ := sm.sessions.Load(session.Id)
+	assert.NotSame(t, old, pair.backend)
+}
+
+// scriptBackend answers like a Redis with its own script cache, EVALSHA fails for a script not
+// loaded on the connection.
+func scriptBackend() func(req *respio.RespPacket) *respio.RespPacket {
+	scripts := xsync.NewMapOf[string, struct{}]()
+	return func(req *respio.RespPacket) *respio.RespPacket {
+		switch strings.ToLower(string(req.Array[0].Data)) {
+		case "script":
+			sha := scriptSha(req.Array[2].Data)
+			scripts.Store(sha, struct{}{})
+			return &respio.RespPacket{Type: respio.RespString, Data: []byte(sha)}
+		case "eval":
+			scripts.Store(scriptSha(req.Array[1].Data), struct{}{})
+			return respio.NewStatus(string(respio.OkCmd))
+		case "evalsha":
+			if _, ok := scripts.Load(string(req.Array[1].Data)); !ok {
+				return respio.NewError("NOSCRIPT No matching script. Please use EVAL.")
+			}
+			return respio.NewStatus(string(respio.OkCmd))
+		default:
+			return respio.NewError("ERR unknown command")
+		}
+	}
+}
+
+func TestSessionManager_BroadcastScriptLoad(t *testing.T) {
+	sm, session, _ := newTestSessionManager(t, scriptBackend())
+	pool, _ := sm.beMgr.instancePool.Load("127.0.0.1:6379")
+	for i := 0; i < 2; i++ {
+		conn := newPipeBackendConnAt(t, "127.0.0.1:6379", scriptBackend())
+		conn.Id = fmt.Sprintf("conn-%d", i)
+		pool.onLines.Store(conn.Id, conn)
+	}
+
+	scriptLoad := respio.NewCommand("SCRIPT", "LOAD", "return 1")
+	assert.True(t, scriptLoad.IsBroadcastCmd())
+	reply, err := sm.Broadcast(session.Id, NextRequestId(), scriptLoad, session.GetAuthInfo())
+	assert.NoError(t, err)
+	assert.Equal(t, scriptSha([]byte("return 1")), string(reply.Data))
+
+	// any connection missing the script would answer NOSCRIPT
+	reply, err = pool.Broadcast(NextRequestId(), respio.NewCommand("EVALSHA", scriptSha([]byte("return 1")), "0"))
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespStatus, reply.Type)
+
+	// the first error reply is returned
+	reply, err = sm.Broadcast(session.Id, NextRequestId(), respio.NewCommand("CONFIG", "SET", "x", "y"),
+		session.GetAuthInfo())
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespError, reply.Type)
+}
+
+func TestSessionManager_BroadcastAsync(t *testing.T) {
+	sm, session, clientReader := newTestSessionManager(t, scriptBackend())
+	pool, _ := sm.beMgr.instancePool.Load("127.0.0.1:6379")
+	release := make(chan struct{})
+	slowScripts := scriptBackend()
+	slow := newPipeBackendConnAt(t, "127.0.0.1:6379", func(req *respio.RespPacket) *respio.RespPacket {
+		<-release
+		return slowScripts(req)
+	})
+	slow.Id = "slow"
+	pool.onLines.Store(slow.Id, slow)
+
+	// the caller does not wait for the slow connection
+	sha := scriptSha([]byte("return 1"))
+	sm.BroadcastAsync(session, NextRequestId(), respio.NewCommand("SCRIPT", "LOAD", "return 1"), session.GetAuthInfo())
+	// the script is loaded before the next command of the session on its connection
+	assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("EVALSHA", sha, "0"),
+		session.GetAuthInfo()))
+	// the reply of EVALSHA waits for the one of the broadcast
+	assert.Eventually(t, func() bool {
+		session.orderMu.Lock()
+		defer session.orderMu.Unlock()
+		return len(session.held) == 1
+	}, time.Second, 10*time.Millisecond)
+	close(release)
+
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, sha, string(reply.Data))
+	reply, err = clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespStatus, reply.Type)
+}
+
+func TestFixedPool_BroadcastSkipsTransactions(t *testing.T) {
+	pool := &FixedPool{onLines: xsync.NewMapOf[string, *BackendConn]()}
+	var received [2]atomic.Int32
+	conns := make([]*BackendConn, 2)
+	for i := range conns {
+		scripts := scriptBackend()
+		conns[i] = newPipeBackendConnAt(t, "127.0.0.1:6379", func(req *respio.RespPacket) *respio.RespPacket {
+			received[i].Add(1)
+			return scripts(req)
+		})
+		conns[i].Id = fmt.Sprintf("conn-%d", i)
+		pool.onLines.Store(conns[i].Id, conns[i])
+	}
+	// the MULTI of another session would queue the SCRIPT LOAD
+	other, _ := newPipeSession(t, "in-multi")
+	conns[1].UpdateTxnState(other, respio.TxCmdStateBegin)
+
+	reply, err := pool.Broadcast(NextRequestId(), respio.NewCommand("SCRIPT", "LOAD", "return 1"))
+	assert.NoError(t, err)
+	assert.Equal(t, scriptSha([]byte("return 1")), string(reply.Data))
+	assert.Equal(t, int32(1), received[0].Load())
+	assert.Equal(t, int32(0), received[1].Load())
+
+	conns[0].UpdateTxnState(other, respio.TxCmdStateBegin)
+	_, err = pool.Broadcast(NextRequestId(), respio.NewCommand("SCRIPT", "LOAD", "return 1"))
+	assert.ErrorIs(t, err, ErrPoolExhausted)
+}
@@ -0,0 +1,138 @@
+package be_cluster
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// HotKey is a sampled key with its estimated count. With sampling, the count is the number of
+// times the key was sampled, not the number of accesses.
+type HotKey struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// InstanceHotKeys reports the commands forwarded to a backend instance and its hottest keys.
+type InstanceHotKeys struct {
+	Addr     string   `json:"addr"`
+	Commands uint64   `json:"commands"`
+	HotKeys  []HotKey `json:"hot_keys"`
+}
+
+// topKSketch tracks the most frequent keys with the Space-Saving algorithm: at most capacity
+// keys are kept, a new key evicts the least counted one and inherits its count. A key seen
+// more often than 1/capacity of the samples is guaranteed to be kept.
+type topKSketch struct {
+	mu       sync.Mutex
+	capacity int
+	counts   map[string]uint64
+}
+
+func newTopKSketch(capacity int) *topKSketch {
+	return &topKSketch{
+		capacity: capacity,
+		counts:   make(map[string]uint64, capacity),
+	}
+}
+
+func (s *topKSketch) add(key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the lookup with string(key) does not allocate
+	if count, ok := s.counts[string(key)]; ok {
+		s.counts[string(key)] = count + 1
+		return
+	}
+	if len(s.counts) < s.capacity {
+		s.counts[string(key)] = 1
+		return
+	}
+	minKey, minCount := "", uint64(0)
+	for k, count := range s.counts {
+		if minKey == "" || count < minCount {
+			minKey, minCount = k, count
+		}
+	}
+	delete(s.counts, minKey)
+	s.counts[string(key)] = minCount + 1
+}
+
+// top returns the keys by descending count.
+func (s *topKSketch) top() []HotKey {
+	s.mu.Lock()
+	keys := make([]HotKey, 0, len(s.counts))
+	for k, count := range s.counts {
+		keys = append(keys, HotKey{Key: k, Count: count})
+	}
+	s.mu.Unlock()
+	slices.SortFunc(keys, func(a, b HotKey) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return keys
+}
+
+type instanceHotKeys struct {
+	commands atomic.Uint64
+	sketch   *topKSketch
+}
+
+// HotKeySampler counts the commands per backend instance and samples their keys into a
+// bounded sketch per instance.
+type HotKeySampler struct {
+	sampleRate float64
+	topK       int
+	instances  *xsync.MapOf[string, *instanceHotKeys]
+}
+
+func NewHotKeySampler(sampleRate float64, topK int) *HotKeySampler {
+	return &HotKeySampler{
+		sampleRate: sampleRate,
+		topK:       topK,
+		instances:  xsync.NewMapOf[string, *instanceHotKeys](),
+	}
+}
+
+// Sample counts the command and, with the sample rate probability, records its keys.
+func (h *HotKeySampler) Sample(addr string, packet *respio.RespPacket) {
+	stats, _ := h.instances.LoadOrCompute(addr, func() *instanceHotKeys {
+		return &instanceHotKeys{sketch: newTopKSketch(h.topK)}
+	})
+	stats.commands.Add(1)
+	if rand.Float64() >= h.sampleRate {
+		return
+	}
+	meta := respio.LookupCommand(packet)
+	if meta == nil {
+		return
+	}
+	for _, key := range meta.ExtractKeys(packet) {
+		stats.sketch.add(key)
+	}
+}
+
+// HotKeys returns the command count and the hottest keys of every instance.
+func (h *HotKeySampler) HotKeys() []InstanceHotKeys {
+	result := make([]InstanceHotKeys, 0, h.instances.Size())
+	h.instances.Range(func(addr string, stats *instanceHotKeys) bool {
+		result = append(result, InstanceHotKeys{
+			Addr:     addr,
+			Commands: stats.commands.Load(),
+			HotKeys:  stats.sketch.top(),
+		})
+		return true
+	})
+	slices.SortFunc(result, func(a, b InstanceHotKeys) int {
+		return strings.Compare(a.Addr, b.Addr)
+	})
+	return result
+}
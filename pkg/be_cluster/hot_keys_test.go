@@ -0,0 +1,48 @@
+package be_cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotKeySampler_SkewedAccess(t *testing.T) {
+	sampler := NewHotKeySampler(1, 8)
+	// one key takes a third of the traffic, the others are spread over many more keys than
+	// the sketch holds
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if i%3 == 0 {
+			key = "hot"
+		}
+		sampler.Sample("127.0.0.1:6379", respio.NewCommand("GET", key))
+	}
+	sampler.Sample("127.0.0.1:6380", respio.NewCommand("MSET", "a", "1", "b", "2"))
+	// unknown commands are counted but have no keys
+	sampler.Sample("127.0.0.1:6380", respio.NewCommand("NOSUCHCMD", "x"))
+
+	hotKeys := sampler.HotKeys()
+	assert.Len(t, hotKeys, 2)
+	assert.Equal(t, "127.0.0.1:6379", hotKeys[0].Addr)
+	assert.Equal(t, uint64(3000), hotKeys[0].Commands)
+	assert.Len(t, hotKeys[0].HotKeys, 8)
+	assert.Equal(t, "hot", hotKeys[0].HotKeys[0].Key)
+	assert.GreaterOrEqual(t, hotKeys[0].HotKeys[0].Count, uint64(1000))
+
+	assert.Equal(t, uint64(2), hotKeys[1].Commands)
+	assert.ElementsMatch(t, []HotKey{{Key: "a", Count: 1}, {Key: "b", Count: 1}}, hotKeys[1].HotKeys)
+}
+
+func TestHotKeySampler_SampleRate(t *testing.T) {
+	sampler := NewHotKeySampler(0.01, 8)
+	for i := 0; i < 10000; i++ {
+		sampler.Sample("127.0.0.1:6379", respio.NewCommand("GET", "hot"))
+	}
+	hotKeys := sampler.HotKeys()
+	assert.Equal(t, uint64(10000), hotKeys[0].Commands)
+	assert.Equal(t, "hot", hotKeys[0].HotKeys[0].Key)
+	// about 100 samples
+	assert.InDelta(t, 100, hotKeys[0].HotKeys[0].Count, 60)
+}
@@ -0,0 +1,59 @@
+package be_cluster
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+var (
+	// unavailableCooldown is how long an instance answering LOADING or BUSY is avoided by the
+	// routing, unless one of its connections gets a successful reply first
+	unavailableCooldown = 2 * time.Second
+
+	loadingErrPrefix = []byte("LOADING ")
+	busyErrPrefix    = []byte("BUSY ")
+)
+
+// instanceHealth is shared by the connections of a backend instance. An instance loading its
+// dataset, or running a script, answers every command with an error, new routes avoid it until
+// the cooldown elapsed.
+type instanceHealth struct {
+	// unavailableUntil is the unix nano time the instance is routed to again, 0 if available
+	unavailableUntil atomic.Int64
+}
+
+// observe records whether the reply shows the instance can serve commands.
+func (h *instanceHealth) observe(reply *respio.RespPacket, addr string) {
+	if h == nil {
+		return
+	}
+	if isUnavailableReply(reply) {
+		if h.unavailableUntil.Swap(time.Now().Add(unavailableCooldown).UnixNano()) == 0 {
+			logger.Info("Backend instance unavailable, routing around it", "Addr", addr,
+				"Reply", string(reply.Data), "Cooldown", unavailableCooldown)
+		}
+		return
+	}
+	if !isErrorReply(reply) && h.unavailableUntil.Load() != 0 && h.unavailableUntil.Swap(0) != 0 {
+		logger.Info("Backend instance available again", "Addr", addr)
+	}
+}
+
+// IsUnavailable reports whether the instance answered LOADING or BUSY within the cooldown.
+func (h *instanceHealth) IsUnavailable() bool {
+	if h == nil {
+		return false
+	}
+	until := h.unavailableUntil.Load()
+	return until != 0 && time.Now().UnixNano() < until
+}
+
+// isUnavailableReply reports whether the reply is a LOADING or BUSY error, but not e.g. a
+// BUSYKEY of RESTORE.
+func isUnavailableReply(reply *respio.RespPacket) bool {
+	return isErrorReply(reply) &&
+		(bytes.HasPrefix(reply.Data, loadingErrPrefix) || bytes.HasPrefix(reply.Data, busyErrPrefix))
+}
@@ -0,0 +1,72 @@
+package be_cluster
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+const (
+	// latencyEWMAWeight is the weight of a new sample in the moving average
+	latencyEWMAWeight = 0.2
+	// latencyEWMADecay is how fast the average of an instance without traffic fades, so that an
+	// instance avoided for being slow is tried again
+	latencyEWMADecay = 10 * time.Second
+)
+
+// backendLatencies is fed by every backend connection and read by the EWMA balancer.
+var backendLatencies = NewLatencyRegistry()
+
+// LatencyRegistry keeps an exponentially weighted moving average of the round trip of the
+// requests to each backend instance, from the write of a request to its reply.
+type LatencyRegistry struct {
+	instances *xsync.MapOf[string, *latencyEWMA]
+}
+
+type latencyEWMA struct {
+	mu sync.Mutex
+	// value is the average in nanoseconds
+	value    float64
+	observed time.Time
+}
+
+func NewLatencyRegistry() *LatencyRegistry {
+	return &LatencyRegistry{
+		instances: xsync.NewMapOf[string, *latencyEWMA](),
+	}
+}
+
+// Observe adds a round trip of the instance to its average.
+func (r *LatencyRegistry) Observe(addr string, latency time.Duration) {
+	ewma, _ := r.instances.LoadOrCompute(addr, func() *latencyEWMA {
+		return &latencyEWMA{}
+	})
+	ewma.mu.Lock()
+	defer ewma.mu.Unlock()
+	if ewma.observed.IsZero() {
+		ewma.value = float64(latency)
+	} else {
+		ewma.value += latencyEWMAWeight * (float64(latency) - ewma.value)
+	}
+	ewma.observed = time.Now()
+}
+
+// Latency returns the average round trip of the instance, faded by the time since its last
+// sample. An instance never observed has 0, so that it gets traffic.
+func (r *LatencyRegistry) Latency(addr string) time.Duration {
+	ewma, ok := r.instances.Load(addr)
+	if !ok {
+		return 0
+	}
+	ewma.mu.Lock()
+	defer ewma.mu.Unlock()
+	idle := time.Since(ewma.observed)
+	return time.Duration(ewma.value * math.Exp(-float64(idle)/float64(latencyEWMADecay)))
+}
+
+// Remove forgets the instance, e.g. once it is offline.
+func (r *LatencyRegistry) Remove(addr string) {
+	r.instances.Delete(addr)
+}
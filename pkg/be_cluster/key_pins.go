@@ -0,0 +1,59 @@
+package be_cluster
+
+import (
+	"errors"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+var ErrPinnedBackendUnavailable = errors.New("ERR pinned backend not available")
+
+// KeyPins sends the commands on some keys to a chosen instance of the tenant cluster instead of
+// the instance the tenant is routed to, e.g. the keys of distributed locks. A pin is a glob on
+// the key, the first pin matching a key of the command wins.
+type KeyPins struct {
+	pins []keyPin
+}
+
+type keyPin struct {
+	pattern string
+	addr    string
+}
+
+// NewKeyPins parses pins of the form pattern=addr, an invalid one is skipped.
+func NewKeyPins(pins []string) *KeyPins {
+	keyPins := &KeyPins{}
+	for _, pin := range pins {
+		pattern, addr, err := common.ParseKeyPin(pin)
+		if err == nil && !validGlob(pattern) {
+			err = errors.New("invalid key pattern")
+		}
+		if err != nil {
+			logger.Info("Ignoring key pin", "Pin", pin, "Error", err)
+			continue
+		}
+		keyPins.pins = append(keyPins.pins, keyPin{pattern: pattern, addr: addr})
+	}
+	return keyPins
+}
+
+// Match returns the instance a key of the command is pinned to. Commands without metadata are
+// never pinned.
+func (p *KeyPins) Match(packet *respio.RespPacket) (string, bool) {
+	if p == nil || len(p.pins) == 0 {
+		return "", false
+	}
+	meta := respio.LookupCommand(packet)
+	if meta == nil {
+		return "", false
+	}
+	for _, key := range meta.ExtractKeys(packet) {
+		for _, pin := range p.pins {
+			if globMatch(pin.pattern, string(key)) {
+				return pin.addr, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,35 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPins_Match(t *testing.T) {
+	pins := NewKeyPins([]string{"lock:*=10.0.0.1:6379", "queue:[ab]=10.0.0.2:6379", "invalid", "bad[=10.0.0.3:6379"})
+	assert.Len(t, pins.pins, 2)
+	match := func(args ...string) string {
+		addr, ok := pins.Match(respio.NewCommand(args...))
+		if !ok {
+			return ""
+		}
+		return addr
+	}
+	assert.Equal(t, "10.0.0.1:6379", match("SET", "lock:1", "v"))
+	assert.Equal(t, "10.0.0.2:6379", match("LPUSH", "queue:b", "v"))
+	// the first pinned key of the command wins
+	assert.Equal(t, "10.0.0.2:6379", match("MGET", "k", "queue:a", "lock:1"))
+	assert.Equal(t, "", match("GET", "queue:c"))
+	assert.Equal(t, "", match("PING"))
+	// the introspection of a key runs on the instance holding it
+	assert.Equal(t, "10.0.0.1:6379", match("DEBUG", "OBJECT", "lock:1"))
+	assert.Equal(t, "10.0.0.1:6379", match("OBJECT", "FREQ", "lock:1"))
+	// a command without metadata is not pinned
+	assert.Equal(t, "", match("NOSUCHCMD", "lock:1"))
+
+	var disabled *KeyPins
+	_, ok := disabled.Match(respio.NewCommand("GET", "lock:1"))
+	assert.False(t, ok)
+}
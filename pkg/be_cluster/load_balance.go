@@ -1,6 +1,7 @@
 package be_cluster
 
 import (
+	"github.com/cespare/xxhash/v2"
 	"github.com/pzhenzhou/elika/pkg/common"
 	"math/rand"
 	"strings"
@@ -13,6 +14,8 @@ const (
 	BalanceTypeRoundRobin BalancerType = 1 << 4
 	BalanceTypeLeastConn  BalancerType = 1 << 5
 	BalanceTypeRandom     BalancerType = 1 << 6
+	BalanceTypeTenantHash BalancerType = 1 << 7
+	BalanceTypeEWMA       BalancerType = 1 << 8
 )
 
 type Balancer interface {
@@ -35,6 +38,71 @@ func NewRandomBalancer() *RandomBalancer {
 	}
 }
 
+var _ Balancer = &TenantHashBalancer{}
+
+// TenantHashBalancer keeps every connection of a tenant on the same instance, which matters
+// when the tenant's data lives on a specific shard. It uses rendezvous hashing of the tenant
+// key against each instance id, so adding or removing an instance only moves the tenants
+// that were mapped to it.
+type TenantHashBalancer struct{}
+
+func (t TenantHashBalancer) Next(tenantKey *ClusterKey, instance []*ClusterInstance) int32 {
+	if tenantKey == nil {
+		return 0
+	}
+	tenantHash := tenantKey.Hash()
+	var (
+		selected  int32
+		bestScore uint64
+	)
+	for i, inst := range instance {
+		score := mixHash(tenantHash ^ xxhash.Sum64String(inst.Id))
+		if i == 0 || score > bestScore {
+			selected = int32(i)
+			bestScore = score
+		}
+	}
+	return selected
+}
+
+func NewTenantHashBalancer() *TenantHashBalancer {
+	return &TenantHashBalancer{}
+}
+
+var _ Balancer = &EWMABalancer{}
+
+// EWMABalancer selects the instance with the lowest moving average of its round trips, the
+// fastest to answer. The sessions of a tenant spread over the instances of its cluster.
+type EWMABalancer struct {
+	latencies *LatencyRegistry
+}
+
+func (e EWMABalancer) Next(_ *ClusterKey, instance []*ClusterInstance) int32 {
+	var (
+		selected int32
+		lowest   time.Duration
+	)
+	for i, inst := range instance {
+		latency := e.latencies.Latency(inst.GetAddr())
+		if i == 0 || latency < lowest {
+			selected = int32(i)
+			lowest = latency
+		}
+	}
+	return selected
+}
+
+func NewEWMABalancer(latencies *LatencyRegistry) *EWMABalancer {
+	return &EWMABalancer{latencies: latencies}
+}
+
+// mixHash is the murmur3 finalizer, it spreads the combined hash over all 64 bits.
+func mixHash(hash uint64) uint64 {
+	hash = (hash ^ (hash >> 33)) * 0xff51afd7ed558ccd
+	hash = (hash ^ (hash >> 33)) * 0xc4ceb9fe1a85ec53
+	return hash ^ (hash >> 33)
+}
+
 func GetBalancerType(config *common.BackendRouterConfig) BalancerType {
 	typeStr := strings.ToLower(config.LBType)
 	switch typeStr {
@@ -44,6 +112,10 @@ func GetBalancerType(config *common.BackendRouterConfig) BalancerType {
 		return BalanceTypeRoundRobin
 	case "least-cluster":
 		return BalanceTypeLeastConn
+	case "tenant-hash":
+		return BalanceTypeTenantHash
+	case "ewma":
+		return BalanceTypeEWMA
 	default:
 		return BalanceTypeRandom
 	}
@@ -52,6 +124,10 @@ func GetBalancerType(config *common.BackendRouterConfig) BalancerType {
 func NewBalancer(balancerType BalancerType) Balancer {
 	if balancerType == BalanceTypeRandom {
 		return NewRandomBalancer()
+	} else if balancerType == BalanceTypeTenantHash {
+		return NewTenantHashBalancer()
+	} else if balancerType == BalanceTypeEWMA {
+		return NewEWMABalancer(backendLatencies)
 	} else {
 		panic("Not support this balancer")
 	}
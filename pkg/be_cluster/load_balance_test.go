@@ -0,0 +1,72 @@
+package be_cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func testInstances(n int) []*ClusterInstance {
+	instances := make([]*ClusterInstance, 0, n)
+	for i := 0; i < n; i++ {
+		instance := LocalClusterInstance("127.0.0.1", 6379+i)
+		instance.Id = fmt.Sprintf("instance-%d", i)
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+func TestTenantHashBalancer_Next(t *testing.T) {
+	balancer := NewTenantHashBalancer()
+	instances := testInstances(3)
+
+	tenant := &ClusterKey{Name: ClusterName{Namespace: "default", Name: "tenant-a"}}
+	first := balancer.Next(tenant, instances)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, first, balancer.Next(tenant, instances))
+	}
+
+	selected := make(map[int32]int)
+	for i := 0; i < 100; i++ {
+		key := &ClusterKey{Name: ClusterName{Namespace: "default", Name: fmt.Sprintf("tenant-%d", i)}}
+		selected[balancer.Next(key, instances)]++
+	}
+	assert.Len(t, selected, len(instances))
+}
+
+func TestEWMABalancer_Next(t *testing.T) {
+	latencies := NewLatencyRegistry()
+	balancer := NewEWMABalancer(latencies)
+	instances := testInstances(2)
+	slow, fast := instances[0].GetAddr(), instances[1].GetAddr()
+
+	// an instance never observed is tried first
+	latencies.Observe(slow, 10*time.Millisecond)
+	assert.Equal(t, int32(1), balancer.Next(nil, instances))
+
+	for i := 0; i < 10; i++ {
+		latencies.Observe(slow, 10*time.Millisecond)
+		latencies.Observe(fast, time.Millisecond)
+		assert.Equal(t, int32(1), balancer.Next(nil, instances))
+	}
+
+	// the fast instance slows down, the average moves over after a few samples
+	var samples int
+	for samples = 1; samples <= 20; samples++ {
+		latencies.Observe(slow, 10*time.Millisecond)
+		latencies.Observe(fast, 20*time.Millisecond)
+		if balancer.Next(nil, instances) == 0 {
+			break
+		}
+	}
+	assert.Greater(t, samples, 1)
+	assert.LessOrEqual(t, samples, 20)
+	assert.Less(t, latencies.Latency(slow), latencies.Latency(fast))
+
+	latencies.Remove(fast)
+	assert.Equal(t, time.Duration(0), latencies.Latency(fast))
+	assert.Equal(t, BalanceTypeEWMA, GetBalancerType(&common.BackendRouterConfig{LBType: "EWMA"}))
+}
@@ -0,0 +1,87 @@
+package be_cluster
+
+import (
+	"context"
+	"time"
+)
+
+// scaleDownIdleTicks is the consecutive load checks a dynamic pool must be idle at before a
+// connection is retired, a burst does not open and close connections over and over.
+const scaleDownIdleTicks = 10
+
+// scaleLoop grows and shrinks a dynamic pool with its load until the pool is closed. A new
+// connection takes the sessions routed from then on, the bound ones stay on their connection.
+func (f *FixedPool) scaleLoop() {
+	interval := f.fixedCfg.scaleInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	idleTicks := 0
+	for range ticker.C {
+		if f.innerPool.IsClosed() {
+			return
+		}
+		// a draining pool is closed soon, a fresh connection would take sessions back
+		if f.draining.Load() {
+			continue
+		}
+		online, inFlight := f.load()
+		switch {
+		case online > 0 && online < f.fixedCfg.MaxActiveSize && inFlight >= online*f.fixedCfg.scaleUpInFlight():
+			idleTicks = 0
+			f.grow()
+		case inFlight == 0 && online > f.fixedCfg.MinActiveSize:
+			if idleTicks++; idleTicks >= scaleDownIdleTicks {
+				idleTicks = 0
+				f.shrink(interval)
+			}
+		default:
+			idleTicks = 0
+		}
+	}
+}
+
+// load returns the connections of the ring still taking sessions and their requests in flight.
+func (f *FixedPool) load() (int, int) {
+	online, inFlight := 0, 0
+	f.onLines.Range(func(_ string, conn *BackendConn) bool {
+		if !conn.IsRetired() && !conn.closed.Load() {
+			online++
+			inFlight += conn.InFlight()
+		}
+		return true
+	})
+	return online, inFlight
+}
+
+// grow adds a connection to the ring, the pool refuses one above MaxActiveSize.
+func (f *FixedPool) grow() {
+	conn, err := f.innerPool.makeConn(context.Background())
+	if err != nil {
+		logger.Info("Failed to grow backend pool", "Addr", f.fixedCfg.Addr, "error", err)
+		return
+	}
+	f.putOnline(conn, nil)
+	logger.Info("Backend pool grown", "Addr", f.fixedCfg.Addr, "BackendConn", conn.Id)
+}
+
+// shrink retires an idle connection out of a transaction, it is closed once its sessions moved.
+func (f *FixedPool) shrink(interval time.Duration) {
+	var retired *BackendConn
+	f.onLines.Range(func(_ string, conn *BackendConn) bool {
+		if !conn.IsRetired() && conn.IsIdle() && !conn.LoadTxnState().Active() {
+			retired = conn
+			return false
+		}
+		return true
+	})
+	if retired == nil {
+		return
+	}
+	f.removeOnline(retired)
+	retired.Retire()
+	f.innerPool.mu.Lock()
+	f.innerPool.tryRemoveConn(retired)
+	f.innerPool.mu.Unlock()
+	logger.Info("Backend pool shrunk", "Addr", f.fixedCfg.Addr, "Retired", retired.Id)
+	go closeRetired(retired, interval)
+}
@@ -0,0 +1,25 @@
+package be_cluster
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// ErrPubSubNotSupported is replied to the commands subscribing a connection to channels.
+var ErrPubSubNotSupported = errors.New("ERR pub/sub is not supported by the proxy")
+
+// subscribeCmds put a connection in the subscribed state. Their messages arrive without a
+// request, on a shared backend connection they would be taken for the replies of other sessions.
+var subscribeCmds = []string{"subscribe", "psubscribe", "ssubscribe", "unsubscribe", "punsubscribe", "sunsubscribe"}
+
+// HandlePubSubCommand rejects the subscribe commands. PUBLISH and PUBSUB have a reply like any
+// other command, they are forwarded.
+func HandlePubSubCommand(packet *respio.RespPacket) (*respio.RespPacket, bool) {
+	if !slices.Contains(subscribeCmds, strings.ToLower(string(packet.GetCommand()))) {
+		return nil, false
+	}
+	return respio.NewError(ErrPubSubNotSupported.Error()), true
+}
@@ -0,0 +1,22 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlePubSubCommand(t *testing.T) {
+	for _, cmd := range [][]string{{"SUBSCRIBE", "ch"}, {"psubscribe", "ch.*"}, {"SSUBSCRIBE", "ch"},
+		{"UNSUBSCRIBE"}, {"PUNSUBSCRIBE"}, {"SUNSUBSCRIBE", "ch"}} {
+		reply, handled := HandlePubSubCommand(respio.NewCommand(cmd...))
+		assert.True(t, handled, cmd)
+		assert.Equal(t, respio.RespError, reply.Type)
+		assert.Equal(t, ErrPubSubNotSupported.Error(), string(reply.Data))
+	}
+	for _, cmd := range [][]string{{"PUBLISH", "ch", "msg"}, {"PUBSUB", "CHANNELS"}, {"GET", "k"}} {
+		_, handled := HandlePubSubCommand(respio.NewCommand(cmd...))
+		assert.False(t, handled, cmd)
+	}
+}
@@ -0,0 +1,57 @@
+package be_cluster
+
+import (
+	"strings"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// ReadSplitPolicy decides whether a command may be served by a replica when read/write
+// splitting is enabled. Read-only commands, including introspection commands like TYPE,
+// TTL or OBJECT ENCODING, go to replicas unless they are pinned to the primary, e.g. for
+// read-after-write consistency. The proxy has no replica routing yet, every command goes to
+// the instance the tenant is routed to, so no flag configures the policy.
+type ReadSplitPolicy struct {
+	primaryPinned map[string]struct{}
+	// unknownToReplica lets a replica serve the commands without metadata, see
+	// common.UnknownCmdPolicyForward
+	unknownToReplica bool
+}
+
+func NewReadSplitPolicy(primaryCmds []string, unknownCmdPolicy string) *ReadSplitPolicy {
+	pinned := make(map[string]struct{}, len(primaryCmds))
+	for _, cmd := range primaryCmds {
+		// sub commands are configured as "object encoding" or "object|encoding"
+		name := strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(cmd, "|", " ")), "|"))
+		if name != "" {
+			pinned[name] = struct{}{}
+		}
+	}
+	return &ReadSplitPolicy{
+		primaryPinned:    pinned,
+		unknownToReplica: unknownCmdPolicy == common.UnknownCmdPolicyForward,
+	}
+}
+
+// RouteToReplica reports whether the command can be served by a replica. Unknown commands are
+// conservatively routed to the primary, unless they are forwarded like any read.
+func (p *ReadSplitPolicy) RouteToReplica(packet *respio.RespPacket) bool {
+	meta := respio.LookupCommand(packet)
+	if meta == nil {
+		return p.unknownToReplica
+	}
+	if !meta.IsReadOnly() {
+		return false
+	}
+	if _, ok := p.primaryPinned[meta.Name]; ok {
+		return false
+	}
+	// a pinned container pins all its sub commands
+	if idx := strings.IndexByte(meta.Name, '|'); idx > 0 {
+		if _, ok := p.primaryPinned[meta.Name[:idx]]; ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,26 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSplitPolicy_RouteToReplica(t *testing.T) {
+	policy := NewReadSplitPolicy([]string{"EXISTS", "object freq"}, common.UnknownCmdPolicyPrimary)
+
+	assert.True(t, policy.RouteToReplica(respio.NewCommand("TTL", "k")))
+	assert.True(t, policy.RouteToReplica(respio.NewCommand("type", "k")))
+	assert.True(t, policy.RouteToReplica(respio.NewCommand("OBJECT", "ENCODING", "k")))
+	assert.True(t, policy.RouteToReplica(respio.NewCommand("MEMORY", "USAGE", "k")))
+	assert.True(t, policy.RouteToReplica(respio.NewCommand("MEMORY", "STATS")))
+	assert.False(t, policy.RouteToReplica(respio.NewCommand("MEMORY", "PURGE")))
+	assert.False(t, policy.RouteToReplica(respio.NewCommand("SET", "k", "v")))
+	assert.False(t, policy.RouteToReplica(respio.NewCommand("UNKNOWNCMD", "k")))
+
+	// overridden commands are pinned to the primary
+	assert.False(t, policy.RouteToReplica(respio.NewCommand("EXISTS", "k")))
+	assert.False(t, policy.RouteToReplica(respio.NewCommand("OBJECT", "FREQ", "k")))
+}
@@ -1,16 +1,31 @@
 package be_cluster
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"strings"
+	"time"
 
+	"github.com/cenkalti/backoff/v5"
 	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+const (
+	backendProbeTimeout = 3 * time.Second
+	// staticMonitorInterval is the default period of the probes of the online static backend
+	staticMonitorInterval = 2 * time.Second
+	// staticOfflineProbes is the number of failed probes in a row taking the static backend offline
+	staticOfflineProbes = 2
 )
 
 type BackendNotify func(instance *ClusterInstance)
 
 type BackendRouter interface {
-	// BackendChangeNotify is called when the backend changes, This is used to notify the backend manager
-	BackendChangeNotify(notify BackendNotify)
+	// BackendChangeNotify is called when the backend changes, This is used to notify the backend manager.
+	// It runs until ctx is done.
+	BackendChangeNotify(ctx context.Context, notify BackendNotify)
 	Selector(balancer Balancer, key *ClusterKey) (*ClusterInstance, error)
 	ListBackend(key *ClusterKey) ([]*ClusterInstance, error)
 }
@@ -19,10 +34,78 @@ var _ BackendRouter = &StaticBackendRouter{}
 
 type StaticBackendRouter struct {
 	backend *ClusterInstance
+	// monitorInterval is the period of the probes once the backend is online, staticMonitorInterval
+	// when zero
+	monitorInterval time.Duration
 }
 
-func (s *StaticBackendRouter) BackendChangeNotify(notify BackendNotify) {
-	notify(s.backend)
+// BackendChangeNotify brings the static backend online once it is reachable, then keeps probing
+// it: once it stops answering it is taken offline, clients get LOADING errors until it is back
+// and its pool is dialed again. The probing stops once ctx is done.
+func (s *StaticBackendRouter) BackendChangeNotify(ctx context.Context, notify BackendNotify) {
+	addr := s.backend.GetAddr()
+	interval := s.monitorInterval
+	if interval <= 0 {
+		interval = staticMonitorInterval
+	}
+	for {
+		if !waitStaticBackend(ctx, addr, interval) {
+			logger.Info("Static backend probing stopped", "Addr", addr)
+			return
+		}
+		notify(s.backend)
+		if !monitorStaticBackend(ctx, addr, interval) {
+			logger.Info("Static backend probing stopped", "Addr", addr)
+			return
+		}
+		offline := *s.backend
+		offline.Status = ClusterStatusOffline
+		notify(&offline)
+	}
+}
+
+// waitStaticBackend probes the backend with a backoff up to maxInterval until it answers, false
+// once ctx is done.
+func waitStaticBackend(ctx context.Context, addr string, maxInterval time.Duration) bool {
+	retryBackOff := backoff.NewExponentialBackOff()
+	retryBackOff.MaxInterval = maxInterval
+	for {
+		err := ProbeBackend(addr, backendProbeTimeout)
+		if err == nil {
+			return true
+		}
+		logger.Info("WARN: static backend is unreachable, clients get LOADING errors", "Addr", addr, "Error", err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(retryBackOff.NextBackOff()):
+		}
+	}
+}
+
+// monitorStaticBackend probes the online backend every interval until staticOfflineProbes probes
+// in a row failed, false once ctx is done.
+func monitorStaticBackend(ctx context.Context, addr string, interval time.Duration) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		err := ProbeBackend(addr, backendProbeTimeout)
+		if err == nil {
+			failed = 0
+			continue
+		}
+		failed++
+		logger.Info("WARN: static backend probe failed", "Addr", addr, "Failed", failed, "Error", err)
+		if failed >= staticOfflineProbes {
+			return true
+		}
+	}
 }
 
 func (s *StaticBackendRouter) Selector(_ Balancer, _ *ClusterKey) (*ClusterInstance, error) {
@@ -50,3 +133,50 @@ func NewBackendRouter(conf *common.ProxyConfig) BackendRouter {
 		}
 	}
 }
+
+// ProbeBackend dials the backend and sends a PING. Any reply, even a NOAUTH error, proves that
+// the backend is reachable.
+func ProbeBackend(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	writer := respio.NewRespWriter(conn)
+	ping := respio.NewCommand("PING")
+	defer respio.ReleaseRespPacket(ping)
+	if err := writer.Write(ping); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	reply, err := respio.NewRespReader(conn).Read()
+	if err != nil {
+		return fmt.Errorf("no reply to PING from %s: %w", addr, err)
+	}
+	respio.ReleaseRespPacket(reply)
+	return nil
+}
+
+// CheckStaticBackend probes the static backend at startup. With fail-fast an unreachable
+// backend is an error, otherwise it is only logged and the proxy starts in the LOADING state.
+func CheckStaticBackend(conf *common.ProxyConfig) error {
+	if strings.ToLower(conf.Router.RouterType) != "static" {
+		return nil
+	}
+	err := ProbeBackend(conf.Router.StaticBackend, backendProbeTimeout)
+	if err == nil {
+		logger.Info("Static backend is reachable", "Addr", conf.Router.StaticBackend)
+		return nil
+	}
+	if conf.FailFast {
+		return fmt.Errorf("static backend %s is unreachable: %w", conf.Router.StaticBackend, err)
+	}
+	logger.Info("WARN: static backend is unreachable, starting in the LOADING state",
+		"Addr", conf.Router.StaticBackend, "Error", err)
+	return nil
+}
@@ -0,0 +1,219 @@
+package be_cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+// servePong answers PONG to every command received by the listener.
+func servePong(t *testing.T, lis net.Listener) {
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader, writer := respio.NewRespReader(conn), respio.NewRespWriter(conn)
+				for {
+					if _, err := reader.Read(); err != nil {
+						return
+					}
+					_ = writer.Write(respio.NewStatus("PONG"))
+					_ = writer.Flush()
+				}
+			}()
+		}
+	}()
+}
+
+// unusedAddr returns an address nothing listens on.
+func unusedAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := lis.Addr().String()
+	_ = lis.Close()
+	return addr
+}
+
+func TestCheckStaticBackend(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	servePong(t, lis)
+	newConfig := func(addr string, failFast bool) *common.ProxyConfig {
+		return &common.ProxyConfig{
+			FailFast: failFast,
+			Router:   common.BackendRouterConfig{RouterType: "static", StaticBackend: addr},
+		}
+	}
+
+	assert.NoError(t, CheckStaticBackend(newConfig(lis.Addr().String(), true)))
+	assert.NoError(t, CheckStaticBackend(newConfig(lis.Addr().String(), false)))
+
+	downAddr := unusedAddr(t)
+	assert.Error(t, CheckStaticBackend(newConfig(downAddr, true)))
+	// without fail-fast the proxy starts anyway
+	assert.NoError(t, CheckStaticBackend(newConfig(downAddr, false)))
+}
+
+func TestStaticBackendRouter_LoadingUntilReachable(t *testing.T) {
+	addr := unusedAddr(t)
+	host, port, err := (&common.BackendRouterConfig{StaticBackend: addr}).StatisEndpoint()
+	assert.NoError(t, err)
+	router := &StaticBackendRouter{backend: LocalClusterInstance(host, port)}
+
+	var notified atomic.Bool
+	go router.BackendChangeNotify(context.Background(), func(instance *ClusterInstance) {
+		notified.Store(true)
+	})
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, notified.Load())
+
+	lis, err := net.Listen("tcp", addr)
+	assert.NoError(t, err)
+	servePong(t, lis)
+	assert.Eventually(t, notified.Load, 5*time.Second, 50*time.Millisecond)
+}
+
+// serveDroppable serves PONG on addr until the returned func is called, which closes the listener
+// and the connections accepted, the way a restarting backend drops its clients.
+func serveDroppable(t *testing.T, addr string) func() {
+	lis, err := net.Listen("tcp", addr)
+	assert.NoError(t, err)
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() {
+				reader, writer := respio.NewRespReader(conn), respio.NewRespWriter(conn)
+				for {
+					if _, err := reader.Read(); err != nil {
+						return
+					}
+					_ = writer.Write(respio.NewStatus("PONG"))
+					_ = writer.Flush()
+				}
+			}()
+		}
+	}()
+	var once sync.Once
+	drop := func() {
+		once.Do(func() {
+			_ = lis.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+		})
+	}
+	t.Cleanup(drop)
+	return drop
+}
+
+func TestStaticBackendRouter_Reconnect(t *testing.T) {
+	addr := unusedAddr(t)
+	drop := serveDroppable(t, addr)
+	host, port, err := (&common.BackendRouterConfig{StaticBackend: addr}).StatisEndpoint()
+	assert.NoError(t, err)
+	router := &StaticBackendRouter{backend: LocalClusterInstance(host, port), monitorInterval: 50 * time.Millisecond}
+	config := &common.ProxyConfig{
+		Router:     common.BackendRouterConfig{RouterType: "static", StaticBackend: addr},
+		BeConnPool: common.BackendPoolConfig{MaxSize: 1, DialTimeout: 100 * time.Millisecond},
+	}
+	beMgr := newBackendManager(config, router)
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a")}
+	beMgr.clusterKeyMap.Store(string(authInfo.Username), &router.backend.Key)
+	beMgr.PrepareCluster()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		beMgr.Close(ctx)
+	}()
+
+	session, clientReader := newPipeSession(t, "static-reconnect")
+	session.SetAuthInfo(authInfo)
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr}
+	sm.sessions.Store(session.Id, &SessionPair{session: session})
+	ping := func() error {
+		if err := sm.Forward(session.Id, NextRequestId(), respio.NewCommand("PING"), authInfo); err != nil {
+			return err
+		}
+		reply, err := clientReader.Read()
+		if err != nil {
+			return err
+		}
+		if reply.Type == respio.RespError {
+			return errors.New(string(reply.Data))
+		}
+		return nil
+	}
+	assert.Eventually(t, func() bool { return ping() == nil }, 5*time.Second, 20*time.Millisecond)
+
+	// the backend goes away, clients get LOADING until it is back
+	drop()
+	assert.Eventually(t, func() bool {
+		return errors.Is(ping(), ErrBackendsNotReady)
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.False(t, beMgr.IsBackendReady())
+
+	// the session bound to the dropped connection resumes on the new pool
+	serveDroppable(t, addr)
+	assert.Eventually(t, func() bool { return ping() == nil }, 5*time.Second, 20*time.Millisecond)
+	assert.True(t, beMgr.IsBackendReady())
+}
+
+func TestSyncRouter_SelectorNoRoutableInstance(t *testing.T) {
+	registry := newDefaultClusterRegistry()
+	router := &SyncRouter{registry: registry}
+	key := LocalClusterInstance("127.0.0.1", 6379).Key
+	assert.NoError(t, registry.AddCluster(&key))
+	cluster, err := registry.GetClusterInstance(key)
+	assert.NoError(t, err)
+	balancers := []Balancer{NewTenantHashBalancer(), NewRandomBalancer(), NewEWMABalancer(NewLatencyRegistry())}
+
+	// the cluster is known before any of its instances
+	for _, balancer := range balancers {
+		_, err = router.Selector(balancer, &key)
+		assert.ErrorIs(t, err, ErrNoInstanceAvailable)
+	}
+
+	instanceA, instanceB := LocalClusterInstance("127.0.0.1", 6379), LocalClusterInstance("127.0.0.1", 6380)
+	instanceB.Id = "b"
+	cluster.Upsert(instanceA)
+	cluster.Upsert(instanceB)
+	cluster.UpdateClusterStatus(key, instanceA.Id, ClusterStatusOffline)
+	for _, balancer := range balancers {
+		selected, err := router.Selector(balancer, &key)
+		assert.NoError(t, err)
+		assert.Same(t, instanceB, selected)
+	}
+
+	// every instance registered is offline
+	cluster.UpdateClusterStatus(key, instanceB.Id, ClusterStatusDeleted)
+	for _, balancer := range balancers {
+		_, err = router.Selector(balancer, &key)
+		assert.ErrorIs(t, err, ErrNoInstanceAvailable)
+	}
+}
@@ -0,0 +1,102 @@
+package be_cluster
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+var (
+	evalCmd    = []byte("eval")
+	evalRoCmd  = []byte("eval_ro")
+	evalShaCmd = []byte("evalsha")
+	evalShaRo  = []byte("evalsha_ro")
+	scriptCmd  = []byte("script")
+	loadSubCmd = []byte("load")
+	flushCmd   = []byte("flush")
+)
+
+type scriptCmdType int
+
+const (
+	scriptCmdNone scriptCmdType = iota
+	// scriptCmdLoad EVAL or SCRIPT LOAD, the argument is the script body
+	scriptCmdLoad
+	// scriptCmdEvalSha EVALSHA, the argument is the sha
+	scriptCmdEvalSha
+	// scriptCmdFlush SCRIPT FLUSH
+	scriptCmdFlush
+)
+
+// classifyScriptCmd returns the kind of a script command and its body or sha argument.
+func classifyScriptCmd(packet *respio.RespPacket) (scriptCmdType, []byte) {
+	if packet.Type != respio.RespArray || len(packet.Array) < 2 {
+		return scriptCmdNone, nil
+	}
+	cmd, arg := packet.Array[0].Data, packet.Array[1].Data
+	switch {
+	case bytes.EqualFold(cmd, evalCmd) || bytes.EqualFold(cmd, evalRoCmd):
+		return scriptCmdLoad, arg
+	case bytes.EqualFold(cmd, evalShaCmd) || bytes.EqualFold(cmd, evalShaRo):
+		return scriptCmdEvalSha, arg
+	case bytes.EqualFold(cmd, scriptCmd) && bytes.EqualFold(arg, loadSubCmd) && len(packet.Array) > 2:
+		return scriptCmdLoad, packet.Array[2].Data
+	case bytes.EqualFold(cmd, scriptCmd) && bytes.EqualFold(arg, flushCmd):
+		return scriptCmdFlush, nil
+	}
+	return scriptCmdNone, nil
+}
+
+// scriptSha returns the sha Redis names a script with.
+func scriptSha(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// ScriptCache keeps the bodies of the scripts the tenants ran with EVAL or SCRIPT LOAD. A backend
+// connection only knows the scripts run on it, with the body the proxy loads a script on the
+// connection an EVALSHA is forwarded to before it answers NOSCRIPT.
+type ScriptCache struct {
+	// bodies is keyed by tenant and sha, a tenant never loads the scripts of another one
+	bodies *xsync.MapOf[string, []byte]
+}
+
+func NewScriptCache() *ScriptCache {
+	return &ScriptCache{
+		bodies: xsync.NewMapOf[string, []byte](),
+	}
+}
+
+func scriptKey(tenant, sha string) string {
+	return tenant + "|" + sha
+}
+
+// Body returns the body of the script with the sha, if the tenant ran it through the proxy.
+func (c *ScriptCache) Body(tenant string, sha []byte) ([]byte, bool) {
+	return c.bodies.Load(scriptKey(tenant, strings.ToLower(string(sha))))
+}
+
+// Flush forgets the scripts of the tenant, after a SCRIPT FLUSH EVALSHA must fail again.
+func (c *ScriptCache) Flush(tenant string) {
+	prefix := tenant + "|"
+	c.bodies.Range(func(key string, _ []byte) bool {
+		if strings.HasPrefix(key, prefix) {
+			c.bodies.Delete(key)
+		}
+		return true
+	})
+}
+
+// observe records the scripts of a command forwarded for the tenant.
+func (c *ScriptCache) observe(tenant string, packet *respio.RespPacket) {
+	switch cmdType, arg := classifyScriptCmd(packet); cmdType {
+	case scriptCmdLoad:
+		c.bodies.Store(scriptKey(tenant, scriptSha(arg)), bytes.Clone(arg))
+	case scriptCmdFlush:
+		c.Flush(tenant)
+	}
+}
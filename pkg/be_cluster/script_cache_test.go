@@ -0,0 +1,42 @@
+package be_cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionManager_EvalShaOnFreshConn(t *testing.T) {
+	sm, session, reader := newTestSessionManager(t, scriptBackend())
+	sm.scripts = NewScriptCache()
+	forward := func(args ...string) *respio.RespPacket {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand(args...), session.GetAuthInfo()))
+		reply, err := reader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+	sha := scriptSha([]byte("return 1"))
+	assert.Equal(t, respio.RespStatus, forward("EVAL", "return 1", "0").Type)
+
+	// the session moves to a connection that never ran the script
+	fresh := newPipeBackendConnAt(t, "127.0.0.1:6379", scriptBackend())
+	sm.sessions.Store(session.Id, &SessionPair{session: session, backend: fresh})
+	assert.False(t, fresh.hasScript([]byte(sha)))
+
+	reply := forward("EVALSHA", sha, "0")
+	assert.Equal(t, respio.RespStatus, reply.Type, string(reply.Data))
+	assert.Eventually(t, func() bool {
+		return fresh.hasScript([]byte(sha))
+	}, time.Second, 10*time.Millisecond)
+
+	// unknown to the proxy, the backend answers
+	assert.Equal(t, respio.RespError, forward("EVALSHA", scriptSha([]byte("return 2")), "0").Type)
+
+	// after SCRIPT FLUSH the script is not reloaded
+	sm.scripts.observe("tenant-a", respio.NewCommand("SCRIPT", "FLUSH"))
+	fresh = newPipeBackendConnAt(t, "127.0.0.1:6379", scriptBackend())
+	sm.sessions.Store(session.Id, &SessionPair{session: session, backend: fresh})
+	assert.Equal(t, respio.RespError, forward("EVALSHA", sha, "0").Type)
+}
@@ -1,9 +1,12 @@
 package be_cluster
 
 import (
+	"bytes"
+	"errors"
 	"github.com/pzhenzhou/elika/pkg/common"
 	"github.com/pzhenzhou/elika/pkg/respio"
 	"net"
+	"sync"
 	"sync/atomic"
 )
 
@@ -11,6 +14,35 @@ const (
 	DefaultSessionOutQSize = 1024
 )
 
+// ReplyMode is the CLIENT REPLY mode of a session.
+type ReplyMode int
+
+const (
+	ReplyModeOn ReplyMode = iota
+	ReplyModeOff
+	// ReplyModeSkip drops the reply of the next command only
+	ReplyModeSkip
+)
+
+var (
+	// sessionIdGen generates the ids returned by CLIENT ID
+	sessionIdGen atomic.Uint64
+	// ErrHelloRequired is replied to the commands sent before HELLO when the proxy requires it
+	ErrHelloRequired = errors.New("ERR HELLO required")
+	// ErrHelloNoAuth is replied to a HELLO without the AUTH option before the client is
+	// authenticated, like Redis does
+	ErrHelloNoAuth = errors.New("NOAUTH HELLO must be called with the client already authenticated, " +
+		"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+		"and select the RESP protocol version at the same time")
+	// ErrHelloInTransaction is replied to HELLO 3 in a transaction on a shared backend connection
+	ErrHelloInTransaction = errors.New("ERR HELLO 3 is not allowed in a transaction")
+	// ErrInvalidAuth is replied to an AUTH with a wrong number of arguments, or without a
+	// username when the tenant is routed by its username
+	ErrInvalidAuth = errors.New("ERR invalid AUTH")
+	// ErrSessionClosed is returned for a command of a session released meanwhile
+	ErrSessionClosed = errors.New("ERR session closed")
+)
+
 // Session represents the TCP connection between a client and the ProxyServer.
 // Memory:
 //   - Id string: ~24-32 bytes (16 bytes for string header + 8-16 bytes for content)
@@ -25,7 +57,8 @@ const (
 //     → 8 bytes (pointer) + ~16 bytes (*bufio.Writer overhead)
 //
 // Total: ~200-248 bytes base size
-// Note: Buffer memory (DefaultBufferSize = 8KB) is allocated in the underlying
+// Note: Buffer memory (respio.DefaultBufferSize unless --session.buffer-size is set, for each
+// of the reader and the writer) is allocated in the underlying
 // Memory Usage Estimation:
 // +------------------+---------------+----------------+
 // | Connections      | Total Objects | Memory Usage   |
@@ -41,19 +74,69 @@ type Session struct {
 	authInfo atomic.Value
 	quit     chan struct{}
 	OutQ     chan *ResponseContext
-	reader   *respio.RespReader
-	writer   *respio.RespWriter
+	// reader is only used by the event loop serving the session
+	reader *respio.RespReader
+	// writer is used by the ReplyLoop, and by the event loop for the errors written right away
+	// with WriteError, writeMu serializes them
+	writer  *respio.RespWriter
+	writeMu sync.Mutex
+	// leasedIO holds the reader and the writer leased from the pool, nil if allocated
+	leasedIO *respio.RespIO
+	// The client state below is kept by the proxy instead of the shared backend connections.
+	// It is only accessed by the event loop serving the session.
+	clientId  uint64
+	name      []byte
+	replyMode ReplyMode
+	noEvict   bool
+	noTouch   bool
+	// libName and libVer are set by CLIENT SETINFO
+	libName []byte
+	libVer  []byte
+	// helloSent is set once the client sent HELLO, refused or not
+	helloSent bool
+	// closing is set once the connection is closed after its last reply, nothing more is read
+	closing bool
+	// protoVer is the protocol version negotiated with HELLO, proxy errors are shaped after it
+	protoVer atomic.Int32
+	// paused is set while the commands of the session are not dispatched, its backend connection
+	// has too many requests in flight
+	paused atomic.Bool
+	// orderMu orders the replies queued to OutQ: every reply takes the next seq when its command
+	// is dispatched, nextOut is the seq queued next and held are the replies arrived before it
+	orderMu sync.Mutex
+	nextSeq uint64
+	nextOut uint64
+	held    map[uint64]*ResponseContext
+}
+
+// NewDefaultSession returns a session whose reply queue holds DefaultSessionOutQSize replies.
+func NewDefaultSession(Id string, client net.Conn) *Session {
+	return NewSession(Id, client, DefaultSessionOutQSize)
 }
 
 func NewSession(Id string, client net.Conn, queueSize int) *Session {
-	return &Session{
-		Id:     Id,
-		Client: client,
-		quit:   make(chan struct{}),
-		OutQ:   make(chan *ResponseContext, queueSize),
-		reader: respio.NewRespReader(client),
-		writer: respio.NewRespWriter(client),
+	return NewSessionWithBufferSize(Id, client, queueSize, respio.DefaultBufferSize)
+}
+
+// NewSessionWithBufferSize returns a session whose client reader and writer each buffer
+// bufferSize bytes. Small buffers cut the memory of many idle connections.
+func NewSessionWithBufferSize(Id string, client net.Conn, queueSize int, bufferSize int) *Session {
+	session := &Session{
+		Id:       Id,
+		clientId: sessionIdGen.Add(1),
+		Client:   client,
+		quit:     make(chan struct{}),
+		OutQ:     make(chan *ResponseContext, queueSize),
+	}
+	if bufferSize == respio.DefaultBufferSize {
+		session.leasedIO = respio.AcquireRespIO(client)
+		session.reader, session.writer = session.leasedIO.Reader, session.leasedIO.Writer
+	} else {
+		session.reader = respio.NewRespReaderSize(client, bufferSize)
+		session.writer = respio.NewRespWriterSize(client, bufferSize)
 	}
+	session.protoVer.Store(respio.Resp2)
+	return session
 }
 
 func (s *Session) Read() (*respio.RespPacket, error) {
@@ -65,6 +148,8 @@ func (s *Session) ReadBuffered() int {
 }
 
 func (s *Session) WriteAndFlush(pkt *respio.RespPacket) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
 	err := s.writer.Write(pkt)
 	if err != nil {
 		logger.Error(err, "Failed to write packet to client", "SessionId", s.Id)
@@ -73,6 +158,170 @@ func (s *Session) WriteAndFlush(pkt *respio.RespPacket) error {
 	return s.writer.Flush()
 }
 
+// WriteError writes an error reply right away, as a blob error if the session speaks RESP3.
+// The packet is not modified, so shared packets like respio.ErrNoAuth can be passed.
+func (s *Session) WriteError(pkt *respio.RespPacket) error {
+	if s.ProtoVersion() >= respio.Resp3 {
+		return s.WriteAndFlush(&respio.RespPacket{Type: respio.RespBlobError, Data: pkt.Data})
+	}
+	return s.WriteAndFlush(pkt)
+}
+
+// RejectBeforeHello reports whether the command must be answered with ErrHelloRequired: HELLO
+// is required and the client has not sent one yet. A HELLO is recorded, it and the connection
+// commands are never rejected.
+func (s *Session) RejectBeforeHello(packet *respio.RespPacket, requireHello bool) bool {
+	if bytes.EqualFold(packet.GetCommand(), respio.HelloCmd) {
+		s.RecordHello()
+		return false
+	}
+	return requireHello && !s.helloSent && !isConnectionCmd(packet)
+}
+
+// RecordHello records that the client sent HELLO, see RejectBeforeHello. A HELLO is handled
+// before the client is authenticated, its AUTH option may authenticate it.
+func (s *Session) RecordHello() {
+	s.helloSent = true
+}
+
+// ValidateAuthInfo checks the credentials of an AUTH before they are used for routing. A
+// username is required when the cluster of the client is found from it.
+func ValidateAuthInfo(authInfo *common.AuthInfo, requireUsername bool) error {
+	if authInfo == nil || requireUsername && len(authInfo.Username) == 0 {
+		return ErrInvalidAuth
+	}
+	return nil
+}
+
+// ProtoVersion returns the protocol version negotiated by the client, RESP2 until a HELLO
+// asking for another version succeeds.
+func (s *Session) ProtoVersion() int {
+	return int(s.protoVer.Load())
+}
+
+func (s *Session) setProtoVersion(protoVer int) {
+	s.protoVer.Store(int32(protoVer))
+}
+
+// Pause marks the session paused, it returns false if it was paused already.
+func (s *Session) Pause() bool {
+	return s.paused.CompareAndSwap(false, true)
+}
+
+// Resume clears the mark set by Pause.
+func (s *Session) Resume() {
+	s.paused.Store(false)
+}
+
+// OutQLen returns the number of replies waiting to be written to the client.
+func (s *Session) OutQLen() int {
+	return len(s.OutQ)
+}
+
+// ReplyLocal queues a reply produced by the proxy itself. The replies of the commands forwarded
+// before may still be on their way from the backend, the reply is held until they are queued.
+func (s *Session) ReplyLocal(pkt *respio.RespPacket) {
+	if pkt == nil {
+		return
+	}
+	if s.suppressReply() {
+		respio.ReleaseRespPacket(pkt)
+		return
+	}
+	s.orderMu.Lock()
+	defer s.orderMu.Unlock()
+	seq := s.nextSeq
+	s.nextSeq++
+	s.place(seq, &ResponseContext{Response: pkt, Local: true})
+}
+
+// ReplyLocalAndClose answers like ReplyLocal, after the replies of the commands dispatched
+// before, and then closes the client connection. Nothing more is read from the client. The
+// reply is written even if CLIENT REPLY turned the replies off, it tells why the connection is
+// closed.
+func (s *Session) ReplyLocalAndClose(pkt *respio.RespPacket) {
+	s.closing = true
+	s.orderMu.Lock()
+	defer s.orderMu.Unlock()
+	seq := s.nextSeq
+	s.nextSeq++
+	s.place(seq, &ResponseContext{Response: pkt, Local: true, closeAfter: true})
+}
+
+// Closing reports whether the client connection is closed once the replies queued are written.
+func (s *Session) Closing() bool {
+	return s.closing
+}
+
+// expectReply returns the seq of the reply of a command dispatched now, the replies of the
+// commands dispatched after it are queued after it.
+func (s *Session) expectReply() uint64 {
+	s.orderMu.Lock()
+	defer s.orderMu.Unlock()
+	seq := s.nextSeq
+	s.nextSeq++
+	return seq
+}
+
+// deliver queues a reply for the ReplyLoop once the replies of the commands dispatched before are
+// queued, followed by the replies that were waiting for it.
+func (s *Session) deliver(rspCtx *ResponseContext) {
+	s.orderMu.Lock()
+	defer s.orderMu.Unlock()
+	if !rspCtx.ordered {
+		s.enqueueReply(rspCtx)
+		return
+	}
+	s.place(rspCtx.seq, rspCtx)
+}
+
+// place must be called with orderMu held.
+func (s *Session) place(seq uint64, rspCtx *ResponseContext) {
+	if seq != s.nextOut {
+		if s.held == nil {
+			s.held = make(map[uint64]*ResponseContext)
+		}
+		s.held[seq] = rspCtx
+		return
+	}
+	s.enqueueReply(rspCtx)
+	s.nextOut++
+	for {
+		next, ok := s.held[s.nextOut]
+		if !ok {
+			return
+		}
+		delete(s.held, s.nextOut)
+		s.enqueueReply(next)
+		s.nextOut++
+	}
+}
+
+// enqueueReply must be called with orderMu held. Once the session is closed nothing drains OutQ
+// anymore, the reply is dropped rather than blocking the backend connection or the event loop.
+func (s *Session) enqueueReply(rspCtx *ResponseContext) {
+	select {
+	case s.OutQ <- rspCtx:
+	case <-s.quit:
+		respio.ReleaseRespPacket(rspCtx.Response)
+		recordDrop(DropSessionClosed)
+	}
+}
+
+// suppressReply reports whether the reply of the current command must be dropped according
+// to CLIENT REPLY. It must be called exactly once per command, in command order.
+func (s *Session) suppressReply() bool {
+	switch s.replyMode {
+	case ReplyModeOff:
+		return true
+	case ReplyModeSkip:
+		s.replyMode = ReplyModeOn
+		return true
+	default:
+		return false
+	}
+}
+
 func (s *Session) ReplyLoop() {
 	for {
 		select {
@@ -80,23 +329,95 @@ func (s *Session) ReplyLoop() {
 			// logger.Info("Session ReadLoop stop", "Id", s.Id)
 			return
 		case rspCtx := <-s.OutQ:
+			if rspCtx.awaitReply != nil {
+				// the placeholder of a pinned command, its reply comes from the pinned instance
+				respio.ReleaseRespPacket(rspCtx.Response)
+				select {
+				case rspCtx = <-rspCtx.awaitReply:
+				case <-s.quit:
+					return
+				}
+			}
 			respPacket := rspCtx.Response
 			callback := rspCtx.Callback
 			if callback != nil {
 				callback(s)
 			}
+			// errors of the proxy are shaped when written, a HELLO answered just before may
+			// have changed the protocol after they were queued
+			if rspCtx.Local && respPacket.Type == respio.RespError && s.ProtoVersion() >= respio.Resp3 {
+				respPacket.Type = respio.RespBlobError
+			}
+			// the shared connections speak RESP2, only the session which switched its own
+			// connection to RESP3 reads RESP3 replies, this is a safeguard
+			if s.ProtoVersion() < respio.Resp3 {
+				respPacket = toResp2(rspCtx)
+			}
 			if err := s.WriteAndFlush(respPacket); err != nil {
-				logger.Error(err, "Failed to write packet to client", "SessionId", s.Id)
+				logger.Error(err, "Failed to write packet to client", "SessionId", s.Id,
+					"RequestId", rspCtx.RequestId)
 				// Release the packet even if there was an error writing it
 				respio.ReleaseRespPacket(respPacket)
-				continue
+				// the bufio writer keeps the error, no later reply can be written either
+				s.closeBroken()
+				return
+			}
+			if rspCtx.RequestId != 0 {
+				reqLogger.V(1).Info("Reply request", "RequestId", rspCtx.RequestId, "SessionId", s.Id)
 			}
 			// Release the packet back to the pool after successfully writing it
 			respio.ReleaseRespPacket(respPacket)
+			if rspCtx.closeAfter {
+				s.closeBroken()
+				return
+			}
 		}
 	}
 }
 
+// toResp2 converts a reply for a RESP2 client. A large reply is forwarded as raw bytes, it is
+// only decoded when read from a connection speaking RESP3, otherwise it is RESP2 already.
+func toResp2(rspCtx *ResponseContext) *respio.RespPacket {
+	packet := rspCtx.Response
+	if packet.Type != respio.RespRaw {
+		return packet.ToRESP2()
+	}
+	if !rspCtx.Resp3 {
+		return packet
+	}
+	decoded, err := respio.NewRespReaderFromBytes(packet.Data).Read()
+	if err != nil {
+		logger.Error(err, "Failed to decode a RESP3 reply, forwarding it as is", "RequestId", rspCtx.RequestId)
+		return packet
+	}
+	respio.ReleaseRespPacket(packet)
+	return decoded.ToRESP2()
+}
+
+// closeBroken closes a session whose client can no longer be written to. Closing the client
+// connection makes the event loop release the session, the replies still queued are dropped.
+func (s *Session) closeBroken() {
+	s.Close()
+	if s.Client != nil {
+		_ = s.Client.Close()
+	}
+	for {
+		select {
+		case rspCtx := <-s.OutQ:
+			respio.ReleaseRespPacket(rspCtx.Response)
+		default:
+			return
+		}
+	}
+}
+
+// releaseIO returns the leased reader and writer to the pool. It must only be called once
+// neither the event loop nor the ReplyLoop uses them anymore.
+func (s *Session) releaseIO() {
+	respio.ReleaseRespIO(s.leasedIO)
+	s.leasedIO = nil
+}
+
 func (s *Session) Close() {
 	logger.Info("Session close", "Id", s.Id)
 	select {
@@ -111,10 +432,26 @@ func (s *Session) IsAuthenticated() bool {
 	return s.GetAuthInfo() != nil
 }
 
+// IsVerified reports whether the credentials of the session were accepted, the routing-only
+// auth info of an AUTH in flight has no password.
+func (s *Session) IsVerified() bool {
+	authInfo := s.GetAuthInfo()
+	return authInfo != nil && authInfo.Password != nil
+}
+
 func (s *Session) SetAuthInfo(authInfo *common.AuthInfo) {
 	s.authInfo.Store(authInfo)
 }
 
+// ResetPendingAuth drops auth info that has not been verified by the backend yet.
+// A session that already completed an AUTH keeps its credentials, like Redis where a
+// failed AUTH does not log out the current user.
+func (s *Session) ResetPendingAuth() {
+	if authInfo := s.GetAuthInfo(); authInfo != nil && authInfo.Password == nil {
+		s.authInfo.Store((*common.AuthInfo)(nil))
+	}
+}
+
 func (s *Session) GetAuthInfo() *common.AuthInfo {
 	if authInfo := s.authInfo.Load(); authInfo != nil {
 		return authInfo.(*common.AuthInfo)
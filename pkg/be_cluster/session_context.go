@@ -1,26 +1,80 @@
 package be_cluster
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/pzhenzhou/elika/pkg/common"
 	"github.com/pzhenzhou/elika/pkg/respio"
 )
 
+var (
+	requestIdGen atomic.Uint64
+	// reqLogger traces a command through dispatch, forward and reply at V(1)
+	reqLogger = logger.WithName("request")
+)
+
+// NextRequestId returns the id tying together the log lines of one command. It is only
+// known to the proxy, the backend never sees it.
+func NextRequestId() uint64 {
+	return requestIdGen.Add(1)
+}
+
 type RequestContext struct {
-	Request  *respio.RespPacket
-	Session  *Session
-	AuthInfo *common.AuthInfo
+	RequestId uint64
+	Request   *respio.RespPacket
+	Session   *Session
+	AuthInfo  *common.AuthInfo
+	// NoReply drops the backend reply instead of sending it to the client (CLIENT REPLY).
+	// It is decided when the request is forwarded because replies arrive asynchronously.
+	NoReply bool
+	// authCache is set for an AUTH when the verification cache is enabled
+	authCache *AuthCache
+	// onAuth is told whether the backend accepted an AUTH, set when an AuthListener is
+	onAuth func(success bool)
+	// sentAt is when the request was written to the backend, its round trip feeds the EWMA
+	// balancer
+	sentAt time.Time
+	// awaitReply is set on the placeholder holding the place of a command forwarded to a pinned
+	// instance, the reply of the command is written to the client instead of its own
+	awaitReply <-chan *ResponseContext
+	// ordered is set once the session gave the reply its place seq, see Session.expectReply
+	ordered bool
+	seq     uint64
 }
 
 type ResponseContext struct {
-	Response *respio.RespPacket
-	Callback func(*Session)
+	// RequestId is the id of the request answered, 0 for the replies of the proxy itself
+	RequestId uint64
+	Response  *respio.RespPacket
+	Callback  func(*Session)
+	// Local marks a reply produced by the proxy rather than the backend
+	Local bool
+	// Resp3 marks a reply read from a backend connection speaking RESP3
+	Resp3 bool
+	// awaitReply is copied from the request, see RequestContext
+	awaitReply <-chan *ResponseContext
+	// ordered and seq are copied from the request, the reply is queued in the order of seq
+	ordered bool
+	seq     uint64
+	// closeAfter closes the client connection once the reply is written
+	closeAfter bool
 }
 
-func NewErrResponseContext(err error) *ResponseContext {
-	return &ResponseContext{
-		Response: &respio.RespPacket{
-			Type: respio.RespError,
-			Data: []byte(err.Error()),
-		},
+// NewErrResponseContext answers the request with an error of the proxy. An AUTH, or a HELLO
+// with the AUTH option, the backend never answered drops the routing-only auth info of the
+// session, like a rejected one.
+func NewErrResponseContext(reqCtx *RequestContext, err error) *ResponseContext {
+	rspCtx := &ResponseContext{
+		RequestId:  reqCtx.RequestId,
+		Response:   respio.NewError(err.Error()),
+		Local:      true,
+		awaitReply: reqCtx.awaitReply,
+		ordered:    reqCtx.ordered,
+		seq:        reqCtx.seq,
+	}
+	if reqCtx.Request != nil && (reqCtx.Request.IsAuthCmd() || reqCtx.Request.IsHelloAuth()) {
+		rspCtx.Callback = (*Session).ResetPendingAuth
 	}
+	return rspCtx
 }
@@ -1,7 +1,10 @@
 package be_cluster
 
 import (
+	"context"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/pzhenzhou/elika/pkg/common"
 	"github.com/pzhenzhou/elika/pkg/respio"
@@ -9,68 +12,219 @@ import (
 	"github.com/puzpuzpuz/xsync/v3"
 )
 
+const (
+	QueueNameWrite   = "write_q"
+	QueueNamePending = "pending_q"
+	QueueNameOut     = "out_q"
+	// QueueNameInFlight counts the requests of the backend connections not answered yet
+	QueueNameInFlight = "in_flight"
+	unknownTenant     = "unknown"
+)
+
+// QueueDepth is a snapshot of the length of an internal queue.
+// Owner is the backend address for backend queues and the tenant for session queues.
+type QueueDepth struct {
+	Queue string
+	Owner string
+	Depth int
+}
+
 type SessionPair struct {
 	session *Session
 	backend *BackendConn
+	// reroute asks Forward to pick a new backend at the next command boundary
+	reroute atomic.Bool
+	// exclusive the backend connection was dialed for this session only, e.g. for DEBUG SLEEP
+	// or HELLO 3. The session goes back to the shared connections once it is idle and the
+	// connection speaks RESP2 again.
+	exclusive bool
+}
+
+// exclusiveDone reports whether the session can leave its dedicated connection without
+// reordering its replies. A RESP3 session keeps it, the shared connections speak RESP2.
+func (p *SessionPair) exclusiveDone() bool {
+	return p.exclusive && !p.inOwnTxn() && p.backend.IsIdle() &&
+		(p.backend.ProtoVersion() < respio.Resp3 || p.backend.closed.Load())
+}
+
+// inOwnTxn reports whether the session is in the middle of a transaction on its backend.
+func (p *SessionPair) inOwnTxn() bool {
+	if p.backend == nil {
+		return false
+	}
+	txState := p.backend.LoadTxnState()
+	return txState != nil && txState.OwnerSession != nil && txState.OwnerSession.Id == p.session.Id
 }
 
 type SessionManager struct {
 	sessions *xsync.MapOf[string, *SessionPair]
 	beMgr    *BackendManager
+	config   *common.ProxyConfig
+	// hotKeys is nil unless hot key sampling is enabled
+	hotKeys *HotKeySampler
+	// authCache is nil unless the AUTH verification cache is enabled
+	authCache *AuthCache
+	scripts   *ScriptCache
+	// pins is nil unless key patterns are pinned to an instance
+	pins *KeyPins
+	// authListener is nil unless the AUTH outcomes are observed, e.g. by the metrics
+	authListener AuthListener
+	// rerouteListener is nil unless the re-routes are observed
+	rerouteListener RerouteListener
 }
 
+// AuthListener is told the outcome of the AUTH of a client. The tenant is unknownTenant for
+// a username no cluster is registered for, so that random usernames do not make a label each.
+type AuthListener func(tenant string, success bool)
+
+// RerouteListener is told each time a session is bound to another backend connection.
+// txContention is set when the connection of the session was held by the transaction of
+// another session.
+type RerouteListener func(txContention bool)
+
 func NewSessionManager(config *common.ProxyConfig) *SessionManager {
-	return &SessionManager{
+	sm := &SessionManager{
 		sessions: xsync.NewMapOf[string, *SessionPair](),
 		beMgr:    GetBackendManager(config),
+		config:   config,
+		scripts:  NewScriptCache(),
+	}
+	if config.HotKey.SampleRate > 0 {
+		sm.hotKeys = NewHotKeySampler(config.HotKey.SampleRate, config.HotKey.TopK)
+	}
+	if config.AuthCache.Enable {
+		sm.authCache = NewAuthCache(config.AuthCache.TTL)
 	}
+	if len(config.Router.Pins) > 0 {
+		sm.pins = NewKeyPins(config.Router.Pins)
+	}
+	return sm
+}
+
+// VerifyCachedAuth reports whether the credentials were accepted by the backend recently and
+// can be verified locally. It is always false when the cache is disabled.
+func (sm *SessionManager) VerifyCachedAuth(authInfo *common.AuthInfo) bool {
+	return sm.authCache != nil && sm.authCache.Verify(authInfo)
+}
+
+// SetAuthListener must be called before the proxy serves clients.
+func (sm *SessionManager) SetAuthListener(listener AuthListener) {
+	sm.authListener = listener
+}
+
+// SetRerouteListener must be called before the proxy serves clients.
+func (sm *SessionManager) SetRerouteListener(listener RerouteListener) {
+	sm.rerouteListener = listener
+}
+
+// NotifyAuth reports the outcome of an AUTH to the listener, if any.
+func (sm *SessionManager) NotifyAuth(username []byte, success bool) {
+	if sm.authListener == nil {
+		return
+	}
+	tenant := unknownTenant
+	if sm.beMgr.GetTenantKey(string(username)) != nil {
+		tenant = string(username)
+	}
+	sm.authListener(tenant, success)
 }
 
 func (sm *SessionManager) RouteRequest(id string, authInfo *common.AuthInfo) (*SessionPair, error) {
 	var err error
+	var rerouted, txContention bool
 	sessionPair, _ := sm.sessions.Compute(id, func(oldValue *SessionPair, loaded bool) (newValue *SessionPair, delete bool) {
 		if loaded {
 			bindBackendConn := oldValue.backend
 			if bindBackendConn != nil {
 				txState := bindBackendConn.LoadTxnState()
-				if txState == nil || txState.OwnerSession != nil && txState.OwnerSession.Id == id {
+				if oldValue.exclusive {
+					if !oldValue.exclusiveDone() {
+						return oldValue, false
+					}
+				} else if txState == nil && !oldValue.reroute.Load() && !bindBackendConn.IsRetired() &&
+					!bindBackendConn.InstanceUnavailable() ||
+					oldValue.inOwnTxn() {
 					// No re-routing needed
 					return oldValue, false
 				}
 			}
 		}
 		// Re-routing needed
-		pool, loadErr := sm.beMgr.GetBackendFixedPool(string(authInfo.Username))
-		if loadErr != nil {
-			logger.Info("Failed to route request", "SessionId", id, "Error", loadErr)
-			err = loadErr
+		backendConn, contended, selectErr := sm.selectBackend(id, string(authInfo.Username))
+		if selectErr != nil {
+			logger.Info("Failed to route request", "SessionId", id, "Error", selectErr)
+			err = selectErr
 			return oldValue, false
 		}
-		backendConn, _ := pool.GetConnByKey([]byte(id))
-		txState := backendConn.LoadTxnState()
-		if txState != nil && txState.OwnerSession.Id != id {
-			if !common.IsProdRuntime() {
-				logger.Info("Current backend cluster has been occupied by another session", "SessionId", id,
-					"OtherId", backendConn.LoadTxnState().OwnerSession.Id)
-			}
-			noTxConn, getTxConnErr := pool.GetNoTxConn()
-			if getTxConnErr != nil {
-				logger.Info("Failed to route request", "SessionId", id, "Error", getTxConnErr)
-				err = getTxConnErr
-				return oldValue, false
-			}
-			backendConn = noTxConn
-		}
+		txContention = contended
+		rerouted = contended || loaded && oldValue.backend != nil && oldValue.backend != backendConn
 		return &SessionPair{
 			session: oldValue.session,
 			backend: backendConn,
 		}, false
 	})
+	if rerouted && sm.rerouteListener != nil {
+		sm.rerouteListener(txContention)
+	}
 	return sessionPair, err
 }
 
-func (sm *SessionManager) Forward(id string, packet *respio.RespPacket, authInfo *common.AuthInfo) error {
-	sessionPair, _ := sm.sessions.Load(id)
+// selectBackend picks the connection of a session of the tenant: the pool of the instance
+// selected for the tenant, then the connection the session id hashes to. A connection held by
+// the transaction of another session is replaced by one out of a transaction, the returned
+// bool reports it.
+func (sm *SessionManager) selectBackend(id string, username string) (*BackendConn, bool, error) {
+	pool, err := sm.beMgr.GetBackendFixedPool(username)
+	if err != nil {
+		return nil, false, err
+	}
+	return sessionConn(pool, id)
+}
+
+// sessionConn returns the connection of the pool the session id hashes to, or one out of a
+// transaction when it is held by the transaction of another session.
+func sessionConn(pool *FixedPool, id string) (*BackendConn, bool, error) {
+	backendConn, err := pool.GetConnByKey([]byte(id))
+	if err != nil {
+		return nil, false, err
+	}
+	txState := backendConn.LoadTxnState()
+	if txState != nil && txState.OwnerSession.Id != id {
+		if !common.IsProdRuntime() {
+			logger.Info("Current backend cluster has been occupied by another session", "SessionId", id,
+				"OtherId", txState.OwnerSession.Id)
+		}
+		noTxConn, err := pool.GetNoTxConn()
+		return noTxConn, true, err
+	}
+	return backendConn, false, nil
+}
+
+// RouteDecision is the backend a session would be routed to.
+type RouteDecision struct {
+	Addr   string `json:"addr"`
+	ConnId string `json:"conn_id"`
+}
+
+// ExplainRoute runs the routing of a session of the tenant whose id is key, without binding
+// or forwarding anything. A session is routed again when its connection is retired, so an
+// existing session may still be bound to another connection.
+func (sm *SessionManager) ExplainRoute(tenant string, key string) (*RouteDecision, error) {
+	backendConn, _, err := sm.selectBackend(key, tenant)
+	if err != nil {
+		return nil, err
+	}
+	return &RouteDecision{Addr: backendConn.instanceId, ConnId: backendConn.Id}, nil
+}
+
+func (sm *SessionManager) Forward(id string, reqId uint64, packet *respio.RespPacket, authInfo *common.AuthInfo) error {
+	sessionPair, ok := sm.sessions.Load(id)
+	if !ok {
+		// closed concurrently, nobody waits for the reply
+		logger.Info("Dropping the request of a closed session", "SessionId", id, "RequestId", reqId)
+		recordDrop(DropSessionClosed)
+		return nil
+	}
 	backendConn := sessionPair.backend
 	needsRoute := false
 	if backendConn == nil {
@@ -79,38 +233,348 @@ func (sm *SessionManager) Forward(id string, packet *respio.RespPacket, authInfo
 		txState := backendConn.LoadTxnState()
 		if txState != nil && txState.OwnerSession != nil && txState.OwnerSession.Id != id {
 			needsRoute = true
+		} else if (sessionPair.reroute.Load() || backendConn.IsRetired() || backendConn.InstanceUnavailable()) &&
+			!sessionPair.inOwnTxn() {
+			// a transaction in progress finishes on its backend first
+			needsRoute = true
 		}
 	}
+	if sessionPair.exclusiveDone() {
+		needsRoute = true
+	}
 	if needsRoute {
 		newPair, err := sm.RouteRequest(id, authInfo)
 		if err != nil {
 			return err
 		}
+		if sessionPair.exclusive && newPair.backend != backendConn {
+			go backendConn.Close()
+		}
 		sessionPair = newPair
 		backendConn = sessionPair.backend
 	}
 
 	// Update transaction state if needed
-	if _, state, ok := packet.IsTxCmd(); ok {
+	_, txCmdState, isTxCmd := packet.IsTxCmd()
+	if isTxCmd {
 		currSession := sessionPair.session
-		sessionPair.backend.UpdateTxnState(currSession, state)
+		sessionPair.backend.UpdateTxnState(currSession, txCmdState)
 	}
 
 	reqCtx := RequestContext{
-		Session:  sessionPair.session,
-		Request:  packet,
-		AuthInfo: authInfo,
+		RequestId: reqId,
+		Session:   sessionPair.session,
+		Request:   packet,
+		AuthInfo:  authInfo,
+		NoReply:   sessionPair.session.suppressReply(),
+	}
+	if packet.IsAuthCmd() {
+		reqCtx.authCache = sm.authCache
+		if sm.authListener != nil {
+			reqCtx.onAuth = func(success bool) {
+				sm.NotifyAuth(authInfo.Username, success)
+			}
+		}
+	}
+	reqLogger.V(1).Info("Forward request", "RequestId", reqId, "SessionId", id,
+		"BackendConn", backendConn.Id)
+	if sm.hotKeys != nil {
+		sm.hotKeys.Sample(backendConn.instanceId, packet)
+	}
+	if sm.scripts != nil {
+		sm.ensureScript(sessionPair, reqId, packet, authInfo)
+	}
+	// a transaction runs on the connection of the session, WATCH included
+	if sm.pins != nil && !sessionPair.exclusive && !sessionPair.inOwnTxn() && !isTxCmd {
+		if addr, ok := sm.pins.Match(packet); ok && addr != backendConn.instanceId {
+			return sm.forwardPinned(sessionPair, addr, &reqCtx)
+		}
 	}
 	sessionPair.backend.Enqueue(&reqCtx)
 	return nil
 }
 
+// forwardPinned sends the command to the pinned instance, the session stays bound to its
+// connection. A PING takes the place of the command on that connection, the reply loop of the
+// session writes the reply of the pinned instance when the PING is answered, so that the
+// replies keep the order of the commands.
+func (sm *SessionManager) forwardPinned(pair *SessionPair, addr string, reqCtx *RequestContext) error {
+	pool, ok := sm.beMgr.tenantInstancePool(string(reqCtx.AuthInfo.Username), addr)
+	if !ok {
+		// the instance is not in the cluster of the tenant, or offline
+		return ErrPinnedBackendUnavailable
+	}
+	pinnedConn, _, err := sessionConn(pool, pair.session.Id)
+	if err != nil {
+		return err
+	}
+	reqLogger.V(1).Info("Forward pinned request", "RequestId", reqCtx.RequestId,
+		"SessionId", pair.session.Id, "BackendConn", pinnedConn.Id)
+	if reqCtx.AuthInfo.Password != nil {
+		// the connection may have been authenticated by another tenant last
+		pinnedConn.Enqueue(&RequestContext{
+			RequestId: reqCtx.RequestId,
+			Session:   pair.session,
+			Request:   respio.NewAuthPacket(reqCtx.AuthInfo.Username, reqCtx.AuthInfo.Password),
+			AuthInfo:  reqCtx.AuthInfo,
+			NoReply:   true,
+		})
+	}
+	if reqCtx.NoReply {
+		pinnedConn.Enqueue(reqCtx)
+		return nil
+	}
+	awaited := &Session{Id: pair.session.Id, OutQ: make(chan *ResponseContext, 1), quit: pair.session.quit}
+	pinnedReq := *reqCtx
+	pinnedReq.Session = awaited
+	pinnedConn.Enqueue(&pinnedReq)
+	pair.backend.Enqueue(&RequestContext{
+		RequestId:  reqCtx.RequestId,
+		Session:    pair.session,
+		Request:    respio.NewCommand("PING"),
+		AuthInfo:   reqCtx.AuthInfo,
+		awaitReply: awaited.OutQ,
+	})
+	return nil
+}
+
+// ensureScript records the scripts run by the tenant, and loads the script of an EVALSHA on the
+// backend connection first when the connection never ran it. Without the body, e.g. the script
+// was loaded before the proxy started, the backend answers NOSCRIPT as usual.
+func (sm *SessionManager) ensureScript(pair *SessionPair, reqId uint64, packet *respio.RespPacket, authInfo *common.AuthInfo) {
+	tenant := string(authInfo.Username)
+	cmdType, sha := classifyScriptCmd(packet)
+	if cmdType != scriptCmdEvalSha {
+		sm.scripts.observe(tenant, packet)
+		return
+	}
+	if pair.backend.hasScript(sha) {
+		return
+	}
+	// a SCRIPT LOAD queued by MULTI would add a reply to the EXEC
+	if txState := pair.backend.LoadTxnState(); txState != nil && txState.State == respio.TxCmdStateBegin {
+		return
+	}
+	body, ok := sm.scripts.Body(tenant, sha)
+	if !ok {
+		return
+	}
+	reqLogger.V(1).Info("Load script before EVALSHA", "RequestId", reqId, "SessionId", pair.session.Id,
+		"BackendConn", pair.backend.Id, "Sha", string(sha))
+	pair.backend.Enqueue(&RequestContext{
+		RequestId: reqId,
+		Session:   pair.session,
+		Request:   respio.NewCommand("SCRIPT", "LOAD", string(body)),
+		AuthInfo:  authInfo,
+		NoReply:   true,
+	})
+}
+
+// ForwardExclusive forwards the request on a backend connection dialed for the session only,
+// for commands holding the connection for long like DEBUG SLEEP. The following commands of the
+// session use the same connection until it has answered all of them.
+func (sm *SessionManager) ForwardExclusive(id string, reqId uint64, packet *respio.RespPacket, authInfo *common.AuthInfo) error {
+	sessionPair, ok := sm.sessions.Load(id)
+	if !ok {
+		recordDrop(DropSessionClosed)
+		return ErrSessionClosed
+	}
+	if sessionPair.exclusive || sessionPair.inOwnTxn() {
+		// a transaction keeps its connection, the command is queued or watched there
+		return sm.Forward(id, reqId, packet, authInfo)
+	}
+	pool, err := sm.beMgr.GetBackendFixedPool(string(authInfo.Username))
+	if err != nil {
+		return err
+	}
+	conn, err := pool.DialExclusive()
+	if err != nil {
+		return err
+	}
+	session := sessionPair.session
+	if authInfo.Password != nil {
+		// the new connection has to authenticate like the session did
+		conn.Enqueue(&RequestContext{
+			RequestId: reqId,
+			Session:   session,
+			Request:   respio.NewAuthPacket(authInfo.Username, authInfo.Password),
+			AuthInfo:  authInfo,
+			NoReply:   true,
+		})
+	}
+	// enqueued before the pair is published, Forward would find the new connection idle
+	conn.Enqueue(&RequestContext{
+		RequestId: reqId,
+		Session:   session,
+		Request:   packet,
+		AuthInfo:  authInfo,
+		NoReply:   session.suppressReply(),
+	})
+	sm.sessions.Store(id, &SessionPair{session: session, backend: conn, exclusive: true})
+	logger.Info("Session moved to an exclusive backend connection", "SessionId", id,
+		"RequestId", reqId, "Addr", conn.instanceId, "BackendConn", conn.Id)
+	return nil
+}
+
+// ForwardHello forwards a HELLO. The shared backend connections stay on RESP2, a session
+// switching to RESP3 moves to a connection of its own, see ForwardExclusive. A transaction on a
+// shared connection cannot move, HELLO 3 is refused there.
+func (sm *SessionManager) ForwardHello(id string, reqId uint64, packet *respio.RespPacket, authInfo *common.AuthInfo) error {
+	if protoVer, ok := packet.HelloProtoVer(); !ok || protoVer < respio.Resp3 {
+		return sm.Forward(id, reqId, packet, authInfo)
+	}
+	sessionPair, ok := sm.sessions.Load(id)
+	if !ok {
+		recordDrop(DropSessionClosed)
+		return ErrSessionClosed
+	}
+	if !sessionPair.exclusive && sessionPair.inOwnTxn() {
+		return ErrHelloInTransaction
+	}
+	return sm.ForwardExclusive(id, reqId, packet, authInfo)
+}
+
+// InTransaction reports whether the session is pinned to its backend connection, by a
+// transaction or an exclusive connection. Its commands must not leave that connection.
+func (sm *SessionManager) InTransaction(id string) bool {
+	sessionPair, ok := sm.sessions.Load(id)
+	return ok && (sessionPair.exclusive || sessionPair.inOwnTxn())
+}
+
+// Broadcast runs the command on every connection of every backend instance of the tenant, so
+// that e.g. a script loaded by one session is known whichever connection serves the next one.
+// It blocks until all the connections answered, the first error reply wins.
+func (sm *SessionManager) Broadcast(id string, reqId uint64, packet *respio.RespPacket, authInfo *common.AuthInfo) (*respio.RespPacket, error) {
+	wait, err := sm.sendBroadcast(id, reqId, packet, authInfo)
+	if err != nil {
+		return nil, err
+	}
+	return wait()
+}
+
+// sendBroadcast enqueues the command on every connection of the tenant, the returned function
+// waits for the replies. The commands the session dispatches next are queued after it on every
+// connection.
+func (sm *SessionManager) sendBroadcast(id string, reqId uint64, packet *respio.RespPacket,
+	authInfo *common.AuthInfo) (func() (*respio.RespPacket, error), error) {
+	pools, err := sm.beMgr.tenantPools(string(authInfo.Username))
+	if err != nil {
+		return nil, err
+	}
+	if sm.scripts != nil {
+		sm.scripts.observe(string(authInfo.Username), packet)
+	}
+	reqLogger.V(1).Info("Broadcast request", "RequestId", reqId, "SessionId", id, "Pools", len(pools))
+	var waits []func() (*respio.RespPacket, error)
+	for _, pool := range pools {
+		wait, err := pool.sendBroadcast(reqId, packet)
+		if err != nil {
+			// the replies of the pools sent to already are left to their collectors
+			return nil, err
+		}
+		waits = append(waits, wait)
+	}
+	return func() (*respio.RespPacket, error) {
+		var reply *respio.RespPacket
+		for _, wait := range waits {
+			poolReply, err := wait()
+			if err != nil {
+				respio.ReleaseRespPacket(reply)
+				return nil, err
+			}
+			reply = mergeBroadcastReply(reply, poolReply)
+		}
+		return reply, nil
+	}, nil
+}
+
+// BroadcastAsync is Broadcast without waiting: the command is enqueued on every connection, and
+// the replies are awaited on another goroutine, the caller, e.g. the event loop of the client,
+// goes on. The merged reply, or the error, keeps the place of the command among the replies of
+// the session.
+func (sm *SessionManager) BroadcastAsync(session *Session, reqId uint64, packet *respio.RespPacket, authInfo *common.AuthInfo) {
+	rspCtx := &ResponseContext{RequestId: reqId}
+	noReply := session.suppressReply()
+	if !noReply {
+		rspCtx.seq = session.expectReply()
+		rspCtx.ordered = true
+	}
+	reply := func(packet *respio.RespPacket, err error) {
+		if err != nil {
+			logger.Info("Failed to broadcast request", "RequestId", reqId, "SessionId", session.Id, "error", err)
+			packet = respio.NewError(err.Error())
+			rspCtx.Local = true
+		}
+		if noReply {
+			respio.ReleaseRespPacket(packet)
+			return
+		}
+		rspCtx.Response = packet
+		session.deliver(rspCtx)
+	}
+	wait, err := sm.sendBroadcast(session.Id, reqId, packet, authInfo)
+	if err != nil {
+		reply(nil, err)
+		return
+	}
+	go func() {
+		reply(wait())
+	}()
+}
+
 func (sm *SessionManager) OpenSession(id string, client net.Conn) {
-	session := NewSession(id, client, 10240)
-	go session.ReplyLoop()
+	queueSize, bufferSize := sm.config.Session.OutQSize, sm.config.Session.BufferSize
+	if queueSize <= 0 {
+		queueSize = DefaultSessionOutQSize
+	}
+	if bufferSize <= 0 {
+		bufferSize = respio.DefaultBufferSize
+	}
+	session := NewSessionWithBufferSize(id, client, queueSize, bufferSize)
+	session.reader.SetMaxRequestSize(sm.config.MaxRequestSize)
+	if sm.config.MaxRequestArgs > 0 {
+		session.reader.SetMaxArrayLen(sm.config.MaxRequestArgs)
+	}
+	security := sm.config.Security
+	if !security.RequireAuth {
+		// the static backend serves the empty username. A non-nil password marks the auth info
+		// verified, a failed AUTH does not drop it.
+		session.SetAuthInfo(&common.AuthInfo{Username: []byte{}, Password: []byte{}})
+	} else if security.AuthTimeout > 0 {
+		time.AfterFunc(security.AuthTimeout, func() {
+			closeUnauthenticated(session)
+		})
+	}
+	go sm.runReplyLoop(session)
 	sm.sessions.Store(id, &SessionPair{session: session})
 }
 
+// closeUnauthenticated closes a client that did not authenticate in time, it would hold a session
+// without ever running a command.
+func closeUnauthenticated(session *Session) {
+	select {
+	case <-session.quit:
+		return
+	default:
+	}
+	if session.IsVerified() {
+		return
+	}
+	logger.Info("Closing client, not authenticated in time", "SessionId", session.Id)
+	session.closeBroken()
+}
+
+// runReplyLoop serves the replies of the session. A loop stopped by a broken client releases
+// the session right away, before the event loop notices the closed connection.
+func (sm *SessionManager) runReplyLoop(session *Session) {
+	session.ReplyLoop()
+	if !sm.releaseSession(session) {
+		// closed by the event loop of the client, which is done with the session. A session
+		// released here may still be read by its event loop, its buffers are left to the GC.
+		session.releaseIO()
+	}
+}
+
 func (sm *SessionManager) LoadSession(id string) *Session {
 	if pair, ok := sm.sessions.Load(id); ok {
 		return pair.session
@@ -120,15 +584,138 @@ func (sm *SessionManager) LoadSession(id string) *Session {
 
 func (sm *SessionManager) CloseSession(id string) {
 	if pair, ok := sm.sessions.LoadAndDelete(id); ok {
-		pair.session.Close()
+		closeSessionPair(pair)
+	}
+}
+
+// releaseSession removes the session unless it was closed already, and reports whether it did.
+// The id is the client address, a new connection may reuse it once the session is closed.
+func (sm *SessionManager) releaseSession(session *Session) bool {
+	var released *SessionPair
+	sm.sessions.Compute(session.Id, func(oldValue *SessionPair, loaded bool) (*SessionPair, bool) {
+		if loaded && oldValue.session == session {
+			released = oldValue
+			return oldValue, true
+		}
+		return oldValue, !loaded
+	})
+	if released != nil {
+		logger.Info("Session released after its ReplyLoop stopped", "Id", session.Id)
+		closeSessionPair(released)
+	}
+	return released != nil
+}
+
+func closeSessionPair(pair *SessionPair) {
+	pair.session.Close()
+	if pair.exclusive {
+		go pair.backend.Close()
 	}
 }
 
-func (sm *SessionManager) Clear() {
-	sm.beMgr.Close()
+// Clear closes the backend pools, see BackendManager.Close, and forgets the sessions.
+func (sm *SessionManager) Clear(ctx context.Context) {
+	sm.beMgr.Close(ctx)
 	sm.sessions.Clear()
 }
 
+// HotKeys returns the sampled hot keys per backend instance, false if sampling is disabled.
+func (sm *SessionManager) HotKeys() ([]InstanceHotKeys, bool) {
+	if sm.hotKeys == nil {
+		return nil, false
+	}
+	return sm.hotKeys.HotKeys(), true
+}
+
+// SessionCount returns the number of open client sessions.
+func (sm *SessionManager) SessionCount() int {
+	return sm.sessions.Size()
+}
+
+// PoolStatus returns the status of the backend pools.
+func (sm *SessionManager) PoolStatus() []PoolStatus {
+	return sm.beMgr.PoolStatus()
+}
+
+// RingStatus returns the consistent hash rings of the backend pools, only the one of the
+// tenant if not empty.
+func (sm *SessionManager) RingStatus(tenant string) ([]RingStatus, error) {
+	return sm.beMgr.RingStatus(tenant)
+}
+
+// ProbeBackends pings every backend instance, the result is cached briefly.
+func (sm *SessionManager) ProbeBackends() []InstanceProbe {
+	return sm.beMgr.ProbeBackends()
+}
+
+// Transactions returns the backend connections pinned by a transaction, the oldest first.
+func (sm *SessionManager) Transactions() []TxnStatus {
+	return sm.beMgr.Transactions()
+}
+
+// Saturated returns a channel closed once the backend connection of the session takes requests
+// again, nil when the session may dispatch its next command.
+func (sm *SessionManager) Saturated(id string) <-chan struct{} {
+	pair, ok := sm.sessions.Load(id)
+	if !ok || pair.backend == nil {
+		return nil
+	}
+	return pair.backend.Saturated()
+}
+
+// QueueDepths samples the backend queues per instance and the session reply queues per tenant.
+// High depths are an early warning of a slow backend or a slow client.
+func (sm *SessionManager) QueueDepths() []QueueDepth {
+	depths := sm.beMgr.queueDepths()
+	outQ := make(map[string]int)
+	sm.sessions.Range(func(_ string, pair *SessionPair) bool {
+		tenant := unknownTenant
+		if authInfo := pair.session.GetAuthInfo(); authInfo != nil {
+			tenant = string(authInfo.Username)
+		}
+		outQ[tenant] += pair.session.OutQLen()
+		return true
+	})
+	for tenant, depth := range outQ {
+		depths = append(depths, QueueDepth{Queue: QueueNameOut, Owner: tenant, Depth: depth})
+	}
+	return depths
+}
+
+// RebalanceInstance drains the backend instance and moves the sessions bound to it to other
+// instances at their next command boundary. It returns the number of affected sessions.
+func (sm *SessionManager) RebalanceInstance(addr string) int {
+	sm.beMgr.DrainInstance(addr)
+	return sm.markReroute(func(pair *SessionPair) bool {
+		return pair.backend.instanceId == addr
+	})
+}
+
+// RebalanceCluster re-routes the sessions of the cluster's tenants through the balancer again.
+func (sm *SessionManager) RebalanceCluster(key ClusterKey) int {
+	return sm.markReroute(func(pair *SessionPair) bool {
+		authInfo := pair.session.GetAuthInfo()
+		if authInfo == nil {
+			return false
+		}
+		tenantKey := sm.beMgr.GetTenantKey(string(authInfo.Username))
+		return tenantKey != nil && *tenantKey == key
+	})
+}
+
+func (sm *SessionManager) markReroute(affected func(pair *SessionPair) bool) int {
+	count := 0
+	sm.sessions.Range(func(_ string, pair *SessionPair) bool {
+		// an exclusive connection is left when idle anyway
+		if pair.backend != nil && !pair.exclusive && affected(pair) {
+			pair.reroute.Store(true)
+			count++
+		}
+		return true
+	})
+	return count
+}
+
 func (sm *SessionManager) LoadBackendMgr() *BackendManager {
 	return sm.beMgr
 }
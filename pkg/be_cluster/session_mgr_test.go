@@ -0,0 +1,674 @@
+package be_cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buraksezer/consistent"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+// listRouter always selects the first of its instances.
+type listRouter struct {
+	instances []*ClusterInstance
+}
+
+func (r *listRouter) BackendChangeNotify(_ context.Context, _ BackendNotify) {}
+
+func (r *listRouter) Selector(_ Balancer, _ *ClusterKey) (*ClusterInstance, error) {
+	return r.instances[0], nil
+}
+
+func (r *listRouter) ListBackend(_ *ClusterKey) ([]*ClusterInstance, error) {
+	return r.instances, nil
+}
+
+func newSingleConnPool(conn *BackendConn) *FixedPool {
+	pool := &FixedPool{
+		onLines: xsync.NewMapOf[string, *BackendConn](),
+		cHasher: consistent.New(nil, consistentCfg),
+	}
+	pool.onLines.Store(conn.Id, conn)
+	pool.cHasher.Add(Member{key: conn.Id})
+	conn.health.Store(&pool.health)
+	return pool
+}
+
+// newTestSessionManager returns a session manager with one authenticated session routed to a
+// fake backend answering with handler.
+func newTestSessionManager(t *testing.T, handler func(req *respio.RespPacket) *respio.RespPacket) (*SessionManager, *Session, *respio.RespReader) {
+	instance := LocalClusterInstance("127.0.0.1", 6379)
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instance}})
+	beMgr.instancePool.Store(instance.GetAddr(), newSingleConnPool(newPipeBackendConnAt(t, instance.GetAddr(), handler)))
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a")}
+	beMgr.clusterKeyMap.Store(string(authInfo.Username), &instance.Key)
+
+	session, clientReader := newPipeSession(t, t.Name())
+	session.SetAuthInfo(authInfo)
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr}
+	sm.sessions.Store(session.Id, &SessionPair{session: session})
+	return sm, session, clientReader
+}
+
+func TestSessionManager_QueueDepths(t *testing.T) {
+	beMgr := newBackendManager(newTestSyncConfig(), &SyncRouter{registry: newDefaultClusterRegistry()})
+	conn := &BackendConn{
+		Id:       "conn-1",
+		writeQ:   make(chan *RequestContext, 8),
+		pendingQ: make(chan *RequestContext, 8),
+	}
+	pool := &FixedPool{onLines: xsync.NewMapOf[string, *BackendConn]()}
+	pool.onLines.Store(conn.Id, conn)
+	beMgr.instancePool.Store("127.0.0.1:6379", pool)
+
+	session := NewSession("s1", nil, 8)
+	session.SetAuthInfo(&common.AuthInfo{Username: []byte("tenant-a")})
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr}
+	sm.sessions.Store(session.Id, &SessionPair{session: session})
+
+	// deliberately fill the queues
+	for i := 0; i < 3; i++ {
+		conn.writeQ <- &RequestContext{}
+		session.OutQ <- &ResponseContext{}
+	}
+	conn.pendingQ <- &RequestContext{}
+
+	assert.ElementsMatch(t, []QueueDepth{
+		{Queue: QueueNameWrite, Owner: "127.0.0.1:6379", Depth: 3},
+		{Queue: QueueNamePending, Owner: "127.0.0.1:6379", Depth: 1},
+		{Queue: QueueNameInFlight, Owner: "127.0.0.1:6379", Depth: 0},
+		{Queue: QueueNameOut, Owner: "tenant-a", Depth: 3},
+	}, sm.QueueDepths())
+}
+
+func TestSessionManager_RebalanceInstance(t *testing.T) {
+	instanceA, instanceB := LocalClusterInstance("127.0.0.1", 6379), LocalClusterInstance("127.0.0.1", 6380)
+	addrA, addrB := instanceA.GetAddr(), instanceB.GetAddr()
+	replyWith := func(name string) func(req *respio.RespPacket) *respio.RespPacket {
+		return func(req *respio.RespPacket) *respio.RespPacket {
+			return &respio.RespPacket{Type: respio.RespString, Data: []byte(name)}
+		}
+	}
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instanceA, instanceB}})
+	beMgr.instancePool.Store(addrA, newSingleConnPool(newPipeBackendConnAt(t, addrA, replyWith("a"))))
+	beMgr.instancePool.Store(addrB, newSingleConnPool(newPipeBackendConnAt(t, addrB, replyWith("b"))))
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a")}
+	beMgr.clusterKeyMap.Store(string(authInfo.Username), &instanceA.Key)
+
+	session, clientReader := newPipeSession(t, "rebalance")
+	session.SetAuthInfo(authInfo)
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr}
+	sm.sessions.Store(session.Id, &SessionPair{session: session})
+
+	get := func() string {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", "k"), authInfo))
+		reply, err := clientReader.Read()
+		assert.NoError(t, err)
+		return string(reply.Data)
+	}
+	assert.Equal(t, "a", get())
+	assert.Equal(t, 1, sm.RebalanceInstance(addrA))
+	assert.Equal(t, "b", get())
+	assert.Equal(t, "b", get())
+}
+
+func TestBackendManager_DrainInstance(t *testing.T) {
+	instanceA, instanceB := LocalClusterInstance("127.0.0.1", 6379), LocalClusterInstance("127.0.0.1", 6380)
+	addrA, addrB := instanceA.GetAddr(), instanceB.GetAddr()
+	backend := func(name string) func(req *respio.RespPacket) *respio.RespPacket {
+		return func(req *respio.RespPacket) *respio.RespPacket {
+			switch string(req.GetCommand()) {
+			case "GET":
+				return &respio.RespPacket{Type: respio.RespString, Data: []byte(name)}
+			case "SET":
+				return respio.NewStatus("QUEUED")
+			case "EXEC":
+				return &respio.RespPacket{Type: respio.RespArray,
+					Array: []*respio.RespPacket{respio.NewStatus(string(respio.OkCmd))}}
+			}
+			return respio.NewStatus(string(respio.OkCmd))
+		}
+	}
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instanceA, instanceB}})
+	connA := newPipeBackendConnAt(t, addrA, backend("a"))
+	poolA := newSingleConnPool(connA)
+	poolA.innerPool = &BackendPool{cfg: &PoolConfig{Addr: addrA}, conns: []*BackendConn{connA}}
+	beMgr.instancePool.Store(addrA, poolA)
+	beMgr.instancePool.Store(addrB, newSingleConnPool(newPipeBackendConnAt(t, addrB, backend("b"))))
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a")}
+	beMgr.clusterKeyMap.Store(string(authInfo.Username), &instanceA.Key)
+
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr}
+	newSession := func(id string) func(args ...string) *respio.RespPacket {
+		session, clientReader := newPipeSession(t, id)
+		session.SetAuthInfo(authInfo)
+		sm.sessions.Store(session.Id, &SessionPair{session: session})
+		return func(args ...string) *respio.RespPacket {
+			assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand(args...), authInfo))
+			reply, err := clientReader.Read()
+			assert.NoError(t, err)
+			return reply
+		}
+	}
+	inTxn := newSession("drain-txn")
+	assert.Equal(t, "a", string(inTxn("GET", "k").Data))
+	inTxn("MULTI")
+
+	beMgr.backendDraining(instanceA)
+	assert.True(t, beMgr.IsDraining(addrA))
+	// new sessions avoid the draining instance
+	assert.Equal(t, "b", string(newSession("drain-new")("GET", "k").Data))
+
+	// the transaction in progress finishes on it, the pool is kept open meanwhile
+	assert.Equal(t, "QUEUED", string(inTxn("SET", "k", "v").Data))
+	time.Sleep(3 * drainCheckInterval)
+	assert.False(t, connA.closed.Load())
+	assert.Equal(t, respio.RespArray, inTxn("EXEC").Type)
+	assert.Equal(t, "b", string(inTxn("GET", "k").Data))
+
+	assert.Eventually(t, func() bool {
+		_, ok := beMgr.instancePool.Load(addrA)
+		return !ok && connA.closed.Load()
+	}, 2*time.Second, 10*time.Millisecond)
+	// the router still selects the closed instance
+	assert.True(t, beMgr.IsDraining(addrA))
+	assert.Equal(t, "b", string(newSession("drain-closed")("GET", "k").Data))
+}
+
+func TestSessionManager_UnavailableInstance(t *testing.T) {
+	defer func(cooldown time.Duration) { unavailableCooldown = cooldown }(unavailableCooldown)
+	unavailableCooldown = 200 * time.Millisecond
+	instanceA, instanceB := LocalClusterInstance("127.0.0.1", 6379), LocalClusterInstance("127.0.0.1", 6380)
+	addrA, addrB := instanceA.GetAddr(), instanceB.GetAddr()
+	var loading atomic.Bool
+	loading.Store(true)
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instanceA, instanceB}})
+	beMgr.instancePool.Store(addrA, newSingleConnPool(newPipeBackendConnAt(t, addrA, func(req *respio.RespPacket) *respio.RespPacket {
+		if loading.Load() {
+			return respio.NewError("LOADING Redis is loading the dataset in memory")
+		}
+		return &respio.RespPacket{Type: respio.RespString, Data: []byte("a")}
+	})))
+	beMgr.instancePool.Store(addrB, newSingleConnPool(newPipeBackendConnAt(t, addrB, func(req *respio.RespPacket) *respio.RespPacket {
+		return &respio.RespPacket{Type: respio.RespString, Data: []byte("b")}
+	})))
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a")}
+	beMgr.clusterKeyMap.Store(string(authInfo.Username), &instanceA.Key)
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr}
+	session, clientReader := newPipeSession(t, t.Name())
+	session.SetAuthInfo(authInfo)
+	sm.sessions.Store(session.Id, &SessionPair{session: session})
+	get := func() *respio.RespPacket {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", "k"), authInfo))
+		reply, err := clientReader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+
+	// the error is forwarded, the next command moves to the other instance
+	reply := get()
+	assert.Equal(t, respio.RespError, reply.Type)
+	assert.True(t, strings.HasPrefix(string(reply.Data), "LOADING"))
+	pool, _ := beMgr.instancePool.Load(addrA)
+	assert.True(t, pool.IsUnavailable())
+	assert.Equal(t, "b", string(get().Data))
+	assert.Equal(t, "b", string(get().Data))
+	statuses := beMgr.PoolStatus()
+	for _, status := range statuses {
+		assert.Equal(t, status.Addr == addrA, status.Unavailable, status.Addr)
+	}
+
+	// once the cooldown elapsed the instance is routed to again
+	loading.Store(false)
+	time.Sleep(unavailableCooldown)
+	assert.False(t, pool.IsUnavailable())
+	pooled, err := beMgr.GetBackendFixedPool(string(authInfo.Username))
+	assert.NoError(t, err)
+	assert.Same(t, pool, pooled)
+
+	// BUSYKEY of RESTORE does not mark the instance
+	assert.True(t, isUnavailableReply(respio.NewError("BUSY Redis is busy running a script")))
+	assert.False(t, isUnavailableReply(respio.NewError("BUSYKEY Target key name already exists.")))
+}
+
+func TestSessionManager_ForwardPoolExhausted(t *testing.T) {
+	sm, session, clientReader := newTestSessionManager(t, func(req *respio.RespPacket) *respio.RespPacket {
+		return respio.NewStatus(string(respio.OkCmd))
+	})
+	assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("MULTI"), session.GetAuthInfo()))
+	_, err := clientReader.Read()
+	assert.NoError(t, err)
+
+	// the only connection is held by the transaction of another session
+	other, _ := newPipeSession(t, "pool-exhausted")
+	other.SetAuthInfo(session.GetAuthInfo())
+	sm.sessions.Store(other.Id, &SessionPair{session: other})
+	err = sm.Forward(other.Id, NextRequestId(), respio.NewCommand("GET", "k"), other.GetAuthInfo())
+	assert.ErrorIs(t, err, ErrPoolExhausted)
+	assert.Equal(t, "pool_exhausted", ForwardErrorType(err))
+}
+
+func TestSessionManager_ExplainRoute(t *testing.T) {
+	instance := LocalClusterInstance("127.0.0.1", 6379)
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instance}})
+	pool := newSingleConnPool(newPipeBackendConnAt(t, instance.GetAddr(), echoKey))
+	second := newPipeBackendConnAt(t, instance.GetAddr(), echoKey)
+	pool.onLines.Store(second.Id, second)
+	pool.cHasher.Add(Member{key: second.Id})
+	beMgr.instancePool.Store(instance.GetAddr(), pool)
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a")}
+	beMgr.clusterKeyMap.Store(string(authInfo.Username), &instance.Key)
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr}
+
+	for i := 0; i < 10; i++ {
+		session, _ := newPipeSession(t, fmt.Sprintf("10.0.0.1:%d", 5000+i))
+		session.SetAuthInfo(authInfo)
+		sm.sessions.Store(session.Id, &SessionPair{session: session})
+		decision, err := sm.ExplainRoute(string(authInfo.Username), session.Id)
+		assert.NoError(t, err)
+		// nothing is bound by the explanation
+		pair, _ := sm.sessions.Load(session.Id)
+		assert.Nil(t, pair.backend)
+
+		pair, err = sm.RouteRequest(session.Id, authInfo)
+		assert.NoError(t, err)
+		assert.Equal(t, &RouteDecision{Addr: instance.GetAddr(), ConnId: pair.backend.Id}, decision)
+	}
+
+	_, err := sm.ExplainRoute("unknown", "10.0.0.1:5000")
+	assert.Error(t, err)
+}
+
+func TestSessionManager_RerouteOnTxContention(t *testing.T) {
+	instance := LocalClusterInstance("127.0.0.1", 6379)
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instance}})
+	pool := newSingleConnPool(newPipeBackendConnAt(t, instance.GetAddr(), echoKey))
+	second := newPipeBackendConnAt(t, instance.GetAddr(), echoKey)
+	pool.onLines.Store(second.Id, second)
+	pool.cHasher.Add(Member{key: second.Id})
+	beMgr.instancePool.Store(instance.GetAddr(), pool)
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a")}
+	beMgr.clusterKeyMap.Store(string(authInfo.Username), &instance.Key)
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr}
+	var reroutes []bool
+	sm.SetRerouteListener(func(txContention bool) {
+		reroutes = append(reroutes, txContention)
+	})
+
+	session, _ := newPipeSession(t, "contended")
+	session.SetAuthInfo(authInfo)
+	sm.sessions.Store(session.Id, &SessionPair{session: session})
+	pair, err := sm.RouteRequest(session.Id, authInfo)
+	assert.NoError(t, err)
+	bound := pair.backend
+	// binding a new session, or keeping its connection, is no re-route
+	_, err = sm.RouteRequest(session.Id, authInfo)
+	assert.NoError(t, err)
+	assert.Empty(t, reroutes)
+
+	other, _ := newPipeSession(t, "other")
+	bound.UpdateTxnState(other, respio.TxCmdStateBegin)
+	pair, err = sm.RouteRequest(session.Id, authInfo)
+	assert.NoError(t, err)
+	assert.NotSame(t, bound, pair.backend)
+	assert.Equal(t, []bool{true}, reroutes)
+}
+
+func TestSessionManager_ForwardPinned(t *testing.T) {
+	instanceA, instanceB := LocalClusterInstance("127.0.0.1", 6379), LocalClusterInstance("127.0.0.1", 6380)
+	addrA, addrB := instanceA.GetAddr(), instanceB.GetAddr()
+	beMgr := newBackendManager(newTestSyncConfig(), &listRouter{instances: []*ClusterInstance{instanceA, instanceB}})
+	// the instance of the tenant answers slower, the replies must keep the order of the commands
+	beMgr.instancePool.Store(addrA, newSingleConnPool(newPipeBackendConnAt(t, addrA, func(req *respio.RespPacket) *respio.RespPacket {
+		time.Sleep(20 * time.Millisecond)
+		return &respio.RespPacket{Type: respio.RespString, Data: []byte("a:" + string(req.Array[len(req.Array)-1].Data))}
+	})))
+	beMgr.instancePool.Store(addrB, newSingleConnPool(newPipeBackendConnAt(t, addrB, func(req *respio.RespPacket) *respio.RespPacket {
+		return &respio.RespPacket{Type: respio.RespString, Data: []byte("b:" + string(req.Array[len(req.Array)-1].Data))}
+	})))
+	authInfo := &common.AuthInfo{Username: []byte("tenant-a")}
+	beMgr.clusterKeyMap.Store(string(authInfo.Username), &instanceA.Key)
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr,
+		pins: NewKeyPins([]string{"lock:*=" + addrB, "offline:*=127.0.0.1:6390"})}
+	session, clientReader := newPipeSession(t, t.Name())
+	session.SetAuthInfo(authInfo)
+	sm.sessions.Store(session.Id, &SessionPair{session: session})
+
+	for _, key := range []string{"k", "lock:1", "lock:2", "k2"} {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", key), authInfo))
+	}
+	for _, expected := range []string{"a:k", "b:lock:1", "b:lock:2", "a:k2"} {
+		reply, err := clientReader.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(reply.Data))
+	}
+	// the session stays on the instance of the tenant
+	pair, _ := sm.sessions.Load(session.Id)
+	assert.Equal(t, addrA, pair.backend.instanceId)
+
+	// a pinned instance out of the cluster of the tenant is not routed to
+	err := sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", "offline:1"), authInfo)
+	assert.ErrorIs(t, err, ErrPinnedBackendUnavailable)
+}
+
+func TestSessionManager_WatchTxnState(t *testing.T) {
+	sm, session, clientReader := newTestSessionManager(t, func(req *respio.RespPacket) *respio.RespPacket {
+		return respio.NewStatus(string(respio.OkCmd))
+	})
+	send := func(args ...string) *TxState {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand(args...), session.GetAuthInfo()))
+		_, err := clientReader.Read()
+		assert.NoError(t, err)
+		pair, _ := sm.sessions.Load(session.Id)
+		return pair.backend.LoadTxnState()
+	}
+	released := func() bool {
+		pair, _ := sm.sessions.Load(session.Id)
+		return pair.backend.LoadTxnState() == nil
+	}
+
+	// WATCH -> UNWATCH
+	assert.Equal(t, respio.TxCmdStateWatch, send("WATCH", "k").State)
+	assert.Equal(t, respio.TxCmdStateWatch, send("GET", "k").State)
+	send("UNWATCH")
+	assert.Eventually(t, released, time.Second, 10*time.Millisecond)
+
+	// WATCH -> MULTI -> EXEC, UNWATCH is only queued inside MULTI
+	assert.Equal(t, respio.TxCmdStateWatch, send("WATCH", "k").State)
+	assert.Equal(t, respio.TxCmdStateBegin, send("MULTI").State)
+	assert.Equal(t, respio.TxCmdStateBegin, send("UNWATCH").State)
+	assert.Equal(t, respio.TxCmdStateBegin, send("SET", "k", "v").State)
+	send("EXEC")
+	assert.Eventually(t, released, time.Second, 10*time.Millisecond)
+}
+
+func TestSessionManager_Transactions(t *testing.T) {
+	sm, session, clientReader := newTestSessionManager(t, func(req *respio.RespPacket) *respio.RespPacket {
+		return respio.NewStatus(string(respio.OkCmd))
+	})
+	send := func(args ...string) {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand(args...), session.GetAuthInfo()))
+		_, err := clientReader.Read()
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, sm.Transactions())
+
+	send("WATCH", "k")
+	watched := sm.Transactions()
+	assert.Len(t, watched, 1)
+	assert.Nil(t, watched[0].StartedAt)
+	time.Sleep(10 * time.Millisecond)
+	send("MULTI")
+	txns := sm.Transactions()
+	assert.Len(t, txns, 1)
+	pair, _ := sm.sessions.Load(session.Id)
+	assert.Equal(t, pair.backend.instanceId, txns[0].Addr)
+	assert.Equal(t, pair.backend.Id, txns[0].ConnId)
+	assert.Equal(t, session.Id, txns[0].SessionId)
+	assert.Equal(t, respio.TxCmdStateBegin, txns[0].State)
+	// the transaction is open since the WATCH
+	assert.Equal(t, watched[0].Since, txns[0].Since)
+	assert.NotNil(t, txns[0].StartedAt)
+	assert.GreaterOrEqual(t, txns[0].OpenMs, int64(10))
+
+	send("EXEC")
+	assert.Eventually(t, func() bool { return len(sm.Transactions()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestSessionManager_OpenSessionSizes(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     common.SessionConfig
+		outQSize   int
+		bufferSize int
+	}{
+		{name: "configured", config: common.SessionConfig{OutQSize: 64, BufferSize: 4096},
+			outQSize: 64, bufferSize: 4096},
+		{name: "default", outQSize: DefaultSessionOutQSize, bufferSize: respio.DefaultBufferSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &SessionManager{
+				sessions: xsync.NewMapOf[string, *SessionPair](),
+				config:   &common.ProxyConfig{Session: tt.config},
+			}
+			clientSide, proxySide := net.Pipe()
+			defer clientSide.Close()
+			sm.OpenSession(tt.name, proxySide)
+			defer sm.CloseSession(tt.name)
+			session := sm.LoadSession(tt.name)
+			assert.Equal(t, tt.outQSize, cap(session.OutQ))
+			assert.Equal(t, tt.bufferSize, session.reader.Size())
+			assert.Equal(t, tt.bufferSize, session.writer.Size())
+		})
+	}
+	// the two-argument constructor keeps the defaults
+	session := NewDefaultSession("default", nil)
+	assert.Equal(t, DefaultSessionOutQSize, cap(session.OutQ))
+	assert.Equal(t, respio.DefaultBufferSize, session.reader.Size())
+}
+
+func TestSessionManager_ForwardExclusive(t *testing.T) {
+	const sleep = 300 * time.Millisecond
+	handler := func(req *respio.RespPacket) *respio.RespPacket {
+		if subCmd, ok := req.DebugSubCommand(); ok && subCmd == "sleep" {
+			time.Sleep(sleep)
+			return respio.NewStatus(string(respio.OkCmd))
+		}
+		return echoKey(req)
+	}
+	sm, sessionA, readerA := newTestSessionManager(t, handler)
+	pool, _ := sm.beMgr.instancePool.Load("127.0.0.1:6379")
+	var exclusive *BackendConn
+	pool.fixedCfg = &PoolConfig{Dialer: func(ctx context.Context) (*BackendConn, error) {
+		exclusive = newPipeBackendConnAt(t, "127.0.0.1:6379", handler)
+		return exclusive, nil
+	}}
+	sessionB, readerB := newPipeSession(t, "session-b")
+	sessionB.SetAuthInfo(sessionA.GetAuthInfo())
+	sm.sessions.Store(sessionB.Id, &SessionPair{session: sessionB})
+	forward := func(session *Session, args ...string) {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand(args...), session.GetAuthInfo()))
+	}
+
+	start := time.Now()
+	assert.NoError(t, sm.ForwardExclusive(sessionA.Id, NextRequestId(),
+		respio.NewCommand("DEBUG", "SLEEP", "0.3"), sessionA.GetAuthInfo()))
+	// queued behind the DEBUG SLEEP on the exclusive connection
+	forward(sessionA, "GET", "a1")
+	forward(sessionB, "GET", "b1")
+
+	reply, err := readerB.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "b1", string(reply.Data))
+	assert.Less(t, time.Since(start), sleep)
+
+	reply, err = readerA.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.OkCmd, reply.Data)
+	reply, err = readerA.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "a1", string(reply.Data))
+	assert.GreaterOrEqual(t, time.Since(start), sleep)
+
+	// once answered, the session goes back to the shared connections
+	forward(sessionA, "GET", "a2")
+	reply, err = readerA.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "a2", string(reply.Data))
+	pair, _ := sm.sessions.Load(sessionA.Id)
+	assert.False(t, pair.exclusive)
+	assert.Eventually(t, exclusive.closed.Load, time.Second, 10*time.Millisecond)
+
+	// a session released meanwhile gets an error, not a panic
+	sm.sessions.Delete(sessionB.Id)
+	assert.ErrorIs(t, sm.ForwardExclusive(sessionB.Id, NextRequestId(),
+		respio.NewCommand("DEBUG", "SLEEP", "0"), sessionB.GetAuthInfo()), ErrSessionClosed)
+}
+
+func TestSessionManager_ForwardHello(t *testing.T) {
+	handler := func(req *respio.RespPacket) *respio.RespPacket {
+		if protoVer, ok := req.HelloProtoVer(); ok {
+			return &respio.RespPacket{Type: respio.RespMap, Array: []*respio.RespPacket{
+				respio.NewBulkString([]byte("proto")), respio.NewInteger(int64(protoVer))}}
+		}
+		if len(req.Array) == 1 {
+			return respio.NewStatus(string(respio.OkCmd))
+		}
+		return echoKey(req)
+	}
+	sm, sessionA, readerA := newTestSessionManager(t, handler)
+	pool, _ := sm.beMgr.instancePool.Load("127.0.0.1:6379")
+	var exclusive *BackendConn
+	pool.fixedCfg = &PoolConfig{Dialer: func(ctx context.Context) (*BackendConn, error) {
+		exclusive = newPipeBackendConnAt(t, "127.0.0.1:6379", handler)
+		return exclusive, nil
+	}}
+	sessionB, readerB := newPipeSession(t, "session-b")
+	sessionB.SetAuthInfo(sessionA.GetAuthInfo())
+	sm.sessions.Store(sessionB.Id, &SessionPair{session: sessionB})
+	forward := func(session *Session, args ...string) {
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand(args...), session.GetAuthInfo()))
+	}
+	read := func(reader *respio.RespReader) *respio.RespPacket {
+		reply, err := reader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+
+	// HELLO 2 changes nothing, it is forwarded like any command
+	assert.NoError(t, sm.ForwardHello(sessionB.Id, NextRequestId(), respio.NewCommand("HELLO", "2"), sessionB.GetAuthInfo()))
+	assert.Equal(t, respio.RespArray, read(readerB).Type)
+	sharedPair, _ := sm.sessions.Load(sessionB.Id)
+	assert.False(t, sharedPair.exclusive)
+
+	assert.NoError(t, sm.ForwardHello(sessionA.Id, NextRequestId(), respio.NewCommand("HELLO", "3"), sessionA.GetAuthInfo()))
+	assert.Equal(t, respio.RespMap, read(readerA).Type)
+	assert.Equal(t, respio.Resp3, sessionA.ProtoVersion())
+	forward(sessionA, "GET", "a1")
+	assert.Equal(t, "a1", string(read(readerA).Data))
+	// the RESP3 session keeps its own connection, the shared one stays on RESP2
+	pair, _ := sm.sessions.Load(sessionA.Id)
+	assert.True(t, pair.exclusive)
+	assert.Same(t, exclusive, pair.backend)
+	assert.Equal(t, respio.Resp3, exclusive.ProtoVersion())
+	forward(sessionB, "GET", "b1")
+	assert.Equal(t, "b1", string(read(readerB).Data))
+	assert.Equal(t, respio.Resp2, sharedPair.backend.ProtoVersion())
+
+	// back on RESP2, the session leaves its connection
+	assert.NoError(t, sm.ForwardHello(sessionA.Id, NextRequestId(), respio.NewCommand("HELLO", "2"), sessionA.GetAuthInfo()))
+	assert.Equal(t, respio.RespArray, read(readerA).Type)
+	forward(sessionA, "GET", "a2")
+	assert.Equal(t, "a2", string(read(readerA).Data))
+	pair, _ = sm.sessions.Load(sessionA.Id)
+	assert.False(t, pair.exclusive)
+	assert.Eventually(t, exclusive.closed.Load, time.Second, 10*time.Millisecond)
+
+	// a transaction on a shared connection cannot move
+	forward(sessionB, "MULTI")
+	assert.Equal(t, respio.OkCmd, read(readerB).Data)
+	assert.ErrorIs(t, sm.ForwardHello(sessionB.Id, NextRequestId(), respio.NewCommand("HELLO", "3"),
+		sessionB.GetAuthInfo()), ErrHelloInTransaction)
+}
+
+func TestSessionManager_ForwardMemoryUsage(t *testing.T) {
+	sm, session, reader := newTestSessionManager(t, func(req *respio.RespPacket) *respio.RespPacket {
+		return &respio.RespPacket{Type: respio.RespInt, Data: []byte("56")}
+	})
+	sm.hotKeys = NewHotKeySampler(1, 8)
+	assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("MEMORY", "USAGE", "user:1"),
+		session.GetAuthInfo()))
+	reply, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "56", string(reply.Data))
+
+	// served by the instance of the tenant, with the key accounted there
+	hotKeys, _ := sm.HotKeys()
+	assert.Len(t, hotKeys, 1)
+	assert.Equal(t, "127.0.0.1:6379", hotKeys[0].Addr)
+	assert.Equal(t, []HotKey{{Key: "user:1", Count: 1}}, hotKeys[0].HotKeys)
+}
+
+func TestSessionManager_AuthTimeout(t *testing.T) {
+	sm := &SessionManager{
+		sessions: xsync.NewMapOf[string, *SessionPair](),
+		config: &common.ProxyConfig{Security: common.SecurityConfig{
+			RequireAuth: true,
+			AuthTimeout: 50 * time.Millisecond,
+		}},
+	}
+	open := func(id string) (*Session, net.Conn) {
+		clientSide, proxySide := net.Pipe()
+		t.Cleanup(func() { _ = clientSide.Close() })
+		sm.OpenSession(id, proxySide)
+		t.Cleanup(func() { sm.CloseSession(id) })
+		return sm.LoadSession(id), clientSide
+	}
+	idle, idleClient := open("idle")
+	pending, _ := open("pending")
+	verified, _ := open("verified")
+	assert.False(t, idle.IsAuthenticated())
+	// the username of an AUTH the backend has not answered yet does not count
+	pending.SetAuthInfo(&common.AuthInfo{Username: []byte("tenant-a")})
+	verified.SetAuthInfo(&common.AuthInfo{Username: []byte("tenant-a"), Password: []byte("secret")})
+
+	// the client connection of the idle session is closed
+	_ = idleClient.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := idleClient.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Eventually(t, func() bool {
+		return sm.LoadSession("idle") == nil && sm.LoadSession("pending") == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.NotNil(t, sm.LoadSession("verified"))
+}
+
+func TestSessionManager_NoAuthRequired(t *testing.T) {
+	store := map[string][]byte{}
+	handler := func(req *respio.RespPacket) *respio.RespPacket {
+		key := string(req.Array[1].Data)
+		if strings.EqualFold(string(req.GetCommand()), "set") {
+			store[key] = req.Array[2].Data
+			return respio.NewStatus(string(respio.OkCmd))
+		}
+		return respio.NewBulkString(store[key])
+	}
+	// the static backend is brought online like backendOnline does, for its empty owner
+	instance := LocalClusterInstance("127.0.0.1", 6379)
+	config := &common.ProxyConfig{Router: common.BackendRouterConfig{RouterType: "static"}}
+	beMgr := newBackendManager(config, &StaticBackendRouter{backend: instance})
+	beMgr.instancePool.Store(instance.GetAddr(), newSingleConnPool(newPipeBackendConnAt(t, instance.GetAddr(), handler)))
+	beMgr.clusterKeyMap.Store(instance.Owner, &instance.Key)
+	sm := &SessionManager{sessions: xsync.NewMapOf[string, *SessionPair](), beMgr: beMgr, config: config}
+	config.Security = common.SecurityConfig{RequireAuth: false, AuthTimeout: 10 * time.Millisecond}
+
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	sm.OpenSession("trusted", proxySide)
+	defer sm.CloseSession("trusted")
+	session := sm.LoadSession("trusted")
+	assert.True(t, session.IsAuthenticated())
+
+	// no AUTH before the commands
+	reader := respio.NewRespReader(clientSide)
+	assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("SET", "k1", "v1"), session.GetAuthInfo()))
+	reply, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.OkCmd, reply.Data)
+	assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", "k1"), session.GetAuthInfo()))
+	reply, err = reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(reply.Data))
+	// no auth timeout either
+	time.Sleep(50 * time.Millisecond)
+	assert.NotNil(t, sm.LoadSession("trusted"))
+}
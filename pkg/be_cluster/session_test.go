@@ -0,0 +1,357 @@
+package be_cluster
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession_ProtoErrors(t *testing.T) {
+	helloOk := func(req *respio.RespPacket) *respio.RespPacket {
+		return respio.NewStatus(string(respio.OkCmd))
+	}
+	tests := []struct {
+		name     string
+		protoVer string
+		errType  byte
+	}{
+		{name: "resp2", protoVer: "2", errType: respio.RespError},
+		{name: "resp3", protoVer: "3", errType: respio.RespBlobError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, session, clientReader := newTestSessionManager(t, helloOk)
+			send := clientCmdSender(t, sm, session)
+
+			send("HELLO", tt.protoVer)
+			reply, err := clientReader.Read()
+			assert.NoError(t, err)
+			assert.Equal(t, respio.RespStatus, reply.Type)
+
+			// rejected by the proxy
+			send("CLIENT", "KILL", "127.0.0.1:1")
+			reply, err = clientReader.Read()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.errType, reply.Type)
+			assert.Equal(t, "ERR CLIENT KILL is not supported by the proxy", string(reply.Data))
+
+			// errors written outside the reply queue follow the same protocol
+			clientSide, proxySide := net.Pipe()
+			defer clientSide.Close()
+			direct := NewSession("direct", proxySide, 1)
+			direct.setProtoVersion(session.ProtoVersion())
+			go func() { _ = direct.WriteError(respio.ErrNoAuth) }()
+			reply, err = respio.NewRespReader(clientSide).Read()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.errType, reply.Type)
+			// the shared packet is left untouched
+			assert.Equal(t, respio.RespError, respio.ErrNoAuth.Type)
+		})
+	}
+}
+
+func TestSession_DownconvertsResp3Replies(t *testing.T) {
+	mapReply := func(req *respio.RespPacket) *respio.RespPacket {
+		if _, ok := req.HelloProtoVer(); ok {
+			return respio.NewStatus(string(respio.OkCmd))
+		}
+		// the shared backend connection was switched to RESP3 by another session
+		reply := respio.AcquireRespPacket()
+		reply.Type = respio.RespMap
+		reply.Array = []*respio.RespPacket{respio.NewBulkString([]byte("k")), respio.NewBulkString([]byte("v"))}
+		return reply
+	}
+	for _, tt := range []struct {
+		protoVer  string
+		replyType byte
+	}{
+		{protoVer: "2", replyType: respio.RespArray},
+		{protoVer: "3", replyType: respio.RespMap},
+	} {
+		t.Run("resp"+tt.protoVer, func(t *testing.T) {
+			sm, session, clientReader := newTestSessionManager(t, mapReply)
+			send := clientCmdSender(t, sm, session)
+			send("HELLO", tt.protoVer)
+			_, err := clientReader.Read()
+			assert.NoError(t, err)
+
+			send("CONFIG", "GET", "k")
+			reply, err := clientReader.Read()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.replyType, reply.Type)
+			assert.Len(t, reply.Array, 2)
+		})
+	}
+}
+
+func TestSession_DownconvertsLargeResp3Replies(t *testing.T) {
+	const pairs = 5000
+	largeMap := func(req *respio.RespPacket) *respio.RespPacket {
+		if _, ok := req.HelloProtoVer(); ok {
+			return respio.NewStatus(string(respio.OkCmd))
+		}
+		reply := respio.AcquireRespPacket()
+		reply.Type = respio.RespMap
+		for i := 0; i < pairs; i++ {
+			reply.Array = append(reply.Array, respio.NewBulkString([]byte(fmt.Sprint(i))), respio.NewInteger(1))
+		}
+		return reply
+	}
+	sm, session, clientReader := newTestSessionManager(t, largeMap)
+	send := clientCmdSender(t, sm, session)
+	send("HELLO", "3")
+	_, err := clientReader.Read()
+	assert.NoError(t, err)
+	// another session of the shared connection negotiated RESP3, this one speaks RESP2
+	session.setProtoVersion(respio.Resp2)
+
+	send("HGETALL", "h")
+	reply, err := clientReader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespArray, reply.Type)
+	assert.Len(t, reply.Array, 2*pairs)
+}
+
+func TestSession_RequestIdLogged(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+	origin := reqLogger
+	reqLogger = funcr.New(func(prefix, args string) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, args)
+	}, funcr.Options{Verbosity: 1})
+	t.Cleanup(func() { reqLogger = origin })
+
+	sm, session, clientReader := newTestSessionManager(t, echoKey)
+	reqId := NextRequestId()
+	assert.NoError(t, sm.Forward(session.Id, reqId, respio.NewCommand("GET", "k1"), session.GetAuthInfo()))
+	_, err := clientReader.Read()
+	assert.NoError(t, err)
+
+	idField := fmt.Sprintf(`"RequestId"=%d`, reqId)
+	hasLine := func(msg string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, line := range lines {
+			if strings.Contains(line, msg) && strings.Contains(line, idField) {
+				return true
+			}
+		}
+		return false
+	}
+	assert.True(t, hasLine(`"msg"="Forward request"`))
+	// the reply is logged once written to the client
+	assert.Eventually(t, func() bool { return hasLine(`"msg"="Reply request"`) }, time.Second, 10*time.Millisecond)
+}
+
+func TestSession_BrokenClientReleased(t *testing.T) {
+	sm, _, _ := newTestSessionManager(t, echoKey)
+	sm.config = newTestSyncConfig()
+	clientSide, proxySide := net.Pipe()
+	sm.OpenSession("broken", proxySide)
+	session := sm.LoadSession("broken")
+	session.SetAuthInfo(&common.AuthInfo{Username: []byte("tenant-a")})
+	_ = clientSide.Close()
+
+	assert.NoError(t, sm.Forward(session.Id, NextRequestId(), respio.NewCommand("GET", "a"), session.GetAuthInfo()))
+	assert.Eventually(t, func() bool {
+		return sm.LoadSession("broken") == nil
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case <-session.quit:
+	default:
+		assert.Fail(t, "session not closed")
+	}
+	// nothing drains OutQ anymore, the replies are dropped instead of blocking
+	for i := 0; i < 2*DefaultSessionOutQSize; i++ {
+		session.ReplyLocal(respio.NewStatus("OK"))
+	}
+
+	// a new connection reusing the id is not released by the stale session
+	_, reused := net.Pipe()
+	sm.OpenSession("broken", reused)
+	defer sm.CloseSession("broken")
+	sm.releaseSession(session)
+	assert.NotNil(t, sm.LoadSession("broken"))
+}
+
+func TestSession_ReplyLocalAndClose(t *testing.T) {
+	session, reader := newPipeSession(t, "closing")
+	// a command dispatched before is still waiting for its backend reply
+	seq := session.expectReply()
+	session.ReplyLocalAndClose(respio.NewError("ERR Protocol error: invalid multibulk length"))
+	assert.True(t, session.Closing())
+	session.deliver(&ResponseContext{Response: respio.NewStatus("OK"), ordered: true, seq: seq})
+
+	reply, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.OkCmd, reply.Data)
+	reply, err = reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, respio.RespError, reply.Type)
+	assert.Equal(t, "ERR Protocol error: invalid multibulk length", string(reply.Data))
+	_, err = reader.Read()
+	assert.Error(t, err)
+	select {
+	case <-session.quit:
+	case <-time.After(time.Second):
+		assert.Fail(t, "session not closed")
+	}
+}
+
+func TestSession_FailedAuthResetsPendingAuth(t *testing.T) {
+	sm, session, reader := newTestSessionManager(t, func(req *respio.RespPacket) *respio.RespPacket {
+		switch string(req.Array[len(req.Array)-1].Data) {
+		case "wrong":
+			return respio.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+		case "limited":
+			return respio.NewError("NOPERM this user has no permissions to run the 'auth' command")
+		}
+		return respio.NewStatus(string(respio.OkCmd))
+	})
+	// the dispatcher sets the username for routing before the backend verified the password
+	pending := &common.AuthInfo{Username: []byte("tenant-a")}
+	auth := func(password string) *respio.RespPacket {
+		session.SetAuthInfo(pending)
+		authInfo := &common.AuthInfo{Username: pending.Username, Password: []byte(password)}
+		assert.NoError(t, sm.Forward(session.Id, NextRequestId(),
+			respio.NewAuthPacket(authInfo.Username, authInfo.Password), authInfo))
+		reply, err := reader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+
+	for _, password := range []string{"wrong", "limited"} {
+		assert.Equal(t, respio.RespError, auth(password).Type)
+		// the next command is answered with NOAUTH
+		assert.False(t, session.IsAuthenticated(), password)
+	}
+
+	// an AUTH the backend never answered
+	pair, _ := sm.sessions.Load(session.Id)
+	_ = pair.backend.Close()
+	reply := auth("secret")
+	assert.Equal(t, ErrBackendConnClosed.Error(), string(reply.Data))
+	assert.False(t, session.IsAuthenticated())
+}
+
+func TestSession_HelloAuth(t *testing.T) {
+	sm, session, reader := newTestSessionManager(t, func(req *respio.RespPacket) *respio.RespPacket {
+		if string(req.Array[len(req.Array)-1].Data) == "wrong" {
+			return respio.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		return &respio.RespPacket{Type: respio.RespMap, Array: []*respio.RespPacket{
+			respio.NewBulkString([]byte("proto")), respio.NewInteger(2)}}
+	})
+	hello := func(password string) *respio.RespPacket {
+		session.SetAuthInfo(&common.AuthInfo{Username: []byte("tenant-a")})
+		packet := respio.NewCommand("HELLO", "2", "AUTH", "tenant-a", password)
+		assert.NoError(t, sm.ForwardHello(session.Id, NextRequestId(), packet, packet.HelloAuthInfoWithSeparator('.')))
+		reply, err := reader.Read()
+		assert.NoError(t, err)
+		return reply
+	}
+
+	assert.Equal(t, respio.RespError, hello("wrong").Type)
+	assert.False(t, session.IsAuthenticated())
+
+	assert.Equal(t, respio.RespArray, hello("secret").Type)
+	assert.True(t, session.IsVerified())
+	assert.Equal(t, "secret", string(session.GetAuthInfo().Password))
+}
+
+func TestValidateAuthInfo(t *testing.T) {
+	withUser := respio.NewCommand("AUTH", "tk.alice", "secret").ToAuthInfo()
+	assert.NoError(t, ValidateAuthInfo(withUser, true))
+	// without a username the static router still has a backend for the client
+	passwordOnly := respio.NewCommand("AUTH", "secret").ToAuthInfo()
+	assert.NoError(t, ValidateAuthInfo(passwordOnly, false))
+	assert.ErrorIs(t, ValidateAuthInfo(passwordOnly, true), ErrInvalidAuth)
+	assert.ErrorIs(t, ValidateAuthInfo(respio.NewCommand("AUTH", "tk.", "secret").ToAuthInfo(), true), ErrInvalidAuth)
+	for _, args := range [][]string{{"AUTH"}, {"AUTH", "tk.alice", "secret", "extra"}} {
+		assert.ErrorIs(t, ValidateAuthInfo(respio.NewCommand(args...).ToAuthInfo(), false), ErrInvalidAuth, args)
+	}
+}
+
+func TestSession_RejectBeforeHello(t *testing.T) {
+	get := respio.NewCommand("GET", "k")
+	// not strict, the client stays on RESP2 without HELLO
+	session := NewSession("no-hello", nil, 8)
+	assert.False(t, session.RejectBeforeHello(get, false))
+	assert.Equal(t, respio.Resp2, session.ProtoVersion())
+
+	session = NewSession("strict", nil, 8)
+	assert.True(t, session.RejectBeforeHello(get, true))
+	assert.False(t, session.RejectBeforeHello(respio.NewCommand("PING"), true))
+	assert.False(t, session.RejectBeforeHello(respio.NewCommand("hello", "3"), true))
+	assert.False(t, session.RejectBeforeHello(get, true))
+}
+
+func TestSession_ConcurrentReadAndReply(t *testing.T) {
+	const replies, errs = 200, 50
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	session := NewSession("concurrent", proxySide, 16)
+	go session.ReplyLoop()
+	defer session.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	// the client sends commands while it reads the replies
+	go func() {
+		defer wg.Done()
+		writer := respio.NewRespWriter(clientSide)
+		for i := 0; i < replies; i++ {
+			assert.NoError(t, writer.Write(respio.NewCommand("GET", fmt.Sprint(i))))
+			assert.NoError(t, writer.Flush())
+		}
+	}()
+	// the event loop reads them and writes errors right away
+	go func() {
+		defer wg.Done()
+		for i := 0; i < replies; i++ {
+			packet, err := session.Read()
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprint(i), string(packet.Array[1].Data))
+			if i < errs {
+				assert.NoError(t, session.WriteError(respio.ErrNoAuth))
+			}
+		}
+	}()
+	// a backend connection answers meanwhile
+	go func() {
+		defer wg.Done()
+		for i := 0; i < replies; i++ {
+			session.deliver(&ResponseContext{Response: respio.NewBulkString([]byte(fmt.Sprint(i)))})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		reader := respio.NewRespReader(clientSide)
+		bulks, noAuths := 0, 0
+		for bulks+noAuths < replies+errs {
+			reply, err := reader.Read()
+			if !assert.NoError(t, err) {
+				return
+			}
+			if reply.Type == respio.RespError {
+				noAuths++
+				continue
+			}
+			// the replies of the backend keep their order
+			assert.Equal(t, fmt.Sprint(bulks), string(reply.Data))
+			bulks++
+		}
+		assert.Equal(t, errs, noAuths)
+	}()
+	wg.Wait()
+}
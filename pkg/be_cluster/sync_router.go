@@ -1,16 +1,36 @@
 package be_cluster
 
-var _ BackendRouter = &SyncRouter{}
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	_ BackendRouter = &SyncRouter{}
+
+	// ErrNoInstanceAvailable is returned when every instance of a cluster is offline
+	ErrNoInstanceAvailable = errors.New("ERR no backend instance available")
+)
 
 type SyncRouter struct {
 	registry ClusterRegistry
 }
 
-func (s *SyncRouter) BackendChangeNotify(notify BackendNotify) {
+// BackendChangeNotify passes the cluster changes pushed to the registry on until ctx is done,
+// or the notify channel is closed.
+func (s *SyncRouter) BackendChangeNotify(ctx context.Context, notify BackendNotify) {
 	notifyChan := s.registry.Notify()
 	for {
 		select {
-		case cluster := <-notifyChan:
+		case <-ctx.Done():
+			logger.Info("SyncRouter change notify stopped")
+			return
+		case cluster, ok := <-notifyChan:
+			if !ok {
+				logger.Info("SyncRouter notify channel closed")
+				return
+			}
 			notify(cluster)
 		}
 	}
@@ -21,14 +41,29 @@ func (s *SyncRouter) Selector(balancer Balancer, key *ClusterKey) (*ClusterInsta
 	if err != nil {
 		return nil, err
 	}
-	clusterList := cluster.GetAllClusterForRead()
-	if len(clusterList) == 1 {
+	clusterList := routableInstances(cluster.GetAllClusterForRead())
+	switch len(clusterList) {
+	case 0:
+		return nil, fmt.Errorf("%w in cluster %s/%s", ErrNoInstanceAvailable, key.Name.Namespace, key.Name.Name)
+	case 1:
 		return clusterList[0], nil
 	}
 	nextIdx := int(balancer.Next(key, clusterList))
 	return clusterList[nextIdx], nil
 }
 
+// routableInstances leaves out the instances gone offline or deleted, they have no pool anymore.
+// A draining instance is kept, the backend manager moves its sessions to another one.
+func routableInstances(instances []*ClusterInstance) []*ClusterInstance {
+	routable := make([]*ClusterInstance, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Status != ClusterStatusOffline && instance.Status != ClusterStatusDeleted {
+			routable = append(routable, instance)
+		}
+	}
+	return routable
+}
+
 func (s *SyncRouter) ListBackend(key *ClusterKey) ([]*ClusterInstance, error) {
 	cluster, err := s.registry.GetClusterInstance(*key)
 	if err != nil {
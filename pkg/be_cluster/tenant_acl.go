@@ -0,0 +1,201 @@
+package be_cluster
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+var errNoKeyPermission = errors.New("NOPERM No permissions to access a key")
+
+// Command categories usable in the command patterns of a TenantACL, matched with the flags of
+// the command metadata.
+var aclCategories = map[string]respio.CommandFlag{
+	"@read":     respio.CmdFlagReadOnly,
+	"@write":    respio.CmdFlagWrite,
+	"@admin":    respio.CmdFlagAdmin,
+	"@blocking": respio.CmdFlagBlocking,
+}
+
+// TenantACL restricts the commands and the keys of a tenant. A command pattern is a category
+// (@read, @write, @admin, @blocking) or a glob on the lower case command name, a sub command
+// is named like "config|get". A denied pattern wins over an allowed one, and with allowed
+// patterns set only the matching commands run. With key patterns set every key of a command
+// must match one of them. Commands with movable keys (e.g. EVAL) are denied then. A command
+// without metadata has neither a category nor known keys, so it is denied as soon as key
+// patterns or categories are set.
+type TenantACL struct {
+	AllowedCommands []string `json:"allowed_commands,omitempty"`
+	DeniedCommands  []string `json:"denied_commands,omitempty"`
+	KeyPatterns     []string `json:"key_patterns,omitempty"`
+}
+
+// Validate checks the patterns, so that a typo does not silently deny every command.
+func (a *TenantACL) Validate() error {
+	for _, pattern := range slices.Concat(a.AllowedCommands, a.DeniedCommands) {
+		if strings.HasPrefix(pattern, "@") {
+			if _, ok := aclCategories[pattern]; !ok {
+				return fmt.Errorf("unknown command category: %s", pattern)
+			}
+			continue
+		}
+		if !validGlob(pattern) {
+			return fmt.Errorf("invalid command pattern: %s", pattern)
+		}
+	}
+	for _, pattern := range a.KeyPatterns {
+		if !validGlob(pattern) {
+			return fmt.Errorf("invalid key pattern: %s", pattern)
+		}
+	}
+	return nil
+}
+
+// Check returns a NOPERM error when the tenant may not run the command.
+func (a *TenantACL) Check(tenant string, packet *respio.RespPacket) error {
+	meta := respio.LookupCommand(packet)
+	name := strings.ToLower(string(packet.GetCommand()))
+	if meta != nil {
+		name = meta.Name
+	}
+	matchCommand := func(pattern string) bool {
+		if flag, ok := aclCategories[pattern]; ok {
+			return meta != nil && meta.HasFlag(flag)
+		}
+		return globMatch(pattern, name)
+	}
+	allowed := len(a.AllowedCommands) == 0
+	for _, pattern := range a.AllowedCommands {
+		if matchCommand(pattern) {
+			allowed = true
+			break
+		}
+	}
+	for _, pattern := range a.DeniedCommands {
+		if matchCommand(pattern) {
+			allowed = false
+			break
+		}
+	}
+	if meta == nil && (len(a.KeyPatterns) > 0 || a.hasCategory()) {
+		allowed = false
+	}
+	if !allowed {
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", tenant, name)
+	}
+	if len(a.KeyPatterns) == 0 {
+		return nil
+	}
+	if meta.HasFlag(respio.CmdFlagMovableKeys) {
+		return errNoKeyPermission
+	}
+	for _, key := range meta.ExtractKeys(packet) {
+		if !a.keyAllowed(string(key)) {
+			return errNoKeyPermission
+		}
+	}
+	return nil
+}
+
+func (a *TenantACL) hasCategory() bool {
+	return slices.ContainsFunc(slices.Concat(a.AllowedCommands, a.DeniedCommands), func(pattern string) bool {
+		return strings.HasPrefix(pattern, "@")
+	})
+}
+
+func (a *TenantACL) keyAllowed(key string) bool {
+	for _, pattern := range a.KeyPatterns {
+		if globMatch(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// validGlob reports whether the character classes of a pattern are closed.
+func validGlob(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return false
+			}
+			i += end + 1
+		}
+	}
+	return pattern != ""
+}
+
+// globMatch matches like Redis KEYS: '*' any sequence, '?' any character, "[a-z]" and "[^a]"
+// classes and '\' escapes. Unlike path.Match, '*' also matches '/'.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if len(s) == 0 || end < 0 {
+				return false
+			}
+			if !classMatch(pattern[1:end+1], s[0]) {
+				return false
+			}
+			pattern = pattern[end+2:]
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+func classMatch(class string, c byte) bool {
+	negate := strings.HasPrefix(class, "^")
+	if negate {
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				matched = true
+			}
+			i += 2
+		} else if class[i] == c {
+			matched = true
+		}
+	}
+	return matched != negate
+}
@@ -0,0 +1,98 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantACL_ReadOnlyTenant(t *testing.T) {
+	registry := newDefaultClusterRegistry()
+	assert.NoError(t, registry.SetTenantACL("reader", &TenantACL{
+		AllowedCommands: []string{"@read"},
+	}))
+	assert.Nil(t, registry.GetTenantACL("writer"))
+	acl := registry.GetTenantACL("reader")
+
+	assert.NoError(t, acl.Check("reader", respio.NewCommand("GET", "k")))
+	assert.NoError(t, acl.Check("reader", respio.NewCommand("MGET", "k1", "k2")))
+	err := acl.Check("reader", respio.NewCommand("SET", "k", "v"))
+	assert.EqualError(t, err, "NOPERM User reader has no permissions to run the 'set' command")
+	// unknown commands have no category
+	assert.Error(t, acl.Check("reader", respio.NewCommand("INFO")))
+
+	registry.DeleteTenantACL("reader")
+	assert.Nil(t, registry.GetTenantACL("reader"))
+}
+
+func TestTenantACL_DeniedCommands(t *testing.T) {
+	acl := &TenantACL{DeniedCommands: []string{"flush*", "@admin", "object|freq"}}
+	assert.NoError(t, acl.Validate())
+	assert.NoError(t, acl.Check("t", respio.NewCommand("SET", "k", "v")))
+	assert.NoError(t, acl.Check("t", respio.NewCommand("OBJECT", "ENCODING", "k")))
+	assert.Error(t, acl.Check("t", respio.NewCommand("FLUSHALL")))
+	assert.Error(t, acl.Check("t", respio.NewCommand("shutdown")))
+	assert.EqualError(t, acl.Check("t", respio.NewCommand("OBJECT", "FREQ", "k")),
+		"NOPERM User t has no permissions to run the 'object|freq' command")
+}
+
+func TestTenantACL_KeyPatterns(t *testing.T) {
+	acl := &TenantACL{KeyPatterns: []string{"app:*", "cache:[0-9]?"}}
+	assert.NoError(t, acl.Validate())
+
+	assert.NoError(t, acl.Check("t", respio.NewCommand("GET", "app:user/1")))
+	assert.NoError(t, acl.Check("t", respio.NewCommand("SET", "cache:1a", "v")))
+	assert.NoError(t, acl.Check("t", respio.NewCommand("PING")))
+	err := acl.Check("t", respio.NewCommand("GET", "other"))
+	assert.EqualError(t, err, "NOPERM No permissions to access a key")
+	assert.NoError(t, acl.Check("t", respio.NewCommand("MEMORY", "USAGE", "app:a")))
+	assert.Error(t, acl.Check("t", respio.NewCommand("MEMORY", "USAGE", "other")))
+	// every key must match
+	assert.Error(t, acl.Check("t", respio.NewCommand("MSET", "app:a", "1", "secret", "2")))
+	assert.Error(t, acl.Check("t", respio.NewCommand("SET", "cache:a1", "v")))
+	// the keys of EVAL cannot be located
+	assert.Error(t, acl.Check("t", respio.NewCommand("EVAL", "return 1", "1", "app:a")))
+}
+
+func TestTenantACL_UnknownCommands(t *testing.T) {
+	unknown := respio.NewCommand("NOSUCHCMD", "secret")
+	// only name patterns: an unknown command is matched by name
+	assert.NoError(t, (&TenantACL{DeniedCommands: []string{"flush*"}}).Check("t", unknown))
+	assert.Error(t, (&TenantACL{DeniedCommands: []string{"nosuch*"}}).Check("t", unknown))
+	// its keys and its category are unknown, so it fails closed
+	err := (&TenantACL{KeyPatterns: []string{"app:*"}}).Check("t", unknown)
+	assert.EqualError(t, err, "NOPERM User t has no permissions to run the 'nosuchcmd' command")
+	assert.Error(t, (&TenantACL{DeniedCommands: []string{"@write"}}).Check("t", unknown))
+	assert.Error(t, (&TenantACL{AllowedCommands: []string{"@read", "nosuch*"}}).Check("t", unknown))
+}
+
+func TestTenantACL_Validate(t *testing.T) {
+	assert.Error(t, (&TenantACL{AllowedCommands: []string{"@readonly"}}).Validate())
+	assert.Error(t, (&TenantACL{DeniedCommands: []string{"get["}}).Validate())
+	assert.Error(t, (&TenantACL{KeyPatterns: []string{""}}).Validate())
+	assert.Error(t, newDefaultClusterRegistry().SetTenantACL("t", &TenantACL{KeyPatterns: []string{"[a"}}))
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"a*", "abc", true},
+		{"a*c", "ac", true},
+		{"a*c", "abd", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"user:*", "user:a/b", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, globMatch(tt.pattern, tt.s), "%s %s", tt.pattern, tt.s)
+	}
+}
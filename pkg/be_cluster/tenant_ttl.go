@@ -0,0 +1,96 @@
+package be_cluster
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// TenantTTLPolicy caps the TTL of the writes of a tenant, e.g. a cache tier that must not grow
+// without bound. SET, SETEX, PSETEX and GETEX get a TTL clamped to MaxTTL, a SET without TTL
+// gets MaxTTL, and so does a GETEX PERSIST. EXPIRE and the like are clamped as well. The other
+// writes, e.g. MSET or PERSIST, are not rewritten.
+type TenantTTLPolicy struct {
+	// MaxTTL in seconds
+	MaxTTL int64 `json:"max_ttl"`
+}
+
+func (p *TenantTTLPolicy) Validate() error {
+	if p.MaxTTL <= 0 {
+		return errors.New("max_ttl must be positive")
+	}
+	return nil
+}
+
+// Apply rewrites the TTL arguments of the command in place, it reports whether the command
+// changed. An argument that is not a number is left for the backend to reject.
+func (p *TenantTTLPolicy) Apply(packet *respio.RespPacket) bool {
+	return p.apply(packet, time.Now())
+}
+
+func (p *TenantTTLPolicy) apply(packet *respio.RespPacket, now time.Time) bool {
+	meta := respio.LookupCommand(packet)
+	if meta == nil {
+		return false
+	}
+	args := packet.Array
+	switch meta.Name {
+	case "set":
+		return p.capOptions(packet, 3, true, now)
+	case "getex":
+		return p.capOptions(packet, 2, false, now)
+	case "setex", "expire":
+		return len(args) > 2 && capArg(args[2], p.MaxTTL)
+	case "psetex", "pexpire":
+		return len(args) > 2 && capArg(args[2], p.MaxTTL*1000)
+	case "expireat":
+		return len(args) > 2 && capArg(args[2], now.Unix()+p.MaxTTL)
+	case "pexpireat":
+		return len(args) > 2 && capArg(args[2], now.UnixMilli()+p.MaxTTL*1000)
+	}
+	return false
+}
+
+// capOptions clamps the expiration options of SET or GETEX starting at index start. A SET
+// without one gets EX MaxTTL when addMissing, KEEPTTL keeps the TTL the key already has.
+func (p *TenantTTLPolicy) capOptions(packet *respio.RespPacket, start int, addMissing bool, now time.Time) bool {
+	args := packet.Array
+	for i := start; i < len(args); i++ {
+		switch strings.ToLower(string(args[i].Data)) {
+		case "ex":
+			return i+1 < len(args) && capArg(args[i+1], p.MaxTTL)
+		case "px":
+			return i+1 < len(args) && capArg(args[i+1], p.MaxTTL*1000)
+		case "exat":
+			return i+1 < len(args) && capArg(args[i+1], now.Unix()+p.MaxTTL)
+		case "pxat":
+			return i+1 < len(args) && capArg(args[i+1], now.UnixMilli()+p.MaxTTL*1000)
+		case "keepttl":
+			return false
+		case "persist":
+			args[i].Data = []byte("EX")
+			packet.Array = slices.Insert(args, i+1, respio.NewBulkString(strconv.AppendInt(nil, p.MaxTTL, 10)))
+			return true
+		}
+	}
+	if !addMissing {
+		return false
+	}
+	packet.Array = append(packet.Array, respio.NewBulkString([]byte("EX")),
+		respio.NewBulkString(strconv.AppendInt(nil, p.MaxTTL, 10)))
+	return true
+}
+
+// capArg lowers the numeric argument to limit if it is above.
+func capArg(arg *respio.RespPacket, limit int64) bool {
+	value, err := strconv.ParseInt(string(arg.Data), 10, 64)
+	if err != nil || value <= limit {
+		return false
+	}
+	arg.Data = strconv.AppendInt(nil, limit, 10)
+	return true
+}
@@ -0,0 +1,76 @@
+package be_cluster
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func commandLine(packet *respio.RespPacket) string {
+	args := make([]string, 0, len(packet.Array))
+	for _, arg := range packet.Array {
+		args = append(args, string(arg.Data))
+	}
+	return strings.Join(args, " ")
+}
+
+func TestTenantTTLPolicy_Apply(t *testing.T) {
+	policy := &TenantTTLPolicy{MaxTTL: 60}
+	now := time.Unix(1_700_000_000, 0)
+	cases := []struct {
+		cmd       []string
+		rewritten string
+	}{
+		// a SET without TTL gets the default one
+		{[]string{"SET", "k", "v"}, "SET k v EX 60"},
+		{[]string{"set", "k", "v", "NX", "GET"}, "set k v NX GET EX 60"},
+		// a TTL above the cap is clamped
+		{[]string{"SET", "k", "v", "EX", "3600"}, "SET k v EX 60"},
+		{[]string{"SET", "k", "v", "px", "120000"}, "SET k v px 60000"},
+		{[]string{"SET", "k", "v", "EXAT", "1800000000"}, "SET k v EXAT 1700000060"},
+		{[]string{"SETEX", "k", "3600", "v"}, "SETEX k 60 v"},
+		{[]string{"PSETEX", "k", "3600000", "v"}, "PSETEX k 60000 v"},
+		{[]string{"GETEX", "k", "PERSIST"}, "GETEX k EX 60"},
+		{[]string{"GETEX", "k", "PXAT", "1800000000000"}, "GETEX k PXAT 1700000060000"},
+		{[]string{"EXPIRE", "k", "3600", "GT"}, "EXPIRE k 60 GT"},
+		{[]string{"PEXPIRE", "k", "3600000"}, "PEXPIRE k 60000"},
+		{[]string{"EXPIREAT", "k", "1800000000"}, "EXPIREAT k 1700000060"},
+		{[]string{"PEXPIREAT", "k", "1800000000000"}, "PEXPIREAT k 1700000060000"},
+	}
+	for _, c := range cases {
+		packet := respio.NewCommand(c.cmd...)
+		assert.True(t, policy.apply(packet, now), c.rewritten)
+		assert.Equal(t, c.rewritten, commandLine(packet))
+	}
+
+	unchanged := [][]string{
+		{"SET", "k", "v", "EX", "30"},
+		{"SET", "k", "v", "KEEPTTL"},
+		{"SET", "k", "v", "EX", "soon"},
+		{"GETEX", "k"},
+		{"EXPIRE", "k", "-1"},
+		{"GET", "k"},
+		{"MSET", "a", "1", "b", "2"},
+	}
+	for _, cmd := range unchanged {
+		packet := respio.NewCommand(cmd...)
+		assert.False(t, policy.apply(packet, now), cmd)
+		assert.Equal(t, strings.Join(cmd, " "), commandLine(packet))
+	}
+}
+
+func TestTenantTTLPolicy_Registry(t *testing.T) {
+	registry := newDefaultClusterRegistry()
+	assert.Error(t, registry.SetTenantTTLPolicy("cache", &TenantTTLPolicy{}))
+	assert.Nil(t, registry.GetTenantTTLPolicy("cache"))
+
+	assert.NoError(t, registry.SetTenantTTLPolicy("cache", &TenantTTLPolicy{MaxTTL: 60}))
+	assert.Equal(t, &TenantTTLPolicy{MaxTTL: 60}, registry.GetTenantTTLPolicy("cache"))
+	assert.Nil(t, registry.GetTenantTTLPolicy("other"))
+
+	registry.DeleteTenantTTLPolicy("cache")
+	assert.Nil(t, registry.GetTenantTTLPolicy("cache"))
+}
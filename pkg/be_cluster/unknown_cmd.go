@@ -0,0 +1,35 @@
+package be_cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+)
+
+// HandleUnknownCommand applies the policy to a command missing from the command metadata. It
+// returns false if the command must be forwarded, it goes to the instance of the tenant like any
+// other.
+func HandleUnknownCommand(policy string, packet *respio.RespPacket) (*respio.RespPacket, bool) {
+	if respio.LookupCommand(packet) != nil {
+		return nil, false
+	}
+	// the command metadata can be extended with the commands logged here
+	logger.V(1).Info("Unknown command", "Command", string(packet.GetCommand()), "Policy", policy)
+	if policy != common.UnknownCmdPolicyReject {
+		return nil, false
+	}
+	return respio.NewError(unknownCommandMsg(packet)), true
+}
+
+// unknownCommandMsg formats the error like Redis.
+func unknownCommandMsg(packet *respio.RespPacket) string {
+	var args strings.Builder
+	if len(packet.Array) > 1 {
+		for _, arg := range packet.Array[1:] {
+			fmt.Fprintf(&args, "'%s' ", arg.Data)
+		}
+	}
+	return fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", packet.GetCommand(), args.String())
+}
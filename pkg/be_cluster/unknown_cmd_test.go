@@ -0,0 +1,39 @@
+package be_cluster
+
+import (
+	"testing"
+
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleUnknownCommand(t *testing.T) {
+	madeUp := func() *respio.RespPacket { return respio.NewCommand("FROBNICATE", "k", "v") }
+
+	reply, handled := HandleUnknownCommand(common.UnknownCmdPolicyReject, madeUp())
+	assert.True(t, handled)
+	assert.Equal(t, respio.RespError, reply.Type)
+	assert.Equal(t, "ERR unknown command 'FROBNICATE', with args beginning with: 'k' 'v' ", string(reply.Data))
+	reply, _ = HandleUnknownCommand(common.UnknownCmdPolicyReject, respio.NewCommand("frobnicate"))
+	assert.Equal(t, "ERR unknown command 'frobnicate', with args beginning with: ", string(reply.Data))
+
+	for _, policy := range []string{common.UnknownCmdPolicyForward, common.UnknownCmdPolicyPrimary} {
+		_, handled = HandleUnknownCommand(policy, madeUp())
+		assert.False(t, handled, policy)
+	}
+	// known commands are never rejected, those handled by the proxy included
+	for _, cmd := range [][]string{{"GET", "k"}, {"MULTI"}, {"WATCH", "k"}, {"HELLO", "3"}, {"SCRIPT", "LOAD", "return 1"},
+		{"PUBLISH", "ch", "msg"}, {"SUBSCRIBE", "ch"}, {"PUBSUB", "CHANNELS"}, {"BITFIELD", "k", "GET", "u8", "0"},
+		{"XREADGROUP", "GROUP", "g", "c", "STREAMS", "s", ">"}, {"LMPOP", "1", "l", "LEFT"}, {"XINFO", "STREAM", "s"},
+		{"CLUSTER", "INFO"}, {"ACL", "WHOAMI"}, {"ZINTERCARD", "2", "z1", "z2"}} {
+		_, handled = HandleUnknownCommand(common.UnknownCmdPolicyReject, respio.NewCommand(cmd...))
+		assert.False(t, handled, cmd)
+	}
+
+	// forwarded, an unknown command goes to the primary unless the policy forwards it like a read
+	assert.False(t, NewReadSplitPolicy(nil, common.UnknownCmdPolicyPrimary).RouteToReplica(madeUp()))
+	assert.False(t, NewReadSplitPolicy(nil, "").RouteToReplica(madeUp()))
+	assert.True(t, NewReadSplitPolicy(nil, common.UnknownCmdPolicyForward).RouteToReplica(madeUp()))
+	assert.False(t, NewReadSplitPolicy(nil, common.UnknownCmdPolicyForward).RouteToReplica(respio.NewCommand("SET", "k", "v")))
+}
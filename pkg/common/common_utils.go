@@ -10,6 +10,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"os"
@@ -34,6 +35,8 @@ const (
 	TenantKeySeparator = '.'
 	EncodingAlphabet   = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_"
 	ProxyRuntime       = "PROXY_RUNTIME"
+	// MaxBase62Len is the length of math.MaxUint64 encoded in base62.
+	MaxBase62Len = 11
 )
 
 func RawZapLogger() *zap.Logger {
@@ -81,13 +84,23 @@ func IsProdRuntime() bool {
 	}
 }
 
+// DecodeBase62 decodes a key produced by EncodeBase62. It rejects input longer than
+// MaxBase62Len and any value that would overflow uint64, so a crafted key can never wrap
+// around and alias to another tenant's code.
 func DecodeBase62(s string) (uint64, error) {
+	if len(s) > MaxBase62Len {
+		return 0, fmt.Errorf("tenant key too long: %d > %d", len(s), MaxBase62Len)
+	}
 	var decoded uint64
 	for i := len(s) - 1; i >= 0; i-- {
 		pos := strings.IndexByte(EncodingAlphabet, s[i])
-		if pos == -1 {
+		// only the first 62 characters of the alphabet are valid digits
+		if pos == -1 || pos >= 62 {
 			return 0, fmt.Errorf("invalid character in tenant key")
 		}
+		if decoded > (math.MaxUint64-uint64(pos))/62 {
+			return 0, fmt.Errorf("tenant key overflows uint64")
+		}
 		decoded = decoded*62 + uint64(pos)
 	}
 	return decoded, nil
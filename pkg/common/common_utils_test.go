@@ -0,0 +1,29 @@
+package common
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeBase62(t *testing.T) {
+	for _, n := range []uint64{0, 1, 61, 62, 3422909936434757924, math.MaxUint64} {
+		decoded, err := DecodeBase62(EncodeBase62(n))
+		assert.NoError(t, err)
+		assert.Equal(t, n, decoded)
+	}
+
+	// the boundary value has the maximum length, anything above it overflows
+	assert.Len(t, EncodeBase62(math.MaxUint64), MaxBase62Len)
+	_, err := DecodeBase62(strings.Repeat("z", MaxBase62Len))
+	assert.Error(t, err)
+
+	_, err = DecodeBase62(strings.Repeat("1", MaxBase62Len+1))
+	assert.Error(t, err)
+
+	// characters outside the base62 digits are rejected
+	_, err = DecodeBase62("-")
+	assert.Error(t, err)
+}
@@ -4,19 +4,37 @@ import (
 	"fmt"
 	"github.com/panjf2000/gnet/v2"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type WebServerConfig struct {
-	EnablePprof bool `help:"Enable pprof for the web proxy" name:"pprof" default:"true"`
+	EnablePprof bool   `help:"Enable pprof for the web proxy" name:"pprof" default:"true"`
+	AdminToken  string `help:"Bearer token required by the admin endpoints, e.g. POST /metrics/reset. Unset disables them." name:"admin-token" env:"ELIKA_ADMIN_TOKEN"`
 }
 
+// SupportedBalancers are the load balancer names accepted by --router.balancer. An empty name
+// selects random.
+var SupportedBalancers = []string{"tenant-hash", "random", "ewma"}
+
 type BackendRouterConfig struct {
-	LBType        string `help:"Type of the load balancer (e.g., round-robin, least-cluster, random)" name:"balancer" default:"random"`
-	RouterType    string `help:"Type of the backend router (e.g., static, sync)" name:"type" required:"true"`
-	StaticBackend string `help:"Address of the static backend (e.g., 127.0.0.1:6379)" name:"static-be"`
-	CpAddr        string `help:"Address of the control plane" name:"cp-addr"`
+	LBType        string        `help:"Type of the load balancer: tenant-hash, random or ewma. tenant-hash keeps a tenant on a stable instance, ewma prefers the instance answering the fastest." name:"balancer" default:"tenant-hash"`
+	RouterType    string        `help:"Type of the backend router (e.g., static, sync)" name:"type" required:"true"`
+	StaticBackend string        `help:"Address of the static backend (e.g., 127.0.0.1:6379)" name:"static-be"`
+	CpAddr        string        `help:"Address of the control plane" name:"cp-addr"`
+	ReadyGrace    time.Duration `help:"Grace period to wait for the first backend cluster before warning. Clients get LOADING errors meanwhile." name:"ready-grace" default:"30s"`
+	Pins          []string      `help:"Key patterns pinned to a backend instance of the tenant cluster, e.g. lock:*=10.0.0.1:6379" name:"pin"`
+}
+
+// ParseKeyPin splits a pin of the form pattern=addr.
+func ParseKeyPin(pin string) (string, string, error) {
+	pattern, addr, ok := strings.Cut(pin, "=")
+	if !ok || pattern == "" || addr == "" {
+		return "", "", fmt.Errorf("invalid pin: %s (must be pattern=host:port)", pin)
+	}
+	return pattern, addr, nil
 }
 
 func (r *BackendRouterConfig) StatisEndpoint() (string, int, error) {
@@ -34,6 +52,15 @@ func (r *BackendRouterConfig) StatisEndpoint() (string, int, error) {
 }
 
 func (r *BackendRouterConfig) Validate() error {
+	if r.LBType != "" && !slices.Contains(SupportedBalancers, strings.ToLower(r.LBType)) {
+		return fmt.Errorf("invalid balancer: %s (must be one of %s)", r.LBType,
+			strings.Join(SupportedBalancers, ", "))
+	}
+	for _, pin := range r.Pins {
+		if _, _, err := ParseKeyPin(pin); err != nil {
+			return err
+		}
+	}
 	routerType := strings.ToLower(r.RouterType)
 	switch routerType {
 	case "static":
@@ -59,9 +86,69 @@ func (r *BackendRouterConfig) Validate() error {
 }
 
 type BackendPoolConfig struct {
-	IsFixed bool `help:"Fixed size backend pool" name:"fixed" default:"true"`
+	IsFixed bool `help:"Fixed size backend pool, a dynamic one scales between min-size and max-size" name:"fixed" default:"true"`
 	MaxSize int  `help:"Maximum size of the backend pool" default:"30"`
 	MaxIdle int  `help:"Maximum idle size of the backend pool" default:"10"`
+	// MinSize is the connections a dynamic pool opens first and keeps when idle
+	MinSize int `help:"Minimum size of a dynamic backend pool" name:"min-size" default:"2"`
+	// RetryMaxElapsed bounds how long a pool keeps trying to reconnect to an unavailable backend
+	RetryMaxElapsed time.Duration `help:"Maximum elapsed time of backend reconnect retries" name:"retry-max-elapsed" default:"30m"`
+	DialTimeout     time.Duration `help:"Timeout of a backend connection dial" name:"dial-timeout" default:"3s"`
+	// ConnMaxLifetime rotates long-lived connections, e.g. to spread them after a backend scale-out
+	ConnMaxLifetime time.Duration `help:"Maximum lifetime of a backend connection before it is replaced. 0 keeps connections forever." name:"conn-max-lifetime" default:"1h"`
+	// MaxInFlight holds the clients of a slow backend back before the connection queues fill up
+	MaxInFlight int `help:"Requests in flight on a backend connection above which its clients are not read. 0 disables the limit." name:"max-in-flight" default:"8192"`
+	// ReadyPollInterval backs the signal of the last connection of a new pool up
+	ReadyPollInterval time.Duration `help:"How often a new backend pool is checked for being full" name:"ready-poll-interval" default:"50ms"`
+}
+
+const (
+	// DebugPolicyBlock rejects every DEBUG command
+	DebugPolicyBlock = "block"
+	// DebugPolicyExclusive runs DEBUG SLEEP on a connection of its own, so it cannot stall the
+	// sessions sharing the backend connections. The other DEBUG sub commands are forwarded.
+	DebugPolicyExclusive = "exclusive"
+	// DebugPolicyAllow forwards DEBUG like any command
+	DebugPolicyAllow = "allow"
+)
+
+const (
+	// UnknownCmdPolicyForward forwards a command missing from the command metadata like a
+	// read, a replica may serve it under read/write splitting
+	UnknownCmdPolicyForward = "forward"
+	// UnknownCmdPolicyReject replies an unknown command error
+	UnknownCmdPolicyReject = "reject"
+	// UnknownCmdPolicyPrimary forwards it to the primary, the command may write. The proxy has no
+	// replica routing yet, so it forwards the command like UnknownCmdPolicyForward for now.
+	UnknownCmdPolicyPrimary = "forward-to-primary"
+)
+
+type SessionConfig struct {
+	OutQSize int `help:"Maximum number of replies queued for a client session" name:"out-q-size" default:"10240"`
+	// BufferSize dominates the memory of a session, lower it for many small connections
+	BufferSize int `help:"Size in bytes of the read and the write buffer of a client session" name:"buffer-size" default:"65536"`
+}
+
+type HotKeyConfig struct {
+	SampleRate float64 `help:"Probability in [0, 1] that the keys of a command are sampled for GET /hotkeys. 0 disables the sampling." name:"sample-rate" default:"0"`
+	TopK       int     `help:"Number of hot keys tracked per backend instance" name:"top-k" default:"64"`
+}
+
+// AuthCacheConfig enables verifying a repeated AUTH locally. A password changed on the backend
+// is still accepted by the proxy until the cached entry expires, so the cache is off by default.
+type AuthCacheConfig struct {
+	Enable bool          `help:"Verify an AUTH already accepted by the backend locally, without a backend round trip" name:"enable" default:"false"`
+	TTL    time.Duration `help:"How long a verified password is cached" name:"ttl" default:"1m"`
+}
+
+type SecurityConfig struct {
+	AllowedCmds []string `help:"Only these commands are accepted from clients, e.g. get,set,del. AUTH, HELLO, PING and QUIT are always allowed. Empty allows every command." name:"allowed-cmds"`
+	// RequireAuth off is meant for a static backend on a trusted network, the clients skip AUTH
+	RequireAuth bool          `help:"Require clients to AUTH before running commands. Only the static router runs without." name:"require-auth" default:"true"`
+	AuthTimeout time.Duration `help:"Close a client that did not authenticate within this time. 0 disables the timeout." name:"auth-timeout" default:"10s"`
+	// RequireHello rejects the commands of a client that skipped the protocol negotiation
+	RequireHello bool     `help:"Require clients to send HELLO, after AUTH if required, before any other command. PING and QUIT are always allowed." name:"require-hello" default:"false"`
+	AdminTenants []string `help:"Tenants allowed to run admin commands, e.g. FAILOVER, REPLICAOF or CLIENT PAUSE. The others get an error." name:"admin-tenants"`
 }
 
 type NodeConfig struct {
@@ -72,17 +159,29 @@ type NodeConfig struct {
 type MetricsConfig struct {
 	EnableMetrics   bool   `help:"Enable metrics collection" name:"enable" default:"false"`
 	MetricsPath     string `help:"Metrics path" name:"path" default:"/metrics"`
-	MetricsSinkType string `help:"Metrics sink type. support prometheus and memory." name:"sink" default:"prometheus"`
+	MetricsSinkType string `help:"Metrics sink type. support prometheus, memory and all." name:"sink" default:"prometheus"`
 }
 
 type ProxyConfig struct {
 	ProxyPort             int                 `help:"ProxyPort for the proxy proxy" name:"port" default:"6378"`
 	ServicePort           int                 `help:"ServicePort for the proxy proxy. Port shared by the http and GRPC." name:"service-port" default:"7080"`
+	HttpPort              int                 `help:"Dedicated port of the http server, bypassing the multiplexing of the service port. 0 serves http on the service port." name:"http-port" default:"0"`
 	MultiCore             bool                `help:"Enable multi-core support" default:"true"`
 	CoreNum               int                 `help:"Number of cores to use" default:"0"`
 	EnableTLS             bool                `help:"Enable TLS for the proxy proxy" default:"false"`
 	EnableActiveUserTrace bool                `help:"Enable active user trace" name:"trace-active-user" default:"false"`
+	MaxRequestSize        int64               `help:"Maximum total size in bytes of a single client command. 0 means unlimited." name:"max-request-size" default:"536870912"`
+	MaxRequestArgs        int64               `help:"Maximum number of arguments of a single client command" name:"max-request-args" default:"1048576"`
+	DebugPolicy           string              `help:"How DEBUG commands are handled: block, exclusive (DEBUG SLEEP gets a dedicated backend connection) or allow" name:"debug-policy" default:"block" enum:"block,exclusive,allow"`
+	UnknownCmdPolicy      string              `help:"How commands missing from the command metadata are handled: forward, reject or forward-to-primary. The proxy has no read/write splitting yet, the forwarding policies send them to the instance of the tenant either way." name:"unknown-cmd-policy" default:"forward-to-primary" enum:"forward,reject,forward-to-primary"`
+	FailFast              bool                `help:"Exit at startup if the static backend is unreachable instead of starting in the LOADING state" name:"fail-fast" default:"false"`
+	Quiet                 bool                `help:"Do not print the banner at startup, the build info is only logged. Implied by the prod runtime." name:"quiet" default:"false"`
+	TenantSeparator       string              `help:"Single character splitting the AUTH username into the tenant key and the user, e.g. ':' for usernames containing dots" name:"tenant-separator" default:"."`
 	BeConnPool            BackendPoolConfig   `embed:"" prefix:"backend-pool."`
+	Session               SessionConfig       `embed:"" prefix:"session."`
+	HotKey                HotKeyConfig        `embed:"" prefix:"hotkey."`
+	AuthCache             AuthCacheConfig     `embed:"" prefix:"auth-cache."`
+	Security              SecurityConfig      `embed:"" prefix:"security."`
 	Router                BackendRouterConfig `embed:"" prefix:"router."`
 	WebServer             WebServerConfig     `embed:"" prefix:"web-proxy."`
 	Node                  NodeConfig          `embed:"" prefix:"node."`
@@ -97,13 +196,85 @@ func (c *ProxyConfig) ServiceListener() net.Listener {
 	return lis
 }
 
+// HttpListener listens on the dedicated http port, it returns nil when http is served on the
+// service port.
+func (c *ProxyConfig) HttpListener() net.Listener {
+	if c.HttpPort == 0 {
+		return nil
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", c.HttpPort))
+	if err != nil {
+		panic(err)
+	}
+	return lis
+}
+
 func (c *ProxyConfig) Validate() error {
 	if c.ProxyPort <= 0 {
 		return fmt.Errorf("invalid port number: %d", c.ProxyPort)
 	}
+	if c.HttpPort < 0 {
+		return fmt.Errorf("invalid http port: %d", c.HttpPort)
+	}
+	if c.HttpPort == c.ProxyPort || c.HttpPort == 0 && c.ServicePort == c.ProxyPort {
+		return fmt.Errorf("the http server and the proxy cannot share port %d", c.ProxyPort)
+	}
+	if c.BeConnPool.DialTimeout <= 0 {
+		return fmt.Errorf("invalid backend dial timeout: %v", c.BeConnPool.DialTimeout)
+	}
+	if c.BeConnPool.ConnMaxLifetime < 0 {
+		return fmt.Errorf("invalid backend connection max lifetime: %v", c.BeConnPool.ConnMaxLifetime)
+	}
+	// the min size of a fixed pool is ignored, it is filled up to the max size
+	if c.BeConnPool.MinSize < 0 || !c.BeConnPool.IsFixed && c.BeConnPool.MinSize > c.BeConnPool.MaxSize {
+		return fmt.Errorf("invalid backend pool min size: %d", c.BeConnPool.MinSize)
+	}
+	if c.BeConnPool.MaxInFlight < 0 {
+		return fmt.Errorf("invalid backend max in flight: %d", c.BeConnPool.MaxInFlight)
+	}
+	if c.Session.OutQSize < 0 {
+		return fmt.Errorf("invalid session out queue size: %d", c.Session.OutQSize)
+	}
+	if c.Session.BufferSize < 0 {
+		return fmt.Errorf("invalid session buffer size: %d", c.Session.BufferSize)
+	}
+	if c.HotKey.SampleRate < 0 || c.HotKey.SampleRate > 1 {
+		return fmt.Errorf("invalid hot key sample rate: %v", c.HotKey.SampleRate)
+	}
+	if c.HotKey.SampleRate > 0 && c.HotKey.TopK <= 0 {
+		return fmt.Errorf("invalid hot key top-k: %d", c.HotKey.TopK)
+	}
+	if c.Security.AuthTimeout < 0 {
+		return fmt.Errorf("invalid auth timeout: %v", c.Security.AuthTimeout)
+	}
+	if !c.Security.RequireAuth && strings.ToLower(c.Router.RouterType) != "static" {
+		// the tenant of a client decides its cluster
+		return fmt.Errorf("clients must AUTH with router type: %s", c.Router.RouterType)
+	}
+	if c.AuthCache.Enable && c.AuthCache.TTL <= 0 {
+		return fmt.Errorf("invalid auth cache ttl: %v", c.AuthCache.TTL)
+	}
+	if c.MaxRequestSize < 0 {
+		return fmt.Errorf("invalid max request size: %d", c.MaxRequestSize)
+	}
+	if c.MaxRequestArgs <= 0 {
+		return fmt.Errorf("invalid max request args: %d", c.MaxRequestArgs)
+	}
+	if len(c.TenantSeparator) != 1 {
+		return fmt.Errorf("invalid tenant separator: %q, must be a single byte", c.TenantSeparator)
+	}
+	if c.Metrics.EnableMetrics && !strings.HasPrefix(c.Metrics.MetricsPath, "/") {
+		return fmt.Errorf("invalid metrics path: %q", c.Metrics.MetricsPath)
+	}
 	return c.Router.Validate()
 }
 
+// ShowBanner reports whether the banner is printed at startup. The prod runtime logs JSON, the
+// banner would be a malformed entry.
+func (c *ProxyConfig) ShowBanner() bool {
+	return !c.Quiet && !IsProdRuntime()
+}
+
 func (c *ProxyConfig) GNetOptions() []gnet.Option {
 	var ops []gnet.Option
 	if c.MultiCore {
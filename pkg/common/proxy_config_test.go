@@ -0,0 +1,129 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendRouterConfig_ValidateBalancer(t *testing.T) {
+	config := BackendRouterConfig{RouterType: "sync", CpAddr: "127.0.0.1:8080"}
+	for _, balancer := range []string{"", "tenant-hash", "Random", "ewma"} {
+		config.LBType = balancer
+		assert.NoError(t, config.Validate(), balancer)
+	}
+	// a typo, or a balancer that is not implemented, is not silently random
+	for _, balancer := range []string{"round_robin", "round-robin", "least-cluster"} {
+		config.LBType = balancer
+		err := config.Validate()
+		assert.Error(t, err, balancer)
+		assert.ErrorContains(t, err, "tenant-hash, random")
+	}
+}
+
+func TestBackendRouterConfig_ValidatePins(t *testing.T) {
+	config := BackendRouterConfig{RouterType: "sync", CpAddr: "127.0.0.1:8080"}
+	config.Pins = []string{"lock:*=10.0.0.1:6379", "queue:[ab]=10.0.0.2:6379"}
+	assert.NoError(t, config.Validate())
+	pattern, addr, err := ParseKeyPin(config.Pins[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "lock:*", pattern)
+	assert.Equal(t, "10.0.0.1:6379", addr)
+
+	for _, pin := range []string{"lock:*", "=10.0.0.1:6379", "lock:*="} {
+		config.Pins = []string{pin}
+		assert.Error(t, config.Validate(), pin)
+	}
+}
+
+func TestProxyConfig_ValidateRequireAuth(t *testing.T) {
+	config := ProxyConfig{
+		ProxyPort:       6378,
+		MaxRequestArgs:  1024,
+		TenantSeparator: ".",
+		BeConnPool:      BackendPoolConfig{DialTimeout: time.Second},
+		Router:          BackendRouterConfig{RouterType: "static", StaticBackend: "127.0.0.1:6379"},
+		Security:        SecurityConfig{RequireAuth: true},
+	}
+	assert.NoError(t, config.Validate())
+	config.Security.RequireAuth = false
+	assert.NoError(t, config.Validate())
+
+	// with the sync router the tenant of a client decides its cluster
+	config.Router = BackendRouterConfig{RouterType: "sync", CpAddr: "127.0.0.1:8080"}
+	assert.Error(t, config.Validate())
+	config.Security.RequireAuth = true
+	assert.NoError(t, config.Validate())
+}
+
+func TestProxyConfig_ValidateTenantSeparator(t *testing.T) {
+	config := ProxyConfig{
+		ProxyPort:       6378,
+		MaxRequestArgs:  1024,
+		TenantSeparator: ":",
+		BeConnPool:      BackendPoolConfig{DialTimeout: time.Second},
+		Router:          BackendRouterConfig{RouterType: "static", StaticBackend: "127.0.0.1:6379"},
+	}
+	assert.NoError(t, config.Validate())
+	for _, separator := range []string{"", "::", "é"} {
+		config.TenantSeparator = separator
+		assert.Error(t, config.Validate(), separator)
+	}
+}
+
+func TestProxyConfig_ValidatePorts(t *testing.T) {
+	config := ProxyConfig{
+		ProxyPort:       6378,
+		ServicePort:     7080,
+		MaxRequestArgs:  1024,
+		TenantSeparator: ".",
+		BeConnPool:      BackendPoolConfig{DialTimeout: time.Second},
+		Router:          BackendRouterConfig{RouterType: "static", StaticBackend: "127.0.0.1:6379"},
+	}
+	assert.NoError(t, config.Validate())
+	assert.Nil(t, config.HttpListener())
+	config.HttpPort = 7081
+	assert.NoError(t, config.Validate())
+
+	config.HttpPort = -1
+	assert.Error(t, config.Validate())
+	config.HttpPort = 6378
+	assert.Error(t, config.Validate())
+	// the service port only serves http without a dedicated port
+	config.HttpPort, config.ServicePort = 0, 6378
+	assert.Error(t, config.Validate())
+	config.HttpPort = 7081
+	assert.NoError(t, config.Validate())
+}
+
+func TestProxyConfig_ValidatePoolMinSize(t *testing.T) {
+	config := ProxyConfig{
+		ProxyPort:       6378,
+		MaxRequestArgs:  1024,
+		TenantSeparator: ".",
+		BeConnPool:      BackendPoolConfig{IsFixed: true, MaxSize: 4, MinSize: 8, DialTimeout: time.Second},
+		Router:          BackendRouterConfig{RouterType: "static", StaticBackend: "127.0.0.1:6379"},
+	}
+	// the min size of a fixed pool does not matter
+	assert.NoError(t, config.Validate())
+	config.BeConnPool.IsFixed = false
+	assert.Error(t, config.Validate())
+	config.BeConnPool.MinSize = 2
+	assert.NoError(t, config.Validate())
+	config.BeConnPool.MinSize = -1
+	assert.Error(t, config.Validate())
+}
+
+func TestProxyConfig_ShowBanner(t *testing.T) {
+	t.Setenv(ProxyRuntime, "dev")
+	config := ProxyConfig{}
+	assert.True(t, config.ShowBanner())
+	config.Quiet = true
+	assert.False(t, config.ShowBanner())
+
+	// the prod runtime logs JSON only
+	t.Setenv(ProxyRuntime, "prod")
+	config.Quiet = false
+	assert.False(t, config.ShowBanner())
+}
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// The build information, set at build time with
+// -ldflags "-X github.com/pzhenzhou/elika/pkg/common.Version=v0.1.0 ...".
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("Version: %s, GitCommit: %s, BuildDate: %s, GoVersion: %s",
+		b.Version, b.GitCommit, b.BuildDate, b.GoVersion)
+}
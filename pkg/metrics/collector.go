@@ -2,8 +2,10 @@ package metrics
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pzhenzhou/elika/pkg/common"
@@ -26,6 +28,7 @@ var (
 	logger = common.InitLogger().WithName("proxy-metrics")
 
 	instance      ProxyMetricsCollector
+	instanceErr   error
 	collectorOnce sync.Once
 )
 
@@ -85,6 +88,38 @@ type ProxyMetricsCollector interface {
 	// IncrementErrorCounter Error metrics
 	IncrementErrorCounter(errorType string)
 
+	// IncrementAuthCounter counts the AUTH outcomes of a tenant, auth_success or auth_failure
+	IncrementAuthCounter(tenant string, success bool)
+
+	// IncrementRerouteCounter counts a session bound to another backend connection in
+	// reroute_total, and in reroute_tx_contention_total if another transaction held its connection
+	IncrementRerouteCounter(txContention bool)
+
+	// IncrementDroppedRequests counts a request dropped instead of served in dropped_requests,
+	// labeled with the reason
+	IncrementDroppedRequests(reason string)
+
+	// RecordBackendDialLatency records the time to establish a backend connection in
+	// backend_dial_latency, labeled with the instance
+	RecordBackendDialLatency(instance string, duration time.Duration)
+
+	// IncrementBackendDialFailures counts a failed backend dial in backend_dial_failures,
+	// labeled with the cause
+	IncrementBackendDialFailures(cause string)
+
+	// SetQueueDepth Saturation metrics of the internal queues, owner is a backend or a tenant
+	SetQueueDepth(queue string, owner string, depth int)
+
+	// SetBuildInfo sets the build_info gauge to 1, labeled with the build information
+	SetBuildInfo(info common.BuildInfo)
+
+	// Snapshot returns the typed stats of the in-memory sink
+	Snapshot() (*Snapshot, error)
+
+	// Reset clears the in-memory metrics. Prometheus counters are cumulative by design and
+	// are not reset, rate() over them is unaffected by a restart or a reset anyway.
+	Reset()
+
 	// Shutdown the metrics collector
 	Shutdown()
 
@@ -150,84 +185,112 @@ func newPrometheusSink() (*prometheus.PrometheusSink, error) {
 	return promSink, nil
 }
 
-func newInMemSink(config *Config) *gometrics.InmemSink {
-	return gometrics.NewInmemSink(
-		config.AggregationInterval,
-		config.RetentionPeriod,
-	)
+func newInMemSink(config *Config) *resettableInmemSink {
+	sink := &resettableInmemSink{
+		interval: config.AggregationInterval,
+		retain:   config.RetentionPeriod,
+	}
+	sink.reset()
+	return sink
+}
+
+// ParseExposeSink maps the sink type of the proxy config to the sink exposing the metrics.
+func ParseExposeSink(sinkType string) (ExposeMetricSink, error) {
+	switch sinkType {
+	case "prometheus":
+		return PrometheusSink, nil
+	case "memory", string(InMemorySink):
+		return InMemorySink, nil
+	case "all":
+		return AllMetricsSink, nil
+	}
+	return "", fmt.Errorf("unknown metrics sink type: %s", sinkType)
 }
 
-// NewMetricsCollector creates a new metrics collector based on the provided config
+// NewMetricsCollector creates a new metrics collector based on the provided config. The
+// collector is created once, a later call returns the same collector or the same error.
 func NewMetricsCollector(config *Config) (ProxyMetricsCollector, error) {
-	var initErr error
 	collectorOnce.Do(func() {
-		if config == nil {
-			config = DefaultConfig()
-		}
-		// Create metrics configuration
-		metricsConf := gometrics.DefaultConfig(config.ServiceName)
-		// Create a fanout sink that will send metrics to multiple sinks if needed
-		sink := &fanoutSink{sinks: make([]gometrics.MetricSink, 0)}
-		var inm *gometrics.InmemSink
-		var promSink *prometheus.PrometheusSink
-		var err error
-		// Configure sinks based on the ExposeSink setting
-		switch config.ExposeSink {
-		case InMemorySink:
-			// Create a single in-memory sink instance and use it for both the collector and the fanout sink
-			inm = newInMemSink(config)
-			sink.sinks = append(sink.sinks, inm)
-		case PrometheusSink:
-			// Create Prometheus sink with custom buckets
-			promSink, err = newPrometheusSink()
-			if err != nil {
-				initErr = err
-				return
-			}
-			sink.sinks = append(sink.sinks, promSink)
-		case AllMetricsSink:
-			inm = newInMemSink(config)
-			promSink, err = newPrometheusSink()
-			if err != nil {
-				initErr = err
-				return
-			}
-			sink.sinks = append(sink.sinks, inm, promSink)
+		collector, err := newHashicorpMetricsCollector(config)
+		if err != nil {
+			instanceErr = err
+			return
 		}
+		instance = collector
+	})
 
-		// Create metrics instance with the sink
-		metricsImpl, err := gometrics.New(metricsConf, sink)
+	return instance, instanceErr
+}
+
+func newHashicorpMetricsCollector(config *Config) (*hashicorpMetricsCollector, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	// Create metrics configuration
+	metricsConf := gometrics.DefaultConfig(config.ServiceName)
+	// Create a fanout sink that will send metrics to multiple sinks if needed
+	sink := &fanoutSink{sinks: make([]gometrics.MetricSink, 0)}
+	var inm *resettableInmemSink
+	var promSink *prometheus.PrometheusSink
+	var err error
+	// Configure sinks based on the ExposeSink setting
+	switch config.ExposeSink {
+	case InMemorySink:
+		// Create a single in-memory sink instance and use it for both the collector and the fanout sink
+		inm = newInMemSink(config)
+		sink.sinks = append(sink.sinks, inm)
+	case PrometheusSink:
+		// Create Prometheus sink with custom buckets
+		promSink, err = newPrometheusSink()
 		if err != nil {
-			initErr = err
-			return
+			return nil, err
 		}
-		instance = &hashicorpMetricsCollector{
-			metrics:            metricsImpl,
-			inm:                inm,
-			promSink:           promSink,
-			exposeSink:         config.ExposeSink,
-			metricsEndpoint:    config.MetricsEndpoint,
-			serviceName:        config.ServiceName,
-			serviceLabel:       gometrics.Label{Name: "service", Value: config.ServiceName},
-			commandLabelPrefix: "command",
-			errorLabelPrefix:   "type",
-			labelPool:          newLabelPool(),
+		sink.sinks = append(sink.sinks, promSink)
+	case AllMetricsSink:
+		inm = newInMemSink(config)
+		promSink, err = newPrometheusSink()
+		if err != nil {
+			return nil, err
 		}
+		sink.sinks = append(sink.sinks, inm, promSink)
+	default:
+		return nil, fmt.Errorf("unknown metrics sink: %s", config.ExposeSink)
+	}
 
-		// Log that the metrics collector has been initialized
-		logger.Info("Metrics collector initialized",
-			"serviceName", config.ServiceName,
-			"sink", config.ExposeSink,
-			"endpoint", config.MetricsEndpoint)
-	})
+	// Create metrics instance with the sink
+	metricsImpl, err := gometrics.New(metricsConf, sink)
+	if err != nil {
+		return nil, err
+	}
+	collector := &hashicorpMetricsCollector{
+		metrics:            metricsImpl,
+		inm:                inm,
+		promSink:           promSink,
+		exposeSink:         config.ExposeSink,
+		metricsEndpoint:    config.MetricsEndpoint,
+		serviceName:        config.ServiceName,
+		serviceLabel:       gometrics.Label{Name: "service", Value: config.ServiceName},
+		commandLabelPrefix: "command",
+		errorLabelPrefix:   "type",
+		labelPool:          newLabelPool(),
+	}
+	if inm != nil {
+		collector.overallLatency = newLatencyWindow(latencyWindowSize)
+		collector.commandLatency = newCommandLatencies()
+	}
 
-	return instance, initErr
+	// Log that the metrics collector has been initialized
+	logger.Info("Metrics collector initialized",
+		"serviceName", config.ServiceName,
+		"sink", config.ExposeSink,
+		"endpoint", config.MetricsEndpoint)
+	return collector, nil
 }
 
 // hashicorpMetricsCollector implements ProxyMetricsCollector using hashicorp/go-metrics
 type hashicorpMetricsCollector struct {
 	metrics         *gometrics.Metrics
-	inm             *gometrics.InmemSink
+	inm             *resettableInmemSink
 	promSink        *prometheus.PrometheusSink
 	exposeSink      ExposeMetricSink
 	metricsEndpoint string
@@ -240,20 +303,35 @@ type hashicorpMetricsCollector struct {
 
 	// Object pool for label slices
 	labelPool *labelPool
+	// overallLatency keeps recent samples for the percentiles of Snapshot, nil without inm
+	overallLatency *latencyWindow
+	// commandLatency keeps recent samples per command, nil without inm
+	commandLatency *commandLatencies
+	// closed drops the records after Shutdown, the sinks may be released already
+	closed atomic.Bool
 }
 
 // RecordCommandLatency records the end-to-end latency (client <-> proxy <-> backend)
 func (h *hashicorpMetricsCollector) RecordCommandLatency(command string, duration time.Duration) {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel, gometrics.Label{Name: h.commandLabelPrefix, Value: command})
 
 	h.metrics.AddSampleWithLabels([]string{"command", "end_to_end_latency"}, float32(duration.Microseconds()), labels)
+	if h.commandLatency != nil {
+		h.commandLatency.add(command, float32(duration.Microseconds()))
+	}
 
 	h.labelPool.put(labels)
 }
 
 // RecordCommandForwardingLatency records the forwarding latency (proxy <-> backend)
 func (h *hashicorpMetricsCollector) RecordCommandForwardingLatency(command string, duration time.Duration) {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel, gometrics.Label{Name: h.commandLabelPrefix, Value: command})
 
@@ -264,16 +342,25 @@ func (h *hashicorpMetricsCollector) RecordCommandForwardingLatency(command strin
 
 // RecordOverallLatency records the end-to-end latency across all commands
 func (h *hashicorpMetricsCollector) RecordOverallLatency(duration time.Duration) {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel)
 
 	h.metrics.AddSampleWithLabels([]string{"overall", "end_to_end_latency"}, float32(duration.Microseconds()), labels)
+	if h.overallLatency != nil {
+		h.overallLatency.add(float32(duration.Microseconds()))
+	}
 
 	h.labelPool.put(labels)
 }
 
 // RecordOverallForwardingLatency records the forwarding latency across all commands
 func (h *hashicorpMetricsCollector) RecordOverallForwardingLatency(duration time.Duration) {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel)
 
@@ -284,6 +371,9 @@ func (h *hashicorpMetricsCollector) RecordOverallForwardingLatency(duration time
 
 // IncrementActiveConnections increments the active connections counter
 func (h *hashicorpMetricsCollector) IncrementActiveConnections() {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel)
 
@@ -294,6 +384,9 @@ func (h *hashicorpMetricsCollector) IncrementActiveConnections() {
 
 // DecrementActiveConnections decrements the active connections counter
 func (h *hashicorpMetricsCollector) DecrementActiveConnections() {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel)
 
@@ -304,6 +397,9 @@ func (h *hashicorpMetricsCollector) DecrementActiveConnections() {
 
 // IncrementCommandCounter increments the counter for a specific command
 func (h *hashicorpMetricsCollector) IncrementCommandCounter(command string) {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel, gometrics.Label{Name: h.commandLabelPrefix, Value: command})
 
@@ -314,6 +410,9 @@ func (h *hashicorpMetricsCollector) IncrementCommandCounter(command string) {
 
 // IncrementCounter increments a counter with a custom label
 func (h *hashicorpMetricsCollector) IncrementCounter(label string) {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel)
 
@@ -324,6 +423,9 @@ func (h *hashicorpMetricsCollector) IncrementCounter(label string) {
 
 // IncrementErrorCounter increments the counter for a specific error type
 func (h *hashicorpMetricsCollector) IncrementErrorCounter(errorType string) {
+	if h.closed.Load() {
+		return
+	}
 	labels := h.labelPool.get()
 	labels = append(labels, h.serviceLabel, gometrics.Label{Name: h.errorLabelPrefix, Value: errorType})
 
@@ -332,6 +434,110 @@ func (h *hashicorpMetricsCollector) IncrementErrorCounter(errorType string) {
 	h.labelPool.put(labels)
 }
 
+// IncrementAuthCounter increments auth_success or auth_failure for the tenant
+func (h *hashicorpMetricsCollector) IncrementAuthCounter(tenant string, success bool) {
+	if h.closed.Load() {
+		return
+	}
+	result := "auth_failure"
+	if success {
+		result = "auth_success"
+	}
+	labels := h.labelPool.get()
+	labels = append(labels, h.serviceLabel, gometrics.Label{Name: "tenant", Value: tenant})
+
+	h.metrics.IncrCounterWithLabels([]string{result}, 1, labels)
+
+	h.labelPool.put(labels)
+}
+
+// IncrementRerouteCounter increments reroute_total, and reroute_tx_contention_total on a
+// transaction contention
+func (h *hashicorpMetricsCollector) IncrementRerouteCounter(txContention bool) {
+	if h.closed.Load() {
+		return
+	}
+	labels := h.labelPool.get()
+	labels = append(labels, h.serviceLabel)
+
+	h.metrics.IncrCounterWithLabels([]string{"reroute_total"}, 1, labels)
+	if txContention {
+		h.metrics.IncrCounterWithLabels([]string{"reroute_tx_contention_total"}, 1, labels)
+	}
+
+	h.labelPool.put(labels)
+}
+
+// IncrementDroppedRequests increments dropped_requests for the reason
+func (h *hashicorpMetricsCollector) IncrementDroppedRequests(reason string) {
+	if h.closed.Load() {
+		return
+	}
+	labels := h.labelPool.get()
+	labels = append(labels, h.serviceLabel, gometrics.Label{Name: "reason", Value: reason})
+
+	h.metrics.IncrCounterWithLabels([]string{"dropped_requests"}, 1, labels)
+
+	h.labelPool.put(labels)
+}
+
+// RecordBackendDialLatency records the dial latency of a backend instance
+func (h *hashicorpMetricsCollector) RecordBackendDialLatency(instance string, duration time.Duration) {
+	if h.closed.Load() {
+		return
+	}
+	labels := h.labelPool.get()
+	labels = append(labels, h.serviceLabel, gometrics.Label{Name: "instance", Value: instance})
+
+	h.metrics.AddSampleWithLabels([]string{"backend_dial_latency"}, float32(duration.Microseconds()), labels)
+
+	h.labelPool.put(labels)
+}
+
+// IncrementBackendDialFailures increments backend_dial_failures for the cause
+func (h *hashicorpMetricsCollector) IncrementBackendDialFailures(cause string) {
+	if h.closed.Load() {
+		return
+	}
+	labels := h.labelPool.get()
+	labels = append(labels, h.serviceLabel, gometrics.Label{Name: "cause", Value: cause})
+
+	h.metrics.IncrCounterWithLabels([]string{"backend_dial_failures"}, 1, labels)
+
+	h.labelPool.put(labels)
+}
+
+// SetQueueDepth sets the gauge of an internal queue length
+func (h *hashicorpMetricsCollector) SetQueueDepth(queue string, owner string, depth int) {
+	if h.closed.Load() {
+		return
+	}
+	labels := h.labelPool.get()
+	labels = append(labels, h.serviceLabel, gometrics.Label{Name: "queue", Value: queue},
+		gometrics.Label{Name: "owner", Value: owner})
+
+	h.metrics.SetGaugeWithLabels([]string{"queue", "depth"}, float32(depth), labels)
+
+	h.labelPool.put(labels)
+}
+
+// SetBuildInfo sets the build_info gauge. Gauges expire from the Prometheus sink, it is set
+// again with every sampling of the queue depths.
+func (h *hashicorpMetricsCollector) SetBuildInfo(info common.BuildInfo) {
+	if h.closed.Load() {
+		return
+	}
+	labels := h.labelPool.get()
+	labels = append(labels, h.serviceLabel, gometrics.Label{Name: "version", Value: info.Version},
+		gometrics.Label{Name: "git_commit", Value: info.GitCommit},
+		gometrics.Label{Name: "build_date", Value: info.BuildDate},
+		gometrics.Label{Name: "go_version", Value: info.GoVersion})
+
+	h.metrics.SetGaugeWithLabels([]string{"build", "info"}, 1, labels)
+
+	h.labelPool.put(labels)
+}
+
 // CollectorHandler returns an HTTP handler for metrics based on the configured sink
 func (h *hashicorpMetricsCollector) CollectorHandler() http.Handler {
 	logger.Info("Creating metrics handler", "sink", h.exposeSink)
@@ -386,6 +592,64 @@ func (h *hashicorpMetricsCollector) InMemoryHandler() http.Handler {
 	})
 }
 
+// Reset replaces the in-memory sink by an empty one and drops the latency samples.
+func (h *hashicorpMetricsCollector) Reset() {
+	if h.inm == nil {
+		return
+	}
+	h.inm.reset()
+	h.overallLatency.reset()
+	h.commandLatency.reset()
+	logger.Info("In-memory metrics reset", "sink", h.exposeSink)
+}
+
+// resettableInmemSink delegates to an InmemSink that Reset can swap while metrics are recorded.
+type resettableInmemSink struct {
+	current  atomic.Pointer[gometrics.InmemSink]
+	interval time.Duration
+	retain   time.Duration
+}
+
+func (r *resettableInmemSink) reset() {
+	r.current.Store(gometrics.NewInmemSink(r.interval, r.retain))
+}
+
+func (r *resettableInmemSink) Data() []*gometrics.IntervalMetrics {
+	return r.current.Load().Data()
+}
+
+func (r *resettableInmemSink) DisplayMetrics(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return r.current.Load().DisplayMetrics(resp, req)
+}
+
+func (r *resettableInmemSink) SetGauge(key []string, val float32) {
+	r.current.Load().SetGauge(key, val)
+}
+
+func (r *resettableInmemSink) SetGaugeWithLabels(key []string, val float32, labels []gometrics.Label) {
+	r.current.Load().SetGaugeWithLabels(key, val, labels)
+}
+
+func (r *resettableInmemSink) EmitKey(key []string, val float32) {
+	r.current.Load().EmitKey(key, val)
+}
+
+func (r *resettableInmemSink) IncrCounter(key []string, val float32) {
+	r.current.Load().IncrCounter(key, val)
+}
+
+func (r *resettableInmemSink) IncrCounterWithLabels(key []string, val float32, labels []gometrics.Label) {
+	r.current.Load().IncrCounterWithLabels(key, val, labels)
+}
+
+func (r *resettableInmemSink) AddSample(key []string, val float32) {
+	r.current.Load().AddSample(key, val)
+}
+
+func (r *resettableInmemSink) AddSampleWithLabels(key []string, val float32, labels []gometrics.Label) {
+	r.current.Load().AddSampleWithLabels(key, val, labels)
+}
+
 // fanoutSink implements a sink that forwards to multiple sinks
 type fanoutSink struct {
 	sinks []gometrics.MetricSink
@@ -433,6 +697,15 @@ func (f *fanoutSink) AddSampleWithLabels(key []string, val float32, labels []gom
 	}
 }
 
+// Shutdown flushes the sinks supporting it
+func (f *fanoutSink) Shutdown() {
+	for _, s := range f.sinks {
+		if ss, ok := s.(gometrics.ShutdownSink); ok {
+			ss.Shutdown()
+		}
+	}
+}
+
 // promHandler returns the Prometheus HTTP handler
 func promHandler() http.Handler {
 	// Create a simple handler that serves Prometheus metrics
@@ -443,9 +716,14 @@ func promHandler() http.Handler {
 	})
 }
 
-// Shutdown stops the metrics collector
+// Shutdown stops recording and flushes the sinks. It is called once the proxy has drained its
+// sessions, right before exit.
 func (h *hashicorpMetricsCollector) Shutdown() {
-	// Nothing to do for now, but could be used to flush metrics or clean up resources
+	if !h.closed.CompareAndSwap(false, true) {
+		return
+	}
+	h.metrics.Shutdown()
+	logger.Info("Metrics collector shutdown", "sink", h.exposeSink)
 }
 
 // Handler returns a Gin handler function for exposing metrics
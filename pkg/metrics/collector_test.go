@@ -0,0 +1,252 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	gometrics "github.com/hashicorp/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector_Shutdown(t *testing.T) {
+	collector, err := newHashicorpMetricsCollector(NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	counted := func() int {
+		total := 0
+		for _, interval := range collector.inm.Data() {
+			interval.RLock()
+			for _, counter := range interval.Counters {
+				total += counter.Count
+			}
+			interval.RUnlock()
+		}
+		return total
+	}
+
+	collector.IncrementCounter("requests")
+	assert.Equal(t, 1, counted())
+
+	collector.Shutdown()
+	assert.NotPanics(t, func() {
+		collector.IncrementCounter("requests")
+		collector.IncrementErrorCounter("timeout")
+		collector.SetQueueDepth("out_q", "tenant", 1)
+		collector.Shutdown()
+	})
+	assert.Equal(t, 1, counted())
+}
+
+func TestCollector_Snapshot(t *testing.T) {
+	collector, err := newHashicorpMetricsCollector(NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	defer collector.Shutdown()
+
+	for i := 1; i <= 100; i++ {
+		collector.IncrementCommandCounter("GET")
+		collector.RecordOverallLatency(time.Duration(i) * time.Microsecond)
+	}
+	collector.IncrementCommandCounter("SET")
+	collector.IncrementErrorCounter("dispatch_error")
+	collector.IncrementErrorCounter("dispatch_error")
+
+	snapshot, err := collector.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(101), snapshot.TotalCommands)
+	assert.Equal(t, map[string]int64{"dispatch_error": 2}, snapshot.Errors)
+	assert.Equal(t, float64(50), snapshot.LatencyP50Us)
+	assert.Equal(t, float64(99), snapshot.LatencyP99Us)
+
+	// a Prometheus only collector has no in-memory sink
+	_, err = (&hashicorpMetricsCollector{exposeSink: PrometheusSink}).Snapshot()
+	assert.ErrorIs(t, err, ErrNoInMemorySink)
+}
+
+func TestCollector_CommandLatency(t *testing.T) {
+	collector, err := newHashicorpMetricsCollector(NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	defer collector.Shutdown()
+
+	// GET: 1..1000us, SET: a fast majority and a slow tail
+	for i := 1; i <= 1000; i++ {
+		collector.RecordCommandLatency("GET", time.Duration(i)*time.Microsecond)
+	}
+	for i := 0; i < 95; i++ {
+		collector.RecordCommandLatency("set", 100*time.Microsecond)
+	}
+	for i := 0; i < 5; i++ {
+		collector.RecordCommandLatency("SET", 10*time.Millisecond)
+	}
+
+	snapshot, err := collector.Snapshot()
+	assert.NoError(t, err)
+	get := snapshot.Commands["get"]
+	assert.Equal(t, 1000, get.Samples)
+	assert.InDelta(t, 500, get.P50Us, 10)
+	assert.InDelta(t, 900, get.P90Us, 10)
+	assert.InDelta(t, 990, get.P99Us, 10)
+	assert.Equal(t, float64(1000), get.MaxUs)
+	set := snapshot.Commands["set"]
+	assert.Equal(t, 100, set.Samples)
+	assert.Equal(t, float64(100), set.P50Us)
+	assert.Equal(t, float64(100), set.P90Us)
+	assert.Equal(t, float64(10000), set.P99Us)
+
+	// the names come from the clients, the number of windows is bounded
+	for i := 0; i < 2*maxLatencyCommands; i++ {
+		collector.RecordCommandLatency(fmt.Sprintf("unknown-%d", i), time.Microsecond)
+	}
+	snapshot, err = collector.Snapshot()
+	assert.NoError(t, err)
+	assert.Len(t, snapshot.Commands, maxLatencyCommands)
+
+	collector.Reset()
+	snapshot, err = collector.Snapshot()
+	assert.NoError(t, err)
+	assert.Empty(t, snapshot.Commands)
+}
+
+func TestCollector_Reset(t *testing.T) {
+	collector, err := newHashicorpMetricsCollector(NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	defer collector.Shutdown()
+
+	collector.IncrementCommandCounter("GET")
+	collector.IncrementErrorCounter("dispatch_error")
+	collector.RecordOverallLatency(time.Millisecond)
+	snapshot, err := collector.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), snapshot.TotalCommands)
+
+	collector.Reset()
+	snapshot, err = collector.Snapshot()
+	assert.NoError(t, err)
+	assert.Zero(t, snapshot.TotalCommands)
+	assert.Empty(t, snapshot.Errors)
+	assert.Zero(t, snapshot.LatencyP99Us)
+
+	// recording goes on into the new sink
+	collector.IncrementCommandCounter("GET")
+	snapshot, err = collector.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), snapshot.TotalCommands)
+}
+
+func TestMiddleware_TrackAuth(t *testing.T) {
+	collector, err := newHashicorpMetricsCollector(NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	defer collector.Shutdown()
+	middleware := NewProxyMetricsMiddleware(collector)
+
+	middleware.TrackAuth("tenant-a", false)
+	middleware.TrackAuth("tenant-a", true)
+	middleware.TrackAuth("tenant-a", true)
+	middleware.TrackAuth("unknown", false)
+
+	counts := make(map[string]int)
+	data, err := collector.inm.DisplayMetrics(nil, nil)
+	assert.NoError(t, err)
+	for _, counter := range data.(gometrics.MetricsSummary).Counters {
+		if tenant, ok := counter.DisplayLabels["tenant"]; ok {
+			counts[counter.Name+"/"+tenant] = counter.Count
+		}
+	}
+	assert.Equal(t, map[string]int{
+		"elika-test.auth_success/tenant-a": 2,
+		"elika-test.auth_failure/tenant-a": 1,
+		"elika-test.auth_failure/unknown":  1,
+	}, counts)
+
+	// the failures show in the errors of the stats
+	snapshot, err := collector.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"auth_failure": 2}, snapshot.Errors)
+}
+
+func TestMiddleware_TrackReroute(t *testing.T) {
+	collector, err := newHashicorpMetricsCollector(NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	defer collector.Shutdown()
+	middleware := NewProxyMetricsMiddleware(collector)
+
+	middleware.TrackReroute(false)
+	middleware.TrackReroute(true)
+
+	counts := make(map[string]int)
+	data, err := collector.inm.DisplayMetrics(nil, nil)
+	assert.NoError(t, err)
+	for _, counter := range data.(gometrics.MetricsSummary).Counters {
+		counts[counter.Name] = counter.Count
+	}
+	assert.Equal(t, 2, counts["elika-test.reroute_total"])
+	assert.Equal(t, 1, counts["elika-test.reroute_tx_contention_total"])
+}
+
+func TestMiddleware_TrackDroppedRequest(t *testing.T) {
+	collector, err := newHashicorpMetricsCollector(NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	defer collector.Shutdown()
+	middleware := NewProxyMetricsMiddleware(collector)
+
+	middleware.TrackDroppedRequest("pool_closed")
+	middleware.TrackDroppedRequest("session_closed")
+	middleware.TrackDroppedRequest("session_closed")
+
+	counts := make(map[string]int)
+	data, err := collector.inm.DisplayMetrics(nil, nil)
+	assert.NoError(t, err)
+	for _, counter := range data.(gometrics.MetricsSummary).Counters {
+		if counter.Name == "elika-test.dropped_requests" {
+			counts[counter.DisplayLabels["reason"]] += counter.Count
+		}
+	}
+	assert.Equal(t, map[string]int{"pool_closed": 1, "session_closed": 2}, counts)
+}
+
+func TestMiddleware_TrackBackendDial(t *testing.T) {
+	collector, err := newHashicorpMetricsCollector(NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	defer collector.Shutdown()
+	middleware := NewProxyMetricsMiddleware(collector)
+
+	middleware.TrackBackendDial("10.0.0.1:6379", time.Millisecond, "")
+	middleware.TrackBackendDial("10.0.0.1:6379", 3*time.Second, "timeout")
+	middleware.TrackBackendDial("10.0.0.2:6379", time.Millisecond, "refused")
+
+	failures, samples := make(map[string]int), make(map[string]int)
+	data, err := collector.inm.DisplayMetrics(nil, nil)
+	assert.NoError(t, err)
+	summary := data.(gometrics.MetricsSummary)
+	for _, counter := range summary.Counters {
+		if counter.Name == "elika-test.backend_dial_failures" {
+			failures[counter.DisplayLabels["cause"]] += counter.Count
+		}
+	}
+	for _, sample := range summary.Samples {
+		if sample.Name == "elika-test.backend_dial_latency" {
+			samples[sample.DisplayLabels["instance"]] += sample.Count
+		}
+	}
+	assert.Equal(t, map[string]int{"timeout": 1, "refused": 1}, failures)
+	assert.Equal(t, map[string]int{"10.0.0.1:6379": 2, "10.0.0.2:6379": 1}, samples)
+}
+
+func TestNewMetricsCollector_UnknownSink(t *testing.T) {
+	_, err := ParseExposeSink("graphite")
+	assert.Error(t, err)
+	sink, err := ParseExposeSink("memory")
+	assert.NoError(t, err)
+	assert.Equal(t, InMemorySink, sink)
+
+	config := DefaultConfig()
+	config.ExposeSink = "graphite"
+	assert.NotPanics(t, func() {
+		collector, err := NewMetricsCollector(config)
+		assert.Error(t, err)
+		assert.Nil(t, collector)
+		// a second call must not return a nil collector without the error
+		collector, err = NewMetricsCollector(DefaultConfig())
+		assert.Error(t, err)
+		assert.Nil(t, collector)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/panjf2000/gnet/v2"
 	"time"
 
+	"github.com/pzhenzhou/elika/pkg/common"
 	"github.com/pzhenzhou/elika/pkg/respio"
 )
 
@@ -84,6 +85,43 @@ func (m *ProxyMetricsMiddleWare) TrackError(errorType string) {
 	m.collector.IncrementErrorCounter(errorType)
 }
 
+// TrackAuth counts the outcome of a client AUTH, a failure is also counted as an error
+func (m *ProxyMetricsMiddleWare) TrackAuth(tenant string, success bool) {
+	m.collector.IncrementAuthCounter(tenant, success)
+	if !success {
+		m.collector.IncrementErrorCounter("auth_failure")
+	}
+}
+
+// TrackReroute counts a session moved to another backend connection
+func (m *ProxyMetricsMiddleWare) TrackReroute(txContention bool) {
+	m.collector.IncrementRerouteCounter(txContention)
+}
+
+// TrackDroppedRequest counts a request dropped because its pool, its session or its backend
+// connection closed
+func (m *ProxyMetricsMiddleWare) TrackDroppedRequest(reason string) {
+	m.collector.IncrementDroppedRequests(reason)
+}
+
+// TrackBackendDial records the latency of a backend dial, and its cause when it failed
+func (m *ProxyMetricsMiddleWare) TrackBackendDial(instance string, duration time.Duration, failureCause string) {
+	m.collector.RecordBackendDialLatency(instance, duration)
+	if failureCause != "" {
+		m.collector.IncrementBackendDialFailures(failureCause)
+	}
+}
+
+// TrackQueueDepth records the sampled length of an internal queue
+func (m *ProxyMetricsMiddleWare) TrackQueueDepth(queue string, owner string, depth int) {
+	m.collector.SetQueueDepth(queue, owner, depth)
+}
+
+// TrackBuildInfo records the build information of the proxy
+func (m *ProxyMetricsMiddleWare) TrackBuildInfo(info common.BuildInfo) {
+	m.collector.SetBuildInfo(info)
+}
+
 // WrapDispatch wraps the command dispatch process with metrics
 func (m *ProxyMetricsMiddleWare) WrapDispatch(packet *respio.RespPacket, fn func() error) error {
 	// Convert []byte to string for the command
@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	gometrics "github.com/hashicorp/go-metrics"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+const (
+	// latencyWindowSize bounds the overall latency samples kept for the percentiles
+	latencyWindowSize = 4096
+	// commandLatencyWindowSize bounds the latency samples kept per command
+	commandLatencyWindowSize = 1024
+	// maxLatencyCommands bounds the commands with a latency window. The names are sent by the
+	// clients, a typo or an unknown command must not allocate a window each.
+	maxLatencyCommands = 256
+)
+
+// ErrNoInMemorySink is returned by Snapshot when the collector only exports to Prometheus
+var ErrNoInMemorySink = errors.New("in-memory metrics sink is not enabled")
+
+// Snapshot is a typed view of the in-memory metrics. The counters cover the interval starting
+// at Interval, the latency percentiles the most recent commands.
+type Snapshot struct {
+	Interval      string           `json:"interval"`
+	TotalCommands int64            `json:"total_commands"`
+	Errors        map[string]int64 `json:"errors"`
+	LatencyP50Us  float64          `json:"latency_p50_us"`
+	LatencyP99Us  float64          `json:"latency_p99_us"`
+	// Commands holds the latency distribution per lower case command name, it is only recorded
+	// with the command latency enabled in the metrics middleware
+	Commands map[string]CommandLatency `json:"commands"`
+}
+
+// CommandLatency is the end-to-end latency distribution of the recent samples of a command.
+type CommandLatency struct {
+	Samples int     `json:"samples"`
+	P50Us   float64 `json:"p50_us"`
+	P90Us   float64 `json:"p90_us"`
+	P99Us   float64 `json:"p99_us"`
+	MaxUs   float64 `json:"max_us"`
+}
+
+// latencyWindow keeps the last size samples. The in-memory sink only aggregates count, sum, min
+// and max, which is not enough for percentiles.
+type latencyWindow struct {
+	mu      sync.Mutex
+	size    int
+	samples []float32
+	next    int
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{size: size, samples: make([]float32, 0, size)}
+}
+
+func (w *latencyWindow) add(val float32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < w.size {
+		w.samples = append(w.samples, val)
+		return
+	}
+	w.samples[w.next] = val
+	w.next = (w.next + 1) % w.size
+}
+
+func (w *latencyWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples = w.samples[:0]
+	w.next = 0
+}
+
+// percentiles returns the nearest-rank percentile of each q in [0, 1], 0 without samples, and
+// the number of samples they were computed from.
+func (w *latencyWindow) percentiles(qs ...float64) ([]float64, int) {
+	w.mu.Lock()
+	sorted := slices.Clone(w.samples)
+	w.mu.Unlock()
+	slices.Sort(sorted)
+	result := make([]float64, len(qs))
+	if len(sorted) == 0 {
+		return result, 0
+	}
+	for i, q := range qs {
+		rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+		result[i] = float64(sorted[max(rank, 0)])
+	}
+	return result, len(sorted)
+}
+
+// commandLatencies keeps a latency window per command.
+type commandLatencies struct {
+	windows *xsync.MapOf[string, *latencyWindow]
+}
+
+func newCommandLatencies() *commandLatencies {
+	return &commandLatencies{windows: xsync.NewMapOf[string, *latencyWindow]()}
+}
+
+func (c *commandLatencies) add(command string, val float32) {
+	command = strings.ToLower(command)
+	window, ok := c.windows.Load(command)
+	if !ok {
+		if c.windows.Size() >= maxLatencyCommands {
+			return
+		}
+		window, _ = c.windows.LoadOrCompute(command, func() *latencyWindow {
+			return newLatencyWindow(commandLatencyWindowSize)
+		})
+	}
+	window.add(val)
+}
+
+func (c *commandLatencies) reset() {
+	c.windows.Clear()
+}
+
+func (c *commandLatencies) summary() map[string]CommandLatency {
+	result := make(map[string]CommandLatency)
+	c.windows.Range(func(command string, window *latencyWindow) bool {
+		quantiles, samples := window.percentiles(0.5, 0.9, 0.99, 1)
+		if samples > 0 {
+			result[command] = CommandLatency{
+				Samples: samples,
+				P50Us:   quantiles[0],
+				P90Us:   quantiles[1],
+				P99Us:   quantiles[2],
+				MaxUs:   quantiles[3],
+			}
+		}
+		return true
+	})
+	return result
+}
+
+// Snapshot builds the typed stats from the in-memory sink.
+func (h *hashicorpMetricsCollector) Snapshot() (*Snapshot, error) {
+	if h.inm == nil {
+		return nil, ErrNoInMemorySink
+	}
+	data, err := h.inm.DisplayMetrics(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	summary := data.(gometrics.MetricsSummary)
+	snapshot := &Snapshot{
+		Interval: summary.Timestamp,
+		Errors:   make(map[string]int64),
+	}
+	commandKey, errorKey := h.metricName("command", "count"), h.metricName("errors")
+	for _, counter := range summary.Counters {
+		switch counter.Name {
+		case commandKey:
+			snapshot.TotalCommands += int64(counter.Sum)
+		case errorKey:
+			snapshot.Errors[counter.DisplayLabels[h.errorLabelPrefix]] += int64(counter.Sum)
+		}
+	}
+	quantiles, _ := h.overallLatency.percentiles(0.5, 0.99)
+	snapshot.LatencyP50Us, snapshot.LatencyP99Us = quantiles[0], quantiles[1]
+	snapshot.Commands = h.commandLatency.summary()
+	return snapshot, nil
+}
+
+// metricName returns the name the sink reports for a key, prefixed by the service name.
+func (h *hashicorpMetricsCollector) metricName(key ...string) string {
+	if h.serviceName != "" {
+		key = append([]string{h.serviceName}, key...)
+	}
+	return strings.Join(key, ".")
+}
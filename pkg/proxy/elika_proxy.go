@@ -1,7 +1,9 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/panjf2000/gnet/v2"
 	"github.com/pzhenzhou/elika/pkg/be_cluster"
@@ -9,6 +11,8 @@ import (
 	"github.com/pzhenzhou/elika/pkg/metrics"
 	"github.com/pzhenzhou/elika/pkg/respio"
 	"io"
+	"strings"
+	"time"
 )
 
 const (
@@ -26,6 +30,8 @@ const (
 
 var (
 	logger = common.InitLogger().WithName("proxy-srv")
+	// queueSampleInterval is the period of the queue depth sampling
+	queueSampleInterval = 5 * time.Second
 )
 
 type ElikaProxyServer struct {
@@ -33,19 +39,43 @@ type ElikaProxyServer struct {
 	eng               *gnet.Engine
 	config            *common.ProxyConfig
 	sessionMgr        *be_cluster.SessionManager
+	registry          be_cluster.ClusterRegistry
+	allowlist         *be_cluster.CommandAllowlist
+	adminTenants      *be_cluster.AdminTenants
 	metricsMiddleware *metrics.ProxyMetricsMiddleWare
+	quit              chan struct{}
 }
 
 func NewElikaProxy(config *common.ProxyConfig) *ElikaProxyServer {
 	proxySrv := &ElikaProxyServer{
-		config:     config,
-		sessionMgr: be_cluster.NewSessionManager(config),
+		config:       config,
+		sessionMgr:   be_cluster.NewSessionManager(config),
+		registry:     be_cluster.GetClusterRegistry(),
+		allowlist:    be_cluster.NewCommandAllowlist(config.Security.AllowedCmds),
+		adminTenants: be_cluster.NewAdminTenants(config.Security.AdminTenants),
+		quit:         make(chan struct{}),
 	}
 	return proxySrv
 }
 
 func (p *ElikaProxyServer) SetMetricsMiddleware(middleware *metrics.ProxyMetricsMiddleWare) {
 	p.metricsMiddleware = middleware
+	p.sessionMgr.SetAuthListener(middleware.TrackAuth)
+	p.sessionMgr.SetRerouteListener(middleware.TrackReroute)
+	be_cluster.SetDropListener(func(reason be_cluster.DropReason) {
+		middleware.TrackDroppedRequest(string(reason))
+	})
+	be_cluster.SetDialListener(func(addr string, latency time.Duration, err error) {
+		var cause string
+		if err != nil {
+			cause = string(be_cluster.DialFailureCauseOf(err))
+		}
+		middleware.TrackBackendDial(addr, latency, cause)
+	})
+}
+
+func (p *ElikaProxyServer) SessionManager() *be_cluster.SessionManager {
+	return p.sessionMgr
 }
 
 func (p *ElikaProxyServer) Start() error {
@@ -53,6 +83,9 @@ func (p *ElikaProxyServer) Start() error {
 	opts = append(opts, gnet.WithReuseAddr(true), gnet.WithReusePort(true))
 	proxyAddr := fmt.Sprintf("tcp://:%d", p.config.ProxyPort)
 	logger.Info("Starting ElikaProxy", "address", proxyAddr)
+	if p.metricsMiddleware != nil {
+		go p.sampleQueueDepths()
+	}
 	var err error
 	if len(opts) > 0 {
 		err = gnet.Run(p, proxyAddr, opts...)
@@ -62,6 +95,26 @@ func (p *ElikaProxyServer) Start() error {
 	return err
 }
 
+// sampleQueueDepths periodically exports the internal queue lengths until the proxy shuts down.
+// The build info gauge is refreshed along, the Prometheus sink expires the gauges not set.
+func (p *ElikaProxyServer) sampleQueueDepths() {
+	ticker := time.NewTicker(queueSampleInterval)
+	defer ticker.Stop()
+	buildInfo := common.GetBuildInfo()
+	p.metricsMiddleware.TrackBuildInfo(buildInfo)
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+			p.metricsMiddleware.TrackBuildInfo(buildInfo)
+			for _, depth := range p.sessionMgr.QueueDepths() {
+				p.metricsMiddleware.TrackQueueDepth(depth.Queue, depth.Owner, depth.Depth)
+			}
+		}
+	}
+}
+
 func (p *ElikaProxyServer) OnBoot(eng gnet.Engine) gnet.Action {
 	p.eng = &eng
 	return gnet.None
@@ -73,47 +126,195 @@ func (p *ElikaProxyServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action)
 	return nil, gnet.None
 }
 
-func (p *ElikaProxyServer) doForward(id string, session *be_cluster.Session, authInfo *common.AuthInfo, packet *respio.RespPacket) error {
-	if err := p.sessionMgr.Forward(id, packet, authInfo); err != nil {
-		return session.WriteAndFlush(&respio.RespPacket{
-			Type: respio.RespError,
-			Data: []byte(err.Error()),
-		})
+func (p *ElikaProxyServer) doForward(id string, reqId uint64, session *be_cluster.Session, authInfo *common.AuthInfo, packet *respio.RespPacket) error {
+	if err := p.sessionMgr.Forward(id, reqId, packet, authInfo); err != nil {
+		logger.Info("Failed to forward request", "RequestId", reqId, "SessionId", id, "error", err)
+		if p.metricsMiddleware != nil {
+			p.metricsMiddleware.TrackError(be_cluster.ForwardErrorType(err))
+		}
+		if packet.IsAuthCmd() {
+			// the username set for routing was not verified
+			session.ResetPendingAuth()
+		}
+		// queued behind the replies in flight, and dropped under CLIENT REPLY OFF like any reply
+		session.ReplyLocal(respio.NewError(err.Error()))
 	}
 	return nil
 }
 
-func (p *ElikaProxyServer) forward(id string, session *be_cluster.Session, authInfo *common.AuthInfo, packet *respio.RespPacket) error {
+func (p *ElikaProxyServer) forward(id string, reqId uint64, session *be_cluster.Session, authInfo *common.AuthInfo, packet *respio.RespPacket) error {
 	if p.metricsMiddleware != nil {
 		return p.metricsMiddleware.WrapForwarding(packet, func() error {
-			return p.doForward(id, session, authInfo, packet)
+			return p.doForward(id, reqId, session, authInfo, packet)
 		})
 	}
-	return p.doForward(id, session, authInfo, packet)
+	return p.doForward(id, reqId, session, authInfo, packet)
 }
 
 func (p *ElikaProxyServer) doDispatch(client *be_cluster.Session, packet *respio.RespPacket) error {
+	reqId := be_cluster.NextRequestId()
+	logger.V(1).Info("Dispatch request", "RequestId", reqId, "SessionId", client.Id,
+		"Command", string(packet.GetCommand()))
+	if !p.allowlist.Allow(packet) {
+		logger.V(1).Info("Command not allowed", "RequestId", reqId, "SessionId", client.Id)
+		client.ReplyLocal(respio.NewError(be_cluster.ErrCommandNotAllowed.Error()))
+		return nil
+	}
+	// AUTH is always handled by the auth path, a client may retry or re-authenticate
+	// on the same connection.
+	if packet.IsAuthCmd() {
+		return p.dispatchAuth(client, reqId, packet)
+	}
+	// HELLO may authenticate the client with its AUTH option
+	if bytes.EqualFold(packet.GetCommand(), respio.HelloCmd) {
+		return p.dispatchHello(client, reqId, packet)
+	}
 	// If client is already authenticated, just forward the packet
 	if client.IsAuthenticated() {
+		if client.RejectBeforeHello(packet, p.config.Security.RequireHello) {
+			client.ReplyLocal(respio.NewError(be_cluster.ErrHelloRequired.Error()))
+			return nil
+		}
 		authInfo := client.GetAuthInfo()
-		return p.forward(client.Id, client, authInfo, packet)
+		if acl := p.registry.GetTenantACL(string(authInfo.Username)); acl != nil {
+			if err := acl.Check(string(authInfo.Username), packet); err != nil {
+				logger.V(1).Info("Command denied by the tenant ACL", "RequestId", reqId,
+					"SessionId", client.Id, "Error", err)
+				client.ReplyLocal(respio.NewError(err.Error()))
+				return nil
+			}
+		}
+		if !p.adminTenants.Allow(string(authInfo.Username), packet) {
+			logger.V(1).Info("Admin command rejected", "RequestId", reqId, "SessionId", client.Id)
+			client.ReplyLocal(respio.NewError(be_cluster.ErrAdminCmdNotSupported.Error()))
+			return nil
+		}
+		if policy := p.registry.GetTenantTTLPolicy(string(authInfo.Username)); policy != nil && policy.Apply(packet) {
+			logger.V(1).Info("TTL capped by the tenant policy", "RequestId", reqId, "SessionId", client.Id)
+		}
+		// CLIENT PAUSE of an admin tenant reaches the backend
+		if bytes.EqualFold(packet.GetCommand(), respio.ClientCmd) && !be_cluster.IsAdminCommand(packet) {
+			if reply, handled := be_cluster.HandleClientCommand(client, packet); handled {
+				client.ReplyLocal(reply)
+				return nil
+			}
+		}
+		if reply, handled := be_cluster.HandleUnknownCommand(p.config.UnknownCmdPolicy, packet); handled {
+			client.ReplyLocal(reply)
+			return nil
+		}
+		if reply, handled := be_cluster.HandleCommandGetKeys(packet); handled {
+			client.ReplyLocal(reply)
+			return nil
+		}
+		if reply, handled := be_cluster.HandleConfigCommand(p.config, packet); handled {
+			client.ReplyLocal(reply)
+			return nil
+		}
+		if reply, handled := be_cluster.HandlePubSubCommand(packet); handled {
+			client.ReplyLocal(reply)
+			return nil
+		}
+		if _, ok := packet.DebugSubCommand(); ok {
+			return p.dispatchDebug(client, reqId, authInfo, packet)
+		}
+		if packet.IsBroadcastCmd() && !p.sessionMgr.InTransaction(client.Id) {
+			return p.dispatchBroadcast(client, reqId, authInfo, packet)
+		}
+		return p.forward(client.Id, reqId, client, authInfo, packet)
 	}
-	// If not authenticated, check if this is an AUTH command
-	if !packet.IsAuthCmd() {
-		logger.Info("Client is not authenticated and sent a non-auth command",
-			"clientId", client.Id, "packet", packet)
-		return client.WriteAndFlush(respio.ErrNoAuth)
+	logger.Info("Client is not authenticated and sent a non-auth command",
+		"clientId", client.Id, "RequestId", reqId, "packet", packet)
+	client.ReplyLocal(respio.NewError(string(respio.ErrNoAuth.Data)))
+	return nil
+}
+
+func (p *ElikaProxyServer) dispatchAuth(client *be_cluster.Session, reqId uint64, packet *respio.RespPacket) error {
+	authInfo := packet.ToAuthInfoWithSeparator(p.config.TenantSeparator[0])
+	requireUsername := strings.EqualFold(p.config.Router.RouterType, "sync")
+	if err := be_cluster.ValidateAuthInfo(authInfo, requireUsername); err != nil {
+		logger.V(1).Info("Invalid AUTH", "RequestId", reqId, "SessionId", client.Id)
+		client.ReplyLocal(respio.NewError(err.Error()))
+		return nil
+	}
+	if p.sessionMgr.VerifyCachedAuth(authInfo) {
+		logger.V(1).Info("AUTH verified by the cache", "RequestId", reqId, "SessionId", client.Id)
+		p.sessionMgr.NotifyAuth(authInfo.Username, true)
+		client.SetAuthInfo(authInfo)
+		client.ReplyLocal(respio.NewStatus("OK"))
+		return nil
 	}
-	// This is an AUTH command, extract auth info
-	authInfo := packet.ToAuthInfo()
-	if len(authInfo.Username) > 0 {
+	// The username is needed for routing before the backend verifies the password.
+	// It is dropped again if the backend rejects the AUTH.
+	if !client.IsAuthenticated() && len(authInfo.Username) > 0 {
 		routingAuthInfo := &common.AuthInfo{
 			Username: authInfo.Username,
 		}
 		client.SetAuthInfo(routingAuthInfo)
 	}
 	authPacket := respio.NewAuthPacket(authInfo.Username, authInfo.Password)
-	return p.forward(client.Id, client, authInfo, authPacket)
+	return p.forward(client.Id, reqId, client, authInfo, authPacket)
+}
+
+// dispatchHello handles HELLO before the auth gate. It counts as the HELLO required by the
+// proxy even if refused, and its AUTH option authenticates the client like AUTH. The shared
+// backend connections stay on RESP2, HELLO 3 moves the session to a connection of its own.
+func (p *ElikaProxyServer) dispatchHello(client *be_cluster.Session, reqId uint64, packet *respio.RespPacket) error {
+	client.RecordHello()
+	authInfo := client.GetAuthInfo()
+	if packet.IsHelloAuth() {
+		authInfo = packet.HelloAuthInfoWithSeparator(p.config.TenantSeparator[0])
+		requireUsername := strings.EqualFold(p.config.Router.RouterType, "sync")
+		if err := be_cluster.ValidateAuthInfo(authInfo, requireUsername); err != nil {
+			logger.V(1).Info("Invalid HELLO AUTH", "RequestId", reqId, "SessionId", client.Id)
+			client.ReplyLocal(respio.NewError(err.Error()))
+			return nil
+		}
+		// the backend knows the username without the tenant key, like for AUTH
+		packet.SetHelloAuthUsername(authInfo.Username)
+		if !client.IsAuthenticated() && len(authInfo.Username) > 0 {
+			client.SetAuthInfo(&common.AuthInfo{
+				Username: authInfo.Username,
+			})
+		}
+	} else if authInfo == nil {
+		client.ReplyLocal(respio.NewError(be_cluster.ErrHelloNoAuth.Error()))
+		return nil
+	}
+	if err := p.sessionMgr.ForwardHello(client.Id, reqId, packet, authInfo); err != nil {
+		logger.Info("Failed to forward request", "RequestId", reqId, "SessionId", client.Id, "error", err)
+		client.ReplyLocal(respio.NewError(err.Error()))
+	}
+	return nil
+}
+
+// dispatchDebug applies the DEBUG policy, see ClassifyDebugCmd. DEBUG SLEEP blocks the backend
+// connection, which is shared by many sessions, so it is rejected or isolated unless explicitly
+// allowed. DEBUG OBJECT is forwarded to the instance holding its key once allowed.
+func (p *ElikaProxyServer) dispatchDebug(client *be_cluster.Session, reqId uint64, authInfo *common.AuthInfo,
+	packet *respio.RespPacket) error {
+	switch action, _ := be_cluster.ClassifyDebugCmd(p.config.DebugPolicy, packet); action {
+	case be_cluster.DebugCmdForward:
+		return p.forward(client.Id, reqId, client, authInfo, packet)
+	case be_cluster.DebugCmdExclusive:
+		if err := p.sessionMgr.ForwardExclusive(client.Id, reqId, packet, authInfo); err != nil {
+			logger.Info("Failed to forward request", "RequestId", reqId, "SessionId", client.Id, "error", err)
+			client.ReplyLocal(respio.NewError(err.Error()))
+		}
+		return nil
+	default:
+		client.ReplyLocal(respio.NewError(be_cluster.ErrDebugDisabled.Error()))
+		return nil
+	}
+}
+
+// dispatchBroadcast runs a command changing the connection state, like SCRIPT LOAD, on every
+// backend connection. A session in a transaction keeps it on its own connection, where MULTI
+// may be queuing it. The event loop does not wait for the replies.
+func (p *ElikaProxyServer) dispatchBroadcast(client *be_cluster.Session, reqId uint64, authInfo *common.AuthInfo,
+	packet *respio.RespPacket) error {
+	p.sessionMgr.BroadcastAsync(client, reqId, packet, authInfo)
+	return nil
 }
 
 func (p *ElikaProxyServer) dispatch(client *be_cluster.Session, packet *respio.RespPacket) error {
@@ -143,21 +344,44 @@ func (p *ElikaProxyServer) dispatch(client *be_cluster.Session, packet *respio.R
 func (p *ElikaProxyServer) OnTraffic(c gnet.Conn) gnet.Action {
 	connId := c.RemoteAddr().String()
 	client := p.sessionMgr.LoadSession(connId)
+	if client == nil {
+		// released on another goroutine, e.g. after a failed write or the auth timeout, while
+		// gnet was still closing the connection
+		logger.Info("Traffic of a released session, closing", "connId", connId)
+		return gnet.Close
+	}
 	if p.metricsMiddleware != nil {
 		return p.metricsMiddleware.WrapTraffic(func() gnet.Action {
-			return p.onEvent(client)
+			return p.onEvent(c, client)
 		})
 	}
-	return p.onEvent(client)
+	return p.onEvent(c, client)
 }
 
-func (p *ElikaProxyServer) onEvent(client *be_cluster.Session) gnet.Action {
+func (p *ElikaProxyServer) onEvent(c gnet.Conn, client *be_cluster.Session) gnet.Action {
 	for {
+		if client.Closing() {
+			// closed by the ReplyLoop once the last reply is written
+			return gnet.None
+		}
+		if resume := p.sessionMgr.Saturated(client.Id); resume != nil {
+			// the commands left unread are dispatched once the backend caught up
+			p.resumeOnDrain(c, client, resume)
+			return gnet.None
+		}
 		packet, err := client.Read()
 		if err != nil {
 			if err == io.EOF {
 				return gnet.None
 			}
+			// like Redis, tell the client why before closing. The error follows the replies of the
+			// commands dispatched before, the ReplyLoop closes the connection once it is written.
+			var protoErr *respio.ProtocolError
+			if errors.As(err, &protoErr) {
+				logger.Info("Closing client on protocol error", "clientId", client.Id, "reason", protoErr.Reason)
+				client.ReplyLocalAndClose(respio.NewError(protoErr.Error()))
+				return gnet.None
+			}
 			return gnet.Close
 		}
 		processErr := p.dispatch(client, packet)
@@ -172,6 +396,23 @@ func (p *ElikaProxyServer) onEvent(client *be_cluster.Session) gnet.Action {
 	return gnet.None
 }
 
+// resumeOnDrain wakes the event loop of the client once its backend connection takes requests
+// again. The client is paused meanwhile, the traffic it sends is buffered and not dispatched.
+func (p *ElikaProxyServer) resumeOnDrain(c gnet.Conn, client *be_cluster.Session, resume <-chan struct{}) {
+	if !client.Pause() {
+		return
+	}
+	logger.V(1).Info("Client paused, backend connection saturated", "clientId", client.Id)
+	go func() {
+		select {
+		case <-resume:
+		case <-p.quit:
+		}
+		client.Resume()
+		_ = c.Wake(nil)
+	}()
+}
+
 func (p *ElikaProxyServer) OnClose(c gnet.Conn, err error) gnet.Action {
 	connId := c.RemoteAddr().String()
 	logger.Info("ElikaProxy closed connection", "connId", connId, "err", err)
@@ -184,13 +425,16 @@ func (p *ElikaProxyServer) OnShutdown(eng gnet.Engine) {
 		return
 	}
 	logger.Info("ElikaProxy is shutting down. cleaning up resources")
-	p.sessionMgr.Clear()
 }
 
+// Shutdown stops the event loops, closing the client sessions, then closes the backend pools.
+// The backend connections still answering requests are given until ctx is done.
 func (p *ElikaProxyServer) Shutdown(ctx context.Context) {
+	close(p.quit)
 	if err := p.eng.Stop(ctx); err != nil {
 		logger.Error(err, "Failed to stop proxy proxy")
 	} else {
 		logger.Info("Proxy proxy stopped")
 	}
+	p.sessionMgr.Clear(ctx)
 }
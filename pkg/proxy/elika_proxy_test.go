@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPassword = "secret"
+
+// backendOnce brings the backend of the tenant alice online, the backend manager and the
+// cluster registry are shared by the proxies of every test.
+var backendOnce sync.Once
+
+// serveRedis answers like a Redis requiring testPassword: AUTH and HELLO check it, HELLO
+// switches the protocol of the connection and GET echoes its key.
+func serveRedis(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			reader, writer := respio.NewRespReader(conn), respio.NewRespWriter(conn)
+			protoVer := respio.Resp2
+			for {
+				req, err := reader.Read()
+				if err != nil {
+					return
+				}
+				_ = writer.Write(redisReply(req, &protoVer))
+				_ = writer.Flush()
+			}
+		}()
+	}
+}
+
+func redisReply(req *respio.RespPacket, protoVer *int) *respio.RespPacket {
+	wrongPass := respio.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+	switch strings.ToLower(string(req.GetCommand())) {
+	case "auth":
+		if string(req.Array[len(req.Array)-1].Data) != testPassword {
+			return wrongPass
+		}
+		return respio.NewStatus(string(respio.OkCmd))
+	case "hello":
+		if authInfo := req.HelloAuthInfoWithSeparator('.'); authInfo != nil && string(authInfo.Password) != testPassword {
+			return wrongPass
+		}
+		*protoVer, _ = strconv.Atoi(string(req.Array[1].Data))
+		reply := &respio.RespPacket{Type: respio.RespArray, Array: []*respio.RespPacket{
+			respio.NewBulkString([]byte("proto")), respio.NewInteger(int64(*protoVer))}}
+		if *protoVer >= respio.Resp3 {
+			reply.Type = respio.RespMap
+		}
+		return reply
+	case "get":
+		return respio.NewBulkString(req.Array[1].Data)
+	default:
+		return respio.NewStatus("PONG")
+	}
+}
+
+func newTestConfig() *common.ProxyConfig {
+	config := &common.ProxyConfig{
+		Router: common.BackendRouterConfig{
+			RouterType: "sync",
+			LBType:     "tenant-hash",
+		},
+		TenantSeparator: ".",
+		Security:        common.SecurityConfig{RequireAuth: true},
+	}
+	config.BeConnPool.IsFixed = true
+	config.BeConnPool.MaxSize = 2
+	return config
+}
+
+// newTestProxy returns a proxy routing the tenant alice to a backend served by serveRedis.
+func newTestProxy(t *testing.T, config *common.ProxyConfig) *ElikaProxyServer {
+	p := NewElikaProxy(config)
+	backendOnce.Do(func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		go serveRedis(lis)
+		instance := be_cluster.LocalClusterInstance("127.0.0.1", lis.Addr().(*net.TCPAddr).Port)
+		instance.Key.Name.Name = "proxy-test"
+		instance.Owner = "alice"
+		registry := be_cluster.GetClusterRegistry()
+		assert.NoError(t, registry.AddCluster(&instance.Key))
+		assert.NoError(t, registry.StatusChange(instance))
+	})
+	assert.Eventually(t, be_cluster.GetBackendManager(config).IsBackendReady, 5*time.Second, 10*time.Millisecond)
+	return p
+}
+
+// openClient opens a session like OnOpen and returns it with the reader of the client side.
+func openClient(t *testing.T, p *ElikaProxyServer, id string) (*be_cluster.Session, *respio.RespReader) {
+	clientSide, proxySide := net.Pipe()
+	p.sessionMgr.OpenSession(id, proxySide)
+	t.Cleanup(func() {
+		p.sessionMgr.CloseSession(id)
+		_ = clientSide.Close()
+	})
+	return p.sessionMgr.LoadSession(id), respio.NewRespReader(clientSide)
+}
+
+func readReply(t *testing.T, reader *respio.RespReader) *respio.RespPacket {
+	reply, err := reader.Read()
+	assert.NoError(t, err)
+	return reply
+}
+
+func TestElikaProxy_PipelinedAuth(t *testing.T) {
+	p := newTestProxy(t, newTestConfig())
+	client, reader := openClient(t, p, "pipelined-auth")
+
+	assert.NoError(t, p.dispatch(client, respio.NewCommand("GET", "k0")))
+	assert.Equal(t, respio.ErrNoAuth.Data, readReply(t, reader).Data)
+
+	// the GET is dispatched before the backend answered the AUTH, its reply comes second
+	assert.NoError(t, p.dispatch(client, respio.NewCommand("AUTH", "tk.alice", testPassword)))
+	assert.NoError(t, p.dispatch(client, respio.NewCommand("GET", "k1")))
+	assert.Equal(t, respio.OkCmd, readReply(t, reader).Data)
+	assert.Equal(t, "k1", string(readReply(t, reader).Data))
+	assert.True(t, client.IsVerified())
+}
+
+func TestElikaProxy_HelloAuthRequireHello(t *testing.T) {
+	config := newTestConfig()
+	config.Security.RequireHello = true
+	p := newTestProxy(t, config)
+
+	client, reader := openClient(t, p, "hello-noauth")
+	assert.NoError(t, p.dispatch(client, respio.NewCommand("HELLO", "3")))
+	assert.Equal(t, be_cluster.ErrHelloNoAuth.Error(), string(readReply(t, reader).Data))
+
+	client, reader = openClient(t, p, "hello-wrongpass")
+	assert.NoError(t, p.dispatch(client, respio.NewCommand("HELLO", "3", "AUTH", "tk.alice", "wrong")))
+	assert.Equal(t, respio.RespError, readReply(t, reader).Type)
+	assert.False(t, client.IsAuthenticated())
+
+	// HELLO authenticates the client and counts as the HELLO required
+	client, reader = openClient(t, p, "hello-auth")
+	assert.NoError(t, p.dispatch(client, respio.NewCommand("HELLO", "3", "AUTH", "tk.alice", testPassword)))
+	assert.NoError(t, p.dispatch(client, respio.NewCommand("GET", "k1")))
+	assert.Equal(t, respio.RespMap, readReply(t, reader).Type)
+	assert.Equal(t, "k1", string(readReply(t, reader).Data))
+	assert.True(t, client.IsVerified())
+	assert.Equal(t, respio.Resp3, client.ProtoVersion())
+}
+
+// releasedConn is a client connection whose session was released.
+type releasedConn struct {
+	gnet.Conn
+	addr net.Addr
+}
+
+func (c releasedConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func TestElikaProxy_TrafficOfReleasedSession(t *testing.T) {
+	p := newTestProxy(t, newTestConfig())
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40001}
+	_, _ = openClient(t, p, addr.String())
+	p.sessionMgr.CloseSession(addr.String())
+
+	assert.Nil(t, p.sessionMgr.LoadSession(addr.String()))
+	assert.Equal(t, gnet.Close, p.OnTraffic(releasedConn{addr: addr}))
+}
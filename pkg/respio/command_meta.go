@@ -0,0 +1,214 @@
+package respio
+
+import (
+	"strconv"
+	"strings"
+)
+
+type CommandFlag uint32
+
+const (
+	// CmdFlagReadOnly the command never modifies data
+	CmdFlagReadOnly CommandFlag = 1 << iota
+	// CmdFlagWrite the command may modify data
+	CmdFlagWrite
+	// CmdFlagAdmin the command changes server state or topology
+	CmdFlagAdmin
+	// CmdFlagBlocking the command may block the connection
+	CmdFlagBlocking
+	// CmdFlagMovableKeys the key positions depend on the arguments (e.g. EVAL numkeys)
+	CmdFlagMovableKeys
+	// CmdFlagContainer the command is only a container of sub commands (e.g. OBJECT)
+	CmdFlagContainer
+)
+
+// CommandMeta describes a command like Redis COMMAND INFO. Key positions are indexes in the
+// command array where the command name is 0. A negative LastKey counts from the end:
+// -1 is the last argument.
+type CommandMeta struct {
+	Name     string
+	Flags    CommandFlag
+	FirstKey int
+	LastKey  int
+	Step     int
+}
+
+func (m *CommandMeta) HasFlag(flag CommandFlag) bool {
+	return m.Flags&flag != 0
+}
+
+func (m *CommandMeta) IsReadOnly() bool {
+	return m.HasFlag(CmdFlagReadOnly)
+}
+
+// ExtractKeys returns the keys of the command according to its key positions.
+// Commands with movable keys return nil, except the scripts whose keys follow numkeys.
+func (m *CommandMeta) ExtractKeys(packet *RespPacket) [][]byte {
+	if m.HasFlag(CmdFlagMovableKeys) {
+		if _, ok := scriptCommands[m.Name]; ok {
+			return ScriptKeys(packet)
+		}
+		return nil
+	}
+	if m.FirstKey <= 0 {
+		return nil
+	}
+	args := packet.Array
+	last := m.LastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	step := max(m.Step, 1)
+	var keys [][]byte
+	for i := m.FirstKey; i <= last && i < len(args); i += step {
+		keys = append(keys, args[i].Data)
+	}
+	return keys
+}
+
+// KeysExtractable reports whether ExtractKeys finds the keys of the command, false for the
+// commands with movable keys other than the scripts, e.g. ZUNIONSTORE or XREAD.
+func (m *CommandMeta) KeysExtractable() bool {
+	if !m.HasFlag(CmdFlagMovableKeys) {
+		return true
+	}
+	_, ok := scriptCommands[m.Name]
+	return ok
+}
+
+// scriptCommands declare their keys like EVAL script numkeys key [key ...] arg [arg ...]
+var scriptCommands = map[string]struct{}{
+	"eval": {}, "evalsha": {}, "eval_ro": {}, "evalsha_ro": {}, "fcall": {}, "fcall_ro": {},
+}
+
+// ScriptKeys returns the keys declared by an EVAL, EVALSHA or FCALL, nil if numkeys is invalid.
+func ScriptKeys(packet *RespPacket) [][]byte {
+	if len(packet.Array) < 3 {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(string(packet.Array[2].Data))
+	if err != nil || numKeys <= 0 || 3+numKeys > len(packet.Array) {
+		return nil
+	}
+	keys := make([][]byte, 0, numKeys)
+	for _, arg := range packet.Array[3 : 3+numKeys] {
+		keys = append(keys, arg.Data)
+	}
+	return keys
+}
+
+var commandTable = make(map[string]*CommandMeta)
+
+func registerCommands(flags CommandFlag, firstKey, lastKey, step int, names ...string) {
+	for _, name := range names {
+		commandTable[name] = &CommandMeta{
+			Name:     name,
+			Flags:    flags,
+			FirstKey: firstKey,
+			LastKey:  lastKey,
+			Step:     step,
+		}
+	}
+}
+
+func init() {
+	// read-only single key
+	registerCommands(CmdFlagReadOnly, 1, 1, 1,
+		"get", "strlen", "getrange", "substr", "type", "ttl", "pttl", "expiretime", "pexpiretime", "dump",
+		"hget", "hmget", "hgetall", "hkeys", "hvals", "hlen", "hexists", "hstrlen", "hrandfield", "hscan",
+		"lrange", "llen", "lindex", "lpos", "smembers", "sismember", "smismember", "scard", "srandmember",
+		"sscan", "zrange", "zrangebyscore", "zrevrange", "zrevrangebyscore", "zrangebylex", "zrevrangebylex",
+		"zrank", "zrevrank", "zscore", "zmscore", "zcard", "zcount", "zlexcount", "zrandmember", "zscan",
+		"xrange", "xrevrange", "xlen", "getbit", "bitcount", "bitpos", "geopos", "geodist", "geohash",
+		"georadius_ro", "georadiusbymember_ro", "geosearch", "sort_ro", "bitfield_ro", "xpending", "httl",
+		"hpttl", "hexpiretime", "hpexpiretime")
+	// read-only multi keys
+	registerCommands(CmdFlagReadOnly, 1, -1, 1,
+		"mget", "exists", "touch", "sinter", "sunion", "sdiff", "pfcount")
+	// read-only, the keys follow numkeys
+	registerCommands(CmdFlagReadOnly|CmdFlagMovableKeys, 0, 0, 0,
+		"sintercard", "zintercard", "zunion", "zinter", "zdiff")
+	registerCommands(CmdFlagReadOnly, 1, 2, 1, "lcs")
+	// read-only keyless
+	registerCommands(CmdFlagReadOnly, 0, 0, 0,
+		"ping", "echo", "randomkey", "keys", "scan", "dbsize", "time", "lastsave")
+	// read-only sub commands, the key follows the sub command
+	registerCommands(CmdFlagReadOnly, 2, 2, 1,
+		"object|encoding", "object|freq", "object|idletime", "object|refcount", "memory|usage", "debug|object")
+	// read-only instance level sub commands, answered by the instance the session is bound to
+	registerCommands(CmdFlagReadOnly, 0, 0, 0,
+		"memory|stats", "memory|doctor", "memory|malloc-stats", "memory|help", "object|help")
+	registerCommands(CmdFlagReadOnly, 2, 2, 1, "xinfo|stream", "xinfo|groups", "xinfo|consumers")
+	registerCommands(CmdFlagReadOnly, 0, 0, 0, "xinfo|help")
+
+	// write single key
+	registerCommands(CmdFlagWrite, 1, 1, 1,
+		"set", "setex", "psetex", "setnx", "getset", "getdel", "getex", "append", "incr", "incrby",
+		"incrbyfloat", "decr", "decrby", "expire", "pexpire", "expireat", "pexpireat", "persist", "restore",
+		"hset", "hsetnx", "hmset", "hdel", "hincrby", "hincrbyfloat", "lpush", "rpush", "lpushx", "rpushx",
+		"lpop", "rpop", "lset", "lrem", "ltrim", "linsert", "sadd", "srem", "spop", "zadd", "zincrby",
+		"zrem", "zpopmin", "zpopmax", "zremrangebyscore", "zremrangebyrank", "zremrangebylex", "xadd",
+		"xdel", "xtrim", "pfadd", "setbit", "setrange", "geoadd", "sort", "bitfield", "move", "xack",
+		"xclaim", "xautoclaim", "xsetid", "hexpire", "hpexpire", "hexpireat", "hpexpireat", "hpersist")
+	registerCommands(CmdFlagWrite, 2, 2, 1,
+		"xgroup|create", "xgroup|setid", "xgroup|destroy", "xgroup|createconsumer", "xgroup|delconsumer")
+	// write multi keys
+	registerCommands(CmdFlagWrite, 1, -1, 1,
+		"del", "unlink", "sinterstore", "sunionstore", "sdiffstore", "pfmerge")
+	registerCommands(CmdFlagWrite, 1, -1, 2, "mset", "msetnx")
+	registerCommands(CmdFlagWrite, 1, 2, 1, "rename", "renamenx", "lmove", "rpoplpush", "smove", "copy",
+		"zrangestore", "geosearchstore")
+	registerCommands(CmdFlagWrite, 2, -1, 1, "bitop")
+	registerCommands(CmdFlagWrite|CmdFlagMovableKeys, 0, 0, 0,
+		"zunionstore", "zinterstore", "zdiffstore", "eval", "evalsha", "fcall", "lmpop", "zmpop",
+		"georadius", "georadiusbymember", "migrate")
+	registerCommands(CmdFlagReadOnly|CmdFlagMovableKeys, 0, 0, 0, "eval_ro", "evalsha_ro", "fcall_ro")
+	registerCommands(CmdFlagWrite, 0, 0, 0, "flushdb", "flushall", "swapdb")
+
+	// blocking
+	registerCommands(CmdFlagWrite|CmdFlagBlocking, 1, -2, 1, "blpop", "brpop", "bzpopmin", "bzpopmax")
+	registerCommands(CmdFlagWrite|CmdFlagBlocking, 1, 2, 1, "blmove", "brpoplpush")
+	registerCommands(CmdFlagReadOnly|CmdFlagBlocking|CmdFlagMovableKeys, 0, 0, 0, "xread")
+	registerCommands(CmdFlagWrite|CmdFlagBlocking|CmdFlagMovableKeys, 0, 0, 0, "blmpop", "bzmpop", "xreadgroup")
+	registerCommands(CmdFlagBlocking, 0, 0, 0, "wait", "waitaof")
+
+	// admin
+	registerCommands(CmdFlagAdmin, 0, 0, 0,
+		"shutdown", "save", "bgsave", "bgrewriteaof", "failover", "replicaof", "slaveof", "monitor",
+		"memory|purge", "client|pause", "client|unpause", "sync", "psync")
+
+	// pub/sub, the channels are not keys
+	registerCommands(0, 0, 0, 0, "publish", "spublish", "subscribe", "unsubscribe", "psubscribe",
+		"punsubscribe", "ssubscribe", "sunsubscribe")
+	// server introspection
+	registerCommands(0, 0, 0, 0, "role", "lolwut", "readonly", "readwrite", "asking")
+
+	// connection and transaction commands, handled by the proxy or bound to the connection
+	registerCommands(0, 0, 0, 0, "auth", "hello", "quit", "reset", "select", "info", "multi", "exec",
+		"discard", "unwatch")
+	registerCommands(0, 1, -1, 1, "watch")
+
+	// containers of sub commands
+	registerCommands(CmdFlagContainer, 0, 0, 0, "object", "memory", "client", "config", "debug", "command",
+		"script", "function", "xinfo", "xgroup", "pubsub", "acl", "cluster", "latency", "slowlog", "module")
+}
+
+// LookupCommand returns the metadata of the packet's command, resolving sub commands such as
+// OBJECT ENCODING. It returns nil for unknown commands.
+func LookupCommand(packet *RespPacket) *CommandMeta {
+	if packet.Type != RespArray || len(packet.Array) == 0 {
+		return nil
+	}
+	name := strings.ToLower(string(packet.Array[0].Data))
+	meta, ok := commandTable[name]
+	if !ok {
+		return nil
+	}
+	if meta.HasFlag(CmdFlagContainer) && len(packet.Array) > 1 {
+		subName := name + "|" + strings.ToLower(string(packet.Array[1].Data))
+		if subMeta, found := commandTable[subName]; found {
+			return subMeta
+		}
+	}
+	return meta
+}
@@ -0,0 +1,59 @@
+package respio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupCommand(t *testing.T) {
+	meta := LookupCommand(NewCommand("OBJECT", "ENCODING", "k1"))
+	assert.Equal(t, "object|encoding", meta.Name)
+	assert.True(t, meta.IsReadOnly())
+	assert.Equal(t, [][]byte{[]byte("k1")}, meta.ExtractKeys(NewCommand("OBJECT", "ENCODING", "k1")))
+
+	memoryUsage := NewCommand("MEMORY", "USAGE", "k1", "SAMPLES", "5")
+	meta = LookupCommand(memoryUsage)
+	assert.Equal(t, "memory|usage", meta.Name)
+	assert.True(t, meta.IsReadOnly())
+	assert.Equal(t, [][]byte{[]byte("k1")}, meta.ExtractKeys(memoryUsage))
+	memoryStats := NewCommand("memory", "stats")
+	assert.True(t, LookupCommand(memoryStats).IsReadOnly())
+	assert.Nil(t, LookupCommand(memoryStats).ExtractKeys(memoryStats))
+	assert.True(t, LookupCommand(NewCommand("MEMORY", "PURGE")).HasFlag(CmdFlagAdmin))
+	assert.True(t, LookupCommand(NewCommand("CLIENT", "PAUSE", "100")).HasFlag(CmdFlagAdmin))
+
+	// the connection and transaction commands are known, WATCH has keys
+	assert.False(t, LookupCommand(NewCommand("MULTI")).IsReadOnly())
+	watch := NewCommand("WATCH", "a", "b")
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, LookupCommand(watch).ExtractKeys(watch))
+
+	mset := NewCommand("MSET", "a", "1", "b", "2")
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, LookupCommand(mset).ExtractKeys(mset))
+
+	blpop := NewCommand("BLPOP", "l1", "l2", "0")
+	assert.True(t, LookupCommand(blpop).HasFlag(CmdFlagBlocking))
+	assert.Equal(t, [][]byte{[]byte("l1"), []byte("l2")}, LookupCommand(blpop).ExtractKeys(blpop))
+
+	evalsha := NewCommand("EVALSHA", "sha", "2", "k1", "k2", "arg")
+	assert.Equal(t, [][]byte{[]byte("k1"), []byte("k2")}, LookupCommand(evalsha).ExtractKeys(evalsha))
+	assert.Nil(t, ScriptKeys(NewCommand("EVAL", "return 1", "3", "k1")))
+	eval := NewCommand("EVAL", "return 1", "0", "arg")
+	assert.Nil(t, LookupCommand(eval).ExtractKeys(eval))
+	fcallRo := NewCommand("FCALL_RO", "fn", "1", "k1", "arg")
+	assert.True(t, LookupCommand(fcallRo).IsReadOnly())
+	assert.Equal(t, [][]byte{[]byte("k1")}, LookupCommand(fcallRo).ExtractKeys(fcallRo))
+	zunion := NewCommand("ZUNIONSTORE", "dst", "1", "z1")
+	assert.Nil(t, LookupCommand(zunion).ExtractKeys(zunion))
+	assert.False(t, LookupCommand(zunion).KeysExtractable())
+	assert.True(t, LookupCommand(eval).KeysExtractable())
+	assert.True(t, LookupCommand(mset).KeysExtractable())
+
+	sintercard := NewCommand("SINTERCARD", "2", "s1", "s2")
+	assert.True(t, LookupCommand(sintercard).IsReadOnly())
+	assert.False(t, LookupCommand(sintercard).KeysExtractable())
+	xgroup := NewCommand("XGROUP", "CREATE", "stream", "group", "$")
+	assert.Equal(t, [][]byte{[]byte("stream")}, LookupCommand(xgroup).ExtractKeys(xgroup))
+
+	assert.Nil(t, LookupCommand(NewCommand("NOSUCHCMD")))
+}
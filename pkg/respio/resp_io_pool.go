@@ -0,0 +1,42 @@
+package respio
+
+import (
+	"net"
+	"sync"
+)
+
+// RespIO is a reader and a writer of DefaultBufferSize bound to the same connection.
+type RespIO struct {
+	Reader *RespReader
+	Writer *RespWriter
+}
+
+// respIOPool keeps the buffers of closed connections for the next ones.
+var respIOPool = sync.Pool{
+	New: func() interface{} {
+		return &RespIO{
+			Reader: NewRespReader(nil),
+			Writer: NewRespWriter(nil),
+		}
+	},
+}
+
+// AcquireRespIO gets a reader and a writer from the pool and binds them to conn.
+func AcquireRespIO(conn net.Conn) *RespIO {
+	rw := respIOPool.Get().(*RespIO)
+	rw.Reader.Reset(conn)
+	rw.Writer.Reset(conn)
+	return rw
+}
+
+// ReleaseRespIO unbinds the reader and the writer from their connection and returns them to the
+// pool. The caller must ensure neither is used anymore, the next connection acquiring them
+// shares nothing with the previous one.
+func ReleaseRespIO(rw *RespIO) {
+	if rw == nil {
+		return
+	}
+	rw.Reader.Reset(nil)
+	rw.Writer.Reset(nil)
+	respIOPool.Put(rw)
+}
@@ -0,0 +1,83 @@
+package respio
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pipeWith returns the client end of a pipe whose server end sends data and then closes.
+func pipeWith(t *testing.T, data string) net.Conn {
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	go func() {
+		_, _ = server.Write([]byte(data))
+		_ = server.Close()
+	}()
+	return client
+}
+
+func TestRespIO_Reset(t *testing.T) {
+	// a half read message and the limits of the previous connection are discarded
+	rw := AcquireRespIO(pipeWith(t, "*2\r\n$3\r\nGET\r\n"))
+	rw.Reader.SetMaxArrayLen(1)
+	rw.Reader.SetMaxRequestSize(1)
+	_, err := rw.Reader.Read()
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.Greater(t, rw.Reader.Buffered(), 0)
+
+	rw.Reader.Reset(pipeWith(t, "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"))
+	assert.Equal(t, 0, rw.Reader.Buffered())
+	packet, err := rw.Reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "GET", string(packet.GetCommand()))
+	assert.Equal(t, "foo", string(packet.Array[1].Data))
+	_, err = rw.Reader.Read()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// the data not flushed to the previous connection is not written to the next one
+	client, server := net.Pipe()
+	defer client.Close()
+	assert.NoError(t, rw.Writer.Write(NewStatus("STALE")))
+	rw.Writer.Reset(client)
+	go func() {
+		_ = rw.Writer.Write(NewStatus("OK"))
+		_ = rw.Writer.Flush()
+		_ = client.Close()
+	}()
+	received, err := io.ReadAll(server)
+	assert.NoError(t, err)
+	assert.Equal(t, "+OK\r\n", string(received))
+	ReleaseRespIO(rw)
+
+	// a released pair is bound to the next connection
+	rw = AcquireRespIO(pipeWith(t, "+PONG\r\n"))
+	defer ReleaseRespIO(rw)
+	assert.Equal(t, DefaultBufferSize, rw.Writer.Size())
+	packet, err = rw.Reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "PONG", string(packet.Data))
+}
+
+// BenchmarkRespIO_Churn compares the allocations of a connection opened and closed right away,
+// with the reader and the writer allocated or leased from the pool.
+func BenchmarkRespIO_Churn(b *testing.B) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reader, writer := NewRespReader(conn), NewRespWriter(conn)
+			_, _ = reader, writer
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ReleaseRespIO(AcquireRespIO(conn))
+		}
+	})
+}
@@ -3,6 +3,7 @@ package respio
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/pzhenzhou/elika/pkg/common"
@@ -48,8 +49,108 @@ func (p *RespPacket) IsAuthCmd() bool {
 	return bytes.EqualFold(cmdPkt.Data, AuthCmd)
 }
 
+// DebugSubCommand returns the lower-cased sub command of a DEBUG command, e.g. "sleep".
+// It returns false if the packet is not a DEBUG.
+func (p *RespPacket) DebugSubCommand() (string, bool) {
+	if p.Type != RespArray || len(p.Array) == 0 || !bytes.EqualFold(p.Array[0].Data, DebugCmd) {
+		return "", false
+	}
+	if len(p.Array) < 2 {
+		return "", true
+	}
+	return strings.ToLower(string(p.Array[1].Data)), true
+}
+
+// broadcastCmds are the sub commands changing the state of a backend connection rather than
+// the data, e.g. the script cache. They have to run on every pooled connection.
+var broadcastCmds = []struct {
+	cmd     []byte
+	subCmds [][]byte
+}{
+	{cmd: []byte("script"), subCmds: [][]byte{[]byte("load"), []byte("flush")}},
+	{cmd: []byte("function"), subCmds: [][]byte{[]byte("load"), []byte("delete"), []byte("flush"), []byte("restore")}},
+	{cmd: []byte("config"), subCmds: [][]byte{[]byte("set")}},
+}
+
+// IsBroadcastCmd reports whether the command must be sent to every backend connection, like
+// SCRIPT LOAD or CONFIG SET.
+func (p *RespPacket) IsBroadcastCmd() bool {
+	if p.Type != RespArray || len(p.Array) < 2 {
+		return false
+	}
+	for _, broadcast := range broadcastCmds {
+		if !bytes.EqualFold(p.Array[0].Data, broadcast.cmd) {
+			continue
+		}
+		for _, subCmd := range broadcast.subCmds {
+			if bytes.EqualFold(p.Array[1].Data, subCmd) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// setNameOpt is the HELLO option naming the connection
+var setNameOpt = []byte("setname")
+
+// HelloProtoVer returns the protocol version requested by a HELLO command. It returns false
+// if the packet is not a HELLO or does not ask for a version.
+func (p *RespPacket) HelloProtoVer() (int, bool) {
+	if p.Type != RespArray || len(p.Array) < 2 || !bytes.EqualFold(p.Array[0].Data, HelloCmd) {
+		return 0, false
+	}
+	protoVer, err := strconv.Atoi(string(p.Array[1].Data))
+	if err != nil {
+		return 0, false
+	}
+	return protoVer, true
+}
+
 // String returns a string representation of the RespPacket
 // Only for debugging purposes
+// ToRESP2 converts the RESP3-only types of the packet and its elements to their RESP2
+// equivalents, the way Redis answers a RESP2 client: a map is flattened to an array of
+// key value pairs, a set and a push become arrays, a double, a big number and a verbatim
+// string become bulk strings, a boolean becomes 1 or 0 and a null the null bulk string. The
+// packet is converted in place and returned, the shared NilPacket must not be passed. An
+// attribute or a RespRaw packet is left as is.
+func (p *RespPacket) ToRESP2() *RespPacket {
+	switch p.Type {
+	case RespMap, RespSet, RespPush:
+		p.Type = RespArray
+	case RespNil:
+		p.Type = RespString
+		p.Data = nil
+	case RespFloat, RespBigInt:
+		p.Type = RespString
+	case RespVerbatim:
+		p.Type = RespString
+		// the data starts with the 3 letter format, e.g. "txt:"
+		if len(p.Data) >= 4 && p.Data[3] == ':' {
+			p.Data = p.Data[4:]
+		}
+	case RespBool:
+		p.Type = RespInt
+		if string(p.Data) == "t" || string(p.Data) == "true" {
+			p.Data = []byte("1")
+		} else {
+			p.Data = []byte("0")
+		}
+	case RespBlobError:
+		// a simple error is a single line
+		p.Type = RespError
+		p.Data = bytes.ReplaceAll(p.Data, []byte(CRLF), []byte(" "))
+	}
+	if p.Type == RespArray {
+		for _, elem := range p.Array {
+			elem.ToRESP2()
+		}
+	}
+	return p
+}
+
 func (p *RespPacket) String() string {
 	switch p.Type {
 	case RespStatus:
@@ -130,13 +231,25 @@ func (p *RespPacket) String() string {
 	case RespPush:
 		return fmt.Sprintf("Push:%s", p.Array) // Similar to Array
 
+	case RespRaw:
+		return fmt.Sprintf("Raw: %d bytes", len(p.Data))
+
 	default:
 		return fmt.Sprintf("(unknown type: %c)", p.Type)
 	}
 }
 
+// ToAuthInfo returns the credentials of an AUTH, the tenant key before the default
+// common.TenantKeySeparator is dropped from the username.
 func (p *RespPacket) ToAuthInfo() *common.AuthInfo {
-	if !p.IsAuthCmd() {
+	return p.ToAuthInfoWithSeparator(common.TenantKeySeparator)
+}
+
+// ToAuthInfoWithSeparator returns the credentials of an AUTH, the username is the part after
+// the first separator, the tenant key before it is dropped. It is nil for an AUTH with a wrong
+// number of arguments.
+func (p *RespPacket) ToAuthInfoWithSeparator(separator byte) *common.AuthInfo {
+	if !p.IsAuthCmd() || len(p.Array) < 2 || len(p.Array) > 3 {
 		return nil
 	}
 	authData := p.Array
@@ -146,24 +259,75 @@ func (p *RespPacket) ToAuthInfo() *common.AuthInfo {
 			Password: authData[1].Data,
 		}
 	} else {
-		authUser := authData[1].Data
-		idx := bytes.IndexByte(authUser, common.TenantKeySeparator)
-		username := authUser
-		if idx != -1 {
-			username = authUser[idx+1:]
-		}
 		auth := &common.AuthInfo{
-			Username: username,
+			Username: trimTenantKey(authData[1].Data, separator),
 			Password: authData[2].Data,
 		}
 		return auth
 	}
 }
 
+// trimTenantKey drops the tenant key before the first separator of a username.
+func trimTenantKey(username []byte, separator byte) []byte {
+	if idx := bytes.IndexByte(username, separator); idx != -1 {
+		return username[idx+1:]
+	}
+	return username
+}
+
+// helloAuthIndex returns the index of the AUTH option of a HELLO, -1 if it has none.
+func (p *RespPacket) helloAuthIndex() int {
+	if _, ok := p.HelloProtoVer(); !ok {
+		return -1
+	}
+	for i := 2; i < len(p.Array); {
+		switch {
+		case bytes.EqualFold(p.Array[i].Data, AuthCmd) && i+2 < len(p.Array):
+			return i
+		case bytes.EqualFold(p.Array[i].Data, setNameOpt):
+			i += 2
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// IsHelloAuth reports whether the command is a HELLO authenticating the client with its AUTH
+// option.
+func (p *RespPacket) IsHelloAuth() bool {
+	return p.helloAuthIndex() != -1
+}
+
+// HelloAuthInfoWithSeparator returns the credentials of the AUTH option of a HELLO like
+// ToAuthInfoWithSeparator, nil if it has none.
+func (p *RespPacket) HelloAuthInfoWithSeparator(separator byte) *common.AuthInfo {
+	idx := p.helloAuthIndex()
+	if idx == -1 {
+		return nil
+	}
+	return &common.AuthInfo{
+		Username: trimTenantKey(p.Array[idx+1].Data, separator),
+		Password: p.Array[idx+2].Data,
+	}
+}
+
+// SetHelloAuthUsername replaces the username of the AUTH option of a HELLO, e.g. by the one
+// without the tenant key the backend knows.
+func (p *RespPacket) SetHelloAuthUsername(username []byte) {
+	if idx := p.helloAuthIndex(); idx != -1 {
+		p.Array[idx+1] = NewBulkString(username)
+	}
+}
+
 func (p *RespPacket) IsTxCmd() ([]byte, TxCmdStateType, bool) {
 	cmd := p.GetCommand()
-	if bytes.EqualFold(cmd, MultiCmd) || bytes.EqualFold(cmd, WatchCmd) {
+	if bytes.EqualFold(cmd, MultiCmd) {
 		return cmd, TxCmdStateBegin, true
+	} else if bytes.EqualFold(cmd, WatchCmd) {
+		return cmd, TxCmdStateWatch, true
+	} else if bytes.EqualFold(cmd, UnwatchCmd) {
+		return cmd, TxCmdStateUnwatch, true
 	} else if bytes.EqualFold(cmd, ExecCmd) || bytes.EqualFold(cmd, DiscardCmd) {
 		return cmd, TxCmdStateEnd, true
 	} else {
@@ -206,3 +370,57 @@ func NewAuthPacket(username, password []byte) *RespPacket {
 	packet.Array = append(packet.Array, cmdPacket, userPacket, passPacket)
 	return packet
 }
+
+// NewCommand builds a command array of bulk strings, e.g. NewCommand("PING").
+func NewCommand(args ...string) *RespPacket {
+	packet := AcquireRespPacket()
+	packet.Type = RespArray
+	for _, arg := range args {
+		argPacket := AcquireRespPacket()
+		argPacket.Type = RespString
+		argPacket.Data = []byte(arg)
+		packet.Array = append(packet.Array, argPacket)
+	}
+	return packet
+}
+
+// NewStatus builds a pooled simple string reply, e.g. NewStatus("OK").
+func NewStatus(status string) *RespPacket {
+	packet := AcquireRespPacket()
+	packet.Type = RespStatus
+	packet.Data = []byte(status)
+	return packet
+}
+
+// NewError builds a pooled error reply. The message starts with the error code, e.g. "ERR ...".
+func NewError(msg string) *RespPacket {
+	packet := AcquireRespPacket()
+	packet.Type = RespError
+	packet.Data = []byte(msg)
+	return packet
+}
+
+// NewProtoError builds a pooled error reply shaped for the protocol version of the client,
+// a blob error for RESP3 and a simple error for RESP2.
+func NewProtoError(msg string, protoVer int) *RespPacket {
+	packet := NewError(msg)
+	if protoVer >= Resp3 {
+		packet.Type = RespBlobError
+	}
+	return packet
+}
+
+func NewInteger(n int64) *RespPacket {
+	packet := AcquireRespPacket()
+	packet.Type = RespInt
+	packet.Data = strconv.AppendInt(nil, n, 10)
+	return packet
+}
+
+// NewBulkString builds a pooled bulk string reply, a nil data is written as the null bulk string.
+func NewBulkString(data []byte) *RespPacket {
+	packet := AcquireRespPacket()
+	packet.Type = RespString
+	packet.Data = data
+	return packet
+}
@@ -0,0 +1,106 @@
+package respio
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRespPacket_ToRESP2(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		expect string
+	}{
+		{name: "resp2 untouched", input: "*2\r\n$3\r\nfoo\r\n:1\r\n", expect: "*2\r\n$3\r\nfoo\r\n:1\r\n"},
+		{name: "map", input: "%2\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n$10\r\nmaxclients\r\n$5\r\n10000\r\n",
+			expect: "*4\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n$10\r\nmaxclients\r\n$5\r\n10000\r\n"},
+		{name: "nested map", input: "%1\r\n$4\r\nmeta\r\n%1\r\n$3\r\nttl\r\n,1.5\r\n",
+			expect: "*2\r\n$4\r\nmeta\r\n*2\r\n$3\r\nttl\r\n$3\r\n1.5\r\n"},
+		{name: "double", input: ",3.14\r\n", expect: "$4\r\n3.14\r\n"},
+		{name: "double inf", input: ",inf\r\n", expect: "$3\r\ninf\r\n"},
+		{name: "big number", input: "(12345678901234567890\r\n", expect: "$20\r\n12345678901234567890\r\n"},
+		{name: "null", input: "_\r\n", expect: "$-1\r\n"},
+		{name: "bool", input: "~2\r\n#t\r\n#f\r\n", expect: "*2\r\n:1\r\n:0\r\n"},
+		{name: "verbatim", input: "=15\r\ntxt:Some string\r\n", expect: "$11\r\nSome string\r\n"},
+		{name: "blob error", input: "!21\r\nSYNTAX invalid syntax\r\n", expect: "-SYNTAX invalid syntax\r\n"},
+		{name: "bool true", input: "#t\r\n", expect: ":1\r\n"},
+		{name: "bool false", input: "#f\r\n", expect: ":0\r\n"},
+		{name: "empty verbatim", input: "=4\r\ntxt:\r\n", expect: "$0\r\n\r\n"},
+		{name: "multiline blob error", input: "!13\r\nERR a\r\nb line\r\n", expect: "-ERR a b line\r\n"},
+		{name: "set of maps", input: "~1\r\n%1\r\n+k\r\n#t\r\n", expect: "*1\r\n*2\r\n+k\r\n:1\r\n"},
+		{name: "array of mixed", input: "*3\r\n$3\r\nfoo\r\n_\r\n(7\r\n", expect: "*3\r\n$3\r\nfoo\r\n$-1\r\n$1\r\n7\r\n"},
+		{name: "push", input: ">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$3\r\nmsg\r\n",
+			expect: "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$3\r\nmsg\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, err := NewRespReaderFromBytes([]byte(tt.input)).Read()
+			assert.NoError(t, err)
+
+			var buf bytes.Buffer
+			writer := &RespWriter{writer: bufio.NewWriter(&buf)}
+			assert.NoError(t, writer.Write(packet.ToRESP2()))
+			assert.NoError(t, writer.Flush())
+			assert.Equal(t, tt.expect, buf.String())
+		})
+	}
+}
+
+func TestRespPacket_ToAuthInfoWithSeparator(t *testing.T) {
+	tests := []struct {
+		username  string
+		separator byte
+		expected  string
+	}{
+		{"tk.alice", '.', "alice"},
+		{"alice", '.', "alice"},
+		{"tk:first.last", ':', "first.last"},
+		// with a custom separator the default one is part of the username
+		{"first.last", ':', "first.last"},
+		{"tk:a:b", ':', "a:b"},
+	}
+	for _, tt := range tests {
+		authInfo := NewCommand("AUTH", tt.username, "secret").ToAuthInfoWithSeparator(tt.separator)
+		assert.Equal(t, tt.expected, string(authInfo.Username), tt.username)
+		assert.Equal(t, "secret", string(authInfo.Password))
+	}
+	assert.Equal(t, "alice", string(NewCommand("AUTH", "tk.alice", "secret").ToAuthInfo().Username))
+	assert.Nil(t, NewCommand("AUTH", "secret").ToAuthInfo().Username)
+	// a wrong number of arguments has no credentials
+	assert.Nil(t, NewCommand("AUTH").ToAuthInfo())
+	assert.Nil(t, NewCommand("AUTH", "tk.alice", "secret", "extra").ToAuthInfo())
+	assert.Empty(t, NewCommand("AUTH", "tk.", "secret").ToAuthInfo().Username)
+}
+
+func TestRespPacket_HelloAuthInfoWithSeparator(t *testing.T) {
+	tests := []struct {
+		args     []string
+		username string
+	}{
+		{[]string{"HELLO", "3", "AUTH", "tk.alice", "secret"}, "alice"},
+		{[]string{"hello", "2", "auth", "alice", "secret", "SETNAME", "app"}, "alice"},
+		{[]string{"HELLO", "3", "SETNAME", "auth", "AUTH", "tk.alice", "secret"}, "alice"},
+		// no AUTH option, or an incomplete one
+		{[]string{"HELLO", "3"}, ""},
+		{[]string{"HELLO"}, ""},
+		{[]string{"HELLO", "3", "AUTH", "alice"}, ""},
+		{[]string{"HELLO", "3", "SETNAME", "app"}, ""},
+		{[]string{"AUTH", "alice", "secret"}, ""},
+	}
+	for _, tt := range tests {
+		packet := NewCommand(tt.args...)
+		authInfo := packet.HelloAuthInfoWithSeparator('.')
+		assert.Equal(t, tt.username != "", packet.IsHelloAuth(), tt.args)
+		if tt.username == "" {
+			assert.Nil(t, authInfo, tt.args)
+			continue
+		}
+		assert.Equal(t, tt.username, string(authInfo.Username), tt.args)
+		assert.Equal(t, "secret", string(authInfo.Password), tt.args)
+		packet.SetHelloAuthUsername(authInfo.Username)
+		assert.Equal(t, tt.username, string(packet.HelloAuthInfoWithSeparator(':').Username), tt.args)
+	}
+}
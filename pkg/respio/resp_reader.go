@@ -15,32 +15,122 @@ import (
 const (
 	DefaultBufferSize = 64 * common.KB
 	MaxBufferSize     = 512 * common.MB
+	// maxHeaderLen bounds a type marker, a length and the CRLF
+	maxHeaderLen = 32
+	// DefaultMaxArrayLen is the largest element count of an aggregate accepted by default
+	DefaultMaxArrayLen = 1024 * 1024
+	// maxArrayPrealloc bounds the capacity allocated from a declared element count, larger
+	// arrays grow as their elements actually arrive
+	maxArrayPrealloc = 1024
 )
 
 var (
 	ErrInvalidSyntax = errors.New("invalid RESP syntax")
 	ErrTooLarge      = errors.New("value too large")
 	ErrBadCRLFEnd    = errors.New("bad CRLF end")
+	// ErrRequestTooLarge is returned when the summed size of all bulk elements of one
+	// top-level message exceeds the reader's request size limit.
+	ErrRequestTooLarge = errors.New("request too large")
 )
 
+// ProtocolError is a malformed RESP stream. Error returns the message Redis replies with
+// before closing the connection, e.g. "ERR Protocol error: invalid bulk length". It wraps
+// the underlying error, so errors.Is(err, ErrInvalidSyntax) still matches.
+type ProtocolError struct {
+	Reason string
+	err    error
+}
+
+func (e *ProtocolError) Error() string {
+	return "ERR Protocol error: " + e.Reason
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.err
+}
+
+func newProtocolError(err error, format string, args ...any) *ProtocolError {
+	return &ProtocolError{Reason: fmt.Sprintf(format, args...), err: err}
+}
+
+// syntaxError turns a parse failure into a ProtocolError with the given reason. Errors of the
+// underlying connection are returned as is.
+func syntaxError(err error, reason string) error {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		err = ErrInvalidSyntax
+	}
+	if errors.Is(err, ErrInvalidSyntax) || errors.Is(err, ErrBadCRLFEnd) {
+		return newProtocolError(err, "%s", reason)
+	}
+	return err
+}
+
 type RespReader struct {
 	reader *bufio.Reader
+	// maxRequestSize caps the total bulk bytes of a single top-level message. 0 means unlimited.
+	maxRequestSize int64
+	// reqSize accumulates the bulk bytes of the message currently being read.
+	reqSize int64
+	// depth is the nesting level of Read, used to detect the start of a top-level message.
+	depth int
+	// maxArrayLen caps the declared element count of an aggregate
+	maxArrayLen int64
 }
 
 func NewRespReader(conn net.Conn) *RespReader {
+	return NewRespReaderSize(conn, DefaultBufferSize)
+}
+
+// NewRespReaderSize returns a reader with a buffer of size bytes. A message larger than the
+// buffer is still read, the buffer only bounds the bytes read ahead.
+func NewRespReaderSize(conn net.Conn, size int) *RespReader {
 	return &RespReader{
-		reader: bufio.NewReaderSize(conn, DefaultBufferSize),
+		reader:      bufio.NewReaderSize(conn, size),
+		maxArrayLen: DefaultMaxArrayLen,
 	}
 }
 
 func NewRespReaderFromBytes(data []byte) *RespReader {
 	return &RespReader{
-		reader: bufio.NewReader(bytes.NewReader(data)),
+		reader:      bufio.NewReader(bytes.NewReader(data)),
+		maxArrayLen: DefaultMaxArrayLen,
 	}
 }
 
+// Reset binds the reader to conn, keeping its buffer. The buffered data and the limits are
+// discarded, the reader is like a new one reading conn.
+func (r *RespReader) Reset(conn net.Conn) {
+	r.reader.Reset(conn)
+	r.maxRequestSize = 0
+	r.reqSize = 0
+	r.depth = 0
+	r.maxArrayLen = DefaultMaxArrayLen
+}
+
+// SetMaxRequestSize limits the total size of the bulk elements of one message.
+// A huge multi-bulk command (e.g. MSET with many moderate values) is rejected with
+// ErrRequestTooLarge before the oversized element is allocated.
+func (r *RespReader) SetMaxRequestSize(size int64) {
+	r.maxRequestSize = size
+}
+
+// SetMaxArrayLen limits the number of elements of an aggregate, e.g. the arguments of a
+// command. A larger declared count is a protocol error, nothing is allocated for it.
+func (r *RespReader) SetMaxArrayLen(maxLen int64) {
+	r.maxArrayLen = maxLen
+}
+
 // Read reads a complete RESP message and returns it as a RespPacket
 func (r *RespReader) Read() (*RespPacket, error) {
+	if r.depth == 0 {
+		// a new top-level message, reset the accumulator
+		r.reqSize = 0
+	}
+	r.depth++
+	defer func() {
+		r.depth--
+	}()
 	b, err := r.reader.ReadByte()
 	if err != nil {
 		// logger.Error(err, "RespReader Failed to read byte")
@@ -75,7 +165,7 @@ func (r *RespReader) Read() (*RespPacket, error) {
 		n, err := r.ReadInt()
 		if err != nil {
 			logger.Error(err, "RespInt Failed to read int")
-			return nil, err
+			return nil, syntaxError(err, "invalid integer")
 		}
 		packet := AcquireRespPacket()
 		packet.Type = RespInt
@@ -102,7 +192,10 @@ func (r *RespReader) Read() (*RespPacket, error) {
 		if err := r.skipCRLF(); err != nil {
 			return nil, err
 		}
-		return NilPacket, nil
+		// a fresh packet, the shared NilPacket must not be released into the pool
+		packet := AcquireRespPacket()
+		packet.Type = RespNil
+		return packet, nil
 	case RespBool:
 		boolVal, err := r.ReadBool()
 		if err != nil {
@@ -114,13 +207,17 @@ func (r *RespReader) Read() (*RespPacket, error) {
 		return packet, nil
 	case RespFloat:
 		// RESP3 Double/Float
-		floatVal, err := r.ReadFloat()
+		// keep the wire form (e.g. inf, -inf, nan), re-formatting is not byte-identical
+		line, err := r.readLine()
 		if err != nil {
 			return nil, err
 		}
+		if _, err := strconv.ParseFloat(string(line), 64); err != nil {
+			return nil, syntaxError(err, "invalid double")
+		}
 		packet := AcquireRespPacket()
 		packet.Type = RespFloat
-		packet.Data = []byte(fmt.Sprintf("%g", floatVal))
+		packet.Data = line
 		return packet, nil
 	case RespBigInt: // '('
 		// RESP3 Big integer
@@ -167,11 +264,15 @@ func (r *RespReader) Read() (*RespPacket, error) {
 	case RespAttr: // '|'
 		return r.ReadArrayLike(b, RespAttr, 2)
 	default:
-		// logger.Info("RespReader Invalid RESP type", "type", string(b))
-		return nil, ErrInvalidSyntax
+		return nil, newProtocolError(ErrInvalidSyntax, "unknown type byte '%c'", b)
 	}
 }
 
+// Size returns the size of the read buffer.
+func (r *RespReader) Size() int {
+	return r.reader.Size()
+}
+
 func (r *RespReader) Buffered() int {
 	return r.reader.Buffered()
 }
@@ -186,7 +287,7 @@ func (r *RespReader) ReadInt() (int64, error) {
 
 	// Check for proper CRLF ending
 	if n := len(b) - 2; n < 0 || b[n] != '\r' {
-		return 0, ErrBadCRLFEnd
+		return 0, newProtocolError(ErrBadCRLFEnd, "expected CRLF")
 	}
 
 	// Skip the type marker if present (:, $, *)
@@ -260,16 +361,19 @@ func (r *RespReader) ReadResp3Len(expectedMarker byte) (int, error) {
 		return 0, err
 	}
 	if b != expectedMarker {
-		return 0, ErrInvalidSyntax
+		return 0, newProtocolError(ErrInvalidSyntax, "expected '%c', got '%c'", expectedMarker, b)
 	}
 
 	length, err := r.ReadInt()
 	if err != nil {
-		return 0, err
+		return 0, syntaxError(err, "invalid multibulk length")
 	}
 
-	if length > 1024*1024 {
-		return 0, ErrTooLarge
+	if length > r.maxArrayLen {
+		return 0, newProtocolError(ErrTooLarge, "invalid multibulk length")
+	}
+	if length < -1 {
+		return 0, newProtocolError(ErrInvalidSyntax, "invalid multibulk length")
 	}
 
 	return int(length), nil
@@ -282,17 +386,23 @@ func (r *RespReader) BulkReadStringWithMarker(marker byte) ([]byte, error) {
 		return nil, err
 	}
 	if b != marker {
-		return nil, ErrInvalidSyntax
+		return nil, newProtocolError(ErrInvalidSyntax, "expected '%c', got '%c'", marker, b)
 	}
 	length, err := r.ReadInt()
 	if err != nil {
-		return nil, err
+		return nil, syntaxError(err, "invalid bulk length")
 	}
 	if length == -1 {
 		return nil, nil
 	}
 	if length > MaxBufferSize {
-		return nil, ErrTooLarge
+		return nil, newProtocolError(ErrTooLarge, "invalid bulk length")
+	}
+	if length < -1 {
+		return nil, newProtocolError(ErrInvalidSyntax, "invalid bulk length")
+	}
+	if err := r.accountRequestSize(length); err != nil {
+		return nil, err
 	}
 
 	buf := make([]byte, length)
@@ -306,6 +416,17 @@ func (r *RespReader) BulkReadStringWithMarker(marker byte) ([]byte, error) {
 	return buf, nil
 }
 
+func (r *RespReader) accountRequestSize(length int64) error {
+	if r.maxRequestSize <= 0 {
+		return nil
+	}
+	r.reqSize += length
+	if r.reqSize > r.maxRequestSize {
+		return ErrRequestTooLarge
+	}
+	return nil
+}
+
 func (r *RespReader) ReadFloat() (float64, error) {
 	// After ',' marker, we read until CRLF.
 	line, err := r.readLine()
@@ -333,7 +454,7 @@ func (r *RespReader) ReadBool() (bool, error) {
 	case 'f':
 		val = false
 	default:
-		return false, ErrInvalidSyntax
+		return false, newProtocolError(ErrInvalidSyntax, "invalid boolean")
 	}
 	// Next should conn CRLF
 	if err := r.skipCRLF(); err != nil {
@@ -349,10 +470,14 @@ func (r *RespReader) readLine() ([]byte, error) {
 		return nil, err
 	}
 	if len(line) < 2 || line[len(line)-2] != '\r' {
-		return nil, ErrBadCRLFEnd
+		return nil, newProtocolError(ErrBadCRLFEnd, "expected CRLF")
 	}
 	// Chop off the trailing "\r\n" so what we return is just the line data.
-	return line[:len(line)-2], nil
+	// The slice returned by ReadSlice is only valid until the next read, copy it because
+	// the packet outlives the reader's buffer.
+	data := make([]byte, len(line)-2)
+	copy(data, line)
+	return data, nil
 }
 
 // skipCRLF reads and validates CRLF
@@ -362,7 +487,7 @@ func (r *RespReader) skipCRLF() error {
 		return err
 	}
 	if b != '\r' {
-		return ErrInvalidSyntax
+		return newProtocolError(ErrBadCRLFEnd, "expected CRLF")
 	}
 
 	b, err = r.reader.ReadByte()
@@ -370,7 +495,7 @@ func (r *RespReader) skipCRLF() error {
 		return err
 	}
 	if b != '\n' {
-		return ErrInvalidSyntax
+		return newProtocolError(ErrBadCRLFEnd, "expected CRLF")
 	}
 	return nil
 }
@@ -391,9 +516,10 @@ func (r *RespReader) ReadArrayLike(marker, respType byte, multiplier int) (*Resp
 	// For maps and attributes, we need twice as many elements (key-value pairs)
 	numElements := length * multiplier
 
-	// Ensure the array has enough capacity
-	if cap(packet.Array) < numElements {
-		packet.Array = make([]*RespPacket, 0, numElements)
+	// Ensure the array has enough capacity, the declared count is not trusted beyond
+	// maxArrayPrealloc
+	if capacity := min(numElements, maxArrayPrealloc); cap(packet.Array) < capacity {
+		packet.Array = make([]*RespPacket, 0, capacity)
 	}
 
 	// Read array elements
@@ -409,3 +535,102 @@ func (r *RespReader) ReadArrayLike(marker, respType byte, multiplier int) (*Resp
 
 	return packet, nil
 }
+
+// PeekMessageLen returns the type marker and the declared length of the next message
+// without consuming it. The length is the byte size for bulk-like types and the element
+// count for aggregate types, and -1 for types without a length header.
+func (r *RespReader) PeekMessageLen() (byte, int64, error) {
+	n := 1
+	for {
+		buf, err := r.reader.Peek(n)
+		if err != nil {
+			return 0, 0, err
+		}
+		switch buf[0] {
+		case RespString, RespBlobError, RespVerbatim, RespArray, RespMap, RespSet, RespAttr, RespPush:
+		default:
+			return buf[0], -1, nil
+		}
+		if idx := bytes.IndexByte(buf, '\n'); idx >= 0 {
+			if idx < 2 || buf[idx-1] != '\r' {
+				return buf[0], -1, nil
+			}
+			length, parseErr := encodeToInt64(buf[1 : idx-1])
+			if parseErr != nil {
+				return buf[0], -1, nil
+			}
+			return buf[0], length, nil
+		}
+		if n >= maxHeaderLen {
+			return buf[0], -1, nil
+		}
+		// the header line is incomplete, wait for at least one more byte
+		n = max(n+1, r.reader.Buffered())
+		n = min(n, maxHeaderLen)
+	}
+}
+
+// ReadRaw reads one complete RESP message and returns its encoded bytes, e.g. to forward a reply
+// that needs no transformation with RespWriter.WriteRaw. The whole message is held in memory, only
+// CopyMessage to the final writer bounds it.
+func (r *RespReader) ReadRaw() ([]byte, error) {
+	var buf bytes.Buffer
+	writer := NewRespWriterFromBuffer(&buf)
+	if err := r.CopyMessage(writer); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CopyMessage reads one complete RESP message and writes its encoded bytes to w without
+// building the packet tree. Bulk payloads are streamed through the reader and writer
+// buffers, so memory use is bounded regardless of the message size.
+func (r *RespReader) CopyMessage(w *RespWriter) error {
+	// pending is the number of messages still to copy, aggregates add their elements
+	pending := int64(1)
+	for pending > 0 {
+		pending--
+		line, err := r.reader.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		if len(line) < 3 || line[len(line)-2] != '\r' {
+			return ErrBadCRLFEnd
+		}
+		marker := line[0]
+		var length int64
+		switch marker {
+		case RespString, RespBlobError, RespVerbatim, RespArray, RespMap, RespSet, RespAttr, RespPush:
+			if length, err = encodeToInt64(line[1 : len(line)-2]); err != nil {
+				return err
+			}
+		case RespStatus, RespError, RespInt, RespNil, RespFloat, RespBool, RespBigInt:
+		default:
+			return ErrInvalidSyntax
+		}
+		if _, err := w.writer.Write(line); err != nil {
+			return err
+		}
+		if length <= 0 {
+			continue
+		}
+		switch marker {
+		case RespString, RespBlobError, RespVerbatim:
+			if length > MaxBufferSize {
+				return ErrTooLarge
+			}
+			// payload and trailing CRLF
+			if _, err := io.CopyN(w.writer, r.reader, length+2); err != nil {
+				return err
+			}
+		case RespMap, RespAttr:
+			pending += length * 2
+		default:
+			pending += length
+		}
+	}
+	return nil
+}
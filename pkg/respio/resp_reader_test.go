@@ -1,6 +1,10 @@
 package respio
 
 import (
+	"bytes"
+	"errors"
+	"runtime"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -92,3 +96,125 @@ func TestRespReader_Read(t *testing.T) {
 		})
 	}
 }
+
+func TestRespReader_MaxRequestSize(t *testing.T) {
+	// MSET k1 aaaaaaaa k2 bbbbbbbb: 2 + 8 + 2 + 8 + 4 = 24 bytes of bulk data
+	msetCmd := []byte("*5\r\n$4\r\nMSET\r\n$2\r\nk1\r\n$8\r\naaaaaaaa\r\n$2\r\nk2\r\n$8\r\nbbbbbbbb\r\n")
+
+	reader := NewRespReaderFromBytes(msetCmd)
+	reader.SetMaxRequestSize(20)
+	_, err := reader.Read()
+	assert.ErrorIs(t, err, ErrRequestTooLarge)
+
+	// The accumulator is reset for each top-level command.
+	twoCmds := append(append([]byte{}, msetCmd...), msetCmd...)
+	reader = NewRespReaderFromBytes(twoCmds)
+	reader.SetMaxRequestSize(24)
+	for i := 0; i < 2; i++ {
+		packet, err := reader.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, 5, len(packet.Array))
+	}
+}
+
+func TestRespReader_MaxArrayLen(t *testing.T) {
+	allocated := func(read func()) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		read()
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	// a declared count above the limit is rejected before any allocation for it
+	var err error
+	alloc := allocated(func() {
+		_, err = NewRespReaderFromBytes([]byte("*100000000\r\n")).Read()
+	})
+	var protoErr *ProtocolError
+	assert.ErrorAs(t, err, &protoErr)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.Equal(t, "invalid multibulk length", protoErr.Reason)
+	assert.Less(t, alloc, uint64(1<<20))
+
+	// a count within the limit is not trusted for the allocation either
+	alloc = allocated(func() {
+		_, err = NewRespReaderFromBytes([]byte("*1000000\r\n$3\r\nGET\r\n")).Read()
+	})
+	assert.Error(t, err)
+	assert.Less(t, alloc, uint64(1<<20))
+
+	reader := NewRespReaderFromBytes([]byte("*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n"))
+	reader.SetMaxArrayLen(2)
+	_, err = reader.Read()
+	assert.ErrorIs(t, err, ErrTooLarge)
+	reader = NewRespReaderFromBytes([]byte("*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n"))
+	reader.SetMaxArrayLen(3)
+	packet, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Len(t, packet.Array, 3)
+}
+
+func TestRespReader_CopyMessage(t *testing.T) {
+	// LRANGE reply with 10000 elements followed by a small reply
+	var input bytes.Buffer
+	input.WriteString("*10000\r\n")
+	for i := 0; i < 10000; i++ {
+		value := strconv.Itoa(i)
+		input.WriteString("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+	}
+	largeReply := input.String()
+	input.WriteString("%1\r\n+k\r\n*2\r\n$-1\r\n_\r\n")
+
+	reader := NewRespReaderFromBytes(input.Bytes())
+	marker, length, err := reader.PeekMessageLen()
+	assert.NoError(t, err)
+	assert.Equal(t, RespArray, marker)
+	assert.Equal(t, int64(10000), length)
+
+	var out bytes.Buffer
+	writer := NewRespWriterFromBuffer(&out)
+	assert.NoError(t, reader.CopyMessage(writer))
+	assert.NoError(t, writer.Flush())
+	assert.Equal(t, largeReply, out.String())
+
+	// the stream stays in sync for the next message
+	out.Reset()
+	assert.NoError(t, reader.CopyMessage(writer))
+	assert.NoError(t, writer.Flush())
+	assert.Equal(t, "%1\r\n+k\r\n*2\r\n$-1\r\n_\r\n", out.String())
+}
+
+func TestRespReader_ProtocolError(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		reason string
+		is     error
+	}{
+		{"bad CRLF", "*1\r\n$4\r\nPINGxx", "expected CRLF", ErrBadCRLFEnd},
+		{"bad bulk length", "*2\r\n$3\r\nGET\r\n$abc\r\nkey\r\n", "invalid bulk length", ErrInvalidSyntax},
+		{"negative bulk length", "*1\r\n$-5\r\n", "invalid bulk length", ErrInvalidSyntax},
+		{"bad multibulk length", "*x\r\n$4\r\nPING\r\n", "invalid multibulk length", ErrInvalidSyntax},
+		{"unknown type byte", "@PING\r\n", "unknown type byte '@'", ErrInvalidSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, err := NewRespReaderFromBytes([]byte(tt.input)).Read()
+			// no partial packet is returned
+			assert.Nil(t, packet)
+			var protoErr *ProtocolError
+			assert.ErrorAs(t, err, &protoErr)
+			assert.Equal(t, tt.reason, protoErr.Reason)
+			assert.Equal(t, "ERR Protocol error: "+tt.reason, err.Error())
+			if tt.is != nil {
+				assert.ErrorIs(t, err, tt.is)
+			}
+		})
+	}
+
+	// an incomplete message is not a protocol error
+	_, err := NewRespReaderFromBytes([]byte("*2\r\n$3\r\nGET\r\n")).Read()
+	var protoErr *ProtocolError
+	assert.False(t, errors.As(err, &protoErr))
+}
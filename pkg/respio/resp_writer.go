@@ -2,6 +2,7 @@ package respio
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"net"
 	"strconv"
@@ -12,8 +13,31 @@ type RespWriter struct {
 }
 
 func NewRespWriter(conn net.Conn) *RespWriter {
+	return NewRespWriterSize(conn, DefaultBufferSize)
+}
+
+// NewRespWriterSize returns a writer with a buffer of size bytes.
+func NewRespWriterSize(conn net.Conn, size int) *RespWriter {
 	return &RespWriter{
-		writer: bufio.NewWriterSize(conn, DefaultBufferSize),
+		writer: bufio.NewWriterSize(conn, size),
+	}
+}
+
+// Size returns the size of the write buffer.
+func (w *RespWriter) Size() int {
+	return w.writer.Size()
+}
+
+// Reset binds the writer to conn, keeping its buffer. The data not flushed and the error of a
+// failed write are discarded.
+func (w *RespWriter) Reset(conn net.Conn) {
+	w.writer.Reset(conn)
+}
+
+// NewRespWriterFromBuffer returns a writer that encodes into buf, e.g. to capture a message.
+func NewRespWriterFromBuffer(buf *bytes.Buffer) *RespWriter {
+	return &RespWriter{
+		writer: bufio.NewWriter(buf),
 	}
 }
 
@@ -87,8 +111,9 @@ func (w *RespWriter) Write(p *RespPacket) error {
 		if err := w.writer.WriteByte(RespBool); err != nil {
 			return err
 		}
+		// the reader stores the value as strconv.FormatBool
 		b := 'f'
-		if string(p.Data) == "t" {
+		if string(p.Data) == "t" || string(p.Data) == "true" {
 			b = 't'
 		}
 		if err := w.writer.WriteByte(byte(b)); err != nil {
@@ -98,17 +123,11 @@ func (w *RespWriter) Write(p *RespPacket) error {
 
 	case RespBlobError:
 		// !<len>\r\n<bytes>\r\n
-		if err := w.writer.WriteByte(RespBlobError); err != nil {
-			return err
-		}
-		return w.WriteBulkString(p.Data)
+		return w.writeBulkWithMarker(RespBlobError, p.Data)
 
 	case RespVerbatim:
 		// =<len>\r\nFORMAT:<bytes>\r\n
-		if err := w.writer.WriteByte(RespVerbatim); err != nil {
-			return err
-		}
-		return w.WriteBulkString(p.Data)
+		return w.writeBulkWithMarker(RespVerbatim, p.Data)
 
 	case RespBigInt:
 		// (<big int>\r\n
@@ -136,12 +155,33 @@ func (w *RespWriter) Write(p *RespPacket) error {
 		// ><len>\r\n<element-1>...<element-n>
 		return w.writeArrayLike(RespPush, p.Array, false)
 
+	case RespRaw:
+		// already encoded, e.g. a large reply captured by RespReader.CopyMessage
+		return w.WriteRaw(p.Data)
+
 	default:
 		logger.Info("RespWriter Unknown packet type", "type", p.Type)
 		return ErrInvalidSyntax
 	}
 }
 
+// WriteRaw writes an already encoded message, e.g. one captured by RespReader.ReadRaw. Only
+// the type marker and the trailing CRLF are checked, the message is not parsed. Like Write it
+// only buffers the bytes, Flush sends them.
+func (w *RespWriter) WriteRaw(b []byte) error {
+	if len(b) < 3 || !bytes.HasSuffix(b, []byte(CRLF)) {
+		return ErrBadCRLFEnd
+	}
+	switch b[0] {
+	case RespStatus, RespError, RespString, RespInt, RespNil, RespFloat, RespBool, RespBlobError,
+		RespVerbatim, RespBigInt, RespArray, RespMap, RespSet, RespAttr, RespPush:
+	default:
+		return ErrInvalidSyntax
+	}
+	_, err := w.writer.Write(b)
+	return err
+}
+
 // WriteArray writes an array of RESP packets
 func (w *RespWriter) WriteArray(array []*RespPacket) error {
 	if array == nil {
@@ -173,7 +213,12 @@ func (w *RespWriter) WriteBulkString(b []byte) error {
 	if b == nil {
 		return w.writeNullBulk()
 	}
-	if err := w.writer.WriteByte(RespString); err != nil {
+	return w.writeBulkWithMarker(RespString, b)
+}
+
+// writeBulkWithMarker writes a length-prefixed payload ($, ! and =)
+func (w *RespWriter) writeBulkWithMarker(marker byte, b []byte) error {
+	if err := w.writer.WriteByte(marker); err != nil {
 		return err
 	}
 	if _, err := w.writer.WriteString(strconv.Itoa(len(b))); err != nil {
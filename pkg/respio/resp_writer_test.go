@@ -0,0 +1,67 @@
+package respio
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRespWriter_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "status", input: "+OK\r\n"},
+		{name: "error", input: "-ERR unknown command\r\n"},
+		{name: "int", input: ":-42\r\n"},
+		{name: "bulk", input: "$5\r\nhello\r\n"},
+		{name: "null bulk", input: "$-1\r\n"},
+		{name: "null", input: "_\r\n"},
+		{name: "bool true", input: "#t\r\n"},
+		{name: "bool false", input: "#f\r\n"},
+		{name: "double", input: ",3.14\r\n"},
+		{name: "double inf", input: ",inf\r\n"},
+		{name: "big number", input: "(3492890328409238509324850943850943825024385\r\n"},
+		{name: "verbatim", input: "=15\r\ntxt:Some string\r\n"},
+		{name: "blob error", input: "!21\r\nSYNTAX invalid syntax\r\n"},
+		// CONFIG GET maxmemory maxclients in RESP3
+		{name: "map", input: "%2\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n$10\r\nmaxclients\r\n$5\r\n10000\r\n"},
+		{name: "nested map", input: "%1\r\n$4\r\nmeta\r\n%1\r\n$3\r\nttl\r\n:10\r\n"},
+		{name: "set", input: "~3\r\n$1\r\na\r\n:1\r\n#t\r\n"},
+		{name: "attr", input: "|1\r\n+key-popularity\r\n%2\r\n$1\r\na\r\n,0.1923\r\n$1\r\nb\r\n,0.0012\r\n"},
+		{name: "push", input: ">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$3\r\nmsg\r\n"},
+		{name: "array of mixed", input: "*3\r\n$3\r\nfoo\r\n_\r\n%1\r\n+k\r\n+v\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, err := NewRespReaderFromBytes([]byte(tt.input)).Read()
+			assert.NoError(t, err)
+
+			var buf bytes.Buffer
+			writer := &RespWriter{writer: bufio.NewWriter(&buf)}
+			assert.NoError(t, writer.Write(packet))
+			assert.NoError(t, writer.Flush())
+			assert.Equal(t, tt.input, buf.String())
+		})
+	}
+}
+
+func TestRespWriter_WriteRaw(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewRespWriterFromBuffer(&buf)
+	assert.NoError(t, writer.WriteRaw([]byte("+OK\r\n")))
+	// like Write, nothing is sent before Flush
+	assert.Equal(t, 0, buf.Len())
+	assert.NoError(t, writer.Flush())
+
+	reader := NewRespReaderFromBytes(buf.Bytes())
+	raw, err := reader.ReadRaw()
+	assert.NoError(t, err)
+	assert.Equal(t, "+OK\r\n", string(raw))
+
+	assert.ErrorIs(t, writer.WriteRaw([]byte("+OK")), ErrBadCRLFEnd)
+	assert.ErrorIs(t, writer.WriteRaw(nil), ErrBadCRLFEnd)
+	assert.ErrorIs(t, writer.WriteRaw([]byte("OK\r\n")), ErrInvalidSyntax)
+}
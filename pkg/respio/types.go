@@ -3,6 +3,11 @@ package respio
 type TxCmdStateType string
 
 const (
+	// TxCmdStateWatch WATCH pins the connection, the commands are still executed right away
+	TxCmdStateWatch TxCmdStateType = "watch"
+	// TxCmdStateUnwatch UNWATCH releases a connection pinned by WATCH only
+	TxCmdStateUnwatch TxCmdStateType = "unwatch"
+	// TxCmdStateBegin MULTI starts queuing the commands
 	TxCmdStateBegin TxCmdStateType = "begin"
 	TxCmdStateEnd   TxCmdStateType = "end"
 )
@@ -11,11 +16,21 @@ var (
 	AuthCmd    = []byte("AUTH")
 	MultiCmd   = []byte("multi")
 	WatchCmd   = []byte("watch")
+	UnwatchCmd = []byte("unwatch")
 	ExecCmd    = []byte("exec")
 	DiscardCmd = []byte("discard")
+	ClientCmd  = []byte("client")
+	HelloCmd   = []byte("hello")
+	DebugCmd   = []byte("debug")
 	OkCmd      = []byte("OK")
 )
 
+const (
+	// Resp2 and Resp3 are the protocol versions a client negotiates with HELLO
+	Resp2 = 2
+	Resp3 = 3
+)
+
 const (
 	CRLF     = "\r\n"
 	Nil      = "$-1\r\n"
@@ -38,4 +53,7 @@ const (
 	RespSet       = byte('~') // ~<len>\r\n... (same as Array)
 	RespAttr      = byte('|') // |<len>\r\n(key)\r\n(value)\r\n... + command reply
 	RespPush      = byte('>') // ><len>\r\n... (same as Array)
+	// RespRaw is not a wire type. The packet Data holds a complete pre-encoded message
+	// which is written verbatim.
+	RespRaw = byte(1)
 )
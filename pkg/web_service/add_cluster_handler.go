@@ -1,6 +1,7 @@
 package web_service
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/pzhenzhou/elika/pkg/be_cluster"
 	"net/http"
@@ -34,14 +35,18 @@ func (a *AddTenantHandler) Handler(ctx *gin.Context) {
 	object, _ := ctx.Get(ClusterRegistryKey)
 	backendDiscovery := object.(be_cluster.ClusterRegistry)
 	err := backendDiscovery.AddCluster(&request)
-	code := http.StatusOK
-	if err != nil {
-		code = http.StatusBadRequest
-	} else {
+	code, message := http.StatusOK, "cluster added"
+	switch {
+	case errors.Is(err, be_cluster.ErrClusterExists):
+		// the instances of the cluster are kept
+		code, message = http.StatusConflict, err.Error()
+	case err != nil:
+		code, message = http.StatusBadRequest, err.Error()
+	default:
 		logger.Info("cluster added", "cluster", &request)
 	}
 	ctx.JSON(code, ApiResponse{
 		Code:    code,
-		Message: "cluster added",
+		Message: message,
 	})
 }
@@ -0,0 +1,28 @@
+package web_service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddTenantHandler_Twice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GlobalClusterRegistry())
+	handler := &AddTenantHandler{}
+	r.POST(handler.Path(), handler.Handler)
+	serve := func(body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, AddTenantPath, strings.NewReader(body)))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(`{"name":{"name":"added-twice"}}`))
+	assert.Equal(t, http.StatusConflict, serve(`{"name":{"name":"added-twice"}}`))
+	assert.Equal(t, http.StatusBadRequest, serve(`{"name":`))
+}
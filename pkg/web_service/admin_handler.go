@@ -0,0 +1,44 @@
+package web_service
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var _ WebHandler = (*AdminHandler)(nil)
+
+// AdminHandler guards a handler with the admin token, sent as "Authorization: Bearer <token>".
+// Without a configured token the handler is disabled.
+type AdminHandler struct {
+	WebHandler
+	token string
+}
+
+func NewAdminHandler(token string, handler WebHandler) *AdminHandler {
+	return &AdminHandler{
+		WebHandler: handler,
+		token:      token,
+	}
+}
+
+func (a *AdminHandler) Handler(ctx *gin.Context) {
+	if a.token == "" {
+		ctx.JSON(http.StatusForbidden, ApiResponse{
+			Code:    http.StatusForbidden,
+			Message: "admin endpoints are disabled, set --web-proxy.admin-token",
+		})
+		return
+	}
+	token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		ctx.JSON(http.StatusUnauthorized, ApiResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "invalid admin token",
+		})
+		return
+	}
+	a.WebHandler.Handler(ctx)
+}
@@ -0,0 +1,39 @@
+package web_service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		token    string
+		header   string
+		expected int
+	}{
+		{name: "no token configured", token: "", header: "Bearer ", expected: http.StatusForbidden},
+		{name: "missing header", token: "secret", header: "", expected: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", header: "Bearer other", expected: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", header: "Bearer secret", expected: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			handler := NewAdminHandler(tt.token, &HealthCheckHandler{})
+			r.GET(handler.Path(), handler.Handler)
+			req := httptest.NewRequest(http.MethodGet, handler.Path(), nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package web_service
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	ClusterEventsPath = "/cluster/events"
+	clusterEventName  = "cluster"
+)
+
+var _ WebHandler = (*ClusterEventsHandler)(nil)
+
+// ClusterEventsHandler streams the cluster instance changes of the registry as server-sent
+// events, GET /cluster/events. The stream ends when the client goes away, or when it falls
+// behind the registry; it lists the clusters with /list_cluster before it subscribes again.
+type ClusterEventsHandler struct {
+}
+
+func (h *ClusterEventsHandler) Path() string {
+	return ClusterEventsPath
+}
+
+func (h *ClusterEventsHandler) Method() HttpMethod {
+	return GET
+}
+
+func (h *ClusterEventsHandler) Handler(ctx *gin.Context) {
+	object, _ := ctx.Get(ClusterRegistryKey)
+	registry := object.(be_cluster.ClusterRegistry)
+	events, unsubscribe := registry.SubscribeChanges()
+	defer unsubscribe()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	// the headers tell the client it is subscribed, before the first change
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Request.Context().Done():
+			return false
+		case instance, ok := <-events:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent(clusterEventName, instance)
+			return true
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package web_service
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterEventsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := be_cluster.GetClusterRegistry()
+	r := gin.New()
+	r.Use(GlobalClusterRegistry())
+	handler := &ClusterEventsHandler{}
+	r.GET(handler.Path(), handler.Handler)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + ClusterEventsPath)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	instance := be_cluster.LocalClusterInstance("10.0.0.7", 6379)
+	instance.Key.Name.Name = "events-cluster"
+	assert.NoError(t, registry.AddCluster(&instance.Key))
+	assert.NoError(t, registry.StatusChange(instance))
+	<-registry.Notify()
+
+	reader := bufio.NewReader(resp.Body)
+	event, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event:"+clusterEventName+"\n", event)
+	data, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(data, "data:"))
+	assert.Contains(t, data, `"name":"events-cluster"`)
+	assert.Contains(t, data, `"addr":"10.0.0.7"`)
+}
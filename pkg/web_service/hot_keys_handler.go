@@ -0,0 +1,53 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	HotKeysPath = "/hotkeys"
+)
+
+var _ WebHandler = (*HotKeysHandler)(nil)
+
+// HotKeySource provides the sampled hot keys, implemented by be_cluster.SessionManager.
+type HotKeySource interface {
+	HotKeys() ([]be_cluster.InstanceHotKeys, bool)
+}
+
+type HotKeysHandler struct {
+	source HotKeySource
+}
+
+func NewHotKeysHandler(source HotKeySource) *HotKeysHandler {
+	return &HotKeysHandler{
+		source: source,
+	}
+}
+
+func (h *HotKeysHandler) Path() string {
+	return HotKeysPath
+}
+
+func (h *HotKeysHandler) Method() HttpMethod {
+	return GET
+}
+
+func (h *HotKeysHandler) Handler(ctx *gin.Context) {
+	hotKeys, enabled := h.source.HotKeys()
+	if !enabled {
+		ctx.JSON(http.StatusNotFound, ApiResponse{
+			Code:    http.StatusNotFound,
+			Message: "hot key sampling is disabled, set --hotkey.sample-rate",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "success",
+		Data:    hotKeys,
+	})
+}
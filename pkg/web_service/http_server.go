@@ -11,6 +11,7 @@ import (
 	"github.com/pzhenzhou/elika/pkg/metrics"
 	"github.com/samber/lo"
 	"github.com/soheilhy/cmux"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -59,8 +60,8 @@ func NewWebServer(config *common.ProxyConfig) *WebServer {
 		&HealthCheckHandler{},
 	}
 	if config.Router.RouterType == "sync" {
-		allHandler = append(allHandler, &AddTenantHandler{},
-			&ListAllTenantsHandler{})
+		allHandler = append(allHandler, NewAdminHandler(config.WebServer.AdminToken, &AddTenantHandler{}),
+			&ListAllTenantsHandler{}, &ClusterEventsHandler{})
 	}
 	return NewWebServerWithHandlers(config, allHandler)
 }
@@ -103,7 +104,8 @@ func initWebServer(config *common.ProxyConfig) *WebServer {
 			if strings.HasPrefix(c.Request.URL.Path, "debug") {
 				return true
 			}
-			return c.Request.URL.Path == "/healthz" && c.Request.Method == "GET"
+			return (c.Request.URL.Path == "/healthz" || c.Request.URL.Path == ReadyPath) &&
+				c.Request.Method == "GET"
 		},
 	}))
 	if enablePprof {
@@ -118,13 +120,18 @@ func initWebServer(config *common.ProxyConfig) *WebServer {
 	}
 }
 
+// Start serves the http requests of the service port, multiplexed by m.
 func (s *WebServer) Start(m cmux.CMux) error {
-	httpL := m.Match(cmux.HTTP1Fast())
+	return s.Serve(m.Match(cmux.HTTP1Fast()))
+}
+
+// Serve serves the http requests accepted by lis, e.g. the listener of a dedicated http port.
+func (s *WebServer) Serve(lis net.Listener) error {
 	httpServer := &http.Server{
 		Handler: s.r,
 	}
 	s.server = httpServer
-	if err := httpServer.Serve(httpL); err != nil {
+	if err := httpServer.Serve(lis); err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
 			return nil
 		}
@@ -146,10 +153,26 @@ func (s *WebServer) Shutdown(ctx context.Context) {
 	}
 }
 
+// SetMetricHandler serves the metrics of the collector on GET metricsPath, unless a handler
+// already owns the path.
 func (s *WebServer) SetMetricHandler(metricsPath string, collector metrics.ProxyMetricsCollector) {
+	_, ok := lo.Find(s.handlers, func(item WebHandler) bool {
+		return item.Path() == metricsPath && item.Method() == GET
+	})
+	if ok {
+		logger.Info("metrics path already registered", "Path", metricsPath)
+		return
+	}
+	logger.Info("WebServer register metrics handler", "Path", metricsPath)
 	s.r.GET(metricsPath, collector.Handler())
 }
 
+// AddHandler registers a handler that depends on state created outside the web service,
+// it must be called before Start.
+func (s *WebServer) AddHandler(handler WebHandler) {
+	s.registerHandler(handler)
+}
+
 func (s *WebServer) registerHandler(handler WebHandler) {
 	_, ok := lo.Find(s.handlers, func(item WebHandler) bool {
 		return item.Path() == handler.Path() && item.Method() == handler.Method()
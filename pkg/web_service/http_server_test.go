@@ -0,0 +1,90 @@
+package web_service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/pzhenzhou/elika/pkg/metrics"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebServer_MetricsPath(t *testing.T) {
+	config := &common.ProxyConfig{
+		Router:  common.BackendRouterConfig{RouterType: "static"},
+		Metrics: common.MetricsConfig{EnableMetrics: true, MetricsPath: "/internal/metrics"},
+	}
+	collector, err := metrics.NewMetricsCollector(metrics.NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+
+	srv := NewWebServer(config)
+	srv.SetMetricHandler(config.Metrics.MetricsPath, collector)
+	w := httptest.NewRecorder()
+	srv.r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Counters")
+
+	// a path owned by a handler is kept
+	assert.NotPanics(t, func() {
+		srv.SetMetricHandler("/healthz", collector)
+	})
+	w = httptest.NewRecorder()
+	srv.r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+}
+
+func TestWebServer_AddClusterNeedsAdminToken(t *testing.T) {
+	config := &common.ProxyConfig{
+		Router:    common.BackendRouterConfig{RouterType: "sync"},
+		WebServer: common.WebServerConfig{AdminToken: "secret"},
+	}
+	srv := NewWebServer(config)
+	addCluster, ok := lo.Find(srv.handlers, func(handler WebHandler) bool {
+		return handler.Path() == AddTenantPath
+	})
+	assert.True(t, ok)
+	assert.IsType(t, &AdminHandler{}, addCluster)
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodPost, AddTenantPath, nil)
+	addCluster.Handler(ctx)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestWebServer_ServeHttpPort(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	_ = lis.Close()
+	config := &common.ProxyConfig{
+		Router:   common.BackendRouterConfig{RouterType: "static"},
+		HttpPort: port,
+	}
+
+	srv := NewWebServer(config)
+	httpListener := config.HttpListener()
+	assert.Equal(t, port, httpListener.Addr().(*net.TCPAddr).Port)
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(httpListener)
+	}()
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/healthz", port))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"status": "ok"}`, string(body))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+	assert.NoError(t, <-served)
+}
@@ -0,0 +1,42 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/metrics"
+)
+
+const (
+	MetricsResetPath = "/metrics/reset"
+)
+
+var _ WebHandler = (*MetricsResetHandler)(nil)
+
+// MetricsResetHandler clears the in-memory metrics, it is registered behind the admin token.
+type MetricsResetHandler struct {
+	collector metrics.ProxyMetricsCollector
+}
+
+func NewMetricsResetHandler(collector metrics.ProxyMetricsCollector) *MetricsResetHandler {
+	return &MetricsResetHandler{
+		collector: collector,
+	}
+}
+
+func (m *MetricsResetHandler) Path() string {
+	return MetricsResetPath
+}
+
+func (m *MetricsResetHandler) Method() HttpMethod {
+	return POST
+}
+
+func (m *MetricsResetHandler) Handler(ctx *gin.Context) {
+	m.collector.Reset()
+	logger.Info("metrics reset", "ClientIP", ctx.ClientIP())
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "in-memory metrics reset, Prometheus counters are cumulative and kept",
+	})
+}
@@ -0,0 +1,60 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	ReadyPath = "/readyz"
+)
+
+var _ WebHandler = (*ReadyHandler)(nil)
+
+// ProbeSource pings the backend instances, implemented by be_cluster.SessionManager.
+type ProbeSource interface {
+	ProbeBackends() []be_cluster.InstanceProbe
+}
+
+// ReadyHandler reports whether every backend instance answers a PING, GET /readyz. Unlike
+// /healthz it checks the backend connections, a pool stays ready when its connections died
+// since. It answers 503 with the health of each instance when one of them is down, or when no
+// instance is online yet.
+type ReadyHandler struct {
+	source ProbeSource
+}
+
+func NewReadyHandler(source ProbeSource) *ReadyHandler {
+	return &ReadyHandler{
+		source: source,
+	}
+}
+
+func (h *ReadyHandler) Path() string {
+	return ReadyPath
+}
+
+func (h *ReadyHandler) Method() HttpMethod {
+	return GET
+}
+
+func (h *ReadyHandler) Handler(ctx *gin.Context) {
+	probes := h.source.ProbeBackends()
+	code, message := http.StatusOK, "ready"
+	if len(probes) == 0 {
+		code, message = http.StatusServiceUnavailable, "no backend online"
+	}
+	for _, probe := range probes {
+		if !probe.Healthy {
+			code, message = http.StatusServiceUnavailable, "backend unhealthy"
+			break
+		}
+	}
+	ctx.JSON(code, ApiResponse{
+		Code:    code,
+		Message: message,
+		Data:    probes,
+	})
+}
@@ -0,0 +1,47 @@
+package web_service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProbeSource []be_cluster.InstanceProbe
+
+func (f fakeProbeSource) ProbeBackends() []be_cluster.InstanceProbe {
+	return f
+}
+
+func TestReadyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serve := func(source ProbeSource) (*httptest.ResponseRecorder, []be_cluster.InstanceProbe) {
+		r := gin.New()
+		handler := NewReadyHandler(source)
+		r.GET(handler.Path(), handler.Handler)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ReadyPath, nil))
+		var response struct {
+			Data []be_cluster.InstanceProbe `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response.Data
+	}
+	live := be_cluster.InstanceProbe{Addr: "127.0.0.1:6379", Healthy: true}
+	dead := be_cluster.InstanceProbe{Addr: "127.0.0.1:6380", Error: "PING timed out"}
+
+	w, probes := serve(fakeProbeSource{live})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []be_cluster.InstanceProbe{live}, probes)
+
+	w, probes = serve(fakeProbeSource{live, dead})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, []be_cluster.InstanceProbe{live, dead}, probes)
+
+	w, _ = serve(fakeProbeSource{})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
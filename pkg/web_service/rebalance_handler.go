@@ -0,0 +1,68 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	RebalancePath = "/rebalance"
+)
+
+var _ WebHandler = (*RebalanceHandler)(nil)
+
+// RebalanceRequest selects the sessions to move. Addr drains a backend instance,
+// ClusterKey re-balances the sessions of a cluster across its instances.
+type RebalanceRequest struct {
+	Addr       string                 `json:"addr,omitempty"`
+	ClusterKey *be_cluster.ClusterKey `json:"cluster_key,omitempty"`
+}
+
+type RebalanceHandler struct {
+	sessionMgr *be_cluster.SessionManager
+}
+
+func NewRebalanceHandler(sessionMgr *be_cluster.SessionManager) *RebalanceHandler {
+	return &RebalanceHandler{
+		sessionMgr: sessionMgr,
+	}
+}
+
+func (r *RebalanceHandler) Path() string {
+	return RebalancePath
+}
+
+func (r *RebalanceHandler) Method() HttpMethod {
+	return POST
+}
+
+func (r *RebalanceHandler) Handler(ctx *gin.Context) {
+	var request RebalanceRequest
+	if err := ctx.ShouldBindBodyWithJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	var moved int
+	if request.Addr != "" {
+		moved = r.sessionMgr.RebalanceInstance(request.Addr)
+	} else if request.ClusterKey != nil {
+		moved = r.sessionMgr.RebalanceCluster(*request.ClusterKey)
+	} else {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: "addr or cluster_key is required",
+		})
+		return
+	}
+	logger.Info("sessions rebalanced", "Addr", request.Addr, "ClusterKey", request.ClusterKey, "Sessions", moved)
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "sessions will be re-routed at their next command",
+		Data:    gin.H{"sessions": moved},
+	})
+}
@@ -0,0 +1,56 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	RingPath = "/ring"
+)
+
+var _ WebHandler = (*RingHandler)(nil)
+
+// RingSource reports the consistent hash rings of the backend pools, implemented by
+// be_cluster.SessionManager.
+type RingSource interface {
+	RingStatus(tenant string) ([]be_cluster.RingStatus, error)
+}
+
+// RingHandler shows how the sessions spread over the connections of each backend pool,
+// GET /ring[?tenant=<name>]. A member owning far more partitions than the others is a hotspot.
+type RingHandler struct {
+	source RingSource
+}
+
+func NewRingHandler(source RingSource) *RingHandler {
+	return &RingHandler{
+		source: source,
+	}
+}
+
+func (r *RingHandler) Path() string {
+	return RingPath
+}
+
+func (r *RingHandler) Method() HttpMethod {
+	return GET
+}
+
+func (r *RingHandler) Handler(ctx *gin.Context) {
+	rings, err := r.source.RingStatus(ctx.Query("tenant"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, ApiResponse{
+			Code:    http.StatusNotFound,
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "success",
+		Data:    rings,
+	})
+}
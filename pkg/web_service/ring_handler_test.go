@@ -0,0 +1,55 @@
+package web_service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRingSource struct{}
+
+func (f fakeRingSource) RingStatus(tenant string) ([]be_cluster.RingStatus, error) {
+	ringA := be_cluster.RingStatus{Addr: "127.0.0.1:6379", Partitions: 2, MaxPartitions: 2,
+		Members: []be_cluster.RingMember{{ConnId: "conn-1", Partitions: 2, Share: 1}}}
+	switch tenant {
+	case "":
+		return []be_cluster.RingStatus{ringA, {Addr: "127.0.0.1:6380", Partitions: 2}}, nil
+	case "tenant-a":
+		return []be_cluster.RingStatus{ringA}, nil
+	default:
+		return nil, errors.New("no tenant key found")
+	}
+}
+
+func TestRingHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := NewRingHandler(fakeRingSource{})
+	r.GET(handler.Path(), handler.Handler)
+	serve := func(query string) (*httptest.ResponseRecorder, []be_cluster.RingStatus) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RingPath+query, nil))
+		var response struct {
+			Data []be_cluster.RingStatus `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response.Data
+	}
+
+	w, rings := serve("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, rings, 2)
+
+	w, rings = serve("?tenant=tenant-a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []be_cluster.RingMember{{ConnId: "conn-1", Partitions: 2, Share: 1}}, rings[0].Members)
+
+	w, _ = serve("?tenant=unknown")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
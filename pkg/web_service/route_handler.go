@@ -0,0 +1,64 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	RoutePath = "/route"
+)
+
+var _ WebHandler = (*RouteHandler)(nil)
+
+// RouteExplainer runs the routing of a session without forwarding, implemented by
+// be_cluster.SessionManager.
+type RouteExplainer interface {
+	ExplainRoute(tenant string, key string) (*be_cluster.RouteDecision, error)
+}
+
+// RouteHandler tells where a session would be routed, GET /route?tenant=<name>&key=<id>. The
+// key is the session id, the remote address of the client.
+type RouteHandler struct {
+	explainer RouteExplainer
+}
+
+func NewRouteHandler(explainer RouteExplainer) *RouteHandler {
+	return &RouteHandler{
+		explainer: explainer,
+	}
+}
+
+func (r *RouteHandler) Path() string {
+	return RoutePath
+}
+
+func (r *RouteHandler) Method() HttpMethod {
+	return GET
+}
+
+func (r *RouteHandler) Handler(ctx *gin.Context) {
+	tenant, key := ctx.Query("tenant"), ctx.Query("key")
+	if tenant == "" || key == "" {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: "tenant and key are required",
+		})
+		return
+	}
+	decision, err := r.explainer.ExplainRoute(tenant, key)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, ApiResponse{
+			Code:    http.StatusNotFound,
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "success",
+		Data:    decision,
+	})
+}
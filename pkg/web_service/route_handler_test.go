@@ -0,0 +1,45 @@
+package web_service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRouteExplainer struct{}
+
+func (f fakeRouteExplainer) ExplainRoute(tenant string, key string) (*be_cluster.RouteDecision, error) {
+	if tenant != "tenant-a" {
+		return nil, errors.New("no tenant key found")
+	}
+	return &be_cluster.RouteDecision{Addr: "127.0.0.1:6379", ConnId: "conn-" + key}, nil
+}
+
+func TestRouteHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := NewRouteHandler(fakeRouteExplainer{})
+	r.GET(handler.Path(), handler.Handler)
+	serve := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutePath+query, nil))
+		return w
+	}
+
+	w := serve("?tenant=tenant-a&key=10.0.0.1:5000")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data be_cluster.RouteDecision `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, be_cluster.RouteDecision{Addr: "127.0.0.1:6379", ConnId: "conn-10.0.0.1:5000"}, response.Data)
+
+	assert.Equal(t, http.StatusBadRequest, serve("?tenant=tenant-a").Code)
+	assert.Equal(t, http.StatusNotFound, serve("?tenant=unknown&key=k").Code)
+}
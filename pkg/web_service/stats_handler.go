@@ -0,0 +1,69 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/pzhenzhou/elika/pkg/metrics"
+)
+
+const (
+	StatsPath = "/stats"
+)
+
+var _ WebHandler = (*StatsHandler)(nil)
+
+// ProxyStatsSource is the proxy state reported next to the metrics, implemented by
+// be_cluster.SessionManager.
+type ProxyStatsSource interface {
+	SessionCount() int
+	PoolStatus() []be_cluster.PoolStatus
+}
+
+// StatsResponse is the typed alternative to the raw in-memory metrics dump.
+type StatsResponse struct {
+	metrics.Snapshot
+	ActiveConnections int                     `json:"active_connections"`
+	Backends          []be_cluster.PoolStatus `json:"backends"`
+}
+
+type StatsHandler struct {
+	collector metrics.ProxyMetricsCollector
+	source    ProxyStatsSource
+}
+
+func NewStatsHandler(collector metrics.ProxyMetricsCollector, source ProxyStatsSource) *StatsHandler {
+	return &StatsHandler{
+		collector: collector,
+		source:    source,
+	}
+}
+
+func (s *StatsHandler) Path() string {
+	return StatsPath
+}
+
+func (s *StatsHandler) Method() HttpMethod {
+	return GET
+}
+
+func (s *StatsHandler) Handler(ctx *gin.Context) {
+	snapshot, err := s.collector.Snapshot()
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, ApiResponse{
+			Code:    http.StatusServiceUnavailable,
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "success",
+		Data: StatsResponse{
+			Snapshot:          *snapshot,
+			ActiveConnections: s.source.SessionCount(),
+			Backends:          s.source.PoolStatus(),
+		},
+	})
+}
@@ -0,0 +1,54 @@
+package web_service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/pzhenzhou/elika/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStatsSource struct{}
+
+func (f fakeStatsSource) SessionCount() int {
+	return 3
+}
+
+func (f fakeStatsSource) PoolStatus() []be_cluster.PoolStatus {
+	return []be_cluster.PoolStatus{{Addr: "127.0.0.1:6379", Connections: 4, InTxn: 1}}
+}
+
+func TestStatsHandler(t *testing.T) {
+	collector, err := metrics.NewMetricsCollector(metrics.NewInMemoryConfig("elika-test"))
+	assert.NoError(t, err)
+	collector.IncrementCommandCounter("GET")
+	collector.IncrementErrorCounter("dispatch_error")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := NewStatsHandler(collector, fakeStatsSource{})
+	r.GET(handler.Path(), handler.Handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, StatsPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Code int                        `json:"code"`
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, http.StatusOK, response.Code)
+	for _, key := range []string{"interval", "total_commands", "errors", "latency_p50_us", "latency_p99_us",
+		"commands", "active_connections", "backends"} {
+		assert.Contains(t, response.Data, key)
+	}
+	assert.JSONEq(t, `1`, string(response.Data["total_commands"]))
+	assert.JSONEq(t, `{"dispatch_error": 1}`, string(response.Data["errors"]))
+	assert.JSONEq(t, `3`, string(response.Data["active_connections"]))
+	assert.JSONEq(t, `[{"addr": "127.0.0.1:6379", "connections": 4, "in_txn": 1, "write_q": 0,
+		"pending_q": 0, "in_flight": 0, "draining": false, "unavailable": false}]`, string(response.Data["backends"]))
+}
@@ -0,0 +1,90 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	TenantACLPath = "/tenant_acl"
+)
+
+var (
+	_ WebHandler = (*SetTenantACLHandler)(nil)
+	_ WebHandler = (*DeleteTenantACLHandler)(nil)
+)
+
+// TenantACLRequest sets the ACL of the tenant, the AUTH username of its clients.
+type TenantACLRequest struct {
+	Tenant string `json:"tenant" binding:"required"`
+	be_cluster.TenantACL
+}
+
+// SetTenantACLHandler replaces the ACL of a tenant, PUT /tenant_acl.
+type SetTenantACLHandler struct{}
+
+func (s *SetTenantACLHandler) Path() string {
+	return TenantACLPath
+}
+
+func (s *SetTenantACLHandler) Method() HttpMethod {
+	return PUT
+}
+
+func (s *SetTenantACLHandler) Handler(ctx *gin.Context) {
+	var request TenantACLRequest
+	if err := ctx.ShouldBindBodyWithJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	object, _ := ctx.Get(ClusterRegistryKey)
+	registry := object.(be_cluster.ClusterRegistry)
+	acl := request.TenantACL
+	if err := registry.SetTenantACL(request.Tenant, &acl); err != nil {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	logger.Info("tenant ACL set", "tenant", request.Tenant, "acl", acl)
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "tenant ACL set",
+	})
+}
+
+// DeleteTenantACLHandler lifts the restrictions of a tenant, DELETE /tenant_acl?tenant=<name>.
+type DeleteTenantACLHandler struct{}
+
+func (d *DeleteTenantACLHandler) Path() string {
+	return TenantACLPath
+}
+
+func (d *DeleteTenantACLHandler) Method() HttpMethod {
+	return DELETE
+}
+
+func (d *DeleteTenantACLHandler) Handler(ctx *gin.Context) {
+	tenant := ctx.Query("tenant")
+	if tenant == "" {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: "tenant is required",
+		})
+		return
+	}
+	object, _ := ctx.Get(ClusterRegistryKey)
+	registry := object.(be_cluster.ClusterRegistry)
+	registry.DeleteTenantACL(tenant)
+	logger.Info("tenant ACL deleted", "tenant", tenant)
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "tenant ACL deleted",
+	})
+}
@@ -0,0 +1,45 @@
+package web_service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantACLHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := be_cluster.GetClusterRegistry()
+	r := gin.New()
+	r.Use(GlobalClusterRegistry())
+	setHandler, deleteHandler := &SetTenantACLHandler{}, &DeleteTenantACLHandler{}
+	r.PUT(setHandler.Path(), setHandler.Handler)
+	r.DELETE(deleteHandler.Path(), deleteHandler.Handler)
+	serve := func(method, path, body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, TenantACLPath,
+		`{"tenant":"acl-reader","allowed_commands":["@read"],"key_patterns":["app:*"]}`))
+	acl := registry.GetTenantACL("acl-reader")
+	assert.Equal(t, &be_cluster.TenantACL{
+		AllowedCommands: []string{"@read"},
+		KeyPatterns:     []string{"app:*"},
+	}, acl)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, TenantACLPath,
+		`{"tenant":"acl-reader","allowed_commands":["@unknown"]}`))
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, TenantACLPath, `{"allowed_commands":["@read"]}`))
+	// a rejected ACL keeps the previous one
+	assert.Equal(t, acl, registry.GetTenantACL("acl-reader"))
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodDelete, TenantACLPath, ""))
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, TenantACLPath+"?tenant=acl-reader", ""))
+	assert.Nil(t, registry.GetTenantACL("acl-reader"))
+}
@@ -0,0 +1,89 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	TenantBindingPath = "/tenant_binding"
+)
+
+var (
+	_ WebHandler = (*BindTenantHandler)(nil)
+	_ WebHandler = (*UnbindTenantHandler)(nil)
+)
+
+// TenantBindingRequest routes the tenant, the AUTH username of its clients, to a cluster.
+type TenantBindingRequest struct {
+	Tenant  string                `json:"tenant" binding:"required"`
+	Cluster be_cluster.ClusterKey `json:"cluster" binding:"required"`
+}
+
+// BindTenantHandler binds a tenant to a registered cluster, POST /tenant_binding.
+type BindTenantHandler struct{}
+
+func (b *BindTenantHandler) Path() string {
+	return TenantBindingPath
+}
+
+func (b *BindTenantHandler) Method() HttpMethod {
+	return POST
+}
+
+func (b *BindTenantHandler) Handler(ctx *gin.Context) {
+	var request TenantBindingRequest
+	if err := ctx.ShouldBindBodyWithJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	object, _ := ctx.Get(ClusterRegistryKey)
+	registry := object.(be_cluster.ClusterRegistry)
+	if err := registry.BindTenant(request.Tenant, request.Cluster); err != nil {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	logger.Info("tenant bound", "tenant", request.Tenant, "cluster", request.Cluster)
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "tenant bound",
+	})
+}
+
+// UnbindTenantHandler removes the binding of a tenant, DELETE /tenant_binding?tenant=<name>.
+type UnbindTenantHandler struct{}
+
+func (u *UnbindTenantHandler) Path() string {
+	return TenantBindingPath
+}
+
+func (u *UnbindTenantHandler) Method() HttpMethod {
+	return DELETE
+}
+
+func (u *UnbindTenantHandler) Handler(ctx *gin.Context) {
+	tenant := ctx.Query("tenant")
+	if tenant == "" {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: "tenant is required",
+		})
+		return
+	}
+	object, _ := ctx.Get(ClusterRegistryKey)
+	registry := object.(be_cluster.ClusterRegistry)
+	registry.UnbindTenant(tenant)
+	logger.Info("tenant unbound", "tenant", tenant)
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "tenant unbound",
+	})
+}
@@ -0,0 +1,44 @@
+package web_service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantBindingHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := be_cluster.GetClusterRegistry()
+	r := gin.New()
+	r.Use(GlobalClusterRegistry())
+	bindHandler, unbindHandler := &BindTenantHandler{}, &UnbindTenantHandler{}
+	r.POST(bindHandler.Path(), bindHandler.Handler)
+	r.DELETE(unbindHandler.Path(), unbindHandler.Handler)
+	serve := func(method, path, body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	// the cluster must be registered first
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, TenantBindingPath,
+		`{"tenant":"binding-tenant","cluster":{"name":{"name":"binding-cluster"}}}`))
+	assert.Nil(t, registry.GetTenantBinding("binding-tenant"))
+
+	key := be_cluster.ClusterKey{Name: be_cluster.ClusterName{Name: "binding-cluster"}}
+	assert.NoError(t, registry.AddCluster(&key))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, TenantBindingPath,
+		`{"tenant":"binding-tenant","cluster":{"name":{"name":"binding-cluster"}}}`))
+	assert.Equal(t, &key, registry.GetTenantBinding("binding-tenant"))
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, TenantBindingPath,
+		`{"cluster":{"name":{"name":"binding-cluster"}}}`))
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodDelete, TenantBindingPath, ""))
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, TenantBindingPath+"?tenant=binding-tenant", ""))
+	assert.Nil(t, registry.GetTenantBinding("binding-tenant"))
+}
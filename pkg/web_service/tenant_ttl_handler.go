@@ -0,0 +1,90 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	TenantTTLPath = "/tenant_ttl"
+)
+
+var (
+	_ WebHandler = (*SetTenantTTLHandler)(nil)
+	_ WebHandler = (*DeleteTenantTTLHandler)(nil)
+)
+
+// TenantTTLRequest sets the TTL policy of the tenant, the AUTH username of its clients.
+type TenantTTLRequest struct {
+	Tenant string `json:"tenant" binding:"required"`
+	be_cluster.TenantTTLPolicy
+}
+
+// SetTenantTTLHandler replaces the TTL policy of a tenant, PUT /tenant_ttl.
+type SetTenantTTLHandler struct{}
+
+func (s *SetTenantTTLHandler) Path() string {
+	return TenantTTLPath
+}
+
+func (s *SetTenantTTLHandler) Method() HttpMethod {
+	return PUT
+}
+
+func (s *SetTenantTTLHandler) Handler(ctx *gin.Context) {
+	var request TenantTTLRequest
+	if err := ctx.ShouldBindBodyWithJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	object, _ := ctx.Get(ClusterRegistryKey)
+	registry := object.(be_cluster.ClusterRegistry)
+	policy := request.TenantTTLPolicy
+	if err := registry.SetTenantTTLPolicy(request.Tenant, &policy); err != nil {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+	logger.Info("tenant TTL policy set", "tenant", request.Tenant, "policy", policy)
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "tenant TTL policy set",
+	})
+}
+
+// DeleteTenantTTLHandler removes the TTL policy of a tenant, DELETE /tenant_ttl?tenant=<name>.
+type DeleteTenantTTLHandler struct{}
+
+func (d *DeleteTenantTTLHandler) Path() string {
+	return TenantTTLPath
+}
+
+func (d *DeleteTenantTTLHandler) Method() HttpMethod {
+	return DELETE
+}
+
+func (d *DeleteTenantTTLHandler) Handler(ctx *gin.Context) {
+	tenant := ctx.Query("tenant")
+	if tenant == "" {
+		ctx.JSON(http.StatusBadRequest, ApiResponse{
+			Code:    http.StatusBadRequest,
+			Message: "tenant is required",
+		})
+		return
+	}
+	object, _ := ctx.Get(ClusterRegistryKey)
+	registry := object.(be_cluster.ClusterRegistry)
+	registry.DeleteTenantTTLPolicy(tenant)
+	logger.Info("tenant TTL policy deleted", "tenant", tenant)
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "tenant TTL policy deleted",
+	})
+}
@@ -0,0 +1,40 @@
+package web_service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantTTLHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := be_cluster.GetClusterRegistry()
+	r := gin.New()
+	r.Use(GlobalClusterRegistry())
+	setHandler, deleteHandler := &SetTenantTTLHandler{}, &DeleteTenantTTLHandler{}
+	r.PUT(setHandler.Path(), setHandler.Handler)
+	r.DELETE(deleteHandler.Path(), deleteHandler.Handler)
+	serve := func(method, path, body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, TenantTTLPath, `{"tenant":"ttl-cache","max_ttl":300}`))
+	policy := registry.GetTenantTTLPolicy("ttl-cache")
+	assert.Equal(t, &be_cluster.TenantTTLPolicy{MaxTTL: 300}, policy)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, TenantTTLPath, `{"tenant":"ttl-cache","max_ttl":0}`))
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, TenantTTLPath, `{"max_ttl":300}`))
+	// a rejected policy keeps the previous one
+	assert.Equal(t, policy, registry.GetTenantTTLPolicy("ttl-cache"))
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodDelete, TenantTTLPath, ""))
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, TenantTTLPath+"?tenant=ttl-cache", ""))
+	assert.Nil(t, registry.GetTenantTTLPolicy("ttl-cache"))
+}
@@ -0,0 +1,48 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+)
+
+const (
+	TransactionsPath = "/transactions"
+)
+
+var _ WebHandler = (*TransactionsHandler)(nil)
+
+// TransactionSource reports the backend connections pinned by a transaction, implemented by
+// be_cluster.SessionManager.
+type TransactionSource interface {
+	Transactions() []be_cluster.TxnStatus
+}
+
+// TransactionsHandler lists the open transactions, the oldest first, GET /transactions. A
+// transaction open for long holds its backend connection, the other sessions move off it.
+type TransactionsHandler struct {
+	source TransactionSource
+}
+
+func NewTransactionsHandler(source TransactionSource) *TransactionsHandler {
+	return &TransactionsHandler{
+		source: source,
+	}
+}
+
+func (h *TransactionsHandler) Path() string {
+	return TransactionsPath
+}
+
+func (h *TransactionsHandler) Method() HttpMethod {
+	return GET
+}
+
+func (h *TransactionsHandler) Handler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "success",
+		Data:    h.source.Transactions(),
+	})
+}
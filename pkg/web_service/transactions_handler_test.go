@@ -0,0 +1,43 @@
+package web_service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/be_cluster"
+	"github.com/pzhenzhou/elika/pkg/respio"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTransactionSource []be_cluster.TxnStatus
+
+func (f fakeTransactionSource) Transactions() []be_cluster.TxnStatus {
+	return f
+}
+
+func TestTransactionsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	since := time.Now().Add(-time.Minute).Truncate(time.Second)
+	handler := NewTransactionsHandler(fakeTransactionSource{{Addr: "127.0.0.1:6379", ConnId: "conn-1",
+		SessionId: "127.0.0.1:50000", State: respio.TxCmdStateBegin, Since: since, OpenMs: 60000}})
+	r.GET(handler.Path(), handler.Handler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, TransactionsPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data []map[string]any `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Data, 1)
+	txn := response.Data[0]
+	assert.Equal(t, "conn-1", txn["conn_id"])
+	assert.Equal(t, "127.0.0.1:50000", txn["session_id"])
+	assert.Equal(t, string(respio.TxCmdStateBegin), txn["state"])
+	assert.Equal(t, float64(60000), txn["open_ms"])
+}
@@ -0,0 +1,33 @@
+package web_service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/common"
+)
+
+const (
+	VersionPath = "/version"
+)
+
+var _ WebHandler = (*VersionHandler)(nil)
+
+// VersionHandler returns the build information of the proxy, GET /version.
+type VersionHandler struct{}
+
+func (v *VersionHandler) Path() string {
+	return VersionPath
+}
+
+func (v *VersionHandler) Method() HttpMethod {
+	return GET
+}
+
+func (v *VersionHandler) Handler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, ApiResponse{
+		Code:    http.StatusOK,
+		Message: "success",
+		Data:    common.GetBuildInfo(),
+	})
+}
@@ -0,0 +1,41 @@
+package web_service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pzhenzhou/elika/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionHandler(t *testing.T) {
+	version, commit := common.Version, common.GitCommit
+	common.Version, common.GitCommit = "v1.2.3", "abc1234"
+	defer func() {
+		common.Version, common.GitCommit = version, commit
+	}()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := &VersionHandler{}
+	r.GET(handler.Path(), handler.Handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, VersionPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Code int               `json:"code"`
+		Data map[string]string `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, map[string]string{
+		"version":    "v1.2.3",
+		"git_commit": "abc1234",
+		"build_date": common.BuildDate,
+		"go_version": runtime.Version(),
+	}, response.Data)
+}